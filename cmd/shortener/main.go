@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"github.com/MalyginaEkaterina/shortener/internal/app"
+	"os"
 )
 
 var (
@@ -12,6 +13,16 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			app.Export(os.Args[2:])
+			return
+		case "import":
+			app.Import(os.Args[2:])
+			return
+		}
+	}
 	fmt.Printf("Build version: %s\n", buildVersion)
 	fmt.Printf("Build date: %s\n", buildDate)
 	fmt.Printf("Build commit: %s\n", buildCommit)
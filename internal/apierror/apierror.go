@@ -0,0 +1,79 @@
+// Package apierror is the catalogue of errors returned by the API. Codes are stable and do not depend on
+// the transport, so they are shared by HTTP handlers and other API layers.
+package apierror
+
+import "net/http"
+
+// Code is the stable machine-readable code of the error.
+type Code string
+
+// Codes of the errors.
+const (
+	InvalidRequest      Code = "invalid_request"
+	BodyRequired        Code = "body_required"
+	MalformedBody       Code = "malformed_body"
+	BodyTooLarge        Code = "body_too_large"
+	UnsupportedEncoding Code = "unsupported_encoding"
+	UnsupportedMedia    Code = "unsupported_media_type"
+	Unauthorized        Code = "unauthorized"
+	AdminDisabled       Code = "admin_disabled"
+	RouteNotFound       Code = "route_not_found"
+	MethodNotAllowed    Code = "method_not_allowed"
+	URLNotFound         Code = "url_not_found"
+	URLDeleted          Code = "url_deleted"
+	OperationNotFound   Code = "operation_not_found"
+	JobNotFound         Code = "job_not_found"
+	JobNotFinished      Code = "job_not_finished"
+	WebhookNotFound     Code = "webhook_not_found"
+	DeliveryNotDead     Code = "delivery_not_dead"
+	FeatureDisabled     Code = "feature_disabled"
+	ErasureDisabled     Code = "erasure_disabled"
+	DeleteQueueFull     Code = "delete_queue_full"
+	Internal            Code = "internal_error"
+)
+
+// Entry describes the error in the catalogue.
+type Entry struct {
+	Code Code `json:"code"`
+	// Title is the short summary of the error which is the same for all its occurrences.
+	Title string `json:"title"`
+	// HTTPStatus is the status code of HTTP responses with the error.
+	HTTPStatus int `json:"http_status"`
+}
+
+// Catalogue returns all errors of the API ordered by their HTTP status codes.
+func Catalogue() []Entry {
+	return []Entry{
+		{InvalidRequest, "Request parameters are invalid", http.StatusBadRequest},
+		{BodyRequired, "Request body is required", http.StatusBadRequest},
+		{MalformedBody, "Request body is malformed", http.StatusBadRequest},
+		{Unauthorized, "Valid token is required", http.StatusUnauthorized},
+		{AdminDisabled, "Administrative endpoints are disabled", http.StatusForbidden},
+		{RouteNotFound, "Route not found", http.StatusNotFound},
+		{URLNotFound, "Short URL not found", http.StatusNotFound},
+		{OperationNotFound, "Delete operation not found", http.StatusNotFound},
+		{JobNotFound, "Import job not found", http.StatusNotFound},
+		{WebhookNotFound, "Webhook not found", http.StatusNotFound},
+		{FeatureDisabled, "Feature is disabled", http.StatusNotFound},
+		{MethodNotAllowed, "Method not allowed", http.StatusMethodNotAllowed},
+		{JobNotFinished, "Import job is not finished", http.StatusConflict},
+		{DeliveryNotDead, "Webhook delivery is not dead", http.StatusConflict},
+		{URLDeleted, "Short URL was deleted", http.StatusGone},
+		{BodyTooLarge, "Request body is too large", http.StatusRequestEntityTooLarge},
+		{UnsupportedEncoding, "Content encoding is not supported", http.StatusUnsupportedMediaType},
+		{UnsupportedMedia, "Content type is not supported", http.StatusUnsupportedMediaType},
+		{Internal, "Internal server error", http.StatusInternalServerError},
+		{ErasureDisabled, "Erasure of users is disabled", http.StatusNotImplemented},
+		{DeleteQueueFull, "Too many URLs queued for deletion", http.StatusServiceUnavailable},
+	}
+}
+
+// Lookup returns the entry of the code. Unknown codes are reported as internal errors.
+func Lookup(code Code) Entry {
+	for _, e := range Catalogue() {
+		if e.Code == code {
+			return e
+		}
+	}
+	return Lookup(Internal)
+}
@@ -0,0 +1,24 @@
+package apierror
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestCatalogue(t *testing.T) {
+	seen := make(map[Code]bool)
+	for _, e := range Catalogue() {
+		assert.False(t, seen[e.Code], "duplicate code %s", e.Code)
+		seen[e.Code] = true
+		assert.NotEmpty(t, e.Title)
+		assert.GreaterOrEqual(t, e.HTTPStatus, http.StatusBadRequest)
+	}
+	assert.True(t, seen[Internal])
+}
+
+func TestLookup(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, Lookup(URLNotFound).HTTPStatus)
+	assert.Equal(t, http.StatusGone, Lookup(URLDeleted).HTTPStatus)
+	assert.Equal(t, Internal, Lookup("unknown").Code)
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"github.com/MalyginaEkaterina/shortener/internal/dump"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Export writes all URLs from the configured storage into the file or stdout.
+// Usage: shortener export [-format jsonl|csv] [-o file] [storage flags].
+func Export(args []string) {
+	var format, output string
+	cfg, err := parseConfig(args, func(flags *flag.FlagSet) {
+		flags.StringVar(&format, "format", dump.FormatJSONL, "dump format: jsonl or csv")
+		flags.StringVar(&output, "o", "", "output file, stdout by default")
+	})
+	if err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		fatal("Error while parsing config", err)
+	}
+	initLogger(cfg)
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			fatal("Error while creating output file", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	w, err := dump.NewWriter(out, format)
+	if err != nil {
+		fatal("Error while creating writer", err)
+	}
+
+	store := initStore(cfg)
+	defer store.Close()
+	count, err := dump.Export(context.Background(), store, w)
+	if err != nil {
+		fatal("Error while exporting URLs", err)
+	}
+	slog.Info("Exported URLs", "urls", count)
+}
+
+// Import saves URLs from the file or stdin into the configured storage keeping their ids.
+// Usage: shortener import [-format jsonl|csv] [-i file] [storage flags].
+func Import(args []string) {
+	var format, input string
+	cfg, err := parseConfig(args, func(flags *flag.FlagSet) {
+		flags.StringVar(&format, "format", dump.FormatJSONL, "dump format: jsonl or csv")
+		flags.StringVar(&input, "i", "", "input file, stdin by default")
+	})
+	if err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		fatal("Error while parsing config", err)
+	}
+	initLogger(cfg)
+
+	var in io.Reader = os.Stdin
+	if input != "" {
+		f, err := os.Open(input)
+		if err != nil {
+			fatal("Error while opening input file", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	r, err := dump.NewReader(in, format)
+	if err != nil {
+		fatal("Error while creating reader", err)
+	}
+
+	store := initStore(cfg)
+	defer store.Close()
+	read, imported, err := dump.Import(context.Background(), store, r)
+	if err != nil {
+		fatal("Error while importing URLs", err)
+	}
+	slog.Info("Imported URLs", "read", read, "imported", imported)
+}
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/health"
+	"github.com/MalyginaEkaterina/shortener/internal/listen"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"log/slog"
+	"time"
+)
+
+// grpcHealthInterval is the interval of updating statuses of the gRPC health service from the checker.
+const grpcHealthInterval = time.Second
+
+// grpcHealth serves the standard gRPC health service with results of the checker. The status of the empty
+// service name is the status of the whole report, the status of every component is served by its name.
+type grpcHealth struct {
+	checker *health.Checker
+	server  *grpchealth.Server
+}
+
+// newGRPCHealth returns the health service which is not serving until it is updated by Run.
+func newGRPCHealth(checker *health.Checker) *grpcHealth {
+	server := grpchealth.NewServer()
+	server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	return &grpcHealth{checker: checker, server: server}
+}
+
+// register registers the health service and the server reflection on the server.
+func (h *grpcHealth) register(s *grpc.Server) {
+	healthpb.RegisterHealthServer(s, h.server)
+	reflection.Register(s)
+}
+
+// Run updates statuses of the health service until ctx is done.
+func (h *grpcHealth) Run(ctx context.Context) {
+	ticker := time.NewTicker(grpcHealthInterval)
+	defer ticker.Stop()
+	for {
+		h.update(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// update sets statuses of the health service to the results of the checker.
+func (h *grpcHealth) update(ctx context.Context) {
+	report := h.checker.Run(ctx)
+	for name, status := range report.Components {
+		h.server.SetServingStatus(name, servingStatus(status.Status))
+	}
+	h.server.SetServingStatus("", servingStatus(report.Status))
+}
+
+// shutdown sets all statuses to not serving, so clients stop sending calls before servers are stopped.
+// Later updates are ignored.
+func (h *grpcHealth) shutdown() {
+	h.server.Shutdown()
+}
+
+// servingStatus converts the status of the checker to the status of the gRPC health service.
+func servingStatus(status string) healthpb.HealthCheckResponse_ServingStatus {
+	if status == health.StatusOK {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
+
+// newGRPCServer opens the grpc-health listener and creates the server of services. TLS is used if the listener
+// has the certificate.
+func newGRPCServer(l internal.Listener, activated *listen.Activated, services func(s *grpc.Server),
+	selfSigned func() (*tls.Certificate, error)) (server, error) {
+	var opts []grpc.ServerOption
+	if l.CertFile != "" {
+		tlsCfg, err := newTLSConfig(l, selfSigned)
+		if err != nil {
+			return server{}, err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+	ln, err := listen.Listen(l.Address, activated)
+	if err != nil {
+		return server{}, err
+	}
+	s := server{listener: l, ln: ln, grpc: grpc.NewServer(opts...)}
+	if services != nil {
+		services(s.grpc)
+	}
+	return s, nil
+}
+
+// serveGRPC serves calls until the server is stopped. Other errors are sent to errs.
+func (s server) serveGRPC(errs chan<- error) {
+	err := s.grpc.Serve(s.ln)
+	if err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		errs <- fmt.Errorf("listener %s: %w", s.listener, err)
+	}
+}
+
+// shutdownGRPC stops accepting calls and waits for in-flight ones for drainTimeout.
+// Connections which are still active after that are closed.
+func shutdownGRPC(ctx context.Context, server *grpc.Server, drainTimeout time.Duration) {
+	drainCtx, cancel := context.WithTimeout(ctx, drainTimeout)
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-drainCtx.Done():
+		slog.Warn("In-flight gRPC calls were not finished, closing connections", "timeout", drainTimeout)
+		server.Stop()
+		<-stopped
+	}
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGRPCHealth(t *testing.T) {
+	ctx := context.Background()
+	checker := health.NewChecker(0, time.Second)
+	var dbDown atomic.Bool
+	checker.Register("storage", func(context.Context) error { return nil })
+	checker.Register("db", func(context.Context) error {
+		if dbDown.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	grpcHealth := newGRPCHealth(checker)
+
+	l := internal.Listener{Address: "127.0.0.1:0", Protocol: internal.ProtocolGRPCHealth}
+	servers, err := newServers(internal.Config{}, []internal.Listener{l}, nil, nil, nil, grpcHealth.register)
+	require.NoError(t, err)
+	errs := make(chan error, 1)
+	go servers[0].serve(errs)
+	t.Cleanup(func() {
+		require.NoError(t, shutdownServers(ctx, servers, time.Second))
+		select {
+		case err := <-errs:
+			t.Errorf("server failed: %v", err)
+		default:
+		}
+	})
+
+	conn, err := grpc.NewClient(servers[0].ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return resp.Status
+	}
+
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""), "not serving before the first update")
+	grpcHealth.update(ctx)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check("storage"))
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	dbDown.Store(true)
+	grpcHealth.update(ctx)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check("db"))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check("storage"))
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}))
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseSend())
+	var services []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		services = append(services, s.Name)
+	}
+	assert.Contains(t, services, healthpb.Health_ServiceDesc.ServiceName)
+
+	dbDown.Store(false)
+	grpcHealth.shutdown()
+	grpcHealth.update(ctx)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""), "updates are ignored after shutdown")
+}
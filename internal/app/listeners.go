@@ -0,0 +1,255 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/handlers"
+	"github.com/MalyginaEkaterina/shortener/internal/listen"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// routeSets contains functions registering route sets by their names.
+type routeSets map[string]func(r chi.Router)
+
+// server serves the route sets of the listener, or the gRPC health service if it is a grpc-health listener.
+type server struct {
+	listener internal.Listener
+	ln       net.Listener
+	http     *http.Server
+	grpc     *grpc.Server
+	// http3 serves HTTP/3 on udp if the listener has HTTP3 enabled.
+	http3 *http3.Server
+	udp   net.PacketConn
+}
+
+// defaultListeners returns listeners used if they are not configured: public and admin routes on the address
+// of the server with HTTP/3 if https is enabled and pprof on pprofAddress.
+func defaultListeners(cfg internal.Config, pprofAddress string) []internal.Listener {
+	protocol := internal.ProtocolHTTP
+	if cfg.EnableHTTPS {
+		protocol = internal.ProtocolHTTPS
+	} else if cfg.EnableH2C {
+		protocol = internal.ProtocolH2C
+	}
+	res := []internal.Listener{{
+		Address:  cfg.Address,
+		Protocol: protocol,
+		Routes:   []string{internal.RoutesPublic, internal.RoutesAdmin},
+		HTTP3:    cfg.EnableHTTPS,
+	}}
+	if pprofAddress != "" {
+		res = append(res, internal.Listener{
+			Address:  pprofAddress,
+			Protocol: internal.ProtocolHTTP,
+			Routes:   []string{internal.RoutesDebug},
+		})
+	}
+	return res
+}
+
+// newServers opens the listeners and creates servers of their route sets.
+// Routes registered by common are served by every HTTP listener, services registered by grpcServices
+// are served by grpc-health listeners.
+func newServers(cfg internal.Config, listeners []internal.Listener, activated *listen.Activated, sets routeSets,
+	common func(r chi.Router), grpcServices func(s *grpc.Server)) ([]server, error) {
+	selfSigned := sync.OnceValues(generateTLSCertificate)
+	res := make([]server, 0, len(listeners))
+	closeAll := func() {
+		for _, s := range res {
+			s.close()
+		}
+	}
+	for _, l := range listeners {
+		var s server
+		var err error
+		if l.Protocol == internal.ProtocolGRPCHealth {
+			s, err = newGRPCServer(l, activated, grpcServices, selfSigned)
+		} else {
+			s, err = newServer(l, activated, newHandler(cfg, l, sets, common), selfSigned)
+		}
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("listener %s: %w", l, err)
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+// newServer opens the listener and creates the server of handler. HTTP/3 is served on the UDP port
+// with the same number as the TCP one and is advertised by Alt-Svc header of responses over TCP.
+func newServer(l internal.Listener, activated *listen.Activated, handler http.Handler,
+	selfSigned func() (*tls.Certificate, error)) (server, error) {
+	s := server{listener: l, http: &http.Server{Handler: handler}}
+	switch l.Protocol {
+	case internal.ProtocolHTTPS:
+		tlsCfg, err := newTLSConfig(l, selfSigned)
+		if err != nil {
+			return server{}, err
+		}
+		s.http.TLSConfig = tlsCfg
+	case internal.ProtocolH2C:
+		// HTTP/2 connections are hijacked from the server, so they are closed on exit without waiting for requests.
+		s.http.Handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	ln, err := listen.Listen(l.Address, activated)
+	if err != nil {
+		return server{}, err
+	}
+	s.ln = ln
+	if l.HTTP3 {
+		s.udp, err = net.ListenPacket("udp", ln.Addr().String())
+		if err != nil {
+			ln.Close()
+			return server{}, fmt.Errorf("error while listening on udp: %w", err)
+		}
+		s.http3 = &http3.Server{
+			Handler:   handler,
+			TLSConfig: s.http.TLSConfig,
+			Port:      s.udp.LocalAddr().(*net.UDPAddr).Port,
+		}
+		s.http.Handler = altSvc(s.http3, handler)
+	}
+	return s, nil
+}
+
+// altSvc advertises HTTP/3 served by h3 in Alt-Svc header.
+func altSvc(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		h3.SetQUICHeaders(writer.Header())
+		next.ServeHTTP(writer, req)
+	})
+}
+
+// newHandler returns the router serving route sets of the listener. The api set is skipped if the public one
+// is served, because it is included there.
+func newHandler(cfg internal.Config, l internal.Listener, sets routeSets, common func(r chi.Router)) http.Handler {
+	r := handlers.NewBaseRouter(cfg)
+	r.Group(common)
+	names := l.RouteSets()
+	for _, name := range names {
+		if name == internal.RoutesAPI && slices.Contains(names, internal.RoutesPublic) {
+			continue
+		}
+		r.Group(sets[name])
+	}
+	return r
+}
+
+// newTLSConfig returns the TLS config of https or grpc-health listener with its certificate or the self-signed one.
+func newTLSConfig(l internal.Listener, selfSigned func() (*tls.Certificate, error)) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if l.MinTLSVersion == "1.3" {
+		cfg.MinVersion = tls.VersionTLS13
+	}
+	if l.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error while loading TLS certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	} else {
+		cert, err := selfSigned()
+		if err != nil {
+			return nil, fmt.Errorf("error while generating TLS certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+	if l.ClientCAFile != "" {
+		data, err := os.ReadFile(l.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in client CA file %s", l.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// close closes the listeners of the server which is not started.
+func (s server) close() {
+	s.ln.Close()
+	if s.udp != nil {
+		s.udp.Close()
+	}
+}
+
+// serve serves requests until the server is shut down. Other errors are sent to errs.
+func (s server) serve(errs chan<- error) {
+	slog.Info("Started server", "listener", s.listener.String(), "address", s.ln.Addr().String())
+	if s.grpc != nil {
+		s.serveGRPC(errs)
+		return
+	}
+	if s.http3 != nil {
+		go func() {
+			slog.Info("Started HTTP/3 server", "listener", s.listener.String(), "address", s.udp.LocalAddr().String())
+			err := s.http3.Serve(s.udp)
+			if !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("listener %s: HTTP/3: %w", s.listener, err)
+			}
+		}()
+	}
+	var err error
+	if s.http.TLSConfig != nil {
+		err = s.http.ServeTLS(s.ln, "", "")
+	} else {
+		err = s.http.Serve(s.ln)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		errs <- fmt.Errorf("listener %s: %w", s.listener, err)
+	}
+}
+
+// shutdown stops the server. HTTP/3 connections are closed after the TCP server is drained, because
+// the HTTP/3 server can not wait for in-flight requests.
+func (s server) shutdown(ctx context.Context, drainTimeout time.Duration) error {
+	if s.grpc != nil {
+		shutdownGRPC(ctx, s.grpc, drainTimeout)
+		return nil
+	}
+	err := shutdownServer(ctx, s.http, drainTimeout)
+	if s.http3 != nil {
+		err = errors.Join(err, s.http3.Close(), s.udp.Close())
+	}
+	return err
+}
+
+// shutdownServers shuts down all servers concurrently, so every one of them waits for in-flight requests
+// for drainTimeout at most.
+func shutdownServers(ctx context.Context, servers []server, drainTimeout time.Duration) error {
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func(i int, s server) {
+			defer wg.Done()
+			if err := s.shutdown(ctx, drainTimeout); err != nil {
+				slog.Error("Error while stopping server", "listener", s.listener.String(), logging.Err(err))
+				errs[i] = fmt.Errorf("listener %s: %w", s.listener, err)
+			}
+		}(i, s)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/handlers"
+	"github.com/MalyginaEkaterina/shortener/internal/service"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestServer serves public routes of the listener and returns the address of the server.
+func startTestServer(t *testing.T, l internal.Listener) string {
+	store := storage.NewMemoryStorage()
+	cfg := internal.Config{BaseURL: "http://localhost:8080"}
+	signer := handlers.Signer{SecretKey: []byte("secret")}
+	urlService := service.URLService{Store: store}
+	deleteWorker := service.NewDeleteWorker(store, storage.NewMemoryDeleteQueue(10), storage.NewMemoryOperationStorage(), nil, nil)
+	sets := routeSets{internal.RoutesPublic: handlers.PublicRoutes(store, cfg, signer, urlService, deleteWorker)}
+	probes := func(r chi.Router) {
+		r.Get("/healthz", handlers.Liveness)
+	}
+
+	servers, err := newServers(cfg, []internal.Listener{l}, nil, sets, probes, nil)
+	require.NoError(t, err)
+	errs := make(chan error, 2)
+	for _, s := range servers {
+		go s.serve(errs)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, shutdownServers(ctx, servers, time.Second))
+		select {
+		case err := <-errs:
+			t.Errorf("server failed: %v", err)
+		default:
+		}
+	})
+	return servers[0].ln.Addr().String()
+}
+
+// shortenAndRedirect shortens the URL and follows the short URL by the client. It returns the protocol of responses.
+func shortenAndRedirect(t *testing.T, client *http.Client, baseURL string, url string) string {
+	resp, err := client.Post(baseURL+"/", "text/plain", strings.NewReader(url))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = client.Get(baseURL + "/" + path.Base(string(body)))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	assert.Equal(t, url, resp.Header.Get("Location"))
+	return resp.Proto
+}
+
+func noRedirect(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+func TestH2C(t *testing.T) {
+	addr := startTestServer(t, internal.Listener{Address: "127.0.0.1:0", Protocol: internal.ProtocolH2C})
+
+	h2 := &http.Client{
+		CheckRedirect: noRedirect,
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		},
+	}
+	assert.Equal(t, "HTTP/2.0", shortenAndRedirect(t, h2, "http://"+addr, "https://ya.ru/h2c"))
+
+	h1 := &http.Client{CheckRedirect: noRedirect}
+	assert.Equal(t, "HTTP/1.1", shortenAndRedirect(t, h1, "http://"+addr, "https://ya.ru/http1"))
+}
+
+func TestHTTP3(t *testing.T) {
+	addr := startTestServer(t, internal.Listener{Address: "127.0.0.1:0", Protocol: internal.ProtocolHTTPS, HTTP3: true})
+	_, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	tlsCfg := &tls.Config{InsecureSkipVerify: true}
+
+	tcp := &http.Client{
+		CheckRedirect: noRedirect,
+		Transport:     &http.Transport{TLSClientConfig: tlsCfg, ForceAttemptHTTP2: true},
+	}
+	resp, err := tcp.Get("https://" + addr + "/healthz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "HTTP/2.0", resp.Proto)
+	assert.Contains(t, resp.Header.Get("Alt-Svc"), `h3=":`+port+`"`)
+
+	rt := &http3.RoundTripper{TLSClientConfig: tlsCfg}
+	defer rt.Close()
+	h3 := &http.Client{CheckRedirect: noRedirect, Transport: rt}
+	assert.Equal(t, "HTTP/3.0", shortenAndRedirect(t, h3, "https://"+addr, "https://ya.ru/h3"))
+}
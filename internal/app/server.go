@@ -8,131 +8,330 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/MalyginaEkaterina/shortener/internal"
 	"github.com/MalyginaEkaterina/shortener/internal/handlers"
+	"github.com/MalyginaEkaterina/shortener/internal/health"
+	"github.com/MalyginaEkaterina/shortener/internal/lifecycle"
+	"github.com/MalyginaEkaterina/shortener/internal/listen"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/MalyginaEkaterina/shortener/internal/outbox"
 	"github.com/MalyginaEkaterina/shortener/internal/service"
 	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/MalyginaEkaterina/shortener/internal/tracing"
 	"github.com/caarlos0/env/v6"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"io"
-	"log"
+	"log/slog"
 	"math/big"
 	"net"
 	"net/http"
-	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
 )
 
 // Start parses flags and env vars and starts the server.
 func Start() {
+	var pprofAddress string
+	var secretFilePath string
+	cfg, err := parseConfig(os.Args[1:], func(flags *flag.FlagSet) {
+		flags.StringVar(&secretFilePath, "p", "", "path to file with secret")
+		flags.StringVar(&pprofAddress, "pprof", "localhost:6060", "address to export pprof on if listeners are not configured")
+	})
+	if err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		fatal("Error while parsing config", err)
+	}
+	initLogger(cfg)
+
+	lc := lifecycle.New()
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.TraceExporter)
+	if err != nil {
+		fatal("Error while initializing tracing", err)
+	}
+	lc.OnStop(lifecycle.StageTelemetry, "tracing", shutdownTracing)
+
+	erasures := initErasureStore(cfg)
+	lc.OnClose(lifecycle.StageStorage, "erasure storage", erasures.Close)
+	store := initStore(cfg)
+	reserveErasedUsers(store, erasures)
+	userGC := initUserGC(cfg, store)
+	store = storage.NewTracingStorage(store, storageKind(cfg))
+	migration := initMigration(cfg, store)
+	if migration != nil {
+		store = migration
+	}
+	var cache *storage.LRUStorage
+	if cfg.CacheSize > 0 {
+		cache = storage.NewLRUStorage(store, cfg.CacheSize, cfg.CacheTTL, cfg.CacheNegativeTTL)
+		store = cache
+		slog.Info("Using LRU cache", "entries", cfg.CacheSize)
+	}
+	lc.OnClose(lifecycle.StageStorage, "url storage", store.Close)
+
+	secretKey, err := getSecret(secretFilePath)
+	if err != nil {
+		fatal("Error while reading secret key", err)
+	}
+
+	if migration != nil {
+		lc.Go(lifecycle.StageWorkers, "migration", migration.Run)
+	}
+	if userGC != nil {
+		lc.Go(lifecycle.StageWorkers, "user GC", userGC.Run)
+	}
+	if cache != nil && cfg.DatabaseDSN != "" {
+		lc.Go(lifecycle.StageWorkers, "URL changes listener", func(ctx context.Context) {
+			storage.ListenURLChanges(ctx, cfg.DatabaseDSN, cache)
+		})
+	}
+
+	auditStore := initAuditStore(cfg)
+	lc.OnClose(lifecycle.StageStorage, "audit storage", auditStore.Close)
+	auditor := service.NewAuditor(auditStore)
+	webhookStore := initWebhookStore(cfg)
+	lc.OnClose(lifecycle.StageStorage, "webhook storage", webhookStore.Close)
+	webhooks := service.NewWebhooks(webhookStore, store, cfg.BaseURL, cfg.WebhookMaxAttempts, cfg.WebhookTimeout,
+		cfg.WebhookRetention)
+	lc.Go(lifecycle.StageWorkers, "webhooks", webhooks.Run)
+	signer := handlers.Signer{SecretKey: secretKey, Erasures: erasures}
+	jobStore := initJobStore(cfg)
+	lc.OnClose(lifecycle.StageStorage, "job storage", jobStore.Close)
+	opStore := initOperationStore(cfg)
+	lc.OnClose(lifecycle.StageStorage, "operation storage", opStore.Close)
+	urlService := service.URLService{
+		Store:              store,
+		RestoreGracePeriod: cfg.RestoreGracePeriod,
+		Erasures:           erasures,
+		Audit:              auditor,
+		Webhooks:           webhooks,
+		Jobs:               jobStore,
+		Operations:         opStore,
+	}
+	deleteQueue := initDeleteQueue(cfg)
+	lc.OnClose(lifecycle.StageStorage, "delete queue", deleteQueue.Close)
+	deleteWorker := service.NewDeleteWorker(store, deleteQueue, opStore, auditor, webhooks)
+	lc.Go(lifecycle.StageQueues, "delete worker", deleteWorker.Run)
+	relay := initOutboxRelay(cfg)
+	if relay != nil {
+		lc.OnClose(lifecycle.StageStorage, "outbox", relay.Close)
+		lc.Go(lifecycle.StageWorkers, "outbox relay", relay.Run)
+	}
+	if cfg.DeleteRetention > 0 && cfg.PurgeInterval > 0 {
+		if cfg.DeleteRetention < cfg.RestoreGracePeriod {
+			slog.Warn("Delete retention is shorter than restore grace period, purged URLs can not be restored")
+		}
+		lc.Go(lifecycle.StageWorkers, "purger", service.NewPurger(store, auditor, cfg.DeleteRetention, cfg.PurgeInterval).Run)
+	}
+	importWorker := service.NewImportWorker(jobStore, urlService)
+	lc.Go(lifecycle.StageWorkers, "import worker", importWorker.Run)
+	checker := health.NewChecker(cfg.HealthCacheTTL, cfg.HealthTimeout)
+	store.RegisterChecks(checker)
+	deleteWorker.RegisterChecks(checker)
+	sets := routeSets{
+		internal.RoutesPublic: func(r chi.Router) {
+			r.Group(handlers.PublicRoutes(store, cfg, signer, urlService, deleteWorker))
+			r.Mount("/api/jobs", handlers.NewJobsRouter(store, cfg, signer, jobStore, importWorker))
+			r.Mount("/api/user/webhooks", handlers.NewWebhooksRouter(store, cfg, signer, webhooks))
+		},
+		internal.RoutesAPI: func(r chi.Router) {
+			r.Group(handlers.APIRoutes(store, cfg, signer, urlService, deleteWorker))
+			r.Mount("/api/jobs", handlers.NewJobsRouter(store, cfg, signer, jobStore, importWorker))
+			r.Mount("/api/user/webhooks", handlers.NewWebhooksRouter(store, cfg, signer, webhooks))
+		},
+		internal.RoutesAdmin: func(r chi.Router) {
+			r.Mount("/api/admin", handlers.NewAdminRouter(handlers.Admin{
+				Store:             store,
+				Token:             cfg.AdminToken,
+				Migration:         migration,
+				Cache:             cache,
+				UserGC:            userGC,
+				Audit:             auditStore,
+				MaxImportBodySize: cfg.MaxImportBodySize,
+			}))
+		},
+		internal.RoutesDebug: func(r chi.Router) {
+			r.Mount("/debug", middleware.Profiler())
+		},
+	}
+	grpcHealth := newGRPCHealth(checker)
+	if slices.ContainsFunc(cfg.Listeners, func(l internal.Listener) bool { return l.Protocol == internal.ProtocolGRPCHealth }) {
+		lc.Go(lifecycle.StageWorkers, "gRPC health", grpcHealth.Run)
+	}
+	probes := func(r chi.Router) {
+		r.Get("/healthz", handlers.Liveness)
+		r.Get("/readyz", handlers.Readiness(checker))
+		r.Get("/ping", handlers.Ping(checker))
+	}
+	listeners := cfg.Listeners
+	if len(listeners) == 0 {
+		listeners = defaultListeners(cfg, pprofAddress)
+	}
+	activated, err := listen.Systemd()
+	if err != nil {
+		fatal("Error while taking sockets passed by systemd", err)
+	}
+	servers, err := newServers(cfg, listeners, activated, sets, probes, grpcHealth.register)
+	if err != nil {
+		fatal("Error while opening listeners", err)
+	}
+	lc.OnStop(lifecycle.StageServer, "HTTP servers", func(ctx context.Context) error {
+		grpcHealth.shutdown()
+		return shutdownServers(ctx, servers, cfg.DrainTimeout)
+	})
+	serverErr := make(chan error, len(servers))
+	for _, s := range servers {
+		go s.serve(serverErr)
+	}
+
+	sigint := make(chan os.Signal, 1)
+	signal.Notify(sigint, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	var failed error
+	select {
+	case sig := <-sigint:
+		slog.Info("Received signal", "signal", sig.String())
+	case failed = <-serverErr:
+		slog.Error("HTTP server failed", logging.Err(failed))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	err = lc.Shutdown(ctx)
+	cancel()
+	if err != nil {
+		fatal("Shutdown failed", err)
+	}
+	if failed != nil {
+		os.Exit(1)
+	}
+	slog.Info("Stopped server")
+}
+
+// shutdownServer stops accepting requests and waits for in-flight ones for drainTimeout.
+// Connections which are still active after that are closed.
+func shutdownServer(ctx context.Context, server *http.Server, drainTimeout time.Duration) error {
+	drainCtx, cancel := context.WithTimeout(ctx, drainTimeout)
+	defer cancel()
+	err := server.Shutdown(drainCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("In-flight requests were not finished, closing connections", "timeout", drainTimeout)
+		return server.Close()
+	}
+	return err
+}
+
+// parseConfig reads the config file, flags and env vars in the order of increasing priority.
+// addFlags is used to define additional flags of the command.
+func parseConfig(args []string, addFlags func(flags *flag.FlagSet)) (internal.Config, error) {
 	cfg := internal.Config{
-		Address: "localhost:8080",
-		BaseURL: "http://localhost:8080",
+		Address:               "localhost:8080",
+		BaseURL:               "http://localhost:8080",
+		DeleteQueueSize:       10000,
+		RestoreGracePeriod:    24 * time.Hour,
+		DeleteRetention:       30 * 24 * time.Hour,
+		PurgeInterval:         time.Hour,
+		UserInactivity:        7 * 24 * time.Hour,
+		UserGCInterval:        time.Hour,
+		WebhookMaxAttempts:    8,
+		WebhookTimeout:        10 * time.Second,
+		WebhookRetention:      7 * 24 * time.Hour,
+		OutboxInterval:        time.Second,
+		LogFormat:             logging.FormatJSON,
+		LogLevel:              "info",
+		HealthCacheTTL:        2 * time.Second,
+		HealthTimeout:         time.Second,
+		DrainTimeout:          10 * time.Second,
+		ShutdownTimeout:       30 * time.Second,
+		MaxBodySize:           1 << 20,
+		MaxBatchBodySize:      16 << 20,
+		MaxImportBodySize:     64 << 20,
+		MaxDecompressionRatio: 100,
 	}
 
 	appName := os.Args[0]
 	cfgFlag := flag.NewFlagSet(appName, flag.ContinueOnError)
+	cfgFlag.SetOutput(io.Discard)
 	var configName string
 	cfgFlag.StringVar(&configName, "c", os.Getenv("CONFIG"), "name of config file")
-	cfgFlag.Parse(os.Args[1:])
+	cfgFlag.Parse(args)
 	if configName != "" {
 		confData, err := os.ReadFile(configName)
 		if err != nil {
-			log.Fatal("Error while reading config file")
+			return cfg, fmt.Errorf("error while reading config file: %w", err)
 		}
 		err = json.Unmarshal(confData, &cfg)
 		if err != nil {
-			log.Fatal("Error while parsing config file")
+			return cfg, fmt.Errorf("error while parsing config file: %w", err)
 		}
 	}
 
-	var pprofAddress string
-	var secretFilePath string
 	flags := flag.NewFlagSet(appName, flag.ContinueOnError)
 	flags.StringVar(&cfg.Address, "a", cfg.Address, "address to listen on")
 	flags.StringVar(&cfg.BaseURL, "b", cfg.BaseURL, "base address for short URL")
 	flags.StringVar(&cfg.FileStoragePath, "f", cfg.FileStoragePath, "file storage path")
 	flags.StringVar(&cfg.DatabaseDSN, "d", cfg.DatabaseDSN, "database connection string")
 	flags.BoolVar(&cfg.EnableHTTPS, "s", cfg.EnableHTTPS, "enable https")
-	flags.StringVar(&secretFilePath, "p", "", "path to file with secret")
-	flags.StringVar(&pprofAddress, "pprof", "localhost:6060", "address to export pprof on")
+	flags.BoolVar(&cfg.EnableH2C, "h2c", cfg.EnableH2C, "enable HTTP/2 without TLS")
+	flags.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "token for administrative endpoints")
+	flags.IntVar(&cfg.CacheSize, "cache-size", cfg.CacheSize, "max number of cached redirects, 0 disables cache")
+	flags.DurationVar(&cfg.CacheTTL, "cache-ttl", cfg.CacheTTL, "time to live of cached redirects, 0 means no expiration")
+	flags.DurationVar(&cfg.CacheNegativeTTL, "cache-negative-ttl", cfg.CacheNegativeTTL, "time to live of cached misses, 0 disables negative caching")
+	flags.IntVar(&cfg.DeleteQueueSize, "delete-queue-size", cfg.DeleteQueueSize, "max number of URL ids queued for deletion")
+	flags.DurationVar(&cfg.RestoreGracePeriod, "restore-grace-period", cfg.RestoreGracePeriod, "time after deletion during which URLs can be restored")
+	flags.DurationVar(&cfg.DeleteRetention, "delete-retention", cfg.DeleteRetention, "time after deletion after which URLs are purged, 0 disables purging")
+	flags.DurationVar(&cfg.PurgeInterval, "purge-interval", cfg.PurgeInterval, "interval between purges of deleted URLs")
+	flags.DurationVar(&cfg.UserInactivity, "user-inactivity", cfg.UserInactivity, "time without activity after which users without URLs are deleted, 0 disables deleting")
+	flags.DurationVar(&cfg.UserGCInterval, "user-gc-interval", cfg.UserGCInterval, "interval between deletions of inactive users")
+	flags.IntVar(&cfg.WebhookMaxAttempts, "webhook-max-attempts", cfg.WebhookMaxAttempts, "number of attempts to deliver the webhook event after which it becomes dead")
+	flags.DurationVar(&cfg.WebhookTimeout, "webhook-timeout", cfg.WebhookTimeout, "timeout of the request to the webhook")
+	flags.DurationVar(&cfg.WebhookRetention, "webhook-retention", cfg.WebhookRetention, "time after which delivered and dead webhook deliveries are pruned, 0 disables pruning")
+	flags.StringVar(&cfg.OutboxSink, "outbox-sink", cfg.OutboxSink, "sink of outbox events: stdout, file:<path>, http(s) URL or nats://host:port/<subject>")
+	flags.DurationVar(&cfg.OutboxInterval, "outbox-interval", cfg.OutboxInterval, "interval between publications of outbox events")
+	flags.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "format of logs: json or text")
+	flags.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "minimal level of logs: debug, info, warn or error")
+	flags.DurationVar(&cfg.HealthCacheTTL, "health-cache-ttl", cfg.HealthCacheTTL, "time during which results of health checks are reused")
+	flags.DurationVar(&cfg.HealthTimeout, "health-timeout", cfg.HealthTimeout, "timeout of every health check")
+	flags.DurationVar(&cfg.DrainTimeout, "drain-timeout", cfg.DrainTimeout, "time to wait for in-flight requests on shutdown")
+	flags.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "hard deadline of the whole shutdown")
+	flags.Int64Var(&cfg.MaxBodySize, "max-body-size", cfg.MaxBodySize, "max size of request bodies in bytes, 0 disables the limit")
+	flags.Int64Var(&cfg.MaxBatchBodySize, "max-batch-body-size", cfg.MaxBatchBodySize, "max size of batch shortening request body in bytes, 0 disables the limit")
+	flags.Int64Var(&cfg.MaxImportBodySize, "max-import-body-size", cfg.MaxImportBodySize, "max size of import request bodies in bytes, 0 disables the limit")
+	flags.IntVar(&cfg.MaxDecompressionRatio, "max-decompression-ratio", cfg.MaxDecompressionRatio, "max ratio of decompressed and compressed request body sizes, 0 disables the limit")
+	flags.StringVar(&cfg.TraceExporter, "trace-exporter", cfg.TraceExporter, "exporter of spans: stdout, file:<path> or otlp, tracing is disabled if empty")
 	flags.StringVar(&configName, "c", os.Getenv("CONFIG"), "name of config file")
-	if err := flags.Parse(os.Args[1:]); err != nil {
-		if err == flag.ErrHelp {
-			return
+	var listeners []internal.Listener
+	flags.Func("listen", "listener [routes@]protocol://address[?options], can be repeated", func(spec string) error {
+		var l internal.Listener
+		if err := l.UnmarshalText([]byte(spec)); err != nil {
+			return err
 		}
-		log.Fatal("Error parsing args", err)
-	}
-
-	if err := env.Parse(&cfg); err != nil {
-		log.Fatal("Error while parsing env", err)
-	}
-
-	if pprofAddress != "" {
-		go http.ListenAndServe(pprofAddress, nil)
-	}
-
-	store := initStore(cfg)
-	defer store.Close()
-
-	secretKey, err := getSecret(secretFilePath)
-	if err != nil {
-		log.Fatal("Error while reading secret key", err)
+		listeners = append(listeners, l)
+		return nil
+	})
+	if addFlags != nil {
+		addFlags(flags)
 	}
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	signer := handlers.Signer{SecretKey: secretKey}
-	urlService := service.URLService{Store: store}
-	deleteWorker := service.NewDeleteWorker(store)
-	go deleteWorker.Run(ctx)
-	r := handlers.NewRouter(store, cfg, signer, urlService, deleteWorker)
-
-	sigint := make(chan os.Signal, 1)
-	connsClosed := make(chan struct{})
-	signal.Notify(sigint, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
-
-	shutdown := func(srv *http.Server) {
-		<-sigint
-		if er := srv.Shutdown(context.Background()); er != nil {
-			log.Printf("HTTP server Shutdown: %v", er)
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return cfg, err
 		}
-		close(connsClosed)
+		return cfg, fmt.Errorf("error parsing args: %w", err)
+	}
+	if len(listeners) > 0 {
+		cfg.Listeners = listeners
 	}
 
-	if cfg.EnableHTTPS {
-		cert, err := generateTLSCertificate()
-		if err != nil {
-			log.Fatal(err)
-		}
-		server := &http.Server{
-			Addr:    cfg.Address,
-			Handler: r,
-			TLSConfig: &tls.Config{
-				Certificates: []tls.Certificate{*cert},
-			},
-		}
-		go shutdown(server)
-		log.Printf("Started TLS server on %s\n", cfg.Address)
-		if err = server.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
-			log.Fatalf("HTTP server ListenAndServeTLS: %v", err)
-		}
-	} else {
-		server := &http.Server{Addr: cfg.Address, Handler: r}
-		go shutdown(server)
-		log.Printf("Started server on %s\n", cfg.Address)
-		if err = server.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalf("HTTP server ListenAndServe: %v", err)
-		}
+	if err := env.Parse(&cfg); err != nil {
+		return cfg, fmt.Errorf("error while parsing env: %w", err)
 	}
-	<-connsClosed
-	log.Printf("Stopped server on %s\n", cfg.Address)
+	return cfg, nil
 }
 
 func generateTLSCertificate() (*tls.Certificate, error) {
@@ -172,24 +371,221 @@ func initStore(cfg internal.Config) storage.Storage {
 	var store storage.Storage
 	var err error
 	if cfg.DatabaseDSN != "" {
-		store, err = storage.NewDBStorage(cfg.DatabaseDSN)
+		dbStore, err := storage.NewDBStorage(cfg.DatabaseDSN)
 		if err != nil {
-			log.Fatal("Database connection error", err)
+			fatal("Database connection error", err)
 		}
-		log.Printf("Using database storage %s\n", cfg.DatabaseDSN)
+		dbStore.Outbox = cfg.OutboxSink != ""
+		store = dbStore
+		slog.Info("Using database storage")
 	} else if cfg.FileStoragePath != "" {
 		store, err = storage.NewCachedFileStorage(cfg.FileStoragePath)
 		if err != nil {
-			log.Fatal("Error creating CachedFileStorage", err)
+			fatal("Error creating CachedFileStorage", err)
 		}
-		log.Printf("Using cached file storage %s\n", cfg.FileStoragePath)
+		slog.Info("Using cached file storage", "path", cfg.FileStoragePath)
 	} else {
 		store = storage.NewMemoryStorage()
-		log.Printf("Using memory storage\n")
+		slog.Info("Using memory storage")
 	}
 	return store
 }
 
+// storageKind returns the kind of the storage of URLs for spans.
+func storageKind(cfg internal.Config) string {
+	if cfg.DatabaseDSN != "" {
+		return "postgresql"
+	} else if cfg.FileStoragePath != "" {
+		return "file"
+	}
+	return "memory"
+}
+
+// initJobStore creates the storage of import jobs of the same kind as the storage of URLs.
+func initJobStore(cfg internal.Config) storage.JobStorage {
+	if cfg.DatabaseDSN != "" {
+		jobStore, err := storage.NewDBJobStorage(cfg.DatabaseDSN)
+		if err != nil {
+			fatal("Error creating DBJobStorage", err)
+		}
+		return jobStore
+	} else if cfg.FileStoragePath != "" {
+		jobStore, err := storage.NewFileJobStorage(cfg.FileStoragePath + ".jobs")
+		if err != nil {
+			fatal("Error creating FileJobStorage", err)
+		}
+		return jobStore
+	}
+	return storage.NewMemoryJobStorage()
+}
+
+// initDeleteQueue creates the queue of URL ids for deletion of the same kind as the storage of URLs.
+func initDeleteQueue(cfg internal.Config) storage.DeleteQueue {
+	if cfg.DatabaseDSN != "" {
+		queue, err := storage.NewDBDeleteQueue(cfg.DatabaseDSN, cfg.DeleteQueueSize)
+		if err != nil {
+			fatal("Error creating DBDeleteQueue", err)
+		}
+		return queue
+	} else if cfg.FileStoragePath != "" {
+		queue, err := storage.NewFileDeleteQueue(cfg.FileStoragePath+".deletes", cfg.DeleteQueueSize)
+		if err != nil {
+			fatal("Error creating FileDeleteQueue", err)
+		}
+		return queue
+	}
+	return storage.NewMemoryDeleteQueue(cfg.DeleteQueueSize)
+}
+
+// initOperationStore creates the storage of deletion operations of the same kind as the storage of URLs.
+func initOperationStore(cfg internal.Config) storage.OperationStorage {
+	if cfg.DatabaseDSN != "" {
+		opStore, err := storage.NewDBOperationStorage(cfg.DatabaseDSN)
+		if err != nil {
+			fatal("Error creating DBOperationStorage", err)
+		}
+		return opStore
+	} else if cfg.FileStoragePath != "" {
+		opStore, err := storage.NewFileOperationStorage(cfg.FileStoragePath + ".operations")
+		if err != nil {
+			fatal("Error creating FileOperationStorage", err)
+		}
+		return opStore
+	}
+	return storage.NewMemoryOperationStorage()
+}
+
+// reserveErasedUsers makes sure that the storage does not issue ids of erased users again. Storages which restore
+// the last user id from owners of URLs lose ids of erased users because their URLs are anonymised.
+func reserveErasedUsers(store storage.Storage, erasures storage.ErasureStorage) {
+	reserver, ok := store.(storage.UserReserver)
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+	last, err := erasures.LastErased(ctx)
+	if err != nil {
+		fatal("Error while getting erased users", err)
+	}
+	if last == 0 {
+		return
+	}
+	if err = reserver.ReserveUser(ctx, last); err != nil {
+		fatal("Error while reserving ids of erased users", err)
+	}
+}
+
+// initErasureStore creates the storage of erased users of the same kind as the storage of URLs.
+func initErasureStore(cfg internal.Config) storage.ErasureStorage {
+	if cfg.DatabaseDSN != "" {
+		erasures, err := storage.NewDBErasureStorage(cfg.DatabaseDSN)
+		if err != nil {
+			fatal("Error creating DBErasureStorage", err)
+		}
+		return erasures
+	} else if cfg.FileStoragePath != "" {
+		erasures, err := storage.NewFileErasureStorage(cfg.FileStoragePath + ".erasures")
+		if err != nil {
+			fatal("Error creating FileErasureStorage", err)
+		}
+		return erasures
+	}
+	return storage.NewMemoryErasureStorage()
+}
+
+// initAuditStore creates the storage of the audit log of the same kind as the storage of URLs.
+func initAuditStore(cfg internal.Config) storage.AuditStorage {
+	if cfg.DatabaseDSN != "" {
+		auditStore, err := storage.NewDBAuditStorage(cfg.DatabaseDSN)
+		if err != nil {
+			fatal("Error creating DBAuditStorage", err)
+		}
+		return auditStore
+	} else if cfg.FileStoragePath != "" {
+		auditStore, err := storage.NewFileAuditStorage(cfg.FileStoragePath + ".audit")
+		if err != nil {
+			fatal("Error creating FileAuditStorage", err)
+		}
+		return auditStore
+	}
+	return storage.NewMemoryAuditStorage()
+}
+
+// initWebhookStore creates the storage of webhooks of the same kind as the storage of URLs.
+func initWebhookStore(cfg internal.Config) storage.WebhookStorage {
+	if cfg.DatabaseDSN != "" {
+		webhookStore, err := storage.NewDBWebhookStorage(cfg.DatabaseDSN)
+		if err != nil {
+			fatal("Error creating DBWebhookStorage", err)
+		}
+		return webhookStore
+	} else if cfg.FileStoragePath != "" {
+		webhookStore, err := storage.NewFileWebhookStorage(cfg.FileStoragePath + ".webhooks")
+		if err != nil {
+			fatal("Error creating FileWebhookStorage", err)
+		}
+		return webhookStore
+	}
+	return storage.NewMemoryWebhookStorage()
+}
+
+// initOutboxRelay creates OutboxRelay and its sink if the sink is configured. Events are saved into the outbox
+// by the database storage only.
+func initOutboxRelay(cfg internal.Config) *service.OutboxRelay {
+	if cfg.OutboxSink == "" {
+		return nil
+	}
+	if cfg.DatabaseDSN == "" {
+		slog.Warn("Outbox requires database storage, events are not published")
+		return nil
+	}
+	o, err := storage.NewDBOutbox(cfg.DatabaseDSN)
+	if err != nil {
+		fatal("Error creating DBOutbox", err)
+	}
+	sink, err := outbox.NewSink(cfg.OutboxSink)
+	if err != nil {
+		fatal("Error creating outbox sink", err)
+	}
+	slog.Info("Publishing outbox events", "sink", cfg.OutboxSink)
+	return service.NewOutboxRelay(o, sink, cfg.OutboxInterval)
+}
+
+// initUserGC creates UserGC if deleting of inactive users is enabled and store keeps users.
+// Users are not deleted during the migration because their URLs may be in the old storage only.
+func initUserGC(cfg internal.Config, store storage.Storage) *service.UserGC {
+	cleaner, ok := store.(storage.UserCleaner)
+	if !ok || cfg.UserInactivity <= 0 || cfg.UserGCInterval <= 0 || cfg.MigrateFromDSN != "" || cfg.MigrateFromFile != "" {
+		return nil
+	}
+	return service.NewUserGC(cleaner, cfg.UserInactivity, cfg.UserGCInterval)
+}
+
+// initMigration creates MigratingStorage if the old storage to migrate from is configured.
+func initMigration(cfg internal.Config, store storage.Storage) *storage.MigratingStorage {
+	if cfg.MigrateFromDSN == "" && cfg.MigrateFromFile == "" {
+		return nil
+	}
+	old := initStore(internal.Config{DatabaseDSN: cfg.MigrateFromDSN, FileStoragePath: cfg.MigrateFromFile})
+	slog.Info("Migrating URLs from the old storage")
+	return storage.NewMigratingStorage(old, store)
+}
+
+// initLogger sets the default logger with the configured format and level. The standard logger writes into it too.
+func initLogger(cfg internal.Config) {
+	logger, err := logging.New(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		fatal("Error while creating logger", err)
+	}
+	slog.SetDefault(logger)
+}
+
+// fatal logs the error and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	os.Exit(1)
+}
+
 func getSecret(path string) ([]byte, error) {
 	if path == "" {
 		// Only for tests.
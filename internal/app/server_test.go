@@ -0,0 +1,39 @@
+package app
+
+import (
+	"context"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestReserveErasedUsers(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "urls")
+	store, err := storage.NewCachedFileStorage(filename)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		userID, err := store.AddUser(ctx)
+		require.NoError(t, err)
+		_, err = store.AddURL(ctx, "https://ya"+strconv.Itoa(i)+".ru", userID)
+		require.NoError(t, err)
+	}
+	_, err = store.EraseUser(ctx, 2)
+	require.NoError(t, err)
+	erasures := storage.NewMemoryErasureStorage()
+	require.NoError(t, erasures.AddErasure(ctx, internal.UserErasure{UserID: 2}))
+	store.Close()
+
+	// The id of the erased user is not issued again after restart.
+	store, err = storage.NewCachedFileStorage(filename)
+	require.NoError(t, err)
+	defer store.Close()
+	reserveErasedUsers(store, erasures)
+	userID, err := store.AddUser(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, userID)
+}
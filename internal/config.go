@@ -1,5 +1,15 @@
 package internal
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
 // Config is the server configuration.
 type Config struct {
 	Address         string `env:"SERVER_ADDRESS" json:"server_address"`
@@ -7,4 +17,249 @@ type Config struct {
 	FileStoragePath string `env:"FILE_STORAGE_PATH" json:"file_storage_path"`
 	DatabaseDSN     string `env:"DATABASE_DSN" json:"database_dsn"`
 	EnableHTTPS     bool   `env:"ENABLE_HTTPS" json:"enable_https"`
+	// EnableH2C enables HTTP/2 without TLS on Address if EnableHTTPS is not set.
+	EnableH2C  bool   `env:"ENABLE_H2C" json:"enable_h2c"`
+	AdminToken string `env:"ADMIN_TOKEN" json:"admin_token"`
+	// MigrateFromFile and MigrateFromDSN set the old storage to migrate URLs from into the configured storage.
+	MigrateFromFile string `env:"MIGRATE_FROM_FILE" json:"migrate_from_file"`
+	MigrateFromDSN  string `env:"MIGRATE_FROM_DSN" json:"migrate_from_dsn"`
+	// CacheSize enables the LRU cache of redirects with up to CacheSize entries.
+	CacheSize        int           `env:"CACHE_SIZE" json:"cache_size"`
+	CacheTTL         time.Duration `env:"CACHE_TTL" json:"cache_ttl"`
+	CacheNegativeTTL time.Duration `env:"CACHE_NEGATIVE_TTL" json:"cache_negative_ttl"`
+	// DeleteQueueSize is the max number of URL ids queued for deletion.
+	DeleteQueueSize int `env:"DELETE_QUEUE_SIZE" json:"delete_queue_size"`
+	// RestoreGracePeriod is the time after deletion during which URLs can be restored.
+	RestoreGracePeriod time.Duration `env:"RESTORE_GRACE_PERIOD" json:"restore_grace_period"`
+	// DeleteRetention is the time after deletion after which URLs are purged, 0 disables purging.
+	DeleteRetention time.Duration `env:"DELETE_RETENTION" json:"delete_retention"`
+	// PurgeInterval is the interval between purges of deleted URLs.
+	PurgeInterval time.Duration `env:"PURGE_INTERVAL" json:"purge_interval"`
+	// UserInactivity is the time without activity after which users without URLs are deleted, 0 disables deleting.
+	UserInactivity time.Duration `env:"USER_INACTIVITY" json:"user_inactivity"`
+	// UserGCInterval is the interval between deletions of inactive users.
+	UserGCInterval time.Duration `env:"USER_GC_INTERVAL" json:"user_gc_interval"`
+	// WebhookMaxAttempts is the number of attempts to deliver the event after which the delivery becomes dead.
+	WebhookMaxAttempts int `env:"WEBHOOK_MAX_ATTEMPTS" json:"webhook_max_attempts"`
+	// WebhookTimeout is the timeout of the request to the webhook.
+	WebhookTimeout time.Duration `env:"WEBHOOK_TIMEOUT" json:"webhook_timeout"`
+	// WebhookRetention is the time after which delivered and dead deliveries are pruned, 0 disables pruning.
+	WebhookRetention time.Duration `env:"WEBHOOK_RETENTION" json:"webhook_retention"`
+	// OutboxSink enables the outbox of events of the database storage and sets where they are published:
+	// stdout, file:<path>, http(s) URL or nats://host:port/<subject>.
+	OutboxSink string `env:"OUTBOX_SINK" json:"outbox_sink"`
+	// OutboxInterval is the interval between publications of outbox events.
+	OutboxInterval time.Duration `env:"OUTBOX_INTERVAL" json:"outbox_interval"`
+	// LogFormat is the format of logs: json or text.
+	LogFormat string `env:"LOG_FORMAT" json:"log_format"`
+	// LogLevel is the minimal level of logged records: debug, info, warn or error.
+	LogLevel string `env:"LOG_LEVEL" json:"log_level"`
+	// TraceExporter enables tracing and sets where spans are exported: stdout, file:<path> or otlp.
+	// OTLP exporter is configured by the standard OTEL_EXPORTER_OTLP_* env vars.
+	TraceExporter string `env:"TRACE_EXPORTER" json:"trace_exporter"`
+	// HealthCacheTTL is the time during which results of health checks are reused by the readiness probe.
+	HealthCacheTTL time.Duration `env:"HEALTH_CACHE_TTL" json:"health_cache_ttl"`
+	// HealthTimeout is the timeout of every health check.
+	HealthTimeout time.Duration `env:"HEALTH_TIMEOUT" json:"health_timeout"`
+	// DrainTimeout is the time to wait for in-flight requests on shutdown before closing connections.
+	DrainTimeout time.Duration `env:"DRAIN_TIMEOUT" json:"drain_timeout"`
+	// ShutdownTimeout is the hard deadline of the whole shutdown including draining of queues and closing of storages.
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" json:"shutdown_timeout"`
+	// Listeners are the addresses the server accepts connections on. If they are empty, the server listens on Address
+	// serving public and admin routes over http or h2c, or over https and HTTP/3 if EnableHTTPS is set.
+	Listeners []Listener `env:"LISTENERS" json:"listeners"`
+	// MaxBodySize is the max size of decoded request bodies of routes without their own limits, 0 disables the limit.
+	MaxBodySize int64 `env:"MAX_BODY_SIZE" json:"max_body_size"`
+	// MaxBatchBodySize is the max size of the decoded request body of batch shortening, 0 disables the limit.
+	MaxBatchBodySize int64 `env:"MAX_BATCH_BODY_SIZE" json:"max_batch_body_size"`
+	// MaxImportBodySize is the max size of decoded request bodies of imports by users and admins, 0 disables the limit.
+	MaxImportBodySize int64 `env:"MAX_IMPORT_BODY_SIZE" json:"max_import_body_size"`
+	// MaxDecompressionRatio is the max ratio of decoded and encoded sizes of compressed request bodies,
+	// 0 disables the limit.
+	MaxDecompressionRatio int `env:"MAX_DECOMPRESSION_RATIO" json:"max_decompression_ratio"`
+}
+
+// Protocols of listeners.
+const (
+	ProtocolHTTP  = "http"
+	ProtocolHTTPS = "https"
+	// ProtocolH2C is http with HTTP/2 without TLS, used behind TLS terminating proxies.
+	ProtocolH2C = "h2c"
+	// ProtocolGRPCHealth is the probe endpoint serving only the gRPC health service and server reflection,
+	// over TLS if the certificate is set. The shortener API is not served over gRPC.
+	ProtocolGRPCHealth = "grpc-health"
+	// ProtocolGRPC is reserved for the shortener API over gRPC which is not implemented.
+	ProtocolGRPC = "grpc"
+)
+
+// Route sets served by listeners.
+const (
+	// RoutesPublic are redirects, shortening and the user API. It includes RoutesAPI.
+	RoutesPublic = "public"
+	// RoutesAPI is the user API without redirects and shortening by the plain text body.
+	RoutesAPI = "api"
+	// RoutesAdmin are administrative endpoints.
+	RoutesAdmin = "admin"
+	// RoutesDebug are pprof endpoints.
+	RoutesDebug = "debug"
+)
+
+// Errors of listener configuration.
+var (
+	ErrUnknownProtocol = errors.New("unknown protocol")
+	ErrUnknownRouteSet = errors.New("unknown route set")
+	ErrInvalidListener = errors.New("invalid listener")
+	// ErrGRPCNotSupported is returned for grpc listeners, because the shortener API is not served over gRPC.
+	ErrGRPCNotSupported = errors.New("the shortener API is not served over gRPC, use grpc-health for the gRPC health probe")
+)
+
+// Listener is the address the server accepts connections on with the protocol and the set of routes served there.
+// It is parsed from a spec [routes@]protocol://address[?options] where routes are joined by "+", e.g.
+// "admin+debug@http://127.0.0.1:9090" or "public@https://:8443?cert=cert.pem&key=key.pem", or from a JSON object.
+type Listener struct {
+	// Address is host:port, unix:<path> of the Unix domain socket or systemd:<name> of the socket passed
+	// by systemd socket activation, where name is the FileDescriptorName of the socket or its index.
+	Address string `json:"address"`
+	// Protocol is http, h2c, https or grpc-health.
+	Protocol string `json:"protocol"`
+	// Routes are the route sets served by the listener: public, api, admin or debug. Default is public.
+	// grpc-health listeners do not serve route sets.
+	Routes []string `json:"routes"`
+	// CertFile and KeyFile are the TLS certificate and key of https or grpc-health listener.
+	// The self-signed certificate is generated for https listener if they are empty, grpc-health listener serves
+	// without TLS then.
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// ClientCAFile enables verification of client certificates signed by CAs from the file.
+	ClientCAFile string `json:"client_ca_file"`
+	// MinTLSVersion is the minimal TLS version: 1.2 or 1.3. Default is 1.2.
+	MinTLSVersion string `json:"min_tls_version"`
+	// HTTP3 enables HTTP/3 over QUIC on the UDP port of https listener with the same address.
+	// It is advertised by Alt-Svc header of responses over TCP.
+	HTTP3 bool `json:"http3"`
+}
+
+// UnmarshalText parses the listener from the spec.
+func (l *Listener) UnmarshalText(text []byte) error {
+	spec := string(text)
+	var res Listener
+	if routes, rest, ok := strings.Cut(spec, "@"); ok {
+		res.Routes = strings.Split(routes, "+")
+		spec = rest
+	}
+	protocol, rest, ok := strings.Cut(spec, "://")
+	if !ok {
+		return fmt.Errorf("%w %q: protocol is required", ErrInvalidListener, text)
+	}
+	res.Protocol = protocol
+	address, options, _ := strings.Cut(rest, "?")
+	res.Address = address
+	values, err := url.ParseQuery(options)
+	if err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidListener, text, err)
+	}
+	for k := range values {
+		switch k {
+		case "cert":
+			res.CertFile = values.Get(k)
+		case "key":
+			res.KeyFile = values.Get(k)
+		case "client_ca":
+			res.ClientCAFile = values.Get(k)
+		case "min_tls":
+			res.MinTLSVersion = values.Get(k)
+		case "http3":
+			res.HTTP3, err = strconv.ParseBool(values.Get(k))
+			if err != nil {
+				return fmt.Errorf("%w %q: invalid http3 option", ErrInvalidListener, text)
+			}
+		default:
+			return fmt.Errorf("%w %q: unknown option %s", ErrInvalidListener, text, k)
+		}
+	}
+	if err := res.validate(); err != nil {
+		return err
+	}
+	*l = res
+	return nil
+}
+
+// UnmarshalJSON parses the listener from the spec string or the JSON object.
+func (l *Listener) UnmarshalJSON(data []byte) error {
+	var spec string
+	if json.Unmarshal(data, &spec) == nil {
+		return l.UnmarshalText([]byte(spec))
+	}
+	type plain Listener
+	var res plain
+	if err := json.Unmarshal(data, &res); err != nil {
+		return err
+	}
+	if err := Listener(res).validate(); err != nil {
+		return err
+	}
+	*l = Listener(res)
+	return nil
+}
+
+// String returns the spec of the listener.
+func (l Listener) String() string {
+	if l.Protocol == ProtocolGRPCHealth && len(l.Routes) == 0 {
+		return l.Protocol + "://" + l.Address
+	}
+	return strings.Join(l.RouteSets(), "+") + "@" + l.Protocol + "://" + l.Address
+}
+
+// RouteSets returns the route sets of the listener, public if they are not set.
+func (l Listener) RouteSets() []string {
+	if len(l.Routes) == 0 {
+		return []string{RoutesPublic}
+	}
+	return l.Routes
+}
+
+func (l Listener) validate() error {
+	switch l.Protocol {
+	case ProtocolHTTP, ProtocolH2C:
+	case ProtocolHTTPS, ProtocolGRPCHealth:
+		if (l.CertFile == "") != (l.KeyFile == "") {
+			return fmt.Errorf("%w %s: both cert and key files are required", ErrInvalidListener, l)
+		}
+	case ProtocolGRPC:
+		return fmt.Errorf("%w: %s", ErrGRPCNotSupported, l)
+	default:
+		return fmt.Errorf("%w %q of listener %s", ErrUnknownProtocol, l.Protocol, l)
+	}
+	if (l.Protocol == ProtocolHTTP || l.Protocol == ProtocolH2C) &&
+		(l.CertFile != "" || l.ClientCAFile != "" || l.MinTLSVersion != "") {
+		return fmt.Errorf("%w %s: TLS options require https or grpc-health", ErrInvalidListener, l)
+	}
+	if l.Protocol == ProtocolGRPCHealth && l.CertFile == "" && (l.ClientCAFile != "" || l.MinTLSVersion != "") {
+		return fmt.Errorf("%w %s: TLS options of grpc-health listener require cert and key files", ErrInvalidListener, l)
+	}
+	if l.Protocol == ProtocolGRPCHealth && len(l.Routes) > 0 {
+		return fmt.Errorf("%w %s: grpc-health listener does not serve route sets", ErrInvalidListener, l)
+	}
+	if l.HTTP3 && l.Protocol != ProtocolHTTPS {
+		return fmt.Errorf("%w %s: http3 requires https", ErrInvalidListener, l)
+	}
+	if l.HTTP3 && (strings.HasPrefix(l.Address, "unix:") || strings.HasPrefix(l.Address, "systemd:")) {
+		return fmt.Errorf("%w %s: http3 requires host:port address", ErrInvalidListener, l)
+	}
+	switch l.MinTLSVersion {
+	case "", "1.2", "1.3":
+	default:
+		return fmt.Errorf("%w %s: unsupported TLS version %s", ErrInvalidListener, l, l.MinTLSVersion)
+	}
+	if l.Address == "" {
+		return fmt.Errorf("%w %s: address is required", ErrInvalidListener, l)
+	}
+	for _, r := range l.Routes {
+		switch r {
+		case RoutesPublic, RoutesAPI, RoutesAdmin, RoutesDebug:
+		default:
+			return fmt.Errorf("%w %q of listener %s", ErrUnknownRouteSet, r, l)
+		}
+	}
+	return nil
 }
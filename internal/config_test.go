@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestListenerUnmarshalText(t *testing.T) {
+	var l Listener
+	require.NoError(t, l.UnmarshalText([]byte("admin+debug@https://unix:/run/admin.sock?cert=c.pem&key=k.pem&min_tls=1.3")))
+	assert.Equal(t, Listener{
+		Address:       "unix:/run/admin.sock",
+		Protocol:      ProtocolHTTPS,
+		Routes:        []string{RoutesAdmin, RoutesDebug},
+		CertFile:      "c.pem",
+		KeyFile:       "k.pem",
+		MinTLSVersion: "1.3",
+	}, l)
+	assert.Equal(t, "admin+debug@https://unix:/run/admin.sock", l.String())
+
+	require.NoError(t, l.UnmarshalText([]byte("https://:8443?http3=true")))
+	assert.True(t, l.HTTP3)
+
+	require.NoError(t, l.UnmarshalText([]byte("h2c://systemd:public")))
+	assert.Equal(t, []string{RoutesPublic}, l.RouteSets())
+
+	require.NoError(t, l.UnmarshalText([]byte("grpc-health://127.0.0.1:9090")))
+	assert.Equal(t, Listener{Address: "127.0.0.1:9090", Protocol: ProtocolGRPCHealth}, l)
+
+	for spec, want := range map[string]error{
+		"localhost:8080":                  ErrInvalidListener,
+		"ftp://:21":                       ErrUnknownProtocol,
+		"grpc://:9090":                    ErrGRPCNotSupported,
+		"public@grpc-health://:9090":      ErrInvalidListener,
+		"grpc-health://:9090?min_tls=1.3": ErrInvalidListener,
+		"http://:8080?http3=true":         ErrInvalidListener,
+		"metrics@http://:9090":            ErrUnknownRouteSet,
+		"http://:8080?cert=c.pem":         ErrInvalidListener,
+		"https://:8443?cert=c.pem":        ErrInvalidListener,
+		"https://:8443?min_tls=1.1":       ErrInvalidListener,
+		"https://:8443?unknown=1":         ErrInvalidListener,
+		"http://":                         ErrInvalidListener,
+	} {
+		require.ErrorIs(t, l.UnmarshalText([]byte(spec)), want, spec)
+	}
+}
+
+func TestListenerUnmarshalJSON(t *testing.T) {
+	var cfg Config
+	require.NoError(t, json.Unmarshal([]byte(`{"listeners": [
+		"api@http://:8080",
+		{"address": "127.0.0.1:9090", "protocol": "http", "routes": ["admin"]}
+	]}`), &cfg))
+	assert.Equal(t, []Listener{
+		{Address: ":8080", Protocol: ProtocolHTTP, Routes: []string{RoutesAPI}},
+		{Address: "127.0.0.1:9090", Protocol: ProtocolHTTP, Routes: []string{RoutesAdmin}},
+	}, cfg.Listeners)
+
+	require.ErrorIs(t, json.Unmarshal([]byte(`{"listeners": [{"address": ":9090", "protocol": "grpc"}]}`), &cfg),
+		ErrGRPCNotSupported)
+	require.NoError(t, json.Unmarshal([]byte(`{"listeners": [{"address": ":9090", "protocol": "grpc-health"}]}`), &cfg))
+	assert.Equal(t, []Listener{{Address: ":9090", Protocol: ProtocolGRPCHealth}}, cfg.Listeners)
+	assert.Equal(t, "grpc-health://:9090", cfg.Listeners[0].String())
+}
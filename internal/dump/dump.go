@@ -0,0 +1,238 @@
+// Package dump reads and writes stored URLs as JSON Lines or CSV.
+// It is used to move URLs between storages keeping their ids.
+package dump
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Dump formats.
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+const importBatchSize = 1000
+
+// Dump errors
+var (
+	ErrUnknownFormat = errors.New("unknown dump format")
+	ErrBadRecord     = errors.New("bad record")
+)
+
+// csvHeader is the header of CSV dumps. Dumps without the last column deleted_at are also readable.
+var csvHeader = []string{"id", "user_id", "original_url", "is_deleted", "created_at", "deleted_at"}
+
+// Writer writes URL records.
+type Writer interface {
+	// Write writes one record.
+	Write(rec internal.URLRecord) error
+	// Flush writes buffered data to the underlying writer.
+	Flush() error
+}
+
+// Reader reads URL records.
+type Reader interface {
+	// Read returns the next record or io.EOF if there are no more records.
+	Read() (internal.URLRecord, error)
+}
+
+// NewWriter creates Writer for the format.
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatJSONL:
+		bw := bufio.NewWriter(w)
+		return &jsonlWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+// NewReader creates Reader for the format.
+func NewReader(r io.Reader, format string) (Reader, error) {
+	switch format {
+	case FormatJSONL:
+		return &jsonlReader{dec: json.NewDecoder(r)}, nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		// the number of fields is taken from the header
+		cr.FieldsPerRecord = 0
+		return &csvReader{r: cr}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+// ContentType returns the MIME type of the format.
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv"
+	}
+	return "application/x-ndjson"
+}
+
+// Export writes all URLs from store into w. Returns the number of written URLs.
+func Export(ctx context.Context, store storage.Storage, w Writer) (int, error) {
+	var count int
+	err := store.Export(ctx, func(rec internal.URLRecord) error {
+		count++
+		return w.Write(rec)
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, w.Flush()
+}
+
+// Import reads all records from r and saves them into store in batches.
+// Returns the number of read and the number of saved URLs.
+func Import(ctx context.Context, store storage.Storage, r Reader) (int, int, error) {
+	var read, imported int
+	batch := make([]internal.URLRecord, 0, importBatchSize)
+	flush := func() error {
+		n, err := store.Import(ctx, batch)
+		imported += n
+		batch = batch[:0]
+		return err
+	}
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return read, imported, fmt.Errorf("%w %d: %w", ErrBadRecord, read+1, err)
+		}
+		read++
+		batch = append(batch, rec)
+		if len(batch) == importBatchSize {
+			if err = flush(); err != nil {
+				return read, imported, err
+			}
+		}
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return read, imported, err
+		}
+	}
+	return read, imported, nil
+}
+
+type jsonlWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) Write(rec internal.URLRecord) error {
+	return j.enc.Encode(rec)
+}
+
+func (j *jsonlWriter) Flush() error {
+	return j.w.Flush()
+}
+
+type jsonlReader struct {
+	dec *json.Decoder
+}
+
+func (j *jsonlReader) Read() (internal.URLRecord, error) {
+	var rec internal.URLRecord
+	err := j.dec.Decode(&rec)
+	return rec, err
+}
+
+type csvWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (c *csvWriter) Write(rec internal.URLRecord) error {
+	if !c.headerWritten {
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+		c.headerWritten = true
+	}
+	var deletedAt string
+	if !rec.DeletedAt.IsZero() {
+		deletedAt = rec.DeletedAt.Format(time.RFC3339Nano)
+	}
+	return c.w.Write([]string{
+		strconv.Itoa(rec.ID),
+		strconv.Itoa(rec.UserID),
+		rec.OriginalURL,
+		strconv.FormatBool(rec.IsDeleted),
+		rec.CreatedAt.Format(time.RFC3339Nano),
+		deletedAt,
+	})
+}
+
+func (c *csvWriter) Flush() error {
+	if !c.headerWritten {
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+		c.headerWritten = true
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type csvReader struct {
+	r          *csv.Reader
+	headerRead bool
+}
+
+func (c *csvReader) Read() (internal.URLRecord, error) {
+	if !c.headerRead {
+		header, err := c.r.Read()
+		if err != nil {
+			return internal.URLRecord{}, err
+		}
+		if header[0] != csvHeader[0] || len(header) < len(csvHeader)-1 {
+			return internal.URLRecord{}, fmt.Errorf("wrong csv header: %v", header)
+		}
+		c.headerRead = true
+	}
+	fields, err := c.r.Read()
+	if err != nil {
+		return internal.URLRecord{}, err
+	}
+	var rec internal.URLRecord
+	rec.ID, err = strconv.Atoi(fields[0])
+	if err != nil {
+		return rec, err
+	}
+	rec.UserID, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return rec, err
+	}
+	rec.OriginalURL = fields[2]
+	rec.IsDeleted, err = strconv.ParseBool(fields[3])
+	if err != nil {
+		return rec, err
+	}
+	if fields[4] != "" {
+		rec.CreatedAt, err = time.Parse(time.RFC3339Nano, fields[4])
+		if err != nil {
+			return rec, err
+		}
+	}
+	if len(fields) > 5 && fields[5] != "" {
+		rec.DeletedAt, err = time.Parse(time.RFC3339Nano, fields[5])
+	}
+	return rec, err
+}
@@ -0,0 +1,133 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	createdAt := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	records := []internal.URLRecord{
+		{ID: 3, UserID: 1, OriginalURL: "https://ya.ru", CreatedAt: createdAt},
+		{ID: 7, UserID: 2, OriginalURL: "https://google.com", IsDeleted: true, CreatedAt: createdAt,
+			DeletedAt: createdAt.Add(time.Hour)},
+		{ID: 8, UserID: 1, OriginalURL: "https://example.com?a=1,b=2", CreatedAt: createdAt},
+	}
+	for _, format := range []string{FormatJSONL, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			src := storage.NewMemoryStorage()
+			n, err := src.Import(context.Background(), records)
+			require.NoError(t, err)
+			require.Equal(t, len(records), n)
+
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, format)
+			require.NoError(t, err)
+			count, err := Export(context.Background(), src, w)
+			require.NoError(t, err)
+			assert.Equal(t, len(records), count)
+
+			dst, err := storage.NewCachedFileStorage(filepath.Join(t.TempDir(), "urls"))
+			require.NoError(t, err)
+			defer dst.Close()
+			r, err := NewReader(&buf, format)
+			require.NoError(t, err)
+			read, imported, err := Import(context.Background(), dst, r)
+			require.NoError(t, err)
+			assert.Equal(t, len(records), read)
+			assert.Equal(t, len(records), imported)
+
+			var got []internal.URLRecord
+			err = dst.Export(context.Background(), func(rec internal.URLRecord) error {
+				got = append(got, rec)
+				return nil
+			})
+			require.NoError(t, err)
+			for i := range got {
+				assert.True(t, records[i].CreatedAt.Equal(got[i].CreatedAt))
+				got[i].CreatedAt = records[i].CreatedAt
+				assert.True(t, records[i].DeletedAt.Equal(got[i].DeletedAt))
+				got[i].DeletedAt = records[i].DeletedAt
+			}
+			assert.Equal(t, records, got)
+
+			url, err := dst.GetURL(context.Background(), "3")
+			require.NoError(t, err)
+			assert.Equal(t, "https://ya.ru", url)
+			_, err = dst.GetURL(context.Background(), "7")
+			assert.ErrorIs(t, err, storage.ErrDeleted)
+
+			id, err := dst.AddURL(context.Background(), "https://new.ru", 1)
+			require.NoError(t, err)
+			assert.Equal(t, 9, id)
+			userID, err := dst.AddUser(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, 3, userID)
+		})
+	}
+}
+
+func TestImportSkipsExisting(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	_, err := store.AddURL(context.Background(), "https://ya.ru", 1)
+	require.NoError(t, err)
+
+	r, err := NewReader(bytes.NewBufferString(
+		`{"id":0,"user_id":2,"original_url":"https://other.ru"}`+"\n"+
+			`{"id":5,"user_id":2,"original_url":"https://ya.ru"}`+"\n"+
+			`{"id":6,"user_id":2,"original_url":"https://new.ru"}`+"\n"), FormatJSONL)
+	require.NoError(t, err)
+	read, imported, err := Import(context.Background(), store, r)
+	require.NoError(t, err)
+	assert.Equal(t, 3, read)
+	assert.Equal(t, 1, imported)
+
+	_, err = store.GetURL(context.Background(), "5")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestReaderErrors(t *testing.T) {
+	_, err := NewReader(bytes.NewBufferString(""), "xml")
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+
+	r, err := NewReader(bytes.NewBufferString("id,user_id,original_url,is_deleted,created_at\nx,1,https://ya.ru,false,\n"), FormatCSV)
+	require.NoError(t, err)
+	_, _, err = Import(context.Background(), storage.NewMemoryStorage(), r)
+	assert.ErrorIs(t, err, ErrBadRecord)
+}
+
+func TestImportIDOutOfRange(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{name: "Huge gap", line: `{"id":100000000,"user_id":1,"original_url":"https://ya.ru"}`},
+		{name: "Id over int32", line: `{"id":4294967296,"user_id":1,"original_url":"https://ya.ru"}`},
+		{name: "User over int32", line: `{"id":1,"user_id":4294967296,"original_url":"https://ya.ru"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := storage.NewCachedFileStorage(filepath.Join(t.TempDir(), "urls"))
+			require.NoError(t, err)
+			defer file.Close()
+			stores := []storage.Storage{storage.NewMemoryStorage()}
+			if tt.name != "Huge gap" {
+				stores = append(stores, file)
+			}
+			for _, store := range stores {
+				r, err := NewReader(bytes.NewBufferString(tt.line+"\n"), FormatJSONL)
+				require.NoError(t, err)
+				_, imported, err := Import(context.Background(), store, r)
+				assert.ErrorIs(t, err, storage.ErrIDOutOfRange)
+				assert.Equal(t, 0, imported)
+			}
+		})
+	}
+}
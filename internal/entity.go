@@ -1,5 +1,7 @@
 package internal
 
+import "time"
+
 // CorrIDOriginalURL contains original URL and its correlation_id.
 type CorrIDOriginalURL struct {
 	CorrID      string `json:"correlation_id"`
@@ -10,10 +12,256 @@ type CorrIDOriginalURL struct {
 type CorrIDUrlID struct {
 	CorrID string
 	URLID  int
+	// Existed is true if the URL had been shortened before, URLID is the id of the existing URL.
+	Existed bool
+	// Err is set if the URL was not saved.
+	Err error
+}
+
+// Statuses of URLs from the batch.
+const (
+	BatchCreated  = "created"
+	BatchExisting = "existing"
+	BatchInvalid  = "invalid"
+	BatchFailed   = "failed"
+)
+
+// BatchResult contains the result of shortening of the URL from the batch.
+type BatchResult struct {
+	CorrID string
+	// URLID is the id of the created or the existing URL.
+	URLID  int
+	Status string
+	// Reason explains why the URL is invalid or failed.
+	Reason string
 }
 
 // IDToDelete contains shortened url ID for deletion and user ID from the request.
 type IDToDelete struct {
 	ID     int
 	UserID int
+	// OpID is the id of the deletion operation which queued the URL.
+	OpID string
+	// Attempts is the number of failed attempts to delete the URL from the queue.
+	Attempts int
+}
+
+// Statuses of URL ids from the request to delete or to restore URLs.
+const (
+	DeleteQueued         = "queued"
+	DeleteRejected       = "rejected"
+	DeleteDeleted        = "deleted"
+	DeleteAlreadyDeleted = "already_deleted"
+	DeleteNotFound       = "not_found"
+	DeleteNotOwner       = "not_owner"
+	DeleteRestored       = "restored"
+	DeleteNotDeleted     = "not_deleted"
+	DeleteExpired        = "expired"
+	DeleteFailed         = "failed"
+)
+
+// DeleteResult contains the status of the URL id after its deletion or restoration.
+type DeleteResult struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"`
+}
+
+// Deletion operation statuses.
+const (
+	OperationPending = "pending"
+	OperationDone    = "done"
+)
+
+// DeleteOperation contains the state of the request to delete URLs.
+// It is done when there are no queued ids left.
+type DeleteOperation struct {
+	ID     string `json:"id"`
+	UserID int    `json:"-"`
+	// Request is the request which created the operation. It is saved with audit events of the deletion.
+	Request   RequestMeta    `json:"-"`
+	Status    string         `json:"status"`
+	Results   []DeleteResult `json:"results"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// URLRecord contains all stored data of a shortened URL. It is used to move URLs between storages.
+type URLRecord struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	OriginalURL string    `json:"original_url"`
+	IsDeleted   bool      `json:"is_deleted"`
+	CreatedAt   time.Time `json:"created_at"`
+	// DeletedAt is zero if the URL is not deleted.
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// Import job statuses.
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// ImportJob contains the state of the asynchronous bulk import of URLs.
+// The running job is leased by the worker which claimed it: LeaseID identifies the claim and updates of another
+// claim are rejected, LeaseUntil is the time after which the job can be claimed again.
+type ImportJob struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"-"`
+	Status     string    `json:"status"`
+	Total      int       `json:"total"`
+	Processed  int       `json:"processed"`
+	Created    int       `json:"created"`
+	Existing   int       `json:"existing"`
+	Invalid    int       `json:"invalid"`
+	Failed     int       `json:"failed"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	LeaseID    string    `json:"-"`
+	LeaseUntil time.Time `json:"-"`
+}
+
+// JobItem contains the URL from the line of the imported file and the result of its shortening.
+// Status is empty until the item is processed.
+type JobItem struct {
+	Line        int    `json:"line"`
+	CorrID      string `json:"correlation_id"`
+	OriginalURL string `json:"original_url"`
+	URLID       int    `json:"url_id,omitempty"`
+	Status      string `json:"status,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// UserErasure is the audit record of the erasure of the user on its request.
+// Tokens of erased users are revoked.
+type UserErasure struct {
+	UserID    int       `json:"user_id"`
+	RequestID string    `json:"request_id,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Links     int       `json:"links"`
+	ErasedAt  time.Time `json:"erased_at"`
+}
+
+// RequestMeta identifies the HTTP request which caused changes of URLs.
+type RequestMeta struct {
+	RequestID string `json:"request_id,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	// TraceParent is the W3C traceparent of the span of the request, so that asynchronous work can be linked to it.
+	TraceParent string `json:"trace_parent,omitempty"`
+}
+
+// Audit event actions. Users can not change URLs after creation, so the update event is recorded
+// when the service changes the URL itself: the original URL of the deleted URL is purged after the retention period.
+const (
+	AuditCreate        = "create"
+	AuditBatchCreate   = "batch_create"
+	AuditUpdate        = "update"
+	AuditDeleteRequest = "delete_request"
+	AuditDelete        = "delete"
+	AuditRestore       = "restore"
+	AuditErase         = "erase"
+)
+
+// AuditEvent is the record of the audit log of the change of the URL.
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	Action    string    `json:"action"`
+	URLID     int       `json:"url_id"`
+	UserID    int       `json:"user_id"`
+	RequestID string    `json:"request_id,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// AuditFilter selects audit events. Nil or zero fields do not filter.
+// From is inclusive and To is exclusive. Only events with id lower than BeforeID are selected if it is set.
+type AuditFilter struct {
+	URLID    *int
+	UserID   *int
+	From     time.Time
+	To       time.Time
+	BeforeID int64
+	Limit    int
+}
+
+// Match reports whether the event satisfies the filter ignoring Limit.
+func (f AuditFilter) Match(e AuditEvent) bool {
+	return (f.URLID == nil || *f.URLID == e.URLID) &&
+		(f.UserID == nil || *f.UserID == e.UserID) &&
+		(f.From.IsZero() || !e.Time.Before(f.From)) &&
+		(f.To.IsZero() || e.Time.Before(f.To)) &&
+		(f.BeforeID == 0 || e.ID < f.BeforeID)
+}
+
+// Webhook event types.
+const (
+	EventLinkCreated = "link.created"
+	EventLinkDeleted = "link.deleted"
+	EventLinkClicked = "link.clicked"
+)
+
+// Webhook is the endpoint of the user which receives events of its URLs.
+// Secret is used to sign payloads and is returned only when the webhook is created.
+type Webhook struct {
+	ID        string    `json:"id"`
+	UserID    int       `json:"-"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LinkEvent is the payload which is posted to webhooks.
+type LinkEvent struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	URLID    int       `json:"url_id"`
+	ShortURL string    `json:"short_url"`
+	UserID   int       `json:"-"`
+	Time     time.Time `json:"time"`
+}
+
+// Webhook delivery statuses. Failed deliveries are retried until they become dead.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+	DeliveryDead      = "dead"
+)
+
+// WebhookDelivery is the attempt to post the event to the webhook with the result of the last attempt.
+type WebhookDelivery struct {
+	ID            string    `json:"id"`
+	WebhookID     string    `json:"webhook_id"`
+	Event         LinkEvent `json:"event"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	StatusCode    int       `json:"status_code,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Types of outbox events which are not published to webhooks.
+const (
+	EventLinkRestored = "link.restored"
+	// EventLinkPurged is saved when the deleted URL is purged after the retention period.
+	EventLinkPurged = "link.purged"
+	// EventLinkErased is saved for every URL of the erased user. Pending events of the user are removed.
+	EventLinkErased = "link.erased"
+)
+
+// OutboxEvent is the event of the change of the URL saved in the same transaction as the change.
+// Events of the same URL are published in ascending order of id.
+type OutboxEvent struct {
+	ID          int64     `json:"id"`
+	Type        string    `json:"type"`
+	URLID       int       `json:"url_id"`
+	UserID      int       `json:"user_id"`
+	OriginalURL string    `json:"original_url,omitempty"`
+	Time        time.Time `json:"time"`
 }
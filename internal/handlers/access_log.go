@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// redacted replaces values of query parameters in the access log.
+const redacted = "REDACTED"
+
+// accessLog creates the set of logging attributes of the request with its id and trace id and logs the request after it is served.
+// The user id is added to the set by handlers, so it is logged too. Values of query parameters are redacted
+// because they may contain tokens or personal data.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		ctx := logging.NewContext(req.Context(), logging.RequestIDKey, middleware.GetReqID(req.Context()))
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			logging.Set(ctx, logging.TraceIDKey, sc.TraceID().String())
+		}
+		ww := middleware.NewWrapResponseWriter(writer, req.ProtoMajor)
+		start := time.Now()
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			slog.LogAttrs(ctx, level, "Request served",
+				slog.String("method", req.Method),
+				slog.String("path", req.URL.Path),
+				slog.String("query", redactQuery(req.URL.RawQuery)),
+				slog.String("proto", req.Proto),
+				slog.String("remote_addr", req.RemoteAddr),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Duration("duration", time.Since(start)),
+			)
+		}()
+		next.ServeHTTP(ww, req.WithContext(ctx))
+	})
+}
+
+// redactQuery returns the query string with the values of parameters replaced by REDACTED.
+func redactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return redacted
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = url.QueryEscape(k) + "=" + redacted
+	}
+	return strings.Join(parts, "&")
+}
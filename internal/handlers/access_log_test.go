@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/MalyginaEkaterina/shortener/internal/service"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedactQuery(t *testing.T) {
+	assert.Equal(t, "", redactQuery(""))
+	assert.Equal(t, "a=REDACTED&token=REDACTED", redactQuery("token=secret&a=1&a=2"))
+	assert.Equal(t, redacted, redactQuery("%zz"))
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.FormatJSON, "info")
+	require.NoError(t, err)
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	store := storage.NewMemoryStorage()
+	signer := Signer{SecretKey: []byte("my secret key")}
+	token, err := signer.CreateSign(7)
+	require.NoError(t, err)
+	cfg := internal.Config{Address: ":8080", BaseURL: "http://localhost:8080"}
+	r := NewRouter(store, cfg, signer, service.URLService{Store: store},
+		service.NewDeleteWorker(store, storage.NewMemoryDeleteQueue(2), storage.NewMemoryOperationStorage(), nil, nil))
+
+	request := httptest.NewRequest(http.MethodGet, "/api/user/urls?token=secret", nil)
+	request.AddCookie(&http.Cookie{Name: "token", Value: token})
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+
+	assert.NotContains(t, buf.String(), "secret")
+	var lines []map[string]any
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		var line map[string]any
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	require.Len(t, lines, 1)
+	assert.Equal(t, "Request served", lines[0]["msg"])
+	assert.Equal(t, "/api/user/urls", lines[0]["path"])
+	assert.Equal(t, "token=REDACTED", lines[0]["query"])
+	assert.Equal(t, float64(http.StatusNoContent), lines[0]["status"])
+	assert.Equal(t, float64(7), lines[0][logging.UserIDKey])
+	assert.NotEmpty(t, lines[0][logging.RequestIDKey])
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/apierror"
+	"github.com/MalyginaEkaterina/shortener/internal/dump"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/MalyginaEkaterina/shortener/internal/service"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Admin contains dependencies of administrative endpoints.
+type Admin struct {
+	Store storage.Storage
+	// Token is required in header "Authorization: Bearer <Token>". Administrative endpoints are disabled if it is empty.
+	Token string
+	// Migration is set if URLs are being migrated between storages.
+	Migration *storage.MigratingStorage
+	// Cache is set if redirects are cached.
+	Cache *storage.LRUStorage
+	// UserGC is set if inactive users are deleted.
+	UserGC *service.UserGC
+	// Audit is the audit log of changes of URLs.
+	Audit storage.AuditStorage
+	// MaxImportBodySize is the limit of the request body of the import, 0 disables the limit.
+	MaxImportBodySize int64
+}
+
+const maxAuditLimit = 1000
+
+// ImportResponse contains the result of the import.
+type ImportResponse struct {
+	Read     int `json:"read"`
+	Imported int `json:"imported"`
+}
+
+// NewAdminRouter creates chi Router with administrative endpoints.
+func NewAdminRouter(admin Admin) chi.Router {
+	r := chi.NewRouter()
+	r.Use(admin.checkToken)
+	r.Get("/export", admin.Export)
+	r.With(bodyLimit(admin.MaxImportBodySize)).Post("/import", admin.Import)
+	r.Get("/migration", admin.MigrationProgress)
+	r.Get("/cache", admin.CacheStats)
+	r.Get("/users", admin.UserGCStats)
+	r.Get("/audit", admin.AuditEvents)
+	return r
+}
+
+func (a Admin) checkToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		if a.Token == "" {
+			writeError(writer, req, apierror.AdminDisabled, "")
+			return
+		}
+		auth := req.Header.Get("Authorization")
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if token == auth || subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+			writeError(writer, req, apierror.Unauthorized, "")
+			return
+		}
+		next.ServeHTTP(writer, req)
+	})
+}
+
+// Trailers of the export. The status is sent before URLs are read from the storage, so the outcome of the export
+// is known from the trailers only.
+const (
+	exportCountTrailer = "X-Export-Count"
+	exportErrorTrailer = "X-Export-Error"
+)
+
+// Export streams all stored URLs with their ids, owners, deleted flags and timestamps.
+// Query parameter format is jsonl (default) or csv. Trailer X-Export-Count contains the number of exported URLs
+// if the dump is complete, X-Export-Error is set instead if the export failed in the middle.
+func (a Admin) Export(writer http.ResponseWriter, req *http.Request) {
+	format := dumpFormat(req)
+	w, err := dump.NewWriter(writer, format)
+	if err != nil {
+		writeError(writer, req, apierror.InvalidRequest, err.Error())
+		return
+	}
+	writer.Header().Set("Content-Type", dump.ContentType(format))
+	writer.Header().Set("Content-Disposition", "attachment; filename=urls."+format)
+	writer.Header().Set("Trailer", exportCountTrailer+", "+exportErrorTrailer)
+	writer.WriteHeader(http.StatusOK)
+	// the body is streamed, so it has no Content-Length which would not allow trailers
+	http.NewResponseController(writer).Flush()
+	count, err := dump.Export(req.Context(), a.Store, w)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error while exporting URLs", logging.Err(err))
+		writer.Header().Set(exportErrorTrailer, "export is incomplete")
+		return
+	}
+	writer.Header().Set(exportCountTrailer, strconv.Itoa(count))
+}
+
+// Import saves URLs from the request body keeping their ids and returns the number of read and saved URLs.
+// Query parameter format is jsonl (default) or csv.
+func (a Admin) Import(writer http.ResponseWriter, req *http.Request) {
+	r, err := dump.NewReader(req.Body, dumpFormat(req))
+	if err != nil {
+		writeError(writer, req, apierror.InvalidRequest, err.Error())
+		return
+	}
+	read, imported, err := dump.Import(req.Context(), a.Store, r)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error while importing URLs", logging.Err(err))
+		if bodyTooLarge(err) {
+			bodyError(writer, req, err)
+		} else if errors.Is(err, dump.ErrBadRecord) || errors.Is(err, storage.ErrIDOutOfRange) {
+			writeError(writer, req, apierror.MalformedBody, err.Error())
+		} else {
+			writeError(writer, req, apierror.Internal, "")
+		}
+		return
+	}
+	marshalResponseAndSetCookie(writer, http.StatusOK, nil, ImportResponse{Read: read, Imported: imported})
+}
+
+// MigrationProgress returns the progress of the migration between storages.
+// Returns status 404 if there is no migration.
+func (a Admin) MigrationProgress(writer http.ResponseWriter, req *http.Request) {
+	if a.Migration == nil {
+		writeError(writer, req, apierror.FeatureDisabled, "There is no migration")
+		return
+	}
+	marshalResponseAndSetCookie(writer, http.StatusOK, nil, a.Migration.Progress())
+}
+
+// CacheStats returns hit and miss counters of the redirect cache.
+// Returns status 404 if cache is disabled.
+func (a Admin) CacheStats(writer http.ResponseWriter, req *http.Request) {
+	if a.Cache == nil {
+		writeError(writer, req, apierror.FeatureDisabled, "Cache is disabled")
+		return
+	}
+	marshalResponseAndSetCookie(writer, http.StatusOK, nil, a.Cache.Stats())
+}
+
+// UserGCStats returns counters of deleted inactive users.
+// Returns status 404 if deleting of inactive users is disabled.
+func (a Admin) UserGCStats(writer http.ResponseWriter, req *http.Request) {
+	if a.UserGC == nil {
+		writeError(writer, req, apierror.FeatureDisabled, "Deleting of inactive users is disabled")
+		return
+	}
+	marshalResponseAndSetCookie(writer, http.StatusOK, nil, a.UserGC.Stats())
+}
+
+// AuditEvents returns events of the audit log in descending order of id.
+// Query parameters url_id and user_id filter by the URL and by the user, from (inclusive) and to (exclusive)
+// filter by the time in RFC 3339 format, limit is the maximum number of events and before is the id of the event
+// to continue from. Returns status 404 if the audit log is disabled.
+func (a Admin) AuditEvents(writer http.ResponseWriter, req *http.Request) {
+	if a.Audit == nil {
+		writeError(writer, req, apierror.FeatureDisabled, "Audit log is disabled")
+		return
+	}
+	filter, err := parseAuditFilter(req.URL.Query())
+	if err != nil {
+		writeError(writer, req, apierror.InvalidRequest, err.Error())
+		return
+	}
+	events, err := a.Audit.GetEvents(req.Context(), filter)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error while getting audit events", logging.Err(err))
+		writeError(writer, req, apierror.Internal, "")
+		return
+	}
+	if events == nil {
+		events = []internal.AuditEvent{}
+	}
+	marshalResponseAndSetCookie(writer, http.StatusOK, nil, events)
+}
+
+func parseAuditFilter(query url.Values) (internal.AuditFilter, error) {
+	var filter internal.AuditFilter
+	intParam := func(name string) (*int, error) {
+		v := query.Get(name)
+		if v == "" {
+			return nil, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an integer", name)
+		}
+		return &n, nil
+	}
+	timeParam := func(name string) (time.Time, error) {
+		v := query.Get(name)
+		if v == "" {
+			return time.Time{}, nil
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s must be a time in RFC 3339 format", name)
+		}
+		return t, nil
+	}
+	var err error
+	if filter.URLID, err = intParam("url_id"); err != nil {
+		return filter, err
+	}
+	if filter.UserID, err = intParam("user_id"); err != nil {
+		return filter, err
+	}
+	if filter.From, err = timeParam("from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = timeParam("to"); err != nil {
+		return filter, err
+	}
+	limit, err := intParam("limit")
+	if err != nil {
+		return filter, err
+	}
+	if limit != nil {
+		if *limit <= 0 || *limit > maxAuditLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxAuditLimit)
+		}
+		filter.Limit = *limit
+	}
+	before, err := intParam("before")
+	if err != nil {
+		return filter, err
+	}
+	if before != nil {
+		filter.BeforeID = int64(*before)
+	}
+	return filter, nil
+}
+
+func dumpFormat(req *http.Request) string {
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		return dump.FormatJSONL
+	}
+	return format
+}
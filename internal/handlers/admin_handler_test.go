@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/service"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAdminAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		header     string
+		statusCode int
+	}{
+		{name: "Disabled without token", token: "", header: "Bearer ", statusCode: 403},
+		{name: "Missing header", token: "admin", header: "", statusCode: 401},
+		{name: "Wrong token", token: "admin", header: "Bearer wrong", statusCode: 401},
+		{name: "Token without Bearer", token: "admin", header: "admin", statusCode: 401},
+		{name: "Correct token", token: "admin", header: "Bearer admin", statusCode: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewAdminRouter(Admin{Store: storage.NewMemoryStorage(), Token: tt.token})
+			request := httptest.NewRequest(http.MethodGet, "/export", nil)
+			if tt.header != "" {
+				request.Header.Set("Authorization", tt.header)
+			}
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, request)
+			assert.Equal(t, tt.statusCode, resp.Code)
+		})
+	}
+}
+
+func TestAdminExportImport(t *testing.T) {
+	src := storage.NewMemoryStorage()
+	_, err := src.AddURL(context.Background(), "https://ya.ru", 1)
+	require.NoError(t, err)
+	_, err = src.AddURL(context.Background(), "https://google.com", 2)
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodGet, "/export?format=csv", nil)
+	request.Header.Set("Authorization", "Bearer admin")
+	resp := httptest.NewRecorder()
+	NewAdminRouter(Admin{Store: src, Token: "admin"}).ServeHTTP(resp, request)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "text/csv", resp.Header().Get("Content-Type"))
+	assert.Equal(t, 3, strings.Count(resp.Body.String(), "\n"))
+	assert.Equal(t, "2", resp.Result().Trailer.Get(exportCountTrailer))
+	assert.Empty(t, resp.Result().Trailer.Get(exportErrorTrailer))
+
+	dst := storage.NewMemoryStorage()
+	request = httptest.NewRequest(http.MethodPost, "/import?format=csv", bytes.NewReader(resp.Body.Bytes()))
+	request.Header.Set("Authorization", "Bearer admin")
+	resp = httptest.NewRecorder()
+	NewAdminRouter(Admin{Store: dst, Token: "admin"}).ServeHTTP(resp, request)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var result ImportResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+	assert.Equal(t, ImportResponse{Read: 2, Imported: 2}, result)
+
+	url, err := dst.GetURL(context.Background(), "1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://google.com", url)
+
+	request = httptest.NewRequest(http.MethodPost, "/import", bytes.NewBufferString("not json"))
+	request.Header.Set("Authorization", "Bearer admin")
+	resp = httptest.NewRecorder()
+	NewAdminRouter(Admin{Store: dst, Token: "admin"}).ServeHTTP(resp, request)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestAdminAuditEvents(t *testing.T) {
+	audit := storage.NewMemoryAuditStorage()
+	store := storage.NewMemoryStorage()
+	urlService := service.URLService{Store: store, Audit: service.NewAuditor(audit)}
+	ctx := service.WithRequestMeta(context.Background(), internal.RequestMeta{RequestID: "req-1", IP: "10.0.0.1"})
+	id, _, err := urlService.AddURL(ctx, "https://ya.ru", 1)
+	require.NoError(t, err)
+	_, _, err = urlService.AddURL(ctx, "https://google.com", 2)
+	require.NoError(t, err)
+	r := NewAdminRouter(Admin{Store: store, Token: "admin", Audit: audit})
+
+	tests := []struct {
+		name       string
+		query      string
+		statusCode int
+		count      int
+	}{
+		{name: "All", query: "", statusCode: 200, count: 2},
+		{name: "By URL", query: "?url_id=" + strconv.Itoa(id), statusCode: 200, count: 1},
+		{name: "By user", query: "?user_id=3", statusCode: 200, count: 0},
+		{name: "Time range", query: "?from=2000-01-01T00:00:00Z&to=2001-01-01T00:00:00Z", statusCode: 200, count: 0},
+		{name: "Limit", query: "?limit=1", statusCode: 200, count: 1},
+		{name: "Wrong id", query: "?url_id=x", statusCode: 400},
+		{name: "Wrong time", query: "?from=yesterday", statusCode: 400},
+		{name: "Wrong limit", query: "?limit=0", statusCode: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/audit"+tt.query, nil)
+			request.Header.Set("Authorization", "Bearer admin")
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, request)
+			require.Equal(t, tt.statusCode, resp.Code)
+			if tt.statusCode != http.StatusOK {
+				return
+			}
+			var events []internal.AuditEvent
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &events))
+			assert.Len(t, events, tt.count)
+		})
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/audit?url_id="+strconv.Itoa(id), nil)
+	request.Header.Set("Authorization", "Bearer admin")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	var events []internal.AuditEvent
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &events))
+	require.Len(t, events, 1)
+	assert.Equal(t, internal.AuditCreate, events[0].Action)
+	assert.Equal(t, 1, events[0].UserID)
+	assert.Equal(t, "req-1", events[0].RequestID)
+	assert.Equal(t, "10.0.0.1", events[0].IP)
+}
+
+// failingExportStorage fails the export after the first URL.
+type failingExportStorage struct {
+	*storage.MemoryStorage
+}
+
+func (s failingExportStorage) Export(ctx context.Context, fn func(url internal.URLRecord) error) error {
+	err := s.MemoryStorage.Export(ctx, fn)
+	if err != nil {
+		return err
+	}
+	return errors.New("connection lost")
+}
+
+func TestAdminExportError(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	_, err := store.AddURL(context.Background(), "https://ya.ru", 1)
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodGet, "/export", nil)
+	request.Header.Set("Authorization", "Bearer admin")
+	resp := httptest.NewRecorder()
+	NewAdminRouter(Admin{Store: failingExportStorage{store}, Token: "admin"}).ServeHTTP(resp, request)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.NotEmpty(t, resp.Result().Trailer.Get(exportErrorTrailer))
+	assert.Empty(t, resp.Result().Trailer.Get(exportCountTrailer))
+}
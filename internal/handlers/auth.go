@@ -1,15 +1,32 @@
 package handlers
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
 )
 
 // Signer is used to create and validate the token.
 type Signer struct {
 	SecretKey []byte
+	// Erasures is used to revoke tokens of erased users. Tokens are not revoked if it is nil.
+	Erasures storage.ErasureStorage
+}
+
+// Authenticate returns the id from the token and a flag indicating if the sign is valid and the token is not revoked.
+func (sg *Signer) Authenticate(ctx context.Context, s string) (int, bool, error) {
+	id, ok, err := sg.CheckSign(s)
+	if err != nil || !ok || sg.Erasures == nil {
+		return id, ok, err
+	}
+	erased, err := sg.Erasures.IsErased(ctx, id)
+	if err != nil {
+		return 0, false, err
+	}
+	return id, !erased, nil
 }
 
 // CheckSign returns the id from the token and a flag indicating if the sign is valid.
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/MalyginaEkaterina/shortener/internal/apierror"
+	"io"
+	"net/http"
+)
+
+// bodyKey is the context key of the request body which is not limited yet.
+type bodyKey struct{}
+
+// limitBody limits the size of request bodies by limit unless the route sets its own limit by bodyLimit.
+// Bodies are not limited if limit is not positive.
+func limitBody(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+			req = req.WithContext(context.WithValue(req.Context(), bodyKey{}, req.Body))
+			if limit > 0 {
+				req.Body = http.MaxBytesReader(writer, req.Body, limit)
+			}
+			next.ServeHTTP(writer, req)
+		})
+	}
+}
+
+// bodyLimit replaces the limit of the request body set by limitBody for the route.
+func bodyLimit(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+			if body, ok := req.Context().Value(bodyKey{}).(io.ReadCloser); ok {
+				req.Body = body
+				if limit > 0 {
+					req.Body = http.MaxBytesReader(writer, body, limit)
+				}
+			}
+			next.ServeHTTP(writer, req)
+		})
+	}
+}
+
+// bodyTooLarge reports if the error of reading the request body is caused by the size or decompression ratio limit.
+func bodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr) || errors.Is(err, ErrDecompressionRatio)
+}
+
+// bodyError writes status 413 if the request body is too large and 400 for other errors of reading it.
+func bodyError(writer http.ResponseWriter, req *http.Request, err error) {
+	if bodyTooLarge(err) {
+		writeError(writer, req, apierror.BodyTooLarge, err.Error())
+		return
+	}
+	writeError(writer, req, apierror.MalformedBody, err.Error())
+}
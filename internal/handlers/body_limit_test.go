@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/service"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimits(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	cfg := internal.Config{
+		BaseURL:               "http://localhost:8080",
+		MaxBodySize:           64,
+		MaxBatchBodySize:      1 << 10,
+		MaxDecompressionRatio: 100,
+	}
+	r := NewRouter(store, cfg, Signer{SecretKey: []byte("my secret key")}, service.URLService{Store: store},
+		service.NewDeleteWorker(store, storage.NewMemoryDeleteQueue(2), storage.NewMemoryOperationStorage(), nil, nil))
+	serve := func(path string, body io.Reader, encoding string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, path, body)
+		if encoding != "" {
+			request.Header.Set("Content-Encoding", encoding)
+		}
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, request)
+		return resp
+	}
+
+	long := "https://ya.ru/" + strings.Repeat("a", 100)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve("/", strings.NewReader(long), "").Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve("/api/shorten", strings.NewReader(`{"url":"`+long+`"}`), "").Code)
+	assert.Equal(t, http.StatusCreated, serve("/api/shorten", strings.NewReader(`{"url":"https://ya.ru"}`), "").Code)
+
+	batch := `[{"correlation_id":"1","original_url":"` + long + `"}]`
+	assert.Equal(t, http.StatusCreated, serve("/api/shorten/batch", strings.NewReader(batch), "").Code,
+		"the batch route has its own limit")
+	batch = `[` + strings.Repeat(`{"correlation_id":"1","original_url":"`+long+`"},`, 10) + `{}]`
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve("/api/shorten/batch", strings.NewReader(batch), "").Code)
+
+	var bomb bytes.Buffer
+	gz := gzip.NewWriter(&bomb)
+	_, err := gz.Write([]byte(`[{"correlation_id":"1","original_url":"https://ya.ru/` + strings.Repeat(" ", 1<<20) + `"}]`))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	cfg.MaxBatchBodySize = 0
+	r = NewRouter(store, cfg, Signer{SecretKey: []byte("my secret key")}, service.URLService{Store: store},
+		service.NewDeleteWorker(store, storage.NewMemoryDeleteQueue(2), storage.NewMemoryOperationStorage(), nil, nil))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve("/api/shorten/batch", &bomb, "gzip").Code,
+		"the decompression ratio is limited without the size limit")
+}
+
+func TestDecodeBatch(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	cfg := internal.Config{BaseURL: "http://localhost:8080"}
+	r := NewRouter(store, cfg, Signer{SecretKey: []byte("my secret key")}, service.URLService{Store: store},
+		service.NewDeleteWorker(store, storage.NewMemoryDeleteQueue(2), storage.NewMemoryOperationStorage(), nil, nil))
+
+	tests := map[string]int{
+		``:      http.StatusBadRequest,
+		`{}`:    http.StatusBadRequest,
+		`[{}`:   http.StatusBadRequest,
+		`[1]`:   http.StatusBadRequest,
+		`[] []`: http.StatusBadRequest,
+		`[{"correlation_id":"1","original_url":"https://ya.ru"}]` + "\n": http.StatusCreated,
+	}
+	for body, want := range tests {
+		request := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body))
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, request)
+		assert.Equal(t, want, resp.Code, body)
+	}
+}
+
+// batchSizes records sizes of batches passed to the service and fails batches with numbers from failed.
+type batchSizes struct {
+	service.URLService
+	sizes  []int
+	failed map[int]bool
+}
+
+func (b *batchSizes) ShortenBatch(ctx context.Context, urls []internal.CorrIDOriginalURL, userID int) ([]internal.BatchResult, error) {
+	b.sizes = append(b.sizes, len(urls))
+	if b.failed[len(b.sizes)] {
+		return nil, errors.New("connection lost")
+	}
+	return b.URLService.ShortenBatch(ctx, urls, userID)
+}
+
+func TestDecodeBatchChunks(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	cfg := internal.Config{BaseURL: "http://localhost:8080"}
+	svc := &batchSizes{URLService: service.URLService{Store: store}}
+	r := NewRouter(store, cfg, Signer{SecretKey: []byte("my secret key")}, svc,
+		service.NewDeleteWorker(store, storage.NewMemoryDeleteQueue(2), storage.NewMemoryOperationStorage(), nil, nil))
+	serve := func(n int) *httptest.ResponseRecorder {
+		urls := make([]internal.CorrIDOriginalURL, n)
+		for i := range urls {
+			urls[i] = internal.CorrIDOriginalURL{CorrID: strconv.Itoa(i), OriginalURL: fmt.Sprintf("https://ya.ru/%d/%d", n, i)}
+		}
+		body, err := json.Marshal(urls)
+		require.NoError(t, err)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/api/shorten/batch", bytes.NewReader(body)))
+		return resp
+	}
+
+	resp := serve(2*batchChunkSize + 1)
+	require.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, []int{batchChunkSize, batchChunkSize, 1}, svc.sizes)
+	var results []CorrIDShortURL
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &results))
+	require.Len(t, results, 2*batchChunkSize+1)
+	assert.Equal(t, strconv.Itoa(2*batchChunkSize), results[2*batchChunkSize].CorrID)
+
+	svc.sizes = nil
+	require.Equal(t, http.StatusCreated, serve(batchChunkSize).Code)
+	assert.Equal(t, []int{batchChunkSize}, svc.sizes, "no empty chunk is passed")
+	svc.sizes = nil
+	resp = serve(0)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Empty(t, svc.sizes)
+	assert.Empty(t, resp.Result().Cookies(), "no user is created for the empty batch")
+
+	// URLs of the failed chunk are reported as failed, URLs of other chunks stay saved.
+	svc.sizes = nil
+	svc.failed = map[int]bool{2: true}
+	resp = serve(batchChunkSize + 2)
+	require.Equal(t, http.StatusMultiStatus, resp.Code)
+	results = nil
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &results))
+	require.Len(t, results, batchChunkSize+2)
+	assert.Equal(t, internal.BatchCreated, results[batchChunkSize-1].Status)
+	for _, v := range results[batchChunkSize:] {
+		assert.Equal(t, internal.BatchFailed, v.Status)
+		assert.Empty(t, v.ShortURL)
+	}
+
+	svc.sizes = nil
+	svc.failed = map[int]bool{1: true, 2: true}
+	resp = serve(batchChunkSize + 3)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code, "nothing is saved")
+}
@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal/apierror"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Content codings supported by the compression middleware.
+const (
+	encodingZstd     = "zstd"
+	encodingBrotli   = "br"
+	encodingGzip     = "gzip"
+	encodingIdentity = "identity"
+)
+
+// minCompressSize is the minimal size of the response body which is compressed. Smaller bodies do not become
+// smaller enough to pay for compression.
+const minCompressSize = 512
+
+// brotliLevel is the quality of brotli compression which is fast enough for dynamic responses.
+const brotliLevel = 4
+
+// ratioCheckMin is the size of the decoded request body after which the decompression ratio is checked.
+// Small bodies are harmless even if they are compressed very well.
+const ratioCheckMin = 64 << 10
+
+// Errors of decoding request bodies.
+var (
+	// ErrUnsupportedEncoding is returned if the request body has the content coding which is not supported.
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+	// ErrDecompressionRatio is returned by reading of the request body which is decompressed more than allowed.
+	ErrDecompressionRatio = errors.New("decompression ratio of request body exceeds the limit")
+)
+
+// compressor compresses responses and decompresses requests using pooled encoders and decoders.
+type compressor struct {
+	encoders map[string]*sync.Pool
+	decoders map[string]*sync.Pool
+	// maxRatio is the max ratio of decoded and encoded sizes of the request body. It is not limited if it is 0.
+	maxRatio int
+}
+
+// encoder is the compressing writer which can be reused for another response.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// decoder is the decompressing reader which can be reused for another request.
+type decoder interface {
+	io.Reader
+	Reset(r io.Reader) error
+}
+
+func newCompressor(maxRatio int) *compressor {
+	return &compressor{
+		maxRatio: maxRatio,
+		encoders: map[string]*sync.Pool{
+			encodingZstd: {New: func() any {
+				w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1),
+					zstd.WithLowerEncoderMem(true))
+				return w
+			}},
+			encodingBrotli: {New: func() any {
+				return brotli.NewWriterLevel(nil, brotliLevel)
+			}},
+			encodingGzip: {New: func() any {
+				w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+				return w
+			}},
+		},
+		decoders: map[string]*sync.Pool{
+			encodingZstd: {New: func() any {
+				d, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+				return d
+			}},
+			encodingBrotli: {New: func() any {
+				return brotli.NewReader(nil)
+			}},
+			encodingGzip: {New: func() any {
+				return new(gzip.Reader)
+			}},
+		},
+	}
+}
+
+// handle decodes the request body by its Content-Encoding and compresses the response body with the coding
+// negotiated by Accept-Encoding if it is large enough and has a compressible type.
+func (c *compressor) handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, release, err := c.decodeRequest(r)
+		if errors.Is(err, ErrUnsupportedEncoding) {
+			w.Header().Set("Accept-Encoding", strings.Join([]string{encodingZstd, encodingBrotli, encodingGzip}, ", "))
+			writeError(w, r, apierror.UnsupportedEncoding, err.Error())
+			return
+		} else if err != nil {
+			writeError(w, r, apierror.MalformedBody, err.Error())
+			return
+		}
+		defer release()
+		r.Body = body
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == encodingIdentity || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, pool: c.encoders[encoding]}
+		defer func() {
+			cw.Close()
+			if cw.encoder != nil {
+				// Compression is interleaved with the handler, so its time is recorded in the request span.
+				trace.SpanFromContext(r.Context()).SetAttributes(
+					attribute.String("http.response.content_encoding", encoding),
+					attribute.Int64("http.response.compression_duration_us", cw.elapsed.Microseconds()))
+			}
+		}()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// decodeRequest returns the request body decoding all its content codings in reverse order of their application.
+// release returns decoders into pools after the request is served.
+func (c *compressor) decodeRequest(r *http.Request) (io.ReadCloser, func(), error) {
+	header := r.Header.Get("Content-Encoding")
+	if header == "" {
+		return r.Body, func() {}, nil
+	}
+	codings := strings.Split(header, ",")
+	encoded := &countingReader{r: r.Body}
+	var body io.Reader = encoded
+	var used []func()
+	release := func() {
+		for _, f := range used {
+			f()
+		}
+	}
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := normalizeEncoding(codings[i])
+		if coding == encodingIdentity {
+			continue
+		}
+		pool, ok := c.decoders[coding]
+		if !ok {
+			release()
+			return nil, nil, fmt.Errorf("%w %s", ErrUnsupportedEncoding, strings.TrimSpace(codings[i]))
+		}
+		d := pool.Get().(decoder)
+		if err := d.Reset(body); err != nil {
+			release()
+			return nil, nil, fmt.Errorf("invalid %s body: %w", coding, err)
+		}
+		used = append(used, func() { pool.Put(d) })
+		body = d
+	}
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	if c.maxRatio > 0 {
+		body = &ratioReader{r: body, encoded: encoded, maxRatio: int64(c.maxRatio)}
+	}
+	return readCloser{Reader: body, Closer: r.Body}, release, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ratioReader fails with ErrDecompressionRatio if the decoded body is maxRatio times larger than the encoded one.
+type ratioReader struct {
+	r        io.Reader
+	encoded  *countingReader
+	decoded  int64
+	maxRatio int64
+}
+
+func (r *ratioReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.decoded += int64(n)
+	if r.decoded > ratioCheckMin && r.decoded > r.maxRatio*r.encoded.n {
+		return 0, ErrDecompressionRatio
+	}
+	return n, err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// normalizeEncoding returns the content coding in lower case with aliases replaced.
+func normalizeEncoding(coding string) string {
+	coding = strings.ToLower(strings.TrimSpace(coding))
+	if coding == "x-gzip" {
+		return encodingGzip
+	}
+	return coding
+}
+
+// negotiateEncoding returns the supported content coding with the highest q-value in Accept-Encoding.
+// Codings with equal q-values are preferred in the order zstd, br, gzip. It returns identity if no coding
+// is acceptable or the header is empty.
+func negotiateEncoding(header string) string {
+	if header == "" {
+		return encodingIdentity
+	}
+	qs := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = normalizeEncoding(coding)
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.EqualFold(k, "q") {
+				parsed, err := strconv.ParseFloat(v, 64)
+				if err != nil || parsed < 0 || parsed > 1 {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		if coding == "*" {
+			wildcard = q
+		} else if coding != "" {
+			qs[coding] = q
+		}
+	}
+	best, bestQ := encodingIdentity, 0.0
+	for _, coding := range []string{encodingZstd, encodingBrotli, encodingGzip} {
+		q, ok := qs[coding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressible reports if responses with the content type are compressed.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/problem+json", "application/x-ndjson", "application/javascript",
+		"application/xml", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// compressWriter buffers the beginning of the response body until it decides if the response is compressed:
+// the body must be at least minCompressSize, have a compressible type and no content coding set by the handler.
+// Responses which are not compressed are written as is with Content-Length if the whole body is buffered.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	pool     *sync.Pool
+	encoder  encoder
+	buf      []byte
+	status   int
+	// decided is set when the headers are written and the response is either compressed or not.
+	decided bool
+	// elapsed is the time spent on compression.
+	elapsed time.Duration
+}
+
+// WriteHeader saves the status code until the response is decided to be compressed or not.
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	if status < http.StatusOK {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+	if !bodyAllowed(status) || !w.canCompress() {
+		w.decide(false)
+		return
+	}
+	if cl, err := strconv.Atoi(w.Header().Get("Content-Length")); err == nil {
+		w.decide(cl >= minCompressSize)
+	}
+}
+
+// Write buffers the body until it is decided to be compressed or not.
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) >= minCompressSize {
+			if err := w.start(); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	return w.write(b)
+}
+
+// Flush decides to compress the buffered body of compressible type, so the streamed response is compressed,
+// and flushes it to the client.
+func (w *compressWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		if err := w.start(); err != nil {
+			return
+		}
+	}
+	if w.encoder != nil {
+		start := time.Now()
+		err := w.encoder.Flush()
+		w.elapsed += time.Since(start)
+		if err != nil {
+			return
+		}
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the original writer for http.ResponseController.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close writes the buffered body and finishes the compressed stream.
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			return nil
+		}
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		if w.Header().Get("Content-Length") == "" && bodyAllowed(w.status) {
+			w.Header().Set("Content-Length", strconv.Itoa(len(w.buf)))
+		}
+		w.decide(false)
+		_, err := w.ResponseWriter.Write(w.buf)
+		return err
+	}
+	if w.encoder == nil {
+		return nil
+	}
+	start := time.Now()
+	err := w.encoder.Close()
+	w.elapsed += time.Since(start)
+	w.encoder.Reset(nil)
+	w.pool.Put(w.encoder)
+	return err
+}
+
+// start writes the headers and the buffered body deciding to compress it if its type is compressible.
+func (w *compressWriter) start() error {
+	if w.Header().Get("Content-Type") == "" && len(w.buf) > 0 {
+		w.Header().Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	w.decide(w.canCompress())
+	buf := w.buf
+	w.buf = nil
+	_, err := w.write(buf)
+	return err
+}
+
+// decide writes the headers of the compressed or not compressed response.
+func (w *compressWriter) decide(compress bool) {
+	w.decided = true
+	if compress {
+		w.encoder = w.pool.Get().(encoder)
+		w.encoder.Reset(w.ResponseWriter)
+		w.Header().Set("Content-Encoding", w.encoding)
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+func (w *compressWriter) write(b []byte) (int, error) {
+	if w.encoder == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	start := time.Now()
+	defer func() { w.elapsed += time.Since(start) }()
+	return w.encoder.Write(b)
+}
+
+// canCompress reports if the response has no content coding and its type is compressible or not known yet.
+func (w *compressWriter) canCompress() bool {
+	if w.Header().Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := w.Header().Get("Content-Type")
+	return contentType == "" || compressible(contentType)
+}
+
+// bodyAllowed reports if the final response with the status can have a body.
+func bodyAllowed(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/service"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                            encodingIdentity,
+		"gzip":                        encodingGzip,
+		"x-gzip":                      encodingGzip,
+		"gzip, deflate, br, zstd":     encodingZstd,
+		"gzip, deflate, br":           encodingBrotli,
+		"br;q=0.5, gzip;q=0.8":        encodingGzip,
+		"zstd;q=0, GZIP;Q=0.1":        encodingGzip,
+		"*":                           encodingZstd,
+		"*;q=0.2, br;q=0.3, zstd;q=0": encodingBrotli,
+		"deflate, compress":           encodingIdentity,
+		"gzip;q=0, identity":          encodingIdentity,
+		"gzip;q=abc":                  encodingIdentity,
+	}
+	for header, want := range tests {
+		assert.Equal(t, want, negotiateEncoding(header), header)
+	}
+}
+
+func decompress(t *testing.T, encoding string, body []byte) string {
+	var r io.Reader
+	switch encoding {
+	case encodingGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		r = gz
+	case encodingBrotli:
+		r = brotli.NewReader(bytes.NewReader(body))
+	case encodingZstd:
+		d, err := zstd.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		defer d.Close()
+		r = d
+	default:
+		r = bytes.NewReader(body)
+	}
+	res, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(res)
+}
+
+func TestCompressResponse(t *testing.T) {
+	large := `{"result":"` + strings.Repeat("a", 2*minCompressSize) + `"}`
+	handler := newCompressor(0).handle(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/json":
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(http.StatusCreated)
+			writer.Write([]byte(large[:minCompressSize/2]))
+			writer.Write([]byte(large[minCompressSize/2:]))
+		case "/small":
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write([]byte(`{"result":"a"}`))
+		case "/zip":
+			writer.Header().Set("Content-Type", "application/zip")
+			writer.Write([]byte(large))
+		case "/sniffed":
+			writer.Write([]byte(strings.Repeat("text ", minCompressSize)))
+		case "/redirect":
+			writer.Header().Set("Location", "https://ya.ru")
+			writer.WriteHeader(http.StatusTemporaryRedirect)
+		case "/stream":
+			writer.Header().Set("Content-Type", "application/x-ndjson")
+			writer.Write([]byte("{}\n"))
+			writer.(http.Flusher).Flush()
+			writer.Write([]byte("{}\n"))
+		}
+	}))
+	serve := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, request)
+		assert.Equal(t, "Accept-Encoding", resp.Header().Get("Vary"), path)
+		return resp
+	}
+
+	for _, encoding := range []string{encodingZstd, encodingBrotli, encodingGzip} {
+		// Pooled encoders are reused by the second response.
+		for i := 0; i < 2; i++ {
+			resp := serve("/json", encoding)
+			assert.Equal(t, http.StatusCreated, resp.Code)
+			assert.Equal(t, encoding, resp.Header().Get("Content-Encoding"))
+			assert.Empty(t, resp.Header().Get("Content-Length"))
+			assert.Equal(t, large, decompress(t, encoding, resp.Body.Bytes()))
+		}
+	}
+
+	resp := serve("/json", "")
+	assert.Empty(t, resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, large, resp.Body.String())
+
+	resp = serve("/small", "gzip")
+	assert.Empty(t, resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, "14", resp.Header().Get("Content-Length"))
+	assert.Equal(t, `{"result":"a"}`, resp.Body.String())
+
+	resp = serve("/zip", "gzip")
+	assert.Empty(t, resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, large, resp.Body.String())
+
+	resp = serve("/sniffed", "br")
+	assert.Equal(t, encodingBrotli, resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header().Get("Content-Type"))
+	assert.Equal(t, strings.Repeat("text ", minCompressSize), decompress(t, encodingBrotli, resp.Body.Bytes()))
+
+	resp = serve("/redirect", "gzip")
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.Code)
+	assert.Empty(t, resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, "0", resp.Header().Get("Content-Length"))
+	assert.Empty(t, resp.Body.Bytes())
+
+	resp = serve("/stream", "zstd")
+	assert.True(t, resp.Flushed)
+	assert.Equal(t, encodingZstd, resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, "{}\n{}\n", decompress(t, encodingZstd, resp.Body.Bytes()))
+}
+
+func TestDecompressRequest(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	cfg := internal.Config{Address: ":8080", BaseURL: "http://localhost:8080"}
+	r := NewRouter(store, cfg, Signer{SecretKey: []byte("my secret key")}, service.URLService{Store: store},
+		service.NewDeleteWorker(store, storage.NewMemoryDeleteQueue(2), storage.NewMemoryOperationStorage(), nil, nil))
+
+	body := `{"url":"https://ya.ru"}`
+	encoders := map[string]func(w io.Writer) io.WriteCloser{
+		encodingGzip:   func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		encodingBrotli: func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+		encodingZstd: func(w io.Writer) io.WriteCloser {
+			e, _ := zstd.NewWriter(w)
+			return e
+		},
+	}
+	for encoding, newEncoder := range encoders {
+		var buf bytes.Buffer
+		e := newEncoder(&buf)
+		_, err := e.Write([]byte(body))
+		require.NoError(t, err)
+		require.NoError(t, e.Close())
+
+		request := httptest.NewRequest(http.MethodPost, "/api/shorten", &buf)
+		request.Header.Set("Content-Encoding", encoding)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, request)
+		assert.Contains(t, []int{http.StatusCreated, http.StatusConflict}, resp.Code, encoding)
+		assert.Contains(t, resp.Body.String(), "http://localhost:8080/0", encoding)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+	request.Header.Set("Content-Encoding", "compress")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.Code)
+	assert.Equal(t, "zstd, br, gzip", resp.Header().Get("Accept-Encoding"))
+
+	request = httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+	request.Header.Set("Content-Encoding", "gzip")
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
@@ -209,7 +209,7 @@ func newTestServer() (ts *http.Server, serverURL string) {
 		BaseURL: "http://localhost:8392",
 	}
 	r := NewRouter(store, cfg, Signer{SecretKey: []byte("secret again")},
-		service.URLService{Store: store}, service.NewDeleteWorker(store))
+		service.URLService{Store: store}, service.NewDeleteWorker(store, storage.NewMemoryDeleteQueue(100), storage.NewMemoryOperationStorage(), nil, nil))
 	ts = &http.Server{
 		Addr:    cfg.Address,
 		Handler: r,
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"github.com/MalyginaEkaterina/shortener/internal/health"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"net/http"
+)
+
+// Liveness returns status 200 while the server is able to serve requests. It does not check dependencies,
+// so that the server is not restarted when they are unavailable.
+func Liveness(writer http.ResponseWriter, _ *http.Request) {
+	marshalResponseAndSetCookie(writer, http.StatusOK, nil, health.Report{Status: health.StatusOK})
+}
+
+// Readiness runs checks of components and returns status 200 if all of them are healthy and 503 otherwise.
+// The body contains the status of every component.
+func Readiness(checker *health.Checker) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		report := checker.Run(req.Context())
+		status := http.StatusOK
+		if report.Status != health.StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		writer.Header().Set("Cache-Control", "no-store")
+		marshalResponseAndSetCookie(writer, status, nil, report)
+	}
+}
+
+// Ping returns status 200 if the check of the storage passes and 500 otherwise. It is kept for clients
+// of the former /ping endpoint, the readiness probe reports every component.
+func Ping(checker *health.Checker) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		writer.Header().Set("Cache-Control", "no-store")
+		status, ok := checker.RunComponent(req.Context(), storage.CheckName)
+		if !ok || status.Status != health.StatusOK {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writer.WriteHeader(http.StatusOK)
+	}
+}
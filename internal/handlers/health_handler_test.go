@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/health"
+	"github.com/MalyginaEkaterina/shortener/internal/service"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	cfg := internal.Config{Address: ":8080", BaseURL: "http://localhost:8080"}
+	deleteWorker := service.NewDeleteWorker(store, storage.NewMemoryDeleteQueue(2), storage.NewMemoryOperationStorage(), nil, nil)
+	checker := health.NewChecker(0, time.Second)
+	store.RegisterChecks(checker)
+	deleteWorker.RegisterChecks(checker)
+	r := NewRouter(store, cfg, Signer{SecretKey: []byte("my secret key")}, service.URLService{Store: store}, deleteWorker)
+	r.Get("/healthz", Liveness)
+	r.Get("/readyz", Readiness(checker))
+	r.Get("/ping", Ping(checker))
+
+	get := func(path string) (int, health.Report) {
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, path, nil))
+		var report health.Report
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &report))
+		return resp.Code, report
+	}
+
+	code, report := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, health.StatusOK, report.Status)
+
+	code, report = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, health.StatusFail, report.Status)
+	assert.Equal(t, health.StatusOK, report.Components["storage"].Status)
+	assert.Equal(t, health.StatusFail, report.Components["delete_worker"].Status)
+	assert.Equal(t, service.ErrWorkerNotRunning.Error(), report.Components["delete_worker"].Error)
+
+	// /ping checks only the storage.
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	checker.Register(storage.CheckName, func(ctx context.Context) error { return errors.New("connection refused") })
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+}
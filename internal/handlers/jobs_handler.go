@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/apierror"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/MalyginaEkaterina/shortener/internal/service"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const resultsPageSize = 1000
+
+// Import file errors
+var (
+	ErrNoURLs         = errors.New("file does not contain URLs")
+	ErrUnknownColumns = errors.New("header must contain column original_url")
+	ErrUnknownFile    = errors.New("file must be text/csv or application/x-ndjson")
+)
+
+// Jobs contains dependencies of import jobs endpoints.
+type Jobs struct {
+	*Router
+	jobs   storage.JobStorage
+	worker service.ImportWorker
+}
+
+// NewJobsRouter creates chi Router with endpoints of asynchronous bulk import jobs.
+func NewJobsRouter(store storage.Storage, cfg internal.Config, signer Signer, jobs storage.JobStorage, worker service.ImportWorker) chi.Router {
+	j := Jobs{
+		Router: &Router{store: store, signer: signer, baseURL: cfg.BaseURL},
+		jobs:   jobs,
+		worker: worker,
+	}
+	r := chi.NewRouter()
+	r.With(bodyLimit(cfg.MaxImportBodySize)).Post("/import", j.Import)
+	r.Get("/{id}", j.GetJob)
+	r.Get("/{id}/result", j.GetResult)
+	return r
+}
+
+// Import receives a CSV or NDJSON file with URLs, queues the import job and returns status 202 and the job.
+// The file is sent as the request body or as the part "file" of multipart/form-data.
+// CSV contains the column original_url and optionally correlation_id. The header is required if there is more than
+// one column. NDJSON contains objects with fields original_url and optionally correlation_id.
+// If request does not contain a valid token a new user will be created.
+func (j Jobs) Import(writer http.ResponseWriter, req *http.Request) {
+	items, err := readImportFile(req)
+	if err != nil {
+		bodyError(writer, req, err)
+		return
+	}
+	userID, tokenCookie, err := j.getIDAndCookie(req)
+	if err != nil {
+		writeError(writer, req, apierror.Internal, "")
+		return
+	}
+	job, err := j.worker.Import(req.Context(), userID, items)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error while creating import job", logging.Err(err))
+		writeError(writer, req, apierror.Internal, "")
+		return
+	}
+	writer.Header().Set("Location", "/api/jobs/"+job.ID)
+	marshalResponseAndSetCookie(writer, http.StatusAccepted, tokenCookie, job)
+}
+
+// GetJob returns the job with its progress. Returns status 404 if the job does not belong to the user.
+func (j Jobs) GetJob(writer http.ResponseWriter, req *http.Request) {
+	job, ok := j.userJob(writer, req)
+	if !ok {
+		return
+	}
+	marshalResponseAndSetCookie(writer, http.StatusOK, nil, job)
+}
+
+// GetResult returns CSV with the result of every imported URL: line, correlation_id, original_url, status,
+// short_url and reason. Returns status 409 if the job is not finished yet.
+func (j Jobs) GetResult(writer http.ResponseWriter, req *http.Request) {
+	job, ok := j.userJob(writer, req)
+	if !ok {
+		return
+	}
+	if job.Status != internal.JobDone && job.Status != internal.JobFailed {
+		writeError(writer, req, apierror.JobNotFinished, "")
+		return
+	}
+	writer.Header().Set("Content-Type", "text/csv")
+	writer.Header().Set("Content-Disposition", "attachment; filename=result-"+job.ID+".csv")
+	writer.WriteHeader(http.StatusOK)
+	w := csv.NewWriter(writer)
+	w.Write([]string{"line", "correlation_id", "original_url", "status", "short_url", "reason"})
+	for offset := 0; ; offset += resultsPageSize {
+		items, err := j.jobs.Items(req.Context(), job.ID, offset, resultsPageSize)
+		if err != nil {
+			slog.ErrorContext(req.Context(), "Error while getting job items", logging.Err(err))
+			return
+		}
+		for _, v := range items {
+			var shortURL string
+			if v.Status == internal.BatchCreated || v.Status == internal.BatchExisting {
+				shortURL = j.baseURL + "/" + strconv.Itoa(v.URLID)
+			}
+			w.Write([]string{strconv.Itoa(v.Line), v.CorrID, v.OriginalURL, v.Status, shortURL, v.Reason})
+		}
+		w.Flush()
+		if w.Error() != nil || len(items) < resultsPageSize {
+			return
+		}
+	}
+}
+
+// userJob returns the job from the URL if it belongs to the user or writes the error.
+func (j Jobs) userJob(writer http.ResponseWriter, req *http.Request) (internal.ImportJob, bool) {
+	userID, err := j.getID(req)
+	if err != nil {
+		writeError(writer, req, apierror.JobNotFound, "")
+		return internal.ImportJob{}, false
+	}
+	job, err := j.jobs.GetJob(req.Context(), chi.URLParam(req, "id"))
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && job.UserID != userID) {
+		writeError(writer, req, apierror.JobNotFound, "")
+		return internal.ImportJob{}, false
+	} else if err != nil {
+		slog.ErrorContext(req.Context(), "Error while getting job", logging.Err(err))
+		writeError(writer, req, apierror.Internal, "")
+		return internal.ImportJob{}, false
+	}
+	return job, true
+}
+
+// readImportFile reads items from the request body or from the part "file" of multipart/form-data.
+func readImportFile(req *http.Request) ([]internal.JobItem, error) {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return parseImportFile(req.Body, mediaType)
+	}
+	mr, err := req.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("part file is required")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch path.Ext(part.FileName()) {
+		case ".csv":
+			partType = "text/csv"
+		case ".ndjson", ".jsonl":
+			partType = "application/x-ndjson"
+		}
+		return parseImportFile(part, partType)
+	}
+}
+
+func parseImportFile(r io.Reader, mediaType string) ([]internal.JobItem, error) {
+	var items []internal.JobItem
+	var err error
+	switch mediaType {
+	case "text/csv":
+		items, err = parseImportCSV(r)
+	case "application/x-ndjson", "application/jsonl", "application/json":
+		items, err = parseImportNDJSON(r)
+	default:
+		return nil, ErrUnknownFile
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrNoURLs
+	}
+	return items, nil
+}
+
+// parseImportCSV reads URLs from CSV. Line of the item is the line of the file.
+func parseImportCSV(r io.Reader) ([]internal.JobItem, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	corrIDCol, urlCol := -1, 0
+	var items []internal.JobItem
+	for first := true; ; first = false {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first && (len(rec) > 1 || strings.TrimSpace(rec[0]) == "original_url") {
+			corrIDCol, urlCol = -1, -1
+			for i, v := range rec {
+				switch strings.TrimSpace(v) {
+				case "correlation_id":
+					corrIDCol = i
+				case "original_url":
+					urlCol = i
+				}
+			}
+			if urlCol == -1 {
+				return nil, ErrUnknownColumns
+			}
+			continue
+		}
+		line, _ := cr.FieldPos(0)
+		item := internal.JobItem{Line: line}
+		if urlCol < len(rec) {
+			item.OriginalURL = strings.TrimSpace(rec[urlCol])
+		}
+		if corrIDCol >= 0 && corrIDCol < len(rec) {
+			item.CorrID = strings.TrimSpace(rec[corrIDCol])
+		}
+		items = append(items, item)
+	}
+}
+
+// parseImportNDJSON reads URLs from NDJSON skipping empty lines. Line of the item is the line of the file.
+func parseImportNDJSON(r io.Reader) ([]internal.JobItem, error) {
+	scanner := bufio.NewScanner(r)
+	var items []internal.JobItem
+	for line := 1; scanner.Scan(); line++ {
+		data := scanner.Bytes()
+		if len(strings.TrimSpace(string(data))) == 0 {
+			continue
+		}
+		var v internal.CorrIDOriginalURL
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		items = append(items, internal.JobItem{Line: line, CorrID: v.CorrID, OriginalURL: v.OriginalURL})
+	}
+	return items, scanner.Err()
+}
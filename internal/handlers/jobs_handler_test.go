@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/service"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseImportFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		mediaType string
+		body      string
+		want      []internal.JobItem
+		wantErr   bool
+	}{
+		{
+			name:      "CSV without header",
+			mediaType: "text/csv",
+			body:      "https://ya.ru\nhttps://google.com\n",
+			want: []internal.JobItem{
+				{Line: 1, OriginalURL: "https://ya.ru"},
+				{Line: 2, OriginalURL: "https://google.com"},
+			},
+		},
+		{
+			name:      "CSV with header",
+			mediaType: "text/csv",
+			body:      "original_url,correlation_id\nhttps://ya.ru,a\n",
+			want:      []internal.JobItem{{Line: 2, CorrID: "a", OriginalURL: "https://ya.ru"}},
+		},
+		{
+			name:      "CSV without original_url column",
+			mediaType: "text/csv",
+			body:      "url,id\nhttps://ya.ru,a\n",
+			wantErr:   true,
+		},
+		{
+			name:      "NDJSON",
+			mediaType: "application/x-ndjson",
+			body:      "{\"correlation_id\":\"a\",\"original_url\":\"https://ya.ru\"}\n\n{\"original_url\":\"https://google.com\"}",
+			want: []internal.JobItem{
+				{Line: 1, CorrID: "a", OriginalURL: "https://ya.ru"},
+				{Line: 3, OriginalURL: "https://google.com"},
+			},
+		},
+		{name: "Wrong NDJSON", mediaType: "application/x-ndjson", body: "{", wantErr: true},
+		{name: "Empty file", mediaType: "text/csv", body: "", wantErr: true},
+		{name: "Unknown type", mediaType: "text/plain", body: "https://ya.ru", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := parseImportFile(strings.NewReader(tt.body), tt.mediaType)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, items)
+		})
+	}
+}
+
+func TestImportJob(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := storage.NewMemoryStorage()
+	jobs := storage.NewMemoryJobStorage()
+	worker := service.NewImportWorker(jobs, service.URLService{Store: store})
+	go worker.Run(ctx)
+	cfg := internal.Config{BaseURL: "http://localhost:8080"}
+	r := NewJobsRouter(store, cfg, Signer{SecretKey: []byte("secret")}, jobs, worker)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", "urls.csv")
+	require.NoError(t, err)
+	part.Write([]byte("correlation_id,original_url\na,https://ya.ru\nb,not a url\nc,https://ya.ru\n"))
+	require.NoError(t, mw.Close())
+
+	request := httptest.NewRequest(http.MethodPost, "/import", &body)
+	request.Header.Set("Content-Type", mw.FormDataContentType())
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	require.Equal(t, http.StatusAccepted, resp.Code)
+	var job internal.ImportJob
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &job))
+	assert.Equal(t, 3, job.Total)
+	assert.Equal(t, "/api/jobs/"+job.ID, resp.Header().Get("Location"))
+	cookies := resp.Result().Cookies()
+	require.Len(t, cookies, 1)
+	resp.Result().Body.Close()
+
+	require.Eventually(t, func() bool {
+		request = httptest.NewRequest(http.MethodGet, "/"+job.ID, nil)
+		request.AddCookie(cookies[0])
+		resp = httptest.NewRecorder()
+		r.ServeHTTP(resp, request)
+		require.Equal(t, http.StatusOK, resp.Code)
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &job))
+		return job.Status == internal.JobDone
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 3, job.Processed)
+	assert.Equal(t, 1, job.Created)
+	assert.Equal(t, 1, job.Existing)
+	assert.Equal(t, 1, job.Invalid)
+
+	request = httptest.NewRequest(http.MethodGet, "/"+job.ID+"/result", nil)
+	request.AddCookie(cookies[0])
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	require.Equal(t, http.StatusOK, resp.Code)
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"line", "correlation_id", "original_url", "status", "short_url", "reason"},
+		{"2", "a", "https://ya.ru", "created", "http://localhost:8080/0", ""},
+		{"3", "b", "not a url", "invalid", "", "original_url must be an absolute http or https URL"},
+		{"4", "c", "https://ya.ru", "existing", "http://localhost:8080/0", ""},
+	}, records)
+
+	// The job of another user is not found.
+	request = httptest.NewRequest(http.MethodGet, "/"+job.ID, nil)
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal/apierror"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/go-chi/chi/v5"
+	"github.com/swaggest/swgui/v5emb"
+	"net/http"
+)
+
+// openAPIPath is the path of the OpenAPI document of the API.
+const openAPIPath = "/openapi.json"
+
+// docsPath is the path of Swagger UI rendering the OpenAPI document.
+const docsPath = "/docs"
+
+// openAPIDocument is the OpenAPI 3 document describing the routes of NewRouter.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// LoadSpec parses the OpenAPI document of the API and validates it.
+func LoadSpec() (*openapi3.T, error) {
+	loader := openapi3.NewLoader()
+	spec, err := loader.LoadFromData(openAPIDocument)
+	if err != nil {
+		return nil, fmt.Errorf("error while loading OpenAPI document: %w", err)
+	}
+	if err = spec.Validate(loader.Context); err != nil {
+		return nil, fmt.Errorf("OpenAPI document is invalid: %w", err)
+	}
+	return spec, nil
+}
+
+// mustLoadSpec returns the OpenAPI document of the API. The document is embedded and checked by tests,
+// so an error is a bug of the build.
+func mustLoadSpec() *openapi3.T {
+	spec, err := LoadSpec()
+	if err != nil {
+		panic(err)
+	}
+	return spec
+}
+
+// OpenAPI returns the OpenAPI document of the API.
+func OpenAPI(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(openAPIDocument)
+}
+
+// SwaggerUI returns the handler of Swagger UI for the OpenAPI document which is mounted on docsPath.
+// Its assets are embedded into the binary.
+func SwaggerUI() http.Handler {
+	return v5emb.New("Shortener API", openAPIPath, docsPath)
+}
+
+// specRoute returns the route of the spec documenting requests with the method to the route pattern of chi,
+// or nil if such requests are not documented. Patterns of chi and paths of the spec use the same templates,
+// but chi trims the trailing slash of patterns, so the pattern of the root is empty.
+func specRoute(spec *openapi3.T, method, pattern string) *routers.Route {
+	if pattern == "" {
+		pattern = "/"
+	}
+	pathItem := spec.Paths.Value(pattern)
+	if pathItem == nil {
+		return nil
+	}
+	op := pathItem.GetOperation(method)
+	if op == nil {
+		return nil
+	}
+	return &routers.Route{Spec: spec, Path: pattern, PathItem: pathItem, Method: method, Operation: op}
+}
+
+// validateRequest returns the middleware validating requests against the spec. It is set on routes after routing,
+// so the route pattern of chi identifies the operation, and after bodyLimit, so the body is read within the limit
+// of the route. Requests of routes which are not documented are passed as is. Tokens are checked by handlers,
+// not by the middleware. Bodies are not validated if excludeBody is set, so they are not buffered.
+func validateRequest(spec *openapi3.T, excludeBody bool) func(http.Handler) http.Handler {
+	options := &openapi3filter.Options{
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		ExcludeRequestBody: excludeBody,
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+			rctx := chi.RouteContext(req.Context())
+			route := specRoute(spec, req.Method, rctx.RoutePattern())
+			if route == nil {
+				next.ServeHTTP(writer, req)
+				return
+			}
+			if body := route.Operation.RequestBody; body != nil && req.Header.Get("Content-Type") == "" {
+				// clients used to omit the type of the body, so the only documented type is assumed
+				if content := body.Value.Content; len(content) == 1 {
+					for mediaType := range content {
+						req.Header.Set("Content-Type", mediaType)
+					}
+				}
+			}
+			params := make(map[string]string, len(rctx.URLParams.Keys))
+			for i, key := range rctx.URLParams.Keys {
+				params[key] = rctx.URLParams.Values[i]
+			}
+			err := openapi3filter.ValidateRequest(req.Context(), &openapi3filter.RequestValidationInput{
+				Request:    req,
+				PathParams: params,
+				Route:      route,
+				Options:    options,
+			})
+			if err != nil {
+				validationError(writer, req, route, err)
+				return
+			}
+			next.ServeHTTP(writer, req)
+		})
+	}
+}
+
+// validationError writes the problem for the error of validation of the request against the route.
+func validationError(writer http.ResponseWriter, req *http.Request, route *routers.Route, err error) {
+	var reqErr *openapi3filter.RequestError
+	switch {
+	case bodyTooLarge(err):
+		bodyError(writer, req, err)
+	case !errors.As(err, &reqErr) || reqErr.RequestBody == nil:
+		writeError(writer, req, apierror.InvalidRequest, err.Error())
+	case errors.Is(err, openapi3filter.ErrInvalidRequired):
+		writeError(writer, req, apierror.BodyRequired, "")
+	case route.Operation.RequestBody.Value.Content.Get(req.Header.Get("Content-Type")) == nil:
+		writeError(writer, req, apierror.UnsupportedMedia, fmt.Sprintf("content type %q is not supported",
+			req.Header.Get("Content-Type")))
+	default:
+		writeError(writer, req, apierror.MalformedBody, err.Error())
+	}
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/apierror"
+	"github.com/MalyginaEkaterina/shortener/internal/service"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSpecTestRouter(t *testing.T) (chi.Router, storage.Storage) {
+	store := storage.NewMemoryStorage()
+	erasures := storage.NewMemoryErasureStorage()
+	cfg := internal.Config{Address: ":8080", BaseURL: "http://localhost:8080", MaxBodySize: 1024}
+	r := NewRouter(store, cfg, Signer{SecretKey: []byte("my secret key"), Erasures: erasures},
+		service.URLService{Store: store, Erasures: erasures, RestoreGracePeriod: time.Hour},
+		service.NewDeleteWorker(store, storage.NewMemoryDeleteQueue(10), storage.NewMemoryOperationStorage(), nil, nil))
+	return r, store
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	spec, err := LoadSpec()
+	require.NoError(t, err)
+	r, _ := newSpecTestRouter(t)
+
+	var routes []string
+	err = chi.Walk(r, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if !strings.HasPrefix(route, docsPath) {
+			routes = append(routes, method+" "+route)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	var documented []string
+	for path, item := range spec.Paths.Map() {
+		for method := range item.Operations() {
+			documented = append(documented, method+" "+path)
+		}
+	}
+	assert.ElementsMatch(t, routes, documented)
+}
+
+// TestOpenAPIResponses calls every documented operation and validates responses against the spec.
+func TestOpenAPIResponses(t *testing.T) {
+	spec, err := LoadSpec()
+	require.NoError(t, err)
+	r, store := newSpecTestRouter(t)
+	options := &openapi3filter.Options{IncludeResponseStatus: true, AuthenticationFunc: openapi3filter.NoopAuthenticationFunc}
+	called := make(map[string]bool)
+
+	do := func(method, target, contentType, body, token string, status int) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			request.Header.Set("Content-Type", contentType)
+		}
+		if token != "" {
+			request.AddCookie(&http.Cookie{Name: "token", Value: token})
+		}
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, request)
+		require.Equal(t, status, resp.Code, "%s %s: %s", method, target, resp.Body.String())
+
+		rctx := chi.NewRouteContext()
+		require.True(t, r.Match(rctx, method, request.URL.Path))
+		route := specRoute(spec, method, rctx.RoutePattern())
+		require.NotNil(t, route, "%s %s is not documented", method, rctx.RoutePattern())
+		require.NotNil(t, route.Operation.Responses.Status(status), "%s %s: status %d is not documented",
+			method, route.Path, status)
+		called[method+" "+route.Path] = true
+		params := make(map[string]string)
+		for i, key := range rctx.URLParams.Keys {
+			params[key] = rctx.URLParams.Values[i]
+		}
+		err := openapi3filter.ValidateResponse(context.Background(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: &openapi3filter.RequestValidationInput{
+				Request:    request,
+				PathParams: params,
+				Route:      route,
+				Options:    options,
+			},
+			Status:  resp.Code,
+			Header:  resp.Header(),
+			Body:    io.NopCloser(bytes.NewReader(resp.Body.Bytes())),
+			Options: options,
+		})
+		require.NoError(t, err, "%s %s", method, target)
+		return resp
+	}
+
+	resp := do(http.MethodPost, "/", "text/plain", "https://ya.ru", "", http.StatusCreated)
+	cookies := resp.Result().Cookies()
+	require.Len(t, cookies, 1)
+	token := cookies[0].Value
+	shortURL := resp.Body.String()
+	id := shortURL[strings.LastIndex(shortURL, "/")+1:]
+	do(http.MethodPost, "/", "", "https://ya.ru", token, http.StatusConflict)
+	do(http.MethodPost, "/", "text/plain", "", token, http.StatusBadRequest)
+
+	do(http.MethodPost, "/api/shorten", "application/json", `{"url":"https://ya2.ru"}`, token, http.StatusCreated)
+	do(http.MethodPost, "/api/shorten", "", `{"url":"https://ya2.ru"}`, token, http.StatusConflict)
+	do(http.MethodPost, "/api/shorten", "", `{"url":1}`, token, http.StatusBadRequest)
+	do(http.MethodPost, "/api/shorten", "text/plain", "https://ya2.ru", token, http.StatusUnsupportedMediaType)
+	do(http.MethodPost, "/api/shorten", "", `{"url":"`+strings.Repeat("a", 1024)+`"}`, token,
+		http.StatusRequestEntityTooLarge)
+
+	batch := `[{"correlation_id":"1","original_url":"https://ya3.ru"}]`
+	do(http.MethodPost, "/api/shorten/batch", "", batch, token, http.StatusCreated)
+	do(http.MethodPost, "/api/shorten/batch", "", batch, token, http.StatusConflict)
+	do(http.MethodPost, "/api/shorten/batch", "", `[{"correlation_id":"1"}]`, token, http.StatusBadRequest)
+	do(http.MethodPost, "/api/shorten/batch", "", `[{"correlation_id":"1"},{"correlation_id":"2","original_url":"https://ya4.ru"}]`,
+		token, http.StatusMultiStatus)
+	do(http.MethodPost, "/api/shorten/batch", "", `{}`, token, http.StatusBadRequest)
+	do(http.MethodPost, "/api/shorten/batch", "", `[]`, token, http.StatusBadRequest)
+
+	do(http.MethodGet, "/"+id, "", "", "", http.StatusTemporaryRedirect)
+	do(http.MethodGet, "/100500", "", "", "", http.StatusNotFound)
+	do(http.MethodGet, "/api/user/urls", "", "", token, http.StatusOK)
+	do(http.MethodGet, "/api/user/urls", "", "", "", http.StatusNoContent)
+
+	do(http.MethodDelete, "/api/user/urls", "", `["`+id+`"]`, "", http.StatusUnauthorized)
+	do(http.MethodDelete, "/api/user/urls", "", `["abc"]`, token, http.StatusBadRequest)
+	resp = do(http.MethodDelete, "/api/user/urls", "", `["`+id+`"]`, token, http.StatusAccepted)
+	do(http.MethodGet, resp.Header().Get("Location"), "", "", token, http.StatusOK)
+	do(http.MethodGet, "/api/user/urls/operations/unknown", "", "", token, http.StatusNotFound)
+
+	urlID, err := strconv.Atoi(id)
+	require.NoError(t, err)
+	_, err = store.DeleteBatch(context.Background(), []internal.IDToDelete{{ID: urlID, UserID: 1}})
+	require.NoError(t, err)
+	do(http.MethodGet, "/"+id, "", "", "", http.StatusGone)
+	do(http.MethodPost, "/api/user/urls/restore", "", `["`+id+`","100"]`, token, http.StatusOK)
+	do(http.MethodPost, "/api/user/urls/restore", "", `["`+id+`"]`, "", http.StatusUnauthorized)
+
+	do(http.MethodGet, "/api/user/export", "", "", token, http.StatusOK)
+	do(http.MethodGet, "/api/user/export", "", "", "", http.StatusUnauthorized)
+	do(http.MethodGet, errorsPath, "", "", "", http.StatusOK)
+	do(http.MethodGet, openAPIPath, "", "", "", http.StatusOK)
+	do(http.MethodDelete, "/api/user", "", "", token, http.StatusOK)
+	do(http.MethodDelete, "/api/user", "", "", token, http.StatusUnauthorized)
+
+	for path, item := range spec.Paths.Map() {
+		for method := range item.Operations() {
+			assert.True(t, called[method+" "+path], "%s %s is not called", method, path)
+		}
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	r, _ := newSpecTestRouter(t)
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		status      int
+		code        apierror.Code
+	}{
+		{"missing field", http.MethodPost, "/api/shorten", "application/json", `{}`, http.StatusBadRequest, apierror.MalformedBody},
+		{"empty url", http.MethodPost, "/api/shorten", "application/json", `{"url":""}`, http.StatusBadRequest, apierror.MalformedBody},
+		{"not JSON", http.MethodPost, "/api/shorten", "application/json", `url`, http.StatusBadRequest, apierror.MalformedBody},
+		{"empty body", http.MethodPost, "/api/shorten", "application/json", ``, http.StatusBadRequest, apierror.BodyRequired},
+		{"unsupported type", http.MethodPost, "/api/shorten", "text/xml", `<url/>`, http.StatusUnsupportedMediaType, apierror.UnsupportedMedia},
+		{"ids are not strings", http.MethodPost, "/api/user/urls/restore", "", `[1]`, http.StatusBadRequest, apierror.MalformedBody},
+		{"batch is not array", http.MethodPost, "/api/shorten/batch", "", `{"url":"https://ya.ru"}`, http.StatusBadRequest, apierror.MalformedBody},
+		{"form body", http.MethodPost, "/", "application/x-www-form-urlencoded", `https://ya.ru`, http.StatusCreated, ""},
+		{"no content type", http.MethodPost, "/api/shorten", "", `{"url":"https://ya2.ru"}`, http.StatusCreated, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				request.Header.Set("Content-Type", tt.contentType)
+			}
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, request)
+			assert.Equal(t, tt.status, resp.Code)
+			if tt.code == "" {
+				return
+			}
+			var p Problem
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &p))
+			assert.Equal(t, tt.code, p.Code)
+		})
+	}
+}
+
+func TestValidateRequestExcludeBody(t *testing.T) {
+	spec, err := LoadSpec()
+	require.NoError(t, err)
+	var read string
+	r := chi.NewRouter()
+	handler := func(writer http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		read = string(body)
+		writer.WriteHeader(http.StatusCreated)
+	}
+	r.With(validateRequest(spec, false)).Post("/api/shorten/batch", handler)
+	r.With(validateRequest(spec, true)).Post("/api/shorten", handler)
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(`{}`)))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`[]`)))
+	assert.Equal(t, http.StatusCreated, resp.Code, "the body is not validated")
+	assert.Equal(t, `[]`, read, "the body is passed as is")
+}
+
+func TestRedirectIsNotValidated(t *testing.T) {
+	r, _ := newSpecTestRouter(t)
+	err := chi.Walk(r, func(method string, route string, _ http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if method == http.MethodGet && route == "/{id}" {
+			assert.Len(t, middlewares, len(r.Middlewares()), "only common middlewares are set on the redirect")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	for _, id := range []string{"abc", "99999999999999999999", "-1"} {
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+		require.Equal(t, http.StatusNotFound, resp.Code, id)
+		var p Problem
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &p))
+		assert.Equal(t, apierror.URLNotFound, p.Code, id)
+	}
+}
+
+func TestSwaggerUI(t *testing.T) {
+	r, _ := newSpecTestRouter(t)
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, openAPIPath, nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, openAPIDocument, resp.Body.Bytes())
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, docsPath+"/", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, resp.Body.String(), openAPIPath)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/MalyginaEkaterina/shortener/internal/apierror"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// problemContentType is the media type of error responses.
+const problemContentType = "application/problem+json"
+
+// errorsPath is the path of the catalogue of errors which types of problems refer to.
+const errorsPath = "/api/errors"
+
+// Problem is the body of error responses defined by RFC 7807.
+type Problem struct {
+	// Type refers to the error in the catalogue.
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Detail explains this occurrence of the error.
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Code is the stable code of the error from the catalogue.
+	Code      apierror.Code `json:"code"`
+	RequestID string        `json:"request_id,omitempty"`
+}
+
+// writeError writes the problem with the code from the catalogue and the detail of this occurrence.
+// The request is used to fill the instance and the request id, it may be nil.
+func writeError(writer http.ResponseWriter, req *http.Request, code apierror.Code, detail string) {
+	e := apierror.Lookup(code)
+	p := Problem{
+		Type:   errorsPath + "#" + string(e.Code),
+		Title:  e.Title,
+		Status: e.HTTPStatus,
+		Detail: detail,
+		Code:   e.Code,
+	}
+	if req != nil {
+		p.Instance = req.URL.Path
+		p.RequestID = middleware.GetReqID(req.Context())
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		slog.Error("Error while serializing problem", logging.Err(err))
+	}
+	writer.Header().Set("Content-Type", problemContentType)
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.Header().Del("Content-Length")
+	writer.WriteHeader(e.HTTPStatus)
+	writer.Write(body)
+}
+
+// ErrorCatalogue returns all errors of the API with their codes, titles and status codes.
+func ErrorCatalogue(writer http.ResponseWriter, _ *http.Request) {
+	marshalResponseAndSetCookie(writer, http.StatusOK, nil, apierror.Catalogue())
+}
+
+// routeNotFound writes status 404 for unknown routes.
+func routeNotFound(writer http.ResponseWriter, req *http.Request) {
+	writeError(writer, req, apierror.RouteNotFound, "")
+}
+
+// methodNotAllowed returns the handler writing status 405 with Allow header listing methods of the route in root.
+func methodNotAllowed(root chi.Routes) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		var allowed []string
+		for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+			http.MethodPatch, http.MethodDelete, http.MethodOptions} {
+			if root.Match(chi.NewRouteContext(), method, req.URL.Path) {
+				allowed = append(allowed, method)
+			}
+		}
+		writer.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(writer, req, apierror.MethodNotAllowed, "")
+	}
+}
+
+// recoverer logs panics of handlers and writes status 500.
+func recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+			if rvr == http.ErrAbortHandler {
+				panic(rvr)
+			}
+			slog.ErrorContext(req.Context(), "Panic while serving request", "panic", rvr, "stack", string(debug.Stack()))
+			writeError(writer, req, apierror.Internal, "")
+		}()
+		next.ServeHTTP(writer, req)
+	})
+}
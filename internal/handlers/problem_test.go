@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/apierror"
+	"github.com/MalyginaEkaterina/shortener/internal/service"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProblems(t *testing.T) {
+	store := &mockStorage{getURLErr: storage.ErrNotFound}
+	cfg := internal.Config{Address: ":8080", BaseURL: "http://localhost:8080"}
+	r := NewRouter(store, cfg, Signer{SecretKey: []byte("my secret key")}, service.URLService{Store: store}, nil)
+	r.Get("/panic", func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	})
+
+	do := func(method, path string) (*http.Response, Problem) {
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest(method, path, nil))
+		result := resp.Result()
+		defer result.Body.Close()
+		assert.Equal(t, problemContentType, result.Header.Get("Content-Type"))
+		var p Problem
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&p))
+		assert.Equal(t, result.StatusCode, p.Status)
+		assert.Equal(t, path, p.Instance)
+		assert.NotEmpty(t, p.RequestID)
+		assert.Equal(t, errorsPath+"#"+string(p.Code), p.Type)
+		return result, p
+	}
+
+	resp, p := do(http.MethodGet, "/1")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, apierror.URLNotFound, p.Code)
+
+	resp, p = do(http.MethodGet, "/api/unknown/route")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, apierror.RouteNotFound, p.Code)
+
+	resp, p = do(http.MethodDelete, "/")
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, apierror.MethodNotAllowed, p.Code)
+	assert.Equal(t, "POST", resp.Header.Get("Allow"))
+
+	resp, p = do(http.MethodPost, "/api/shorten")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, apierror.BodyRequired, p.Code)
+
+	resp, p = do(http.MethodGet, "/panic")
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, apierror.Internal, p.Code)
+}
+
+func TestErrorCatalogue(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	cfg := internal.Config{Address: ":8080", BaseURL: "http://localhost:8080"}
+	r := NewRouter(store, cfg, Signer{SecretKey: []byte("my secret key")}, service.URLService{Store: store}, nil)
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, errorsPath, nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	var entries []apierror.Entry
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &entries))
+	assert.Equal(t, apierror.Catalogue(), entries)
+}
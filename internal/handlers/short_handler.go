@@ -3,13 +3,17 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/apierror"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
 	"github.com/MalyginaEkaterina/shortener/internal/service"
 	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/MalyginaEkaterina/shortener/internal/tracing"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 )
@@ -21,43 +25,98 @@ type Router struct {
 	baseURL      string
 	service      service.Service
 	deleteWorker service.DeleteWorker
+	// maxBatchBodySize is the limit of the request body of the batch shortening.
+	maxBatchBodySize int64
+	// validate validates requests against the OpenAPI document.
+	validate func(http.Handler) http.Handler
+	// validateParams validates requests against the OpenAPI document except their bodies.
+	validateParams func(http.Handler) http.Handler
 }
 
 // NewRouter creates new chi Router and configures it.
 func NewRouter(store storage.Storage, cfg internal.Config, signer Signer, service service.Service, deleteWorker service.DeleteWorker) chi.Router {
+	r := NewBaseRouter(cfg)
+	r.Group(PublicRoutes(store, cfg, signer, service, deleteWorker))
+	return r
+}
+
+// NewBaseRouter returns the router with common middlewares and handlers of unknown routes
+// which route sets are registered on. Request bodies are limited by cfg.MaxBodySize unless the route has its own limit.
+func NewBaseRouter(cfg internal.Config) chi.Router {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	r.Use(gzipHandle)
+	r.Use(traceRequest)
+	r.Use(accessLog)
+	r.Use(recoverer)
+	r.Use(requestMeta)
+	r.Use(newCompressor(cfg.MaxDecompressionRatio).handle)
+	r.Use(limitBody(cfg.MaxBodySize))
 
-	router := &Router{
-		store:        store,
-		signer:       signer,
-		baseURL:      cfg.BaseURL,
-		service:      service,
-		deleteWorker: deleteWorker,
-	}
+	r.NotFound(routeNotFound)
+	r.MethodNotAllowed(methodNotAllowed(r))
+	return r
+}
 
-	r.Route("/", func(r chi.Router) {
-		r.Post("/", router.ShortURL)
+// PublicRoutes returns the function registering redirects, shortening by the plain text body and the user API.
+func PublicRoutes(store storage.Storage, cfg internal.Config, signer Signer, service service.Service, deleteWorker service.DeleteWorker) func(r chi.Router) {
+	router := newRouter(store, cfg, signer, service, deleteWorker)
+	return func(r chi.Router) {
+		r.With(router.validate).Post("/", router.ShortURL)
+		// the redirect is the hot path, so it is not validated: storages report ids which are not numbers as not found
 		r.Get("/{id}", router.GetURLByID)
-		r.Post("/api/shorten", router.Shorten)
-		r.Get("/api/user/urls", router.GetUserUrls)
-		r.Get("/ping", PingDB(store))
-		r.Post("/api/shorten/batch", router.ShortenBatch)
-		r.Delete("/api/user/urls", router.DeleteBatch)
-	})
+		router.apiRoutes(r)
+	}
+}
 
-	r.NotFound(func(writer http.ResponseWriter, request *http.Request) {
-		http.Error(writer, "Wrong request", http.StatusBadRequest)
-	})
+// APIRoutes returns the function registering the user API without redirects.
+func APIRoutes(store storage.Storage, cfg internal.Config, signer Signer, service service.Service, deleteWorker service.DeleteWorker) func(r chi.Router) {
+	return newRouter(store, cfg, signer, service, deleteWorker).apiRoutes
+}
+
+func newRouter(store storage.Storage, cfg internal.Config, signer Signer, service service.Service, deleteWorker service.DeleteWorker) *Router {
+	spec := mustLoadSpec()
+	return &Router{
+		store:            store,
+		signer:           signer,
+		baseURL:          cfg.BaseURL,
+		service:          service,
+		deleteWorker:     deleteWorker,
+		maxBatchBodySize: cfg.MaxBatchBodySize,
+		validate:         validateRequest(spec, false),
+		validateParams:   validateRequest(spec, true),
+	}
+}
+
+// apiRoutes registers the user API, the OpenAPI document with Swagger UI and the catalogue of errors.
+// Requests are validated against the OpenAPI document after the limit of the body of the route is set.
+// The body of the batch is not validated, because the handler decodes it element by element without buffering.
+func (r *Router) apiRoutes(mux chi.Router) {
+	mux.Mount(docsPath, SwaggerUI())
+	api := mux.With(r.validate)
+	api.Get(openAPIPath, OpenAPI)
+	api.Get(errorsPath, ErrorCatalogue)
+	api.Post("/api/shorten", r.Shorten)
+	api.Get("/api/user/urls", r.GetUserUrls)
+	mux.With(bodyLimit(r.maxBatchBodySize), r.validateParams).Post("/api/shorten/batch", r.ShortenBatch)
+	api.Delete("/api/user/urls", r.DeleteBatch)
+	api.Get("/api/user/urls/operations/{id}", r.GetDeleteOperation)
+	api.Post("/api/user/urls/restore", r.RestoreBatch)
+	api.Get("/api/user/export", r.ExportUserData)
+	api.Delete("/api/user", r.EraseUser)
+}
 
-	r.MethodNotAllowed(func(writer http.ResponseWriter, request *http.Request) {
-		http.Error(writer, "Method not allowed", http.StatusBadRequest)
+// requestMeta saves the request id, the client IP and the trace of the request into the request context
+// for the audit log and asynchronous deletion.
+func requestMeta(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		ctx := service.WithRequestMeta(req.Context(), internal.RequestMeta{
+			RequestID:   middleware.GetReqID(req.Context()),
+			IP:          req.RemoteAddr,
+			TraceParent: tracing.Inject(req.Context()),
+		})
+		next.ServeHTTP(writer, req.WithContext(ctx))
 	})
-	return r
 }
 
 // ShortenRequest contains a request to shorten the URL.
@@ -77,9 +136,12 @@ type ShortOriginalURL struct {
 }
 
 // CorrIDShortURL contains shortened URL and its corresponding correlation_id from the request to shorten of the batch.
+// Status is created, existing, invalid or failed. Reason explains why the URL is invalid or failed.
 type CorrIDShortURL struct {
 	CorrID   string `json:"correlation_id"`
-	ShortURL string `json:"short_url"`
+	ShortURL string `json:"short_url,omitempty"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"`
 }
 
 // Handler errors
@@ -96,25 +158,26 @@ func (r *Router) getIDAndCookie(req *http.Request) (int, *http.Cookie, error) {
 	sign, err := req.Cookie("token")
 	if err == nil {
 		signValue = sign.Value
-		userID, authOK, err = r.signer.CheckSign(signValue)
+		userID, authOK, err = r.signer.Authenticate(req.Context(), signValue)
 		if err != nil {
-			log.Println("Error while checking of sign", err)
+			slog.ErrorContext(req.Context(), "Error while checking of sign", logging.Err(err))
 			return 0, nil, err
 		}
 	}
 	if err != nil || !authOK {
 		userID, err = r.store.AddUser(req.Context())
 		if err != nil {
-			log.Println("Error while adding user", err)
+			slog.ErrorContext(req.Context(), "Error while adding user", logging.Err(err))
 			return 0, nil, err
 		}
 		signValue, err = r.signer.CreateSign(userID)
 		if err != nil {
-			log.Println("Error while creating of sign", err)
+			slog.ErrorContext(req.Context(), "Error while creating of sign", logging.Err(err))
 			return 0, nil, err
 		}
 		cookie = &http.Cookie{Name: "token", Value: signValue, MaxAge: 0}
 	}
+	logging.Set(req.Context(), logging.UserIDKey, userID)
 	return userID, cookie, nil
 }
 
@@ -123,14 +186,15 @@ func (r *Router) getID(req *http.Request) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	userID, authOK, err := r.signer.CheckSign(sign.Value)
+	userID, authOK, err := r.signer.Authenticate(req.Context(), sign.Value)
 	if err != nil {
-		log.Println("Error while checking of sign", err)
+		slog.ErrorContext(req.Context(), "Error while checking of sign", logging.Err(err))
 		return 0, err
 	}
 	if !authOK {
 		return 0, ErrSignNotValid
 	}
+	logging.Set(req.Context(), logging.UserIDKey, userID)
 	return userID, nil
 }
 
@@ -144,13 +208,13 @@ func (r *Router) Shorten(writer http.ResponseWriter, req *http.Request) {
 	}
 	userID, tokenCookie, err := r.getIDAndCookie(req)
 	if err != nil {
-		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+		writeError(writer, req, apierror.Internal, "")
 		return
 	}
 	ind, alreadyExists, err := r.service.AddURL(req.Context(), shortenRequest.URL, userID)
 	if err != nil {
-		log.Println("Error while adding URl", err)
-		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+		slog.ErrorContext(req.Context(), "Error while adding URl", logging.Err(err))
+		writeError(writer, req, apierror.Internal, "")
 		return
 	}
 	var status int
@@ -166,8 +230,8 @@ func (r *Router) Shorten(writer http.ResponseWriter, req *http.Request) {
 func marshalResponseAndSetCookie(writer http.ResponseWriter, status int, cookie *http.Cookie, response any) {
 	respJSON, err := json.Marshal(response)
 	if err != nil {
-		log.Println("Error while serializing response", err)
-		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+		slog.Error("Error while serializing response", logging.Err(err))
+		writeError(writer, nil, apierror.Internal, "")
 		return
 	}
 	if cookie != nil {
@@ -181,44 +245,109 @@ func marshalResponseAndSetCookie(writer http.ResponseWriter, status int, cookie
 func unmarshalRequest(writer http.ResponseWriter, req *http.Request, v any) bool {
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+		bodyError(writer, req, err)
 		return false
 	}
 	if len(body) == 0 {
-		http.Error(writer, "Request body is required", http.StatusBadRequest)
+		writeError(writer, req, apierror.BodyRequired, "")
 		return false
 	}
 	err = json.Unmarshal(body, v)
 	if err != nil {
-		http.Error(writer, "Failed to parse request body", http.StatusBadRequest)
+		writeError(writer, req, apierror.MalformedBody, "")
 		return false
 	}
 	return true
 }
 
+// batchChunkSize is the number of URLs of the batch passed to the service at once.
+const batchChunkSize = 1000
+
+// decodeBatch decodes the JSON array of the batch from the request body element by element and passes URLs
+// to handle by chunks of batchChunkSize, so neither the body nor the whole batch is kept in memory.
+// Writes the error and returns false if the body is not a valid non-empty array or handle fails. Chunks which are
+// handled before the error are not rolled back.
+func decodeBatch(writer http.ResponseWriter, req *http.Request, handle func(urls []internal.CorrIDOriginalURL) error) bool {
+	dec := json.NewDecoder(req.Body)
+	decodeError := func(err error) bool {
+		if bodyTooLarge(err) {
+			bodyError(writer, req, err)
+		} else {
+			writeError(writer, req, apierror.MalformedBody, "")
+		}
+		return false
+	}
+	handleChunk := func(urls []internal.CorrIDOriginalURL) bool {
+		if err := handle(urls); err != nil {
+			slog.ErrorContext(req.Context(), "Error while handling batch", logging.Err(err))
+			writeError(writer, req, apierror.Internal, "")
+			return false
+		}
+		return true
+	}
+	tok, err := dec.Token()
+	if errors.Is(err, io.EOF) {
+		writeError(writer, req, apierror.BodyRequired, "")
+		return false
+	} else if err != nil {
+		return decodeError(err)
+	}
+	if tok != json.Delim('[') {
+		return decodeError(errors.New("array is expected"))
+	}
+	urls := make([]internal.CorrIDOriginalURL, 0, batchChunkSize)
+	decoded := 0
+	for dec.More() {
+		var v internal.CorrIDOriginalURL
+		if err = dec.Decode(&v); err != nil {
+			return decodeError(err)
+		}
+		urls = append(urls, v)
+		decoded++
+		if len(urls) == batchChunkSize {
+			if !handleChunk(urls) {
+				return false
+			}
+			urls = urls[:0]
+		}
+	}
+	// the closing bracket and the end of the body
+	if _, err = dec.Token(); err != nil {
+		return decodeError(err)
+	}
+	if _, err = dec.Token(); !errors.Is(err, io.EOF) {
+		return decodeError(errors.New("unexpected data after array"))
+	}
+	if decoded == 0 {
+		writeError(writer, req, apierror.InvalidRequest, "batch is empty")
+		return false
+	}
+	return handleChunk(urls)
+}
+
 // ShortURL receives text with URL and returns status 201 and shortened URL.
 // Returns status 409 if the URL has already been shortened.
 // If request does not contain a valid token new user will be created.
 func (r *Router) ShortURL(writer http.ResponseWriter, req *http.Request) {
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+		bodyError(writer, req, err)
 		return
 	}
 	if len(body) == 0 {
-		http.Error(writer, "Request body is required", http.StatusBadRequest)
+		writeError(writer, req, apierror.BodyRequired, "")
 		return
 	}
 	userID, tokenCookie, err := r.getIDAndCookie(req)
 	if err != nil {
-		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+		writeError(writer, req, apierror.Internal, "")
 		return
 	}
 	url := string(body)
 	ind, alreadyExists, err := r.service.AddURL(req.Context(), url, userID)
 	if err != nil {
-		log.Println("Error while adding URl", err)
-		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+		slog.ErrorContext(req.Context(), "Error while adding URl", logging.Err(err))
+		writeError(writer, req, apierror.Internal, "")
 		return
 	}
 	var status int
@@ -237,29 +366,30 @@ func (r *Router) ShortURL(writer http.ResponseWriter, req *http.Request) {
 }
 
 // GetURLByID receives url parameter with id and returns status 307 and associated URL in header Location.
-// Returns status 400 if requested id does not exist.
+// Returns status 404 if requested id does not exist.
 // Returns status 410 if requested id was deleted.
 func (r *Router) GetURLByID(writer http.ResponseWriter, req *http.Request) {
 	id := chi.URLParam(req, "id")
 	if id == "" {
-		http.Error(writer, "Url ID is required", http.StatusBadRequest)
+		writeError(writer, req, apierror.URLNotFound, "")
 		return
 	}
 	url, err := r.store.GetURL(req.Context(), id)
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
-			http.Error(writer, "Not found", http.StatusBadRequest)
+			writeError(writer, req, apierror.URLNotFound, "")
 		} else if errors.Is(err, storage.ErrDeleted) {
-			http.Error(writer, "Was deleted", http.StatusGone)
+			writeError(writer, req, apierror.URLDeleted, "")
 		} else {
-			log.Println("Error while getting URL", err)
-			http.Error(writer, "Internal server error", http.StatusInternalServerError)
+			slog.ErrorContext(req.Context(), "Error while getting URL", logging.Err(err))
+			writeError(writer, req, apierror.Internal, "")
 		}
 		return
 	}
 	writer.Header().Set("Content-Type", "text/html; charset=UTF-8")
 	writer.Header().Set("Location", url)
 	writer.WriteHeader(http.StatusTemporaryRedirect)
+	r.service.Clicked(req.Context(), id)
 }
 
 // GetUserUrls returns the list of shortened and original URLs for the user.
@@ -276,8 +406,8 @@ func (r *Router) GetUserUrls(writer http.ResponseWriter, req *http.Request) {
 		writer.WriteHeader(http.StatusNoContent)
 		return
 	} else if err != nil {
-		log.Println("Error while getting URLs", err)
-		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+		slog.ErrorContext(req.Context(), "Error while getting URLs", logging.Err(err))
+		writeError(writer, req, apierror.Internal, "")
 		return
 	}
 
@@ -288,37 +418,138 @@ func (r *Router) GetUserUrls(writer http.ResponseWriter, req *http.Request) {
 	marshalResponseAndSetCookie(writer, http.StatusOK, nil, urlsList)
 }
 
-// ShortenBatch receives JSON with the list of URLs and their correlation_id and returns status 201
-// and the list of shortened URLs with their correlation_id.
+// ShortenBatch receives JSON with the list of URLs and their correlation_id and returns the result for every URL:
+// its correlation_id, status and shortened URL if the status is created or existing or the reason otherwise.
+// Returns status 201 if all URLs are created, 409 if all URLs existed, 400 if all URLs are invalid or the batch is empty,
+// 500 if no URL is saved because of errors and 207 for a mixed outcome.
+// URLs are saved by chunks of batchChunkSize, URLs of the chunk which is failed to save get status failed, so
+// the client knows which URLs are saved.
 // If request does not contain a valid token a new user will be created.
 func (r *Router) ShortenBatch(writer http.ResponseWriter, req *http.Request) {
-	var urls []internal.CorrIDOriginalURL
-	if !unmarshalRequest(writer, req, &urls) {
+	var (
+		identified  bool
+		userID      int
+		tokenCookie *http.Cookie
+		shortenUrls = make([]CorrIDShortURL, 0)
+		counts      = make(map[string]int)
+	)
+	// the user is identified by the first chunk, so no user is created for a malformed body
+	// which is rejected before the first chunk
+	handle := func(urls []internal.CorrIDOriginalURL) error {
+		if !identified {
+			var err error
+			userID, tokenCookie, err = r.getIDAndCookie(req)
+			if err != nil {
+				return err
+			}
+			identified = true
+		}
+		if len(urls) == 0 {
+			return nil
+		}
+		results, err := r.service.ShortenBatch(req.Context(), urls, userID)
+		if err != nil {
+			slog.ErrorContext(req.Context(), "Error while adding URls", logging.Err(err))
+			results = make([]internal.BatchResult, len(urls))
+			for i, v := range urls {
+				results[i] = internal.BatchResult{CorrID: v.CorrID, Status: internal.BatchFailed, Reason: "URL was not saved"}
+			}
+		}
+		for _, v := range results {
+			u := CorrIDShortURL{CorrID: v.CorrID, Status: v.Status, Reason: v.Reason}
+			if v.Status == internal.BatchCreated || v.Status == internal.BatchExisting {
+				u.ShortURL = r.baseURL + "/" + strconv.Itoa(v.URLID)
+			}
+			shortenUrls = append(shortenUrls, u)
+			counts[v.Status]++
+		}
+		return nil
+	}
+	if !decodeBatch(writer, req, handle) {
 		return
 	}
-	userID, tokenCookie, err := r.getIDAndCookie(req)
+	if counts[internal.BatchFailed] == len(shortenUrls) {
+		writeError(writer, req, apierror.Internal, "")
+		return
+	}
+	marshalResponseAndSetCookie(writer, batchStatus(counts, len(shortenUrls)), tokenCookie, shortenUrls)
+}
+
+// batchStatus returns the status of the batch response by the numbers of URLs with every status.
+func batchStatus(counts map[string]int, total int) int {
+	switch total {
+	case counts[internal.BatchCreated]:
+		return http.StatusCreated
+	case counts[internal.BatchExisting]:
+		return http.StatusConflict
+	case counts[internal.BatchInvalid]:
+		return http.StatusBadRequest
+	default:
+		return http.StatusMultiStatus
+	}
+}
+
+// retryAfter is the number of seconds in header Retry-After of the response with status 503.
+const retryAfter = "10"
+
+// DeleteBatch receives the list of shortened URL IDs, queued them for deletion and returns status 202
+// with the deletion operation. Its status is available by the URL from header Location.
+// Returns status 503 if the deletion queue is full.
+func (r *Router) DeleteBatch(writer http.ResponseWriter, req *http.Request) {
+	var urlIDs []string
+	if !unmarshalRequest(writer, req, &urlIDs) {
+		return
+	}
+
+	userID, err := r.getID(req)
 	if err != nil {
-		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+		writeError(writer, req, apierror.Unauthorized, "")
 		return
 	}
 
-	corrIDUrlIDs, err := r.store.AddBatch(req.Context(), urls, userID)
+	ids, err := parseURLIDs(urlIDs)
 	if err != nil {
-		log.Println("Error while adding URls", err)
-		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+		writeError(writer, req, apierror.InvalidRequest, err.Error())
 		return
 	}
+	op, err := r.deleteWorker.Delete(req.Context(), userID, ids)
+	if errors.Is(err, storage.ErrQueueFull) {
+		writer.Header().Set("Retry-After", retryAfter)
+		writeError(writer, req, apierror.DeleteQueueFull, "")
+		return
+	} else if err != nil {
+		slog.ErrorContext(req.Context(), "Error while queueing URLs for deletion", logging.Err(err))
+		writeError(writer, req, apierror.Internal, "")
+		return
+	}
+	writer.Header().Set("Location", "/api/user/urls/operations/"+op.ID)
+	marshalResponseAndSetCookie(writer, http.StatusAccepted, nil, op)
+}
 
-	shortenUrls := make([]CorrIDShortURL, len(corrIDUrlIDs))
-	for i, v := range corrIDUrlIDs {
-		u := CorrIDShortURL{CorrID: v.CorrID, ShortURL: r.baseURL + "/" + strconv.Itoa(v.URLID)}
-		shortenUrls[i] = u
+// GetDeleteOperation returns the deletion operation with the status of every URL ID:
+// queued, rejected, deleted, already_deleted, not_found or not_owner.
+// Returns status 404 if the operation does not belong to the user.
+func (r *Router) GetDeleteOperation(writer http.ResponseWriter, req *http.Request) {
+	userID, err := r.getID(req)
+	if err != nil {
+		writeError(writer, req, apierror.OperationNotFound, "")
+		return
 	}
-	marshalResponseAndSetCookie(writer, http.StatusCreated, tokenCookie, shortenUrls)
+	op, err := r.deleteWorker.Operation(req.Context(), chi.URLParam(req, "id"))
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && op.UserID != userID) {
+		writeError(writer, req, apierror.OperationNotFound, "")
+		return
+	} else if err != nil {
+		slog.ErrorContext(req.Context(), "Error while getting deletion operation", logging.Err(err))
+		writeError(writer, req, apierror.Internal, "")
+		return
+	}
+	marshalResponseAndSetCookie(writer, http.StatusOK, nil, op)
 }
 
-// DeleteBatch receives the list of shortened URL IDs, queued them for deletion and returns status 202.
-func (r *Router) DeleteBatch(writer http.ResponseWriter, req *http.Request) {
+// RestoreBatch receives the list of shortened URL IDs, restores URLs deleted within the grace period
+// and returns status 200 with the status of every URL ID: restored, not_deleted, expired, not_found or not_owner.
+func (r *Router) RestoreBatch(writer http.ResponseWriter, req *http.Request) {
 	var urlIDs []string
 	if !unmarshalRequest(writer, req, &urlIDs) {
 		return
@@ -326,20 +557,32 @@ func (r *Router) DeleteBatch(writer http.ResponseWriter, req *http.Request) {
 
 	userID, err := r.getID(req)
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
+		writeError(writer, req, apierror.Unauthorized, "")
 		return
 	}
 
-	idsToDelete := make([]internal.IDToDelete, len(urlIDs))
+	ids, err := parseURLIDs(urlIDs)
+	if err != nil {
+		writeError(writer, req, apierror.InvalidRequest, err.Error())
+		return
+	}
+	results, err := r.service.Restore(req.Context(), userID, ids)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error while restoring URLs", logging.Err(err))
+		writeError(writer, req, apierror.Internal, "")
+		return
+	}
+	marshalResponseAndSetCookie(writer, http.StatusOK, nil, results)
+}
 
+func parseURLIDs(urlIDs []string) ([]int, error) {
+	ids := make([]int, len(urlIDs))
 	for i, idStr := range urlIDs {
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
-			http.Error(writer, err.Error(), http.StatusBadRequest)
-			return
+			return nil, fmt.Errorf("id %q is not an integer", idStr)
 		}
-		idsToDelete[i] = internal.IDToDelete{ID: id, UserID: userID}
+		ids[i] = id
 	}
-	r.deleteWorker.Delete(idsToDelete)
-	writer.WriteHeader(http.StatusAccepted)
+	return ids, nil
 }
@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/health"
 	"github.com/MalyginaEkaterina/shortener/internal/service"
 	"github.com/MalyginaEkaterina/shortener/internal/storage"
 	"github.com/stretchr/testify/assert"
@@ -13,7 +15,9 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestGetUrlById(t *testing.T) {
@@ -37,7 +41,7 @@ func TestGetUrlById(t *testing.T) {
 			name:  "Negative test with incorrect url id #1",
 			path:  "/1",
 			store: &mockStorage{getURLErr: storage.ErrNotFound},
-			want:  want{400, ""},
+			want:  want{404, ""},
 		},
 		{
 			name:  "Negative test with getURLError",
@@ -49,20 +53,20 @@ func TestGetUrlById(t *testing.T) {
 			name:  "Negative test with empty url id",
 			path:  "/",
 			store: &mockStorage{},
-			want:  want{400, ""},
+			want:  want{405, ""},
 		},
 		{
 			name:  "Negative test with empty url id #2",
 			path:  "",
 			store: &mockStorage{},
-			want:  want{400, ""},
+			want:  want{405, ""},
 		},
 	}
 	cfg := internal.Config{Address: ":8080", BaseURL: "http://localhost:8080"}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			r := NewRouter(tt.store, cfg, Signer{SecretKey: []byte("secret again")},
-				service.URLService{Store: tt.store}, service.NewDeleteWorker(tt.store))
+				service.URLService{Store: tt.store}, service.NewDeleteWorker(tt.store, storage.NewMemoryDeleteQueue(100), storage.NewMemoryOperationStorage(), nil, nil))
 			ts := httptest.NewServer(r)
 			defer ts.Close()
 
@@ -119,7 +123,7 @@ func TestShortUrl(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			r := NewRouter(tt.store, cfg, Signer{SecretKey: []byte("sikrit")},
-				service.URLService{Store: tt.store}, service.NewDeleteWorker(tt.store))
+				service.URLService{Store: tt.store}, service.NewDeleteWorker(tt.store, storage.NewMemoryDeleteQueue(100), storage.NewMemoryOperationStorage(), nil, nil))
 			ts := httptest.NewServer(r)
 			defer ts.Close()
 
@@ -178,7 +182,7 @@ func TestShorten(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			r := NewRouter(tt.store, cfg, Signer{SecretKey: []byte("secret")},
-				service.URLService{Store: tt.store}, service.NewDeleteWorker(tt.store))
+				service.URLService{Store: tt.store}, service.NewDeleteWorker(tt.store, storage.NewMemoryDeleteQueue(100), storage.NewMemoryOperationStorage(), nil, nil))
 			ts := httptest.NewServer(r)
 			defer ts.Close()
 
@@ -240,7 +244,7 @@ func TestGetUserUrls(t *testing.T) {
 	cfg := internal.Config{Address: ":8080", BaseURL: "http://localhost:8080"}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := NewRouter(tt.store, cfg, signer, service.URLService{Store: tt.store}, service.NewDeleteWorker(tt.store))
+			r := NewRouter(tt.store, cfg, signer, service.URLService{Store: tt.store}, service.NewDeleteWorker(tt.store, storage.NewMemoryDeleteQueue(100), storage.NewMemoryOperationStorage(), nil, nil))
 			ts := httptest.NewServer(r)
 			defer ts.Close()
 
@@ -285,8 +289,41 @@ func TestShortenBatch(t *testing.T) {
 				{CorrID: "str2", URLID: 2},
 			}},
 			want: want{201, []CorrIDShortURL{
-				{CorrID: "str1", ShortURL: "http://localhost:8080/1"},
-				{CorrID: "str2", ShortURL: "http://localhost:8080/2"},
+				{CorrID: "str1", ShortURL: "http://localhost:8080/1", Status: "created"},
+				{CorrID: "str2", ShortURL: "http://localhost:8080/2", Status: "created"},
+			}},
+		},
+		{
+			name:    "Test with all existing URLs",
+			request: "[{\"correlation_id\":\"str1\",\"original_url\":\"https://test1.ru\"}]",
+			store: &mockStorage{addBatch: []internal.CorrIDUrlID{
+				{CorrID: "str1", URLID: 1, Existed: true},
+			}},
+			want: want{409, []CorrIDShortURL{
+				{CorrID: "str1", ShortURL: "http://localhost:8080/1", Status: "existing"},
+			}},
+		},
+		{
+			name: "Test with mixed outcome",
+			request: "[{\"correlation_id\":\"str1\",\"original_url\":\"https://test1.ru\"}," +
+				"{\"correlation_id\":\"str2\",\"original_url\":\"test2\"}," +
+				"{\"correlation_id\":\"str3\",\"original_url\":\"https://test3.ru\"}]",
+			store: &mockStorage{addBatch: []internal.CorrIDUrlID{
+				{CorrID: "str1", URLID: 1},
+				{CorrID: "str3", URLID: 3, Existed: true},
+			}},
+			want: want{207, []CorrIDShortURL{
+				{CorrID: "str1", ShortURL: "http://localhost:8080/1", Status: "created"},
+				{CorrID: "str2", Status: "invalid", Reason: "original_url must be an absolute http or https URL"},
+				{CorrID: "str3", ShortURL: "http://localhost:8080/3", Status: "existing"},
+			}},
+		},
+		{
+			name:    "Test with all invalid URLs",
+			request: "[{\"correlation_id\":\"\",\"original_url\":\"https://test1.ru\"}]",
+			store:   &mockStorage{},
+			want: want{400, []CorrIDShortURL{
+				{CorrID: "", Status: "invalid", Reason: "correlation_id is required"},
 			}},
 		},
 		{
@@ -306,7 +343,7 @@ func TestShortenBatch(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			r := NewRouter(tt.store, cfg, Signer{SecretKey: []byte("my secret key")},
-				service.URLService{Store: tt.store}, service.NewDeleteWorker(tt.store))
+				service.URLService{Store: tt.store}, service.NewDeleteWorker(tt.store, storage.NewMemoryDeleteQueue(100), storage.NewMemoryOperationStorage(), nil, nil))
 			ts := httptest.NewServer(r)
 			defer ts.Close()
 
@@ -328,6 +365,181 @@ func TestShortenBatch(t *testing.T) {
 
 }
 
+func TestDeleteBatch(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	signer := Signer{SecretKey: []byte("my secret key")}
+	token, err := signer.CreateSign(1)
+	require.NoError(t, err)
+	cfg := internal.Config{Address: ":8080", BaseURL: "http://localhost:8080"}
+	r := NewRouter(store, cfg, signer, service.URLService{Store: store},
+		service.NewDeleteWorker(store, storage.NewMemoryDeleteQueue(2), storage.NewMemoryOperationStorage(), nil, nil))
+
+	tests := []struct {
+		name       string
+		request    string
+		statusCode int
+	}{
+		{name: "Queued", request: `["1","2"]`, statusCode: 202},
+		{name: "Queue is full", request: `["3"]`, statusCode: 503},
+		{name: "Wrong id", request: `["x"]`, statusCode: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodDelete, "/api/user/urls", bytes.NewBufferString(tt.request))
+			request.AddCookie(&http.Cookie{Name: "token", Value: token})
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, request)
+			assert.Equal(t, tt.statusCode, resp.Code)
+			if tt.statusCode == http.StatusServiceUnavailable {
+				assert.Equal(t, retryAfter, resp.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestDeleteOperationAndRestore(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	signer := Signer{SecretKey: []byte("my secret key")}
+	token, err := signer.CreateSign(1)
+	require.NoError(t, err)
+	otherToken, err := signer.CreateSign(2)
+	require.NoError(t, err)
+	id, err := store.AddURL(context.Background(), "https://ya.ru", 1)
+	require.NoError(t, err)
+	cfg := internal.Config{Address: ":8080", BaseURL: "http://localhost:8080"}
+	r := NewRouter(store, cfg, signer, service.URLService{Store: store, RestoreGracePeriod: time.Hour},
+		service.NewDeleteWorker(store, storage.NewMemoryDeleteQueue(10), storage.NewMemoryOperationStorage(), nil, nil))
+
+	do := func(method, target, body, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		request.AddCookie(&http.Cookie{Name: "token", Value: token})
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, request)
+		return resp
+	}
+
+	resp := do(http.MethodDelete, "/api/user/urls", `["`+strconv.Itoa(id)+`"]`, token)
+	require.Equal(t, http.StatusAccepted, resp.Code)
+	location := resp.Header().Get("Location")
+	require.NotEmpty(t, location)
+
+	resp = do(http.MethodGet, location, "", token)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var op internal.DeleteOperation
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &op))
+	assert.Equal(t, internal.OperationPending, op.Status)
+	assert.Equal(t, []internal.DeleteResult{{ID: id, Status: internal.DeleteQueued}}, op.Results)
+
+	resp = do(http.MethodGet, location, "", otherToken)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp = do(http.MethodGet, "/api/user/urls/operations/unknown", "", token)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	_, err = store.DeleteBatch(context.Background(), []internal.IDToDelete{{ID: id, UserID: 1}})
+	require.NoError(t, err)
+	resp = do(http.MethodPost, "/api/user/urls/restore", `["`+strconv.Itoa(id)+`","100"]`, token)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var results []internal.DeleteResult
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &results))
+	assert.Equal(t, []internal.DeleteResult{
+		{ID: id, Status: internal.DeleteRestored},
+		{ID: 100, Status: internal.DeleteNotFound},
+	}, results)
+	url, err := store.GetURL(context.Background(), strconv.Itoa(id))
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", url)
+}
+
+func TestExportAndEraseUser(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	erasures := storage.NewMemoryErasureStorage()
+	signer := Signer{SecretKey: []byte("my secret key"), Erasures: erasures}
+	token, err := signer.CreateSign(1)
+	require.NoError(t, err)
+	id1, err := store.AddURL(context.Background(), "https://ya1.ru", 1)
+	require.NoError(t, err)
+	id2, err := store.AddURL(context.Background(), "https://ya2.ru", 1)
+	require.NoError(t, err)
+	_, err = store.DeleteBatch(context.Background(), []internal.IDToDelete{{ID: id2, UserID: 1}})
+	require.NoError(t, err)
+	jobs := storage.NewMemoryJobStorage()
+	require.NoError(t, jobs.CreateJob(context.Background(), internal.ImportJob{ID: "job", UserID: 1}, nil))
+	ops := storage.NewMemoryOperationStorage()
+	require.NoError(t, ops.CreateOperation(context.Background(), internal.DeleteOperation{ID: "op", UserID: 1}))
+	webhookStore := storage.NewMemoryWebhookStorage()
+	require.NoError(t, webhookStore.AddWebhook(context.Background(),
+		internal.Webhook{ID: "wh", UserID: 1, URL: "https://example.com/hook", Secret: "secret"}))
+	require.NoError(t, webhookStore.AddDeliveries(context.Background(), []internal.WebhookDelivery{
+		{ID: "delivery", WebhookID: "wh", Status: internal.DeliveryPending}}))
+	cfg := internal.Config{Address: ":8080", BaseURL: "http://localhost:8080"}
+	webhooks := service.NewWebhooks(webhookStore, store, cfg.BaseURL, 1, time.Second, 0)
+	r := NewRouter(store, cfg, signer, service.URLService{Store: store, Erasures: erasures, Jobs: jobs, Operations: ops,
+		Webhooks: webhooks},
+		service.NewDeleteWorker(store, storage.NewMemoryDeleteQueue(10), ops, nil, nil))
+
+	do := func(method, target, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			request.AddCookie(&http.Cookie{Name: "token", Value: token})
+		}
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, request)
+		return resp
+	}
+
+	resp := do(http.MethodGet, "/api/user/export", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	resp = do(http.MethodGet, "/api/user/export", token)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/zip", resp.Header().Get("Content-Type"))
+	zr, err := zip.NewReader(bytes.NewReader(resp.Body.Bytes()), int64(resp.Body.Len()))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 2)
+	var account UserAccount
+	readZipJSON(t, zr.File[0], &account)
+	assert.Equal(t, 1, account.UserID)
+	assert.Equal(t, 2, account.Links)
+	assert.Equal(t, 1, account.DeletedLinks)
+	var links []UserLink
+	readZipJSON(t, zr.File[1], &links)
+	require.Len(t, links, 2)
+	assert.Equal(t, "http://localhost:8080/"+strconv.Itoa(id1), links[0].ShortURL)
+	assert.Equal(t, "https://ya1.ru", links[0].OriginalURL)
+	assert.False(t, links[0].IsDeleted)
+	assert.True(t, links[1].IsDeleted)
+	assert.NotNil(t, links[1].DeletedAt)
+
+	resp = do(http.MethodDelete, "/api/user", token)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var erasure internal.UserErasure
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &erasure))
+	assert.Equal(t, 1, erasure.UserID)
+	assert.Equal(t, 2, erasure.Links)
+	_, err = store.GetURL(context.Background(), strconv.Itoa(id1))
+	assert.ErrorIs(t, err, storage.ErrDeleted)
+	_, err = jobs.GetJob(context.Background(), "job")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = ops.GetOperation(context.Background(), "op")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = webhookStore.GetWebhook(context.Background(), "wh")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = webhookStore.GetDelivery(context.Background(), "delivery")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	resp = do(http.MethodGet, "/api/user/urls", token)
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	resp = do(http.MethodGet, "/api/user/export", token)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func readZipJSON(t *testing.T, f *zip.File, v any) {
+	rc, err := f.Open()
+	require.NoError(t, err)
+	defer rc.Close()
+	require.NoError(t, json.NewDecoder(rc).Decode(v))
+}
+
 type mockStorage struct {
 	addURL        int
 	addURLErr     error
@@ -340,8 +552,28 @@ type mockStorage struct {
 	addBatchErr   error
 }
 
-func (s *mockStorage) DeleteBatch(_ context.Context, _ []internal.IDToDelete) error {
-	return nil
+func (s *mockStorage) DeleteBatch(_ context.Context, _ []internal.IDToDelete) ([]internal.DeleteResult, error) {
+	return nil, nil
+}
+
+func (s *mockStorage) RestoreBatch(_ context.Context, _ []internal.IDToDelete, _ time.Time) ([]internal.DeleteResult, error) {
+	return nil, nil
+}
+
+func (s *mockStorage) PurgeDeleted(_ context.Context, _ time.Time) ([]internal.URLRecord, error) {
+	return nil, nil
+}
+
+func (s *mockStorage) GetURLOwner(_ context.Context, _ int) (int, error) {
+	return 0, storage.ErrNotFound
+}
+
+func (s *mockStorage) GetUserRecords(_ context.Context, _ int) ([]internal.URLRecord, error) {
+	return nil, nil
+}
+
+func (s *mockStorage) EraseUser(_ context.Context, _ int) ([]int, error) {
+	return nil, nil
 }
 
 func (s *mockStorage) AddBatch(_ context.Context, _ []internal.CorrIDOriginalURL, _ int) ([]internal.CorrIDUrlID, error) {
@@ -374,5 +606,16 @@ func (s *mockStorage) GetURL(_ context.Context, _ string) (string, error) {
 	return s.getURL, s.getURLErr
 }
 
+func (s *mockStorage) Export(_ context.Context, _ func(url internal.URLRecord) error) error {
+	return nil
+}
+
+func (s *mockStorage) Import(_ context.Context, _ []internal.URLRecord) (int, error) {
+	return 0, nil
+}
+
+func (s *mockStorage) RegisterChecks(_ health.Registry) {
+}
+
 func (s *mockStorage) Close() {
 }
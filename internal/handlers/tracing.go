@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// traceRequest starts the server span of the request continuing the trace from W3C traceparent header if it is present.
+// The span is named by the method and the route pattern after the request is routed.
+func traceRequest(next http.Handler) http.Handler {
+	named := http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		span := trace.SpanFromContext(req.Context())
+		span.SetAttributes(attribute.String("http.request_id", middleware.GetReqID(req.Context())))
+		next.ServeHTTP(writer, req)
+		if pattern := chi.RouteContext(req.Context()).RoutePattern(); pattern != "" {
+			span.SetName(req.Method + " " + pattern)
+			span.SetAttributes(attribute.String("http.route", pattern))
+		}
+	})
+	return otelhttp.NewHandler(named, "HTTP request")
+}
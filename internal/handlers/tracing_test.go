@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/service"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/MalyginaEkaterina/shortener/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTraceRequest(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	_, err := tracing.Init(context.Background(), "")
+	require.NoError(t, err)
+
+	store := storage.NewTracingStorage(storage.NewMemoryStorage(), "memory")
+	signer := Signer{SecretKey: []byte("my secret key")}
+	cfg := internal.Config{Address: ":8080", BaseURL: "http://localhost:8080"}
+	r := NewRouter(store, cfg, signer, service.URLService{Store: store},
+		service.NewDeleteWorker(store, storage.NewMemoryDeleteQueue(2), storage.NewMemoryOperationStorage(), nil, nil))
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://ya.ru"}`))
+	request.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	require.Equal(t, http.StatusCreated, resp.Code)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		assert.Equal(t, traceID, s.SpanContext().TraceID().String(), s.Name())
+		spans[s.Name()] = s
+	}
+	server, ok := spans["POST /api/shorten"]
+	require.True(t, ok)
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	addURL, ok := spans["URLService.AddURL"]
+	require.True(t, ok)
+	assert.Equal(t, server.SpanContext().SpanID(), addURL.Parent().SpanID())
+	for _, name := range []string{"Storage.AddUser", "Storage.AddURL"} {
+		s, ok := spans[name]
+		require.True(t, ok, name)
+		assert.NotEqual(t, server.SpanContext().SpanID(), s.SpanContext().SpanID())
+	}
+	assert.Equal(t, addURL.SpanContext().SpanID(), spans["Storage.AddURL"].Parent().SpanID())
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/apierror"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/MalyginaEkaterina/shortener/internal/service"
+	"github.com/go-chi/chi/v5/middleware"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// UserAccount contains metadata of the account in the archive of user data.
+// Clicks on short URLs are not recorded, so the archive contains no click data.
+type UserAccount struct {
+	UserID       int       `json:"user_id"`
+	ExportedAt   time.Time `json:"exported_at"`
+	Links        int       `json:"links"`
+	DeletedLinks int       `json:"deleted_links"`
+}
+
+// UserLink contains the URL of the user in the archive of user data.
+type UserLink struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	CreatedAt   time.Time  `json:"created_at"`
+	IsDeleted   bool       `json:"is_deleted"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+}
+
+// ExportUserData returns zip archive with all data of the user: account.json with metadata of the account
+// and links.json with all URLs of the user including deleted ones. Returns status 401 if there is no valid token.
+func (r *Router) ExportUserData(writer http.ResponseWriter, req *http.Request) {
+	userID, err := r.getID(req)
+	if err != nil {
+		writeError(writer, req, apierror.Unauthorized, "")
+		return
+	}
+	records, err := r.store.GetUserRecords(req.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error while getting URLs of user", logging.Err(err))
+		writeError(writer, req, apierror.Internal, "")
+		return
+	}
+
+	account := UserAccount{UserID: userID, ExportedAt: time.Now(), Links: len(records)}
+	links := make([]UserLink, len(records))
+	for i, rec := range records {
+		links[i] = UserLink{
+			ShortURL:    r.baseURL + "/" + strconv.Itoa(rec.ID),
+			OriginalURL: rec.OriginalURL,
+			CreatedAt:   rec.CreatedAt,
+			IsDeleted:   rec.IsDeleted,
+		}
+		if rec.IsDeleted {
+			account.DeletedLinks++
+			deletedAt := rec.DeletedAt
+			links[i].DeletedAt = &deletedAt
+		}
+	}
+
+	writer.Header().Set("Content-Type", "application/zip")
+	writer.Header().Set("Content-Disposition", "attachment; filename=user-"+strconv.Itoa(userID)+".zip")
+	writer.WriteHeader(http.StatusOK)
+	zw := zip.NewWriter(writer)
+	err = writeZipJSON(zw, "account.json", account)
+	if err == nil {
+		err = writeZipJSON(zw, "links.json", links)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error while writing user data", logging.Err(err))
+	}
+}
+
+func writeZipJSON(zw *zip.Writer, name string, v any) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// EraseUser removes all URLs of the user keeping their ids deleted, revokes tokens of the user and returns status 200
+// with the audit record of the erasure. Import jobs, deletion operations and webhooks of the user are erased too.
+// Returns status 401 if there is no valid token.
+func (r *Router) EraseUser(writer http.ResponseWriter, req *http.Request) {
+	userID, err := r.getID(req)
+	if err != nil {
+		writeError(writer, req, apierror.Unauthorized, "")
+		return
+	}
+	erasure, err := r.service.EraseUser(req.Context(), internal.UserErasure{
+		UserID:    userID,
+		RequestID: middleware.GetReqID(req.Context()),
+		IP:        req.RemoteAddr,
+	})
+	if errors.Is(err, service.ErrErasureDisabled) {
+		writeError(writer, req, apierror.ErasureDisabled, "")
+		return
+	} else if err != nil {
+		slog.ErrorContext(req.Context(), "Error while erasing user", logging.Err(err))
+		writeError(writer, req, apierror.Internal, "")
+		return
+	}
+	marshalResponseAndSetCookie(writer, http.StatusOK, &http.Cookie{Name: "token", MaxAge: -1}, erasure)
+}
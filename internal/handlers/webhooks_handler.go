@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/apierror"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/MalyginaEkaterina/shortener/internal/service"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultDeliveriesLimit = 100
+	maxDeliveriesLimit     = 1000
+)
+
+// WebhookRequest contains a request to register the webhook. All events are received if Events is empty.
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// Webhooks contains dependencies of webhooks endpoints.
+type Webhooks struct {
+	*Router
+	webhooks *service.Webhooks
+}
+
+// NewWebhooksRouter creates chi Router with endpoints of webhooks of the user.
+func NewWebhooksRouter(store storage.Storage, cfg internal.Config, signer Signer, webhooks *service.Webhooks) chi.Router {
+	h := Webhooks{
+		Router:   &Router{store: store, signer: signer, baseURL: cfg.BaseURL},
+		webhooks: webhooks,
+	}
+	r := chi.NewRouter()
+	r.Post("/", h.Register)
+	r.Get("/", h.List)
+	r.Delete("/{id}", h.Delete)
+	r.Get("/{id}/deliveries", h.Deliveries)
+	r.Post("/{id}/deliveries/{deliveryID}/retry", h.Redeliver)
+	return r
+}
+
+// Register receives JSON with the URL of the endpoint and the list of events and returns status 201 and the webhook
+// with the secret used to sign its requests. The secret is returned only once.
+// Requests are posted with headers X-Shortener-Event, X-Shortener-Delivery, X-Shortener-Timestamp and
+// X-Shortener-Signature, see service.SignWebhook. Returns status 401 if there is no valid token.
+func (h Webhooks) Register(writer http.ResponseWriter, req *http.Request) {
+	userID, err := h.getID(req)
+	if err != nil {
+		writeError(writer, req, apierror.Unauthorized, "")
+		return
+	}
+	var webhookReq WebhookRequest
+	if !unmarshalRequest(writer, req, &webhookReq) {
+		return
+	}
+	webhook, err := h.webhooks.Register(req.Context(), userID, webhookReq.URL, webhookReq.Events)
+	if errors.Is(err, service.ErrBadWebhook) {
+		writeError(writer, req, apierror.InvalidRequest, err.Error())
+		return
+	} else if err != nil {
+		slog.ErrorContext(req.Context(), "Error while registering webhook", logging.Err(err))
+		writeError(writer, req, apierror.Internal, "")
+		return
+	}
+	marshalResponseAndSetCookie(writer, http.StatusCreated, nil, webhook)
+}
+
+// List returns webhooks of the user without secrets. Returns status 401 if there is no valid token.
+func (h Webhooks) List(writer http.ResponseWriter, req *http.Request) {
+	userID, err := h.getID(req)
+	if err != nil {
+		writeError(writer, req, apierror.Unauthorized, "")
+		return
+	}
+	webhooks, err := h.webhooks.List(req.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error while getting webhooks", logging.Err(err))
+		writeError(writer, req, apierror.Internal, "")
+		return
+	}
+	if webhooks == nil {
+		webhooks = []internal.Webhook{}
+	}
+	marshalResponseAndSetCookie(writer, http.StatusOK, nil, webhooks)
+}
+
+// Delete deletes the webhook and returns status 204. Returns status 404 if the webhook does not belong to the user.
+func (h Webhooks) Delete(writer http.ResponseWriter, req *http.Request) {
+	userID, err := h.getID(req)
+	if err != nil {
+		writeError(writer, req, apierror.WebhookNotFound, "")
+		return
+	}
+	err = h.webhooks.Delete(req.Context(), userID, chi.URLParam(req, "id"))
+	if !h.checkError(writer, req, err) {
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// Deliveries returns the delivery log of the webhook, last deliveries first. Query parameter status filters
+// deliveries by status, status=dead returns the dead-letter list. Query parameter limit is the maximum number
+// of deliveries. Returns status 404 if the webhook does not belong to the user.
+func (h Webhooks) Deliveries(writer http.ResponseWriter, req *http.Request) {
+	userID, err := h.getID(req)
+	if err != nil {
+		writeError(writer, req, apierror.WebhookNotFound, "")
+		return
+	}
+	limit := defaultDeliveriesLimit
+	if v := req.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxDeliveriesLimit {
+			writeError(writer, req, apierror.InvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxDeliveriesLimit))
+			return
+		}
+	}
+	deliveries, err := h.webhooks.Deliveries(req.Context(), userID, chi.URLParam(req, "id"),
+		req.URL.Query().Get("status"), limit)
+	if !h.checkError(writer, req, err) {
+		return
+	}
+	if deliveries == nil {
+		deliveries = []internal.WebhookDelivery{}
+	}
+	marshalResponseAndSetCookie(writer, http.StatusOK, nil, deliveries)
+}
+
+// Redeliver queues the dead delivery again and returns status 202 and the delivery.
+// Returns status 409 if the delivery is not dead and status 404 if it does not belong to the user.
+func (h Webhooks) Redeliver(writer http.ResponseWriter, req *http.Request) {
+	userID, err := h.getID(req)
+	if err != nil {
+		writeError(writer, req, apierror.WebhookNotFound, "")
+		return
+	}
+	delivery, err := h.webhooks.Redeliver(req.Context(), userID, chi.URLParam(req, "id"), chi.URLParam(req, "deliveryID"))
+	if errors.Is(err, service.ErrDeliveryNotDead) {
+		writeError(writer, req, apierror.DeliveryNotDead, "")
+		return
+	}
+	if !h.checkError(writer, req, err) {
+		return
+	}
+	marshalResponseAndSetCookie(writer, http.StatusAccepted, nil, delivery)
+}
+
+// checkError writes status 404 for storage.ErrNotFound and 500 for other errors. Returns true if err is nil.
+func (h Webhooks) checkError(writer http.ResponseWriter, req *http.Request, err error) bool {
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(writer, req, apierror.WebhookNotFound, "")
+		return false
+	} else if err != nil {
+		slog.ErrorContext(req.Context(), "Error while processing webhook", logging.Err(err))
+		writeError(writer, req, apierror.Internal, "")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/service"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhooks(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	signer := Signer{SecretKey: []byte("my secret key")}
+	token, err := signer.CreateSign(1)
+	require.NoError(t, err)
+	otherToken, err := signer.CreateSign(2)
+	require.NoError(t, err)
+	cfg := internal.Config{Address: ":8080", BaseURL: "http://localhost:8080"}
+	webhooks := service.NewWebhooks(storage.NewMemoryWebhookStorage(), store, cfg.BaseURL, 3, time.Second, time.Hour)
+	r := NewWebhooksRouter(store, cfg, signer, webhooks)
+
+	do := func(method, target, body, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		if token != "" {
+			request.AddCookie(&http.Cookie{Name: "token", Value: token})
+		}
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, request)
+		return resp
+	}
+
+	resp := do(http.MethodPost, "/", `{"url":"https://example.com/hook"}`, "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = do(http.MethodPost, "/", `{"url":"example.com"}`, token)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = do(http.MethodPost, "/", `{"url":"https://example.com/hook","events":["link.created"]}`, token)
+	require.Equal(t, http.StatusCreated, resp.Code)
+	var webhook internal.Webhook
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &webhook))
+	assert.NotEmpty(t, webhook.Secret)
+	assert.Equal(t, []string{internal.EventLinkCreated}, webhook.Events)
+
+	resp = do(http.MethodGet, "/", "", token)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var list []internal.Webhook
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &list))
+	require.Len(t, list, 1)
+	assert.Empty(t, list[0].Secret)
+	resp = do(http.MethodGet, "/", "", otherToken)
+	assert.JSONEq(t, `[]`, resp.Body.String())
+
+	resp = do(http.MethodGet, "/"+webhook.ID+"/deliveries?status=dead", "", token)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `[]`, resp.Body.String())
+	resp = do(http.MethodGet, "/"+webhook.ID+"/deliveries?limit=0", "", token)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = do(http.MethodGet, "/"+webhook.ID+"/deliveries", "", otherToken)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp = do(http.MethodPost, "/"+webhook.ID+"/deliveries/unknown/retry", "", token)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = do(http.MethodDelete, "/"+webhook.ID, "", otherToken)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp = do(http.MethodDelete, "/"+webhook.ID, "", token)
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	resp = do(http.MethodDelete, "/"+webhook.ID, "", token)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
@@ -0,0 +1,172 @@
+// Package health checks components of the application for the readiness probe.
+package health
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Statuses of components and the whole report.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// ErrTimeout is the result of the check which did not finish within the timeout.
+var ErrTimeout = errors.New("check timed out")
+
+// Check returns error if the component is not healthy.
+type Check func(ctx context.Context) error
+
+// Registry is used by components to register their checks.
+type Registry interface {
+	// Register adds the check of the component with the name. The check with the same name is replaced.
+	Register(name string, check Check)
+}
+
+// ComponentStatus is the result of the check of the component.
+type ComponentStatus struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Report is the result of all checks. Status is ok only if all components are ok.
+type Report struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components,omitempty"`
+}
+
+var _ Registry = (*Checker)(nil)
+
+// Checker runs registered checks. Every check is given timeout and its result is cached for ttl,
+// so that frequent probes do not load the components. Concurrent calls wait for the running check.
+type Checker struct {
+	ttl     time.Duration
+	timeout time.Duration
+
+	mutex  sync.Mutex
+	checks map[string]*entry
+}
+
+// entry is the registered check with its last result.
+type entry struct {
+	check Check
+
+	mutex  sync.Mutex
+	status ComponentStatus
+}
+
+// NewChecker creates new Checker.
+func NewChecker(ttl, timeout time.Duration) *Checker {
+	return &Checker{ttl: ttl, timeout: timeout, checks: make(map[string]*entry)}
+}
+
+// Register adds the check of the component with the name. The check with the same name is replaced.
+func (c *Checker) Register(name string, check Check) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.checks[name] = &entry{check: check}
+}
+
+// Names returns names of registered components in ascending order.
+func (c *Checker) Names() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	names := make([]string, 0, len(c.checks))
+	for name := range c.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run runs all checks concurrently or takes their cached results.
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mutex.Lock()
+	checks := make(map[string]*entry, len(c.checks))
+	for name, e := range c.checks {
+		checks[name] = e
+	}
+	c.mutex.Unlock()
+
+	report := Report{Status: StatusOK, Components: make(map[string]ComponentStatus, len(checks))}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for name, e := range checks {
+		wg.Add(1)
+		go func(name string, e *entry) {
+			defer wg.Done()
+			status := c.run(ctx, e)
+			mutex.Lock()
+			defer mutex.Unlock()
+			report.Components[name] = status
+			if status.Status != StatusOK {
+				report.Status = StatusFail
+			}
+		}(name, e)
+	}
+	wg.Wait()
+	return report
+}
+
+// RunComponent runs the check of the component with the name or takes its cached result.
+// Returns false if there is no such component.
+func (c *Checker) RunComponent(ctx context.Context, name string) (ComponentStatus, bool) {
+	c.mutex.Lock()
+	e, ok := c.checks[name]
+	c.mutex.Unlock()
+	if !ok {
+		return ComponentStatus{}, false
+	}
+	return c.run(ctx, e), true
+}
+
+// run returns the cached result of the check if it is younger than ttl, otherwise runs the check.
+func (c *Checker) run(ctx context.Context, e *entry) ComponentStatus {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if !e.status.CheckedAt.IsZero() && time.Since(e.status.CheckedAt) < c.ttl {
+		return e.status
+	}
+	// The result is shared with other callers, so it must not depend on cancellation of this one.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- e.check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ErrTimeout
+	}
+	e.status = ComponentStatus{Status: StatusOK, Duration: time.Since(start).String(), CheckedAt: time.Now()}
+	if err != nil {
+		e.status.Status = StatusFail
+		e.status.Error = err.Error()
+	}
+	return e.status
+}
+
+// prefixed adds the prefix to names of registered checks.
+type prefixed struct {
+	registry Registry
+	prefix   string
+}
+
+// WithPrefix returns Registry which adds the prefix to names of checks registered in r.
+func WithPrefix(r Registry, prefix string) Registry {
+	return prefixed{registry: r, prefix: prefix}
+}
+
+// Register adds the check with the prefixed name.
+func (p prefixed) Register(name string, check Check) {
+	p.registry.Register(p.prefix+name, check)
+}
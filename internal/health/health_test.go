@@ -0,0 +1,68 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChecker(t *testing.T) {
+	c := NewChecker(time.Hour, 50*time.Millisecond)
+	var calls atomic.Int32
+	var fail atomic.Bool
+	c.Register("db", func(ctx context.Context) error {
+		calls.Add(1)
+		if fail.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	WithPrefix(c, "old.").Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.Equal(t, []string{"db", "old.slow"}, c.Names())
+
+	report := c.Run(context.Background())
+	assert.Equal(t, StatusFail, report.Status)
+	require.Len(t, report.Components, 2)
+	assert.Equal(t, StatusOK, report.Components["db"].Status)
+	assert.Equal(t, StatusFail, report.Components["old.slow"].Status)
+	assert.Equal(t, ErrTimeout.Error(), report.Components["old.slow"].Error)
+
+	fail.Store(true)
+	report = c.Run(context.Background())
+	assert.Equal(t, StatusOK, report.Components["db"].Status, "result is cached")
+	assert.Equal(t, int32(1), calls.Load())
+	status, ok := c.RunComponent(context.Background(), "db")
+	require.True(t, ok)
+	assert.Equal(t, StatusOK, status.Status)
+	assert.Equal(t, int32(1), calls.Load())
+	_, ok = c.RunComponent(context.Background(), "unknown")
+	assert.False(t, ok)
+}
+
+func TestCheckerTTL(t *testing.T) {
+	c := NewChecker(0, time.Second)
+	var fail atomic.Bool
+	c.Register("db", func(ctx context.Context) error {
+		if fail.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	assert.Equal(t, StatusOK, c.Run(context.Background()).Status)
+	fail.Store(true)
+	report := c.Run(context.Background())
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, "connection refused", report.Components["db"].Error)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	fail.Store(false)
+	assert.Equal(t, StatusOK, c.Run(canceled).Status, "cancellation of the caller does not fail checks")
+}
@@ -0,0 +1,154 @@
+// Package lifecycle runs background workers and stops them together with other components in a defined order.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stage defines the order of shutdown. Components of an earlier stage are stopped first.
+type Stage int
+
+// Stages of shutdown.
+const (
+	// StageServer stops accepting requests and waits for in-flight ones.
+	StageServer Stage = iota
+	// StageQueues drains queues filled by requests, e.g. the delete queue.
+	StageQueues
+	// StageWorkers stops other background workers.
+	StageWorkers
+	// StageStorage closes storages after nothing uses them.
+	StageStorage
+	// StageTelemetry flushes telemetry which is produced until the end.
+	StageTelemetry
+)
+
+// String returns the name of the stage.
+func (s Stage) String() string {
+	switch s {
+	case StageServer:
+		return "server"
+	case StageQueues:
+		return "queues"
+	case StageWorkers:
+		return "workers"
+	case StageStorage:
+		return "storage"
+	case StageTelemetry:
+		return "telemetry"
+	}
+	return fmt.Sprintf("stage%d", int(s))
+}
+
+// ErrDeadline is returned by Shutdown if components were not stopped before the deadline.
+var ErrDeadline = errors.New("shutdown deadline exceeded")
+
+// component is registered to be stopped at the stage.
+type component struct {
+	stage Stage
+	name  string
+	stop  func(ctx context.Context) error
+}
+
+// Manager stops registered components by stages. Components of the same stage are stopped in the reverse order
+// of registration like deferred calls, so that a component registered after its dependency is stopped before it.
+type Manager struct {
+	mutex      sync.Mutex
+	components []component
+	stopped    bool
+}
+
+// New creates new Manager.
+func New() *Manager {
+	return &Manager{}
+}
+
+// OnStop registers stop of the component at the stage.
+func (m *Manager) OnStop(stage Stage, name string, stop func(ctx context.Context) error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.components = append(m.components, component{stage: stage, name: name, stop: stop})
+}
+
+// OnClose registers close of the component at the stage.
+func (m *Manager) OnClose(stage Stage, name string, close func()) {
+	m.OnStop(stage, name, func(context.Context) error {
+		close()
+		return nil
+	})
+}
+
+// Go runs the worker in a goroutine until it is stopped at the stage. The worker is stopped by cancelling its context
+// and must return after finishing or saving its current work. Stop waits for the worker to return.
+func (m *Manager) Go(stage Stage, name string, run func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx)
+	}()
+	m.OnStop(stage, name, func(stopCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		}
+	})
+}
+
+// Shutdown stops all components by stages logging every step. Errors of components are logged and joined.
+// If ctx is done before all components are stopped, Shutdown returns ErrDeadline without waiting for them.
+// Shutdown may be called only once, next calls do nothing.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mutex.Lock()
+	if m.stopped {
+		m.mutex.Unlock()
+		return nil
+	}
+	m.stopped = true
+	components := make([]component, len(m.components))
+	for i, c := range m.components {
+		components[len(components)-1-i] = c
+	}
+	m.mutex.Unlock()
+	sort.SliceStable(components, func(i, j int) bool { return components[i].stage < components[j].stage })
+
+	done := make(chan error, 1)
+	go func() {
+		done <- stop(ctx, components)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		slog.Error("Shutdown deadline exceeded")
+		return ErrDeadline
+	}
+}
+
+// stop stops components one by one.
+func stop(ctx context.Context, components []component) error {
+	start := time.Now()
+	slog.Info("Shutting down")
+	var errs []error
+	for _, c := range components {
+		phaseStart := time.Now()
+		slog.Info("Stopping", "stage", c.stage.String(), "component", c.name)
+		if err := c.stop(ctx); err != nil {
+			slog.Error("Error while stopping", "stage", c.stage.String(), "component", c.name, logging.Err(err))
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+			continue
+		}
+		slog.Info("Stopped", "stage", c.stage.String(), "component", c.name, "duration", time.Since(phaseStart))
+	}
+	slog.Info("Shutdown is complete", "duration", time.Since(start))
+	return errors.Join(errs...)
+}
@@ -0,0 +1,63 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShutdownOrder(t *testing.T) {
+	m := New()
+	var mutex sync.Mutex
+	var order []string
+	record := func(name string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		order = append(order, name)
+	}
+
+	m.OnClose(StageStorage, "urls", func() { record("urls") })
+	m.OnClose(StageStorage, "queue", func() { record("queue") })
+	m.Go(StageQueues, "delete worker", func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		record("delete worker")
+	})
+	m.Go(StageWorkers, "webhooks", func(ctx context.Context) {
+		<-ctx.Done()
+		record("webhooks")
+	})
+	m.OnStop(StageServer, "server", func(ctx context.Context) error {
+		record("server")
+		return nil
+	})
+	failure := errors.New("flush failed")
+	m.OnStop(StageTelemetry, "tracing", func(ctx context.Context) error {
+		record("tracing")
+		return failure
+	})
+
+	err := m.Shutdown(context.Background())
+	require.ErrorIs(t, err, failure)
+	assert.Equal(t, []string{"server", "delete worker", "webhooks", "queue", "urls", "tracing"}, order)
+
+	require.NoError(t, m.Shutdown(context.Background()))
+	assert.Len(t, order, 6)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	m := New()
+	stuck := make(chan struct{})
+	defer close(stuck)
+	m.Go(StageWorkers, "stuck", func(ctx context.Context) {
+		<-stuck
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, m.Shutdown(ctx), ErrDeadline)
+}
@@ -0,0 +1,141 @@
+// Package listen opens listeners on TCP addresses, Unix domain sockets and sockets passed by systemd socket activation.
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Address prefixes of Unix domain sockets and sockets passed by systemd.
+const (
+	unixPrefix    = "unix:"
+	systemdPrefix = "systemd:"
+)
+
+// firstActivatedFD is the first file descriptor passed by systemd.
+const firstActivatedFD = 3
+
+// ErrNotActivated is returned by Listen if there is no socket passed by systemd with the name.
+var ErrNotActivated = errors.New("socket was not passed by systemd")
+
+// Activated contains sockets passed by systemd socket activation by their names and indexes.
+type Activated struct {
+	byName map[string]net.Listener
+	byInd  []net.Listener
+}
+
+// Systemd takes sockets passed by systemd socket activation and unsets its env vars,
+// so they are not inherited by child processes. It returns empty Activated if the process was not activated.
+func Systemd() (*Activated, error) {
+	a, err := activated(os.Getenv, os.Getpid(), firstActivatedFD)
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	return a, err
+}
+
+func activated(getenv func(string) string, pid int, firstFD int) (*Activated, error) {
+	a := &Activated{byName: make(map[string]net.Listener)}
+	if getenv("LISTEN_PID") != strconv.Itoa(pid) {
+		return a, nil
+	}
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", getenv("LISTEN_FDS"))
+	}
+	var names []string
+	if v := getenv("LISTEN_FDNAMES"); v != "" {
+		names = strings.Split(v, ":")
+	}
+	for i := 0; i < n; i++ {
+		fd := firstFD + i
+		syscall.CloseOnExec(fd)
+		name := strconv.Itoa(i)
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			a.Close()
+			return nil, fmt.Errorf("error while using socket %s passed by systemd: %w", name, err)
+		}
+		a.byInd = append(a.byInd, ln)
+		if i < len(names) {
+			a.byName[name] = ln
+		}
+	}
+	return a, nil
+}
+
+// Len returns the number of passed sockets.
+func (a *Activated) Len() int {
+	return len(a.byInd)
+}
+
+// get returns the socket by its name or index.
+func (a *Activated) get(name string) (net.Listener, error) {
+	if a == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotActivated, name)
+	}
+	if ln, ok := a.byName[name]; ok {
+		return ln, nil
+	}
+	if i, err := strconv.Atoi(name); err == nil && i >= 0 && i < len(a.byInd) {
+		return a.byInd[i], nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotActivated, name)
+}
+
+// Close closes all passed sockets. It is used to release sockets which are not served.
+func (a *Activated) Close() {
+	for _, ln := range a.byInd {
+		ln.Close()
+	}
+}
+
+// Listen opens the listener on the address: host:port, unix:<path> or systemd:<name> where name is
+// the FileDescriptorName of the socket or its index. The stale Unix domain socket file is removed before listening.
+func Listen(address string, a *Activated) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(address, systemdPrefix):
+		return a.get(strings.TrimPrefix(address, systemdPrefix))
+	case strings.HasPrefix(address, unixPrefix):
+		path := strings.TrimPrefix(address, unixPrefix)
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	default:
+		return net.Listen("tcp", address)
+	}
+}
+
+// removeStaleSocket removes the socket file left by the previous process. Sockets which accept connections
+// and files of other types are not touched.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error while checking socket file: %w", err)
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("error while removing stale socket file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package listen
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s.sock")
+	ln, err := Listen("unix:"+path, nil)
+	require.NoError(t, err)
+
+	_, err = Listen("unix:"+path, nil)
+	require.Error(t, err, "socket in use must not be removed")
+
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	conn.Close()
+	require.NoError(t, ln.Close())
+
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+	_, err = Listen("unix:"+path, nil)
+	require.Error(t, err, "regular file must not be removed")
+}
+
+func TestListenUnixStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s.sock")
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, ln.Close())
+
+	ln, err = Listen("unix:"+path, nil)
+	require.NoError(t, err)
+	ln.Close()
+}
+
+func TestSystemd(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer tcp.Close()
+	f, err := tcp.(*net.TCPListener).File()
+	require.NoError(t, err)
+	// The descriptor is owned by Activated as the ones passed by systemd.
+
+	env := map[string]string{
+		"LISTEN_PID":     "42",
+		"LISTEN_FDS":     "1",
+		"LISTEN_FDNAMES": "admin",
+	}
+	a, err := activated(func(k string) string { return env[k] }, 7, int(f.Fd()))
+	require.NoError(t, err)
+	assert.Equal(t, 0, a.Len(), "sockets of other process must be ignored")
+
+	env["LISTEN_PID"] = "7"
+	a, err = activated(func(k string) string { return env[k] }, 7, int(f.Fd()))
+	require.NoError(t, err)
+	defer a.Close()
+	require.Equal(t, 1, a.Len())
+
+	byName, err := Listen("systemd:admin", a)
+	require.NoError(t, err)
+	assert.Equal(t, tcp.Addr().String(), byName.Addr().String())
+	byInd, err := Listen("systemd:"+strconv.Itoa(0), a)
+	require.NoError(t, err)
+	assert.Equal(t, byName, byInd)
+
+	_, err = Listen("systemd:public", a)
+	require.ErrorIs(t, err, ErrNotActivated)
+	_, err = Listen("systemd:0", nil)
+	require.ErrorIs(t, err, ErrNotActivated)
+}
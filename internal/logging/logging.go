@@ -0,0 +1,140 @@
+// Package logging configures slog and carries attributes of the request, such as its id and the user id,
+// in the context, so that every line logged with the context can be correlated.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Log formats.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Keys of attributes of the request.
+const (
+	RequestIDKey = "request_id"
+	UserIDKey    = "user_id"
+	TraceIDKey   = "trace_id"
+	ErrorKey     = "error"
+)
+
+// ErrUnknownFormat is returned by New if the format is not json or text.
+var ErrUnknownFormat = errors.New("unknown log format")
+
+// New creates the logger writing to w in the format json or text with records of the level
+// debug, info, warn or error and above. Attributes saved in the context are added to every record.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	case FormatText:
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+	return slog.New(Handler{Handler: h}), nil
+}
+
+// Err returns the attribute of the error.
+func Err(err error) slog.Attr {
+	return slog.Any(ErrorKey, err)
+}
+
+type fieldsKey struct{}
+
+// fields are attributes of the context. They are changed by Set after the context is created,
+// e.g. when the user of the request becomes known, so they are guarded by the mutex.
+type fields struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// NewContext returns the context with its own set of attributes which contains attributes of ctx and args
+// given as key-value pairs or slog.Attr like in slog.Logger.With.
+func NewContext(ctx context.Context, args ...any) context.Context {
+	f := &fields{attrs: Attrs(ctx)}
+	f.attrs = append(f.attrs, argsToAttrs(args)...)
+	return context.WithValue(ctx, fieldsKey{}, f)
+}
+
+// Set sets the attribute in the set of ctx created by NewContext. It is visible to all contexts derived from ctx
+// and to the code holding ctx, e.g. to the access log. Does nothing if ctx has no set.
+func Set(ctx context.Context, key string, value any) {
+	f, ok := ctx.Value(fieldsKey{}).(*fields)
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, a := range f.attrs {
+		if a.Key == key {
+			f.attrs[i] = slog.Any(key, value)
+			return
+		}
+	}
+	f.attrs = append(f.attrs, slog.Any(key, value))
+}
+
+// Attrs returns a copy of attributes saved in ctx.
+func Attrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	f, ok := ctx.Value(fieldsKey{}).(*fields)
+	if !ok {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]slog.Attr(nil), f.attrs...)
+}
+
+// argsToAttrs converts key-value pairs and slog.Attr into attributes.
+func argsToAttrs(args []any) []slog.Attr {
+	var r slog.Record
+	r.Add(args...)
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return attrs
+}
+
+// Handler adds attributes saved in the context of the record to it.
+type Handler struct {
+	slog.Handler
+}
+
+// Handle adds attributes of ctx to the record and passes it to the wrapped handler.
+func (h Handler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs := Attrs(ctx); len(attrs) > 0 {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs returns Handler wrapping the handler with attrs.
+func (h Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return Handler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup returns Handler wrapping the handler with the group.
+func (h Handler) WithGroup(name string) slog.Handler {
+	return Handler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatText, "warn")
+	require.NoError(t, err)
+	logger.Info("skipped")
+	logger.Warn("logged", "a", 1)
+	assert.NotContains(t, buf.String(), "skipped")
+	assert.Contains(t, buf.String(), "msg=logged a=1")
+
+	_, err = New(&buf, "xml", "info")
+	require.ErrorIs(t, err, ErrUnknownFormat)
+	_, err = New(&buf, FormatJSON, "loud")
+	require.Error(t, err)
+}
+
+func TestContextAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatJSON, "info")
+	require.NoError(t, err)
+
+	ctx := NewContext(context.Background(), RequestIDKey, "req-1")
+	Set(ctx, UserIDKey, 1)
+	Set(ctx, UserIDKey, 2)
+	child := NewContext(ctx, slog.String("job_id", "job-1"))
+	Set(context.Background(), UserIDKey, 3)
+	logger.ErrorContext(child, "failed", Err(errors.New("boom")))
+	logger.InfoContext(context.Background(), "plain")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var line map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &line))
+	assert.Equal(t, "req-1", line[RequestIDKey])
+	assert.Equal(t, float64(2), line[UserIDKey])
+	assert.Equal(t, "job-1", line["job_id"])
+	assert.Equal(t, "boom", line[ErrorKey])
+	assert.NotContains(t, lines[1], RequestIDKey)
+	assert.Len(t, Attrs(ctx), 2)
+}
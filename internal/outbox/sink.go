@@ -0,0 +1,218 @@
+// Package outbox contains sinks which receive events published by the outbox relay.
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSubject is the NATS subject used if the sink target has no subject.
+const DefaultSubject = "shortener.links"
+
+const (
+	httpTimeout  = 10 * time.Second
+	flushTimeout = 10 * time.Second
+)
+
+// ErrUnknownSink is returned by NewSink if the target is not supported.
+var ErrUnknownSink = errors.New("unknown outbox sink")
+
+// Sink receives events in the order they were saved. The same events can be published again
+// if the relay fails before removing them from the outbox, so they must be deduplicated by id.
+type Sink interface {
+	// Publish publishes events. Returns error if any of them may not be published.
+	Publish(ctx context.Context, events []internal.OutboxEvent) error
+	// Close closes resources.
+	Close() error
+}
+
+// NewSink creates the sink by the target: "stdout", "file:<path>", http or https URL of the endpoint
+// or "nats://host:port/<subject>".
+func NewSink(target string) (Sink, error) {
+	switch {
+	case target == "stdout":
+		return NewWriterSink(os.Stdout), nil
+	case strings.HasPrefix(target, "file:"):
+		return NewFileSink(strings.TrimPrefix(target, "file:"))
+	case strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "https://"):
+		return NewHTTPSink(target, httpTimeout), nil
+	case strings.HasPrefix(target, "nats://"):
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		subject := strings.TrimPrefix(u.Path, "/")
+		u.Path = ""
+		return NewNATSSink(u.String(), subject)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownSink, target)
+}
+
+// writeEvents writes events as JSON lines.
+func writeEvents(w io.Writer, events []internal.OutboxEvent) error {
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriterSink writes events as JSON lines into the writer.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink creates new WriterSink.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// Publish writes events as JSON lines.
+func (s *WriterSink) Publish(_ context.Context, events []internal.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeEvents(s.w, events)
+}
+
+// Close does nothing, the writer is owned by the caller.
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// FileSink appends events as JSON lines to the file.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens or creates the file for appending.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Publish appends events to the file and syncs it, so they are not lost after they are removed from the outbox.
+func (s *FileSink) Publish(_ context.Context, events []internal.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := bufio.NewWriter(s.file)
+	err := writeEvents(w, events)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = s.file.Sync()
+	}
+	return err
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// HTTPSink posts events as JSON lines to the endpoint.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSink creates new HTTPSink.
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{url: url, client: &http.Client{Timeout: timeout, Transport: otelhttp.NewTransport(http.DefaultTransport)}}
+}
+
+// Publish posts events in one request with content type application/x-ndjson.
+// Events are published only if the endpoint responds with 2xx status.
+func (s *HTTPSink) Publish(ctx context.Context, events []internal.OutboxEvent) error {
+	var body bytes.Buffer
+	if err := writeEvents(&body, events); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("outbox endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close closes idle connections.
+func (s *HTTPSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// NATSSink publishes every event as a message to the NATS subject.
+type NATSSink struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// NewNATSSink connects to the NATS server. DefaultSubject is used if subject is empty.
+func NewNATSSink(url, subject string) (*NATSSink, error) {
+	if subject == "" {
+		subject = DefaultSubject
+	}
+	conn, err := nats.Connect(url)
+	if err != nil {
+		return nil, err
+	}
+	return &NATSSink{conn: conn, subject: subject}, nil
+}
+
+// Publish publishes events with the id of the event in header Nats-Msg-Id, which is used by JetStream
+// for deduplication, and waits until the server has processed them.
+func (s *NATSSink) Publish(ctx context.Context, events []internal.OutboxEvent) error {
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		msg := nats.NewMsg(s.subject)
+		msg.Header.Set(nats.MsgIdHdr, strconv.FormatInt(e.ID, 10))
+		msg.Data = data
+		if err = s.conn.PublishMsg(msg); err != nil {
+			return err
+		}
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		return s.conn.FlushTimeout(flushTimeout)
+	}
+	return s.conn.FlushWithContext(ctx)
+}
+
+// Close drains and closes the connection.
+func (s *NATSSink) Close() error {
+	return s.conn.Drain()
+}
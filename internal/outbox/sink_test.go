@@ -0,0 +1,125 @@
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testEvents() []internal.OutboxEvent {
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []internal.OutboxEvent{
+		{ID: 1, Type: internal.EventLinkCreated, URLID: 5, UserID: 2, OriginalURL: "https://ya.ru", Time: now},
+		{ID: 2, Type: internal.EventLinkDeleted, URLID: 5, UserID: 2, Time: now},
+	}
+}
+
+func readEvents(t *testing.T, r io.Reader) []internal.OutboxEvent {
+	var res []internal.OutboxEvent
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		var e internal.OutboxEvent
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &e))
+		res = append(res, e)
+	}
+	require.NoError(t, sc.Err())
+	return res
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewWriterSink(&buf)
+	require.NoError(t, s.Publish(context.Background(), testEvents()))
+	assert.Equal(t, testEvents(), readEvents(t, &buf))
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events")
+	s, err := NewSink("file:" + path)
+	require.NoError(t, err)
+	events := testEvents()
+	require.NoError(t, s.Publish(context.Background(), events[:1]))
+	require.NoError(t, s.Publish(context.Background(), events[1:]))
+	require.NoError(t, s.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Equal(t, events, readEvents(t, f))
+}
+
+func TestHTTPSink(t *testing.T) {
+	var got []internal.OutboxEvent
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		got = append(got, readEvents(t, r.Body)...)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	s, err := NewSink(srv.URL)
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Publish(context.Background(), testEvents()))
+	assert.Equal(t, testEvents(), got)
+
+	status = http.StatusServiceUnavailable
+	require.Error(t, s.Publish(context.Background(), testEvents()))
+}
+
+func TestNATSSink(t *testing.T) {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1})
+	require.NoError(t, err)
+	go ns.Start()
+	defer ns.Shutdown()
+	require.True(t, ns.ReadyForConnections(5*time.Second))
+
+	conn, err := nats.Connect(ns.ClientURL())
+	require.NoError(t, err)
+	defer conn.Close()
+	msgs := make(chan *nats.Msg, 10)
+	sub, err := conn.ChanSubscribe(DefaultSubject, msgs)
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+	require.NoError(t, conn.Flush())
+
+	s, err := NewSink(ns.ClientURL())
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Publish(context.Background(), testEvents()))
+
+	for _, want := range testEvents() {
+		select {
+		case msg := <-msgs:
+			assert.Equal(t, strconv.FormatInt(want.ID, 10), msg.Header.Get(nats.MsgIdHdr))
+			var e internal.OutboxEvent
+			require.NoError(t, json.Unmarshal(msg.Data, &e))
+			assert.Equal(t, want, e)
+		case <-time.After(5 * time.Second):
+			t.Fatal("event was not received")
+		}
+	}
+}
+
+func TestNewSink(t *testing.T) {
+	_, err := NewSink("kafka://localhost")
+	require.ErrorIs(t, err, ErrUnknownSink)
+	s, err := NewSink("stdout")
+	require.NoError(t, err)
+	assert.IsType(t, &WriterSink{}, s)
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"log/slog"
+	"time"
+)
+
+// requestMetaKey is the context key of internal.RequestMeta.
+type requestMetaKey struct{}
+
+// WithRequestMeta returns the context carrying the request which causes changes of URLs.
+func WithRequestMeta(ctx context.Context, meta internal.RequestMeta) context.Context {
+	return context.WithValue(ctx, requestMetaKey{}, meta)
+}
+
+// RequestMetaFrom returns the request saved by WithRequestMeta or empty RequestMeta.
+func RequestMetaFrom(ctx context.Context) internal.RequestMeta {
+	meta, _ := ctx.Value(requestMetaKey{}).(internal.RequestMeta)
+	return meta
+}
+
+// Auditor appends events of changes of URLs to the audit log. Nil *Auditor does nothing.
+// The log is best-effort: events are appended after the change is committed and errors are logged only,
+// so the log can miss events of changes which are done.
+type Auditor struct {
+	store storage.AuditStorage
+	now   func() time.Time
+}
+
+// NewAuditor creates new *Auditor.
+func NewAuditor(store storage.AuditStorage) *Auditor {
+	return &Auditor{store: store, now: time.Now}
+}
+
+// Record appends the event with action for every URL id of the user made by the request from ctx.
+// Errors are logged only, so that failures of the audit log do not fail changes which are already done.
+func (a *Auditor) Record(ctx context.Context, action string, userID int, urlIDs []int) {
+	a.RecordRequest(ctx, RequestMetaFrom(ctx), action, userID, urlIDs)
+}
+
+// RecordRequest appends the event with action for every URL id of the user made by the request.
+// It is used if the change is done after the request is finished.
+func (a *Auditor) RecordRequest(ctx context.Context, meta internal.RequestMeta, action string, userID int, urlIDs []int) {
+	if a == nil || len(urlIDs) == 0 {
+		return
+	}
+	now := a.now()
+	events := make([]internal.AuditEvent, len(urlIDs))
+	for i, id := range urlIDs {
+		events[i] = internal.AuditEvent{
+			Action:    action,
+			URLID:     id,
+			UserID:    userID,
+			RequestID: meta.RequestID,
+			IP:        meta.IP,
+			Time:      now,
+		}
+	}
+	if err := a.store.AddEvents(ctx, events); err != nil {
+		slog.ErrorContext(ctx, "Error while saving audit events", "events", len(events), "action", action, logging.Err(err))
+	}
+}
@@ -2,44 +2,74 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/health"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
 	"github.com/MalyginaEkaterina/shortener/internal/storage"
-	"log"
-	"sync"
+	"github.com/MalyginaEkaterina/shortener/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	deleteChanSize  = 100
 	deleteChunkSize = 10
-	retryIn         = 5 * time.Second
+	minRetryDelay   = time.Second
+	maxRetryDelay   = 5 * time.Minute
 	flushAfter      = 10 * time.Second
 	deleteTimeout   = 30 * time.Second
+	staleFlushAfter = 3 * flushAfter
+	// deleteMaxAttempts is the number of failed attempts after which ids leave the queue as failed.
+	deleteMaxAttempts = 20
+)
+
+// Errors of the check of DeleteURL.
+var (
+	ErrWorkerNotRunning = errors.New("worker is not running")
+	ErrFlushStale       = errors.New("queue is not flushed")
 )
 
 // DeleteWorker is used to add url IDs for deletion.
 type DeleteWorker interface {
-	// Delete is used to queue the list of shortened URL IDs for deletion.
-	Delete(ids []internal.IDToDelete)
+	// Delete is used to queue the list of shortened URL IDs of the user for deletion. Returns the created operation.
+	// Returns storage.ErrQueueFull if the queue has no room for them.
+	Delete(ctx context.Context, userID int, urlIDs []int) (internal.DeleteOperation, error)
+	// Operation returns the deletion operation by its id or storage.ErrNotFound.
+	Operation(ctx context.Context, id string) (internal.DeleteOperation, error)
 }
 
 var _ DeleteWorker = (*DeleteURL)(nil)
 
-// DeleteURL is used for creating the buffer of shortened URL IDs for deletion from Storage.
+// DeleteURL is used for queueing shortened URL IDs for deletion and deleting them from Storage.
 type DeleteURL struct {
-	inCh  chan internal.IDToDelete
-	buf   []internal.IDToDelete
-	mutex sync.RWMutex
-	store storage.Storage
+	queue    storage.DeleteQueue
+	store    storage.Storage
+	ops      storage.OperationStorage
+	audit    *Auditor
+	webhooks *Webhooks
+	// pushed is the number of IDs queued since the last flush.
+	pushed      atomic.Int64
+	flushSignal Signal
+	running     atomic.Bool
+	// flushedAt is the time in unix nanoseconds of the end of the last flush which did not fail.
+	flushedAt atomic.Int64
 }
 
-// NewDeleteWorker creates new DeleteURL.
-func NewDeleteWorker(store storage.Storage) *DeleteURL {
+// NewDeleteWorker creates new DeleteURL. Requests to delete and actual deletions are recorded by audit
+// and deletions are published to webhooks if they are not nil.
+func NewDeleteWorker(store storage.Storage, queue storage.DeleteQueue, ops storage.OperationStorage, audit *Auditor,
+	webhooks *Webhooks) *DeleteURL {
 	return &DeleteURL{
-		inCh:  make(chan internal.IDToDelete, deleteChanSize),
-		buf:   make([]internal.IDToDelete, 0),
-		store: store,
+		queue:       queue,
+		store:       store,
+		ops:         ops,
+		audit:       audit,
+		webhooks:    webhooks,
+		flushSignal: NewSignal(),
 	}
 }
 
@@ -62,93 +92,271 @@ func (s Signal) Notify() {
 }
 
 // Run starts the deleting worker.
-// Receives ID for deletion from channel inCh and put it into array buf.
-// Flushes buffer with IDs after reaching by buf the size=deleteChunkSize or after period=flushAfter.
+// Flushes the queue at start, after deleteChunkSize IDs are queued and every flushAfter.
+// IDs which failed to be deleted are retried with exponential backoff, after deleteMaxAttempts they are given up
+// and reported as failed in their operations.
 func (w *DeleteURL) Run(ctx context.Context) {
+	w.running.Store(true)
+	defer w.running.Store(false)
 	flushTick := time.NewTicker(flushAfter)
-	flushSignal := NewSignal()
-
-	go func() {
-		for range flushSignal.C {
-			err := w.flushBuf()
-			if err != nil {
-				log.Println(err)
-				time.Sleep(retryIn)
-				flushSignal.Notify()
-			}
-		}
-	}()
-
+	defer flushTick.Stop()
+	w.flush(ctx)
 	for {
 		select {
-		case v := <-w.inCh:
-			func() {
-				w.mutex.Lock()
-				defer w.mutex.Unlock()
-				w.buf = append(w.buf, v)
-				if len(w.buf) >= deleteChunkSize {
-					flushSignal.Notify()
-					flushTick = time.NewTicker(flushAfter)
-				}
-			}()
+		case <-w.flushSignal.C:
+			w.flush(ctx)
+			flushTick.Reset(flushAfter)
 		case <-flushTick.C:
-			func() {
-				w.mutex.RLock()
-				defer w.mutex.RUnlock()
-				if len(w.buf) > 0 {
-					log.Printf("Flushing after %v\n", flushAfter)
-					flushSignal.Notify()
-				}
-			}()
+			w.flush(ctx)
 		case <-ctx.Done():
-			log.Println("Stopping delete worker")
-			close(w.inCh)
-			err := w.flushAll()
-			if err != nil {
-				log.Println(err)
-			}
+			slog.Info("Stopping delete worker")
+			flushCtx, cancel := context.WithTimeout(context.Background(), deleteTimeout)
+			w.flush(flushCtx)
+			cancel()
 			return
 		}
 	}
 }
 
-// Delete is used to queue the list of shortened URL IDs for deletion
-func (w *DeleteURL) Delete(ids []internal.IDToDelete) {
-	for _, v := range ids {
-		w.inCh <- v
+// RegisterChecks registers the check of the worker as component "delete_worker". It fails if the worker is not
+// running or the queue has not been flushed without errors for staleFlushAfter.
+func (w *DeleteURL) RegisterChecks(r health.Registry) {
+	r.Register("delete_worker", w.check)
+}
+
+// check returns error if the worker is not running or its flushes fail.
+func (w *DeleteURL) check(_ context.Context) error {
+	if !w.running.Load() {
+		return ErrWorkerNotRunning
 	}
+	since := time.Since(time.Unix(0, w.flushedAt.Load()))
+	if since > staleFlushAfter {
+		return fmt.Errorf("%w: last successful flush was %v ago", ErrFlushStale, since.Round(time.Second))
+	}
+	return nil
 }
 
-func (w *DeleteURL) flushAll() error {
-	w.mutex.Lock()
-	defer w.mutex.Unlock()
-	var ids []internal.IDToDelete
-	ids = append(ids, w.buf...)
-	for v := range w.inCh {
-		ids = append(ids, v)
+// Delete is used to queue the list of shortened URL IDs of the user for deletion.
+// Creates the operation with status queued for every id. If the queue has no room for the ids they are rejected
+// and storage.ErrQueueFull is returned.
+func (w *DeleteURL) Delete(ctx context.Context, userID int, urlIDs []int) (_ internal.DeleteOperation, err error) {
+	ctx, span := tracing.Start(ctx, "DeleteURL.Delete", trace.WithAttributes(attribute.Int("shortener.batch_size", len(urlIDs))))
+	defer func() { tracing.End(span, err) }()
+	id, err := newID()
+	if err != nil {
+		return internal.DeleteOperation{}, fmt.Errorf(`error while generating operation id: %w`, err)
+	}
+	now := time.Now()
+	op := internal.DeleteOperation{
+		ID:        id,
+		UserID:    userID,
+		Request:   RequestMetaFrom(ctx),
+		Status:    internal.OperationPending,
+		Results:   make([]internal.DeleteResult, len(urlIDs)),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	ids := make([]internal.IDToDelete, len(urlIDs))
+	for i, v := range urlIDs {
+		op.Results[i] = internal.DeleteResult{ID: v, Status: internal.DeleteQueued}
+		ids[i] = internal.IDToDelete{ID: v, UserID: userID, OpID: id}
 	}
 	if len(ids) == 0 {
-		return nil
+		op.Status = internal.OperationDone
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), deleteTimeout)
-	defer cancel()
-	err := w.store.DeleteBatch(ctx, ids)
+	err = w.ops.CreateOperation(ctx, op)
 	if err != nil {
-		return fmt.Errorf(`URL ids to delete flushing error: %w`, err)
+		return internal.DeleteOperation{}, fmt.Errorf(`error while creating operation: %w`, err)
+	}
+	if len(ids) == 0 {
+		return op, nil
+	}
+
+	err = w.queue.Push(ctx, ids)
+	if err != nil {
+		rejected := make([]internal.DeleteResult, len(urlIDs))
+		for i, v := range urlIDs {
+			rejected[i] = internal.DeleteResult{ID: v, Status: internal.DeleteRejected}
+		}
+		if saveErr := w.ops.SaveResults(ctx, id, rejected); saveErr != nil {
+			slog.ErrorContext(ctx, "Error while rejecting operation", "operation_id", id, logging.Err(saveErr))
+		}
+		return internal.DeleteOperation{}, err
+	}
+	w.audit.Record(ctx, internal.AuditDeleteRequest, userID, urlIDs)
+	if w.pushed.Add(int64(len(ids))) >= deleteChunkSize {
+		w.flushSignal.Notify()
+	}
+	return op, nil
+}
+
+// Operation returns the deletion operation by its id or storage.ErrNotFound.
+func (w *DeleteURL) Operation(ctx context.Context, id string) (internal.DeleteOperation, error) {
+	return w.ops.GetOperation(ctx, id)
+}
+
+// flush deletes ready IDs from the queue by chunks of deleteChunkSize until the queue has no ready IDs or an error occurs.
+func (w *DeleteURL) flush(ctx context.Context) {
+	w.pushed.Store(0)
+	for {
+		n, err := w.queue.Process(ctx, deleteChunkSize, w.deleteBatch, retryDelay)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error while deleting URLs", logging.Err(err))
+			return
+		}
+		if n < deleteChunkSize {
+			w.flushedAt.Store(time.Now().UnixNano())
+			return
+		}
 	}
-	w.buf = w.buf[:0]
-	return nil
 }
 
-func (w *DeleteURL) flushBuf() error {
-	ctx, cancel := context.WithTimeout(context.Background(), deleteTimeout)
+// deleteBatch deletes IDs from storage and saves the result of every ID into its operation.
+// Retried IDs which are already deleted are saved as deleted, because the results of the previous attempt could be lost.
+// The span of the deletion is linked to the spans of the requests which created the operations.
+func (w *DeleteURL) deleteBatch(ctx context.Context, ids []internal.IDToDelete) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
 	defer cancel()
-	w.mutex.Lock()
-	defer w.mutex.Unlock()
-	err := w.store.DeleteBatch(ctx, w.buf)
+	live := make([]internal.IDToDelete, 0, len(ids))
+	var dead []internal.IDToDelete
+	for _, v := range ids {
+		if v.Attempts >= deleteMaxAttempts {
+			dead = append(dead, v)
+		} else {
+			live = append(live, v)
+		}
+	}
+	if len(dead) > 0 {
+		if err = w.giveUp(ctx, dead); err != nil {
+			return err
+		}
+	}
+	if len(live) == 0 {
+		return nil
+	}
+	ids = live
+	ops := w.operations(ctx, ids)
+	var links []trace.Link
+	for _, op := range ops {
+		if sc := tracing.Extract(op.Request.TraceParent); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc, Attributes: []attribute.KeyValue{
+				attribute.String("shortener.operation_id", op.ID),
+			}})
+		}
+	}
+	ctx, span := tracing.Start(ctx, "DeleteURL.deleteBatch", trace.WithNewRoot(), trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("shortener.batch_size", len(ids))))
+	defer func() { tracing.End(span, err) }()
+
+	results, err := w.store.DeleteBatch(ctx, ids)
 	if err != nil {
 		return fmt.Errorf(`URL ids to delete flushing error: %w`, err)
 	}
-	w.buf = w.buf[:0]
+	var opIDs []string
+	opResults := make(map[string][]internal.DeleteResult)
+	deleted := make(map[deletedKey][]int)
+	for i, v := range results {
+		opID := ids[i].OpID
+		if v.Status == internal.DeleteDeleted {
+			key := deletedKey{opID: opID, userID: ids[i].UserID}
+			deleted[key] = append(deleted[key], v.ID)
+		}
+		if opID == "" {
+			continue
+		}
+		if v.Status == internal.DeleteAlreadyDeleted && ids[i].Attempts > 0 {
+			// the previous attempt could delete the URL and fail to save the results of the operation
+			v.Status = internal.DeleteDeleted
+		}
+		if _, ok := opResults[opID]; !ok {
+			opIDs = append(opIDs, opID)
+		}
+		opResults[opID] = append(opResults[opID], v)
+	}
+	w.recordDeleted(ctx, ops, deleted)
+	for key, urlIDs := range deleted {
+		w.webhooks.Publish(ctx, internal.EventLinkDeleted, key.userID, urlIDs)
+	}
+	for _, opID := range opIDs {
+		err = w.ops.SaveResults(ctx, opID, opResults[opID])
+		if errors.Is(err, storage.ErrNotFound) {
+			slog.WarnContext(ctx, "Deletion operation not found", "operation_id", opID)
+		} else if err != nil {
+			return fmt.Errorf(`error while saving results of operation %s: %w`, opID, err)
+		}
+	}
 	return nil
 }
+
+// giveUp saves ids which failed deleteMaxAttempts times as failed into their operations.
+func (w *DeleteURL) giveUp(ctx context.Context, ids []internal.IDToDelete) error {
+	var opIDs []string
+	opResults := make(map[string][]internal.DeleteResult)
+	for _, v := range ids {
+		slog.ErrorContext(ctx, "Giving up deleting URL", "url_id", v.ID, "user_id", v.UserID, "operation_id", v.OpID,
+			"attempts", v.Attempts)
+		if v.OpID == "" {
+			continue
+		}
+		if _, ok := opResults[v.OpID]; !ok {
+			opIDs = append(opIDs, v.OpID)
+		}
+		opResults[v.OpID] = append(opResults[v.OpID], internal.DeleteResult{ID: v.ID, Status: internal.DeleteFailed})
+	}
+	for _, opID := range opIDs {
+		err := w.ops.SaveResults(ctx, opID, opResults[opID])
+		if errors.Is(err, storage.ErrNotFound) {
+			slog.WarnContext(ctx, "Deletion operation not found", "operation_id", opID)
+		} else if err != nil {
+			return fmt.Errorf(`error while saving results of operation %s: %w`, opID, err)
+		}
+	}
+	return nil
+}
+
+// deletedKey groups deleted ids by their operation and owner.
+type deletedKey struct {
+	opID   string
+	userID int
+}
+
+// operations returns operations of ids by their id. Operations which failed to be read are skipped.
+func (w *DeleteURL) operations(ctx context.Context, ids []internal.IDToDelete) map[string]internal.DeleteOperation {
+	ops := make(map[string]internal.DeleteOperation)
+	for _, v := range ids {
+		if v.OpID == "" {
+			continue
+		}
+		if _, ok := ops[v.OpID]; ok {
+			continue
+		}
+		op, err := w.ops.GetOperation(ctx, v.OpID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error while getting deletion operation", "operation_id", v.OpID, logging.Err(err))
+		}
+		ops[v.OpID] = op
+	}
+	return ops
+}
+
+// recordDeleted records deleted ids with the request which created their operation.
+func (w *DeleteURL) recordDeleted(ctx context.Context, ops map[string]internal.DeleteOperation, deleted map[deletedKey][]int) {
+	if w.audit == nil {
+		return
+	}
+	for key, urlIDs := range deleted {
+		w.audit.RecordRequest(ctx, ops[key.opID].Request, internal.AuditDelete, key.userID, urlIDs)
+	}
+}
+
+// retryDelay returns the delay before the next attempt to delete IDs which failed attempts times.
+func retryDelay(attempts int) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Second, retryDelay(1))
+	assert.Equal(t, 2*time.Second, retryDelay(2))
+	assert.Equal(t, 8*time.Second, retryDelay(4))
+	assert.Equal(t, maxRetryDelay, retryDelay(20))
+	assert.Equal(t, maxRetryDelay, retryDelay(1000))
+}
+
+func TestDeleteOperation(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	id1, err := store.AddURL(ctx, "https://ya1.ru", 1)
+	require.NoError(t, err)
+	id2, err := store.AddURL(ctx, "https://ya2.ru", 2)
+	require.NoError(t, err)
+	audit := storage.NewMemoryAuditStorage()
+	w := NewDeleteWorker(store, storage.NewMemoryDeleteQueue(3), storage.NewMemoryOperationStorage(), NewAuditor(audit), nil)
+
+	reqCtx := WithRequestMeta(ctx, internal.RequestMeta{RequestID: "req-1", IP: "10.0.0.1"})
+	op, err := w.Delete(reqCtx, 1, []int{id1, id2})
+	require.NoError(t, err)
+	assert.Equal(t, internal.OperationPending, op.Status)
+	assert.Equal(t, []internal.DeleteResult{
+		{ID: id1, Status: internal.DeleteQueued},
+		{ID: id2, Status: internal.DeleteQueued},
+	}, op.Results)
+
+	_, err = w.Delete(ctx, 1, []int{3, 4})
+	require.ErrorIs(t, err, storage.ErrQueueFull)
+
+	w.flush(ctx)
+	op, err = w.Operation(ctx, op.ID)
+	require.NoError(t, err)
+	assert.Equal(t, internal.OperationDone, op.Status)
+	assert.Equal(t, []internal.DeleteResult{
+		{ID: id1, Status: internal.DeleteDeleted},
+		{ID: id2, Status: internal.DeleteNotOwner},
+	}, op.Results)
+
+	events, err := audit.GetEvents(ctx, internal.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, internal.AuditDelete, events[0].Action)
+	assert.Equal(t, id1, events[0].URLID)
+	assert.Equal(t, 1, events[0].UserID)
+	assert.Equal(t, "req-1", events[0].RequestID)
+	assert.Equal(t, "10.0.0.1", events[0].IP)
+	assert.Equal(t, internal.AuditDeleteRequest, events[1].Action)
+	assert.Equal(t, internal.AuditDeleteRequest, events[2].Action)
+}
+
+func TestDeleteWorkerCheck(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	w := NewDeleteWorker(store, storage.NewMemoryDeleteQueue(3), storage.NewMemoryOperationStorage(), nil, nil)
+	require.ErrorIs(t, w.check(context.Background()), ErrWorkerNotRunning)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return w.check(context.Background()) == nil }, time.Second, 10*time.Millisecond)
+
+	w.flushedAt.Store(time.Now().Add(-staleFlushAfter - time.Minute).UnixNano())
+	require.ErrorIs(t, w.check(context.Background()), ErrFlushStale)
+
+	cancel()
+	<-done
+	require.ErrorIs(t, w.check(context.Background()), ErrWorkerNotRunning)
+}
+
+func TestDeleteGiveUp(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	id1, err := store.AddURL(ctx, "https://ya1.ru", 1)
+	require.NoError(t, err)
+	id2, err := store.AddURL(ctx, "https://ya2.ru", 1)
+	require.NoError(t, err)
+	w := NewDeleteWorker(store, storage.NewMemoryDeleteQueue(3), storage.NewMemoryOperationStorage(), nil, nil)
+	op, err := w.Delete(ctx, 1, []int{id1, id2})
+	require.NoError(t, err)
+
+	// Ids which failed deleteMaxAttempts times are not deleted any more.
+	err = w.deleteBatch(ctx, []internal.IDToDelete{
+		{ID: id1, UserID: 1, OpID: op.ID, Attempts: deleteMaxAttempts},
+		{ID: id2, UserID: 1, OpID: op.ID, Attempts: deleteMaxAttempts - 1},
+	})
+	require.NoError(t, err)
+	op, err = w.Operation(ctx, op.ID)
+	require.NoError(t, err)
+	assert.Equal(t, internal.OperationDone, op.Status)
+	assert.Equal(t, []internal.DeleteResult{
+		{ID: id1, Status: internal.DeleteFailed},
+		{ID: id2, Status: internal.DeleteDeleted},
+	}, op.Results)
+	_, err = store.GetURL(ctx, strconv.Itoa(id1))
+	require.NoError(t, err)
+}
+
+// failingSaveStorage fails to save results while fail is set.
+type failingSaveStorage struct {
+	storage.OperationStorage
+	fail bool
+}
+
+func (s *failingSaveStorage) SaveResults(ctx context.Context, opID string, results []internal.DeleteResult) error {
+	if s.fail {
+		return errors.New("connection lost")
+	}
+	return s.OperationStorage.SaveResults(ctx, opID, results)
+}
+
+func TestDeleteRetryAfterLostResults(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	id, err := store.AddURL(ctx, "https://ya.ru", 1)
+	require.NoError(t, err)
+	ops := &failingSaveStorage{OperationStorage: storage.NewMemoryOperationStorage()}
+	w := NewDeleteWorker(store, storage.NewMemoryDeleteQueue(3), ops, nil, nil)
+	op, err := w.Delete(ctx, 1, []int{id})
+	require.NoError(t, err)
+
+	ops.fail = true
+	require.Error(t, w.deleteBatch(ctx, []internal.IDToDelete{{ID: id, UserID: 1, OpID: op.ID}}))
+	ops.fail = false
+	require.NoError(t, w.deleteBatch(ctx, []internal.IDToDelete{{ID: id, UserID: 1, OpID: op.ID, Attempts: 1}}))
+	op, err = w.Operation(ctx, op.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []internal.DeleteResult{{ID: id, Status: internal.DeleteDeleted}}, op.Results)
+}
@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	importWorkers     = 4
+	importChunkSize   = 1000
+	importRetries     = 5
+	importTimeout     = 30 * time.Second
+	importRescanAfter = 10 * time.Second
+	// importLease is the time for which the job stays with the worker after its claim or its last saved chunk.
+	importLease = 5 * time.Minute
+)
+
+// ImportWorker is used to create asynchronous bulk imports of URLs.
+type ImportWorker interface {
+	// Import saves the job with items for the user and queues it. Returns the queued job.
+	Import(ctx context.Context, userID int, items []internal.JobItem) (internal.ImportJob, error)
+}
+
+var _ ImportWorker = (*ImportURL)(nil)
+
+// ImportURL processes import jobs by a pool of workers. Jobs and their progress are kept in JobStorage,
+// so unfinished jobs are resumed after restart if JobStorage is durable. Workers claim jobs in JobStorage
+// with a lease, so replicas sharing JobStorage process every job once.
+type ImportURL struct {
+	store   storage.JobStorage
+	service Service
+	// wake wakes up idle workers when a job is created.
+	wake chan struct{}
+	now  func() time.Time
+}
+
+// NewImportWorker creates new ImportURL.
+func NewImportWorker(store storage.JobStorage, service Service) *ImportURL {
+	return &ImportURL{
+		store:   store,
+		service: service,
+		wake:    make(chan struct{}, importWorkers),
+		now:     time.Now,
+	}
+}
+
+// Run starts importWorkers workers. Idle workers claim jobs when a job is created and every importRescanAfter,
+// which picks up jobs of other replicas and jobs whose lease expired.
+// When ctx is done the workers stop after saving the current chunk and release their jobs, the rest is resumed
+// by the next claim.
+func (w *ImportURL) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < importWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work(ctx)
+		}()
+	}
+	<-ctx.Done()
+	slog.Info("Stopping import worker")
+	wg.Wait()
+}
+
+// work processes claimed jobs one by one until there is no job to claim and waits for the next one.
+func (w *ImportURL) work(ctx context.Context) {
+	rescanTick := time.NewTicker(importRescanAfter)
+	defer rescanTick.Stop()
+	for {
+		for w.claim(ctx) {
+		}
+		select {
+		case <-w.wake:
+		case <-rescanTick.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// claim claims the next job and processes it. Returns false if there is no job to claim.
+func (w *ImportURL) claim(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	leaseID, err := newID()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error while generating lease id", logging.Err(err))
+		return false
+	}
+	now := w.now()
+	job, err := w.store.ClaimJob(ctx, leaseID, now, now.Add(importLease))
+	if errors.Is(err, storage.ErrNotFound) {
+		return false
+	} else if err != nil {
+		if ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Error while claiming job", logging.Err(err))
+		}
+		return false
+	}
+	w.process(ctx, job)
+	return true
+}
+
+// Import saves the job with items for the user and queues it. Returns the queued job.
+func (w *ImportURL) Import(ctx context.Context, userID int, items []internal.JobItem) (internal.ImportJob, error) {
+	id, err := newID()
+	if err != nil {
+		return internal.ImportJob{}, fmt.Errorf(`error while generating job id: %w`, err)
+	}
+	now := w.now()
+	job := internal.ImportJob{
+		ID:        id,
+		UserID:    userID,
+		Status:    internal.JobQueued,
+		Total:     len(items),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err = w.store.CreateJob(ctx, job, items)
+	if err != nil {
+		return internal.ImportJob{}, fmt.Errorf(`error while creating job: %w`, err)
+	}
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// newID returns random hex id of a job or an operation.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// process shortens items of the claimed job by chunks of importChunkSize starting from the first unprocessed item
+// and saves the results after every chunk extending the lease. It stops if the job was claimed by another worker
+// after the lease expired.
+func (w *ImportURL) process(ctx context.Context, job internal.ImportJob) {
+	ctx = logging.NewContext(ctx, "job_id", job.ID)
+	for job.Processed < job.Total {
+		if ctx.Err() != nil {
+			// the job is claimed again without waiting for the lease
+			job.LeaseUntil = time.Time{}
+			w.finish(job)
+			return
+		}
+		err := w.processChunk(ctx, &job)
+		if errors.Is(err, storage.ErrNotFound) {
+			slog.WarnContext(ctx, "Import job was claimed by another worker or removed")
+			return
+		}
+		if ctx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Import job failed", logging.Err(err))
+			job.Status = internal.JobFailed
+			job.Error = "URLs were not saved"
+			w.finish(job)
+			return
+		}
+	}
+	job.Status = internal.JobDone
+	w.finish(job)
+}
+
+// finish saves the final state of the job or the state of the released job.
+func (w *ImportURL) finish(job internal.ImportJob) {
+	job.UpdatedAt = w.now()
+	ctx, cancel := context.WithTimeout(context.Background(), importTimeout)
+	defer cancel()
+	err := w.store.UpdateJob(ctx, job)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error while updating job", "job_id", job.ID, logging.Err(err))
+	}
+}
+
+// processChunk shortens the next chunk of items and saves the results. The job is changed only if the results
+// are saved.
+func (w *ImportURL) processChunk(ctx context.Context, job *internal.ImportJob) error {
+	items, err := w.store.Items(ctx, job.ID, job.Processed, importChunkSize)
+	if err != nil {
+		return fmt.Errorf(`error while getting job items: %w`, err)
+	}
+	if len(items) == 0 {
+		return fmt.Errorf(`job has %d items, expected %d`, job.Processed, job.Total)
+	}
+	urls := make([]internal.CorrIDOriginalURL, len(items))
+	for i, v := range items {
+		corrID := v.CorrID
+		if corrID == "" {
+			corrID = strconv.Itoa(v.Line)
+		}
+		urls[i] = internal.CorrIDOriginalURL{CorrID: corrID, OriginalURL: v.OriginalURL}
+	}
+
+	results, err := w.shortenWithRetries(ctx, urls, job.UserID)
+	if err != nil {
+		return err
+	}
+	next := *job
+	for i, v := range results {
+		items[i].URLID = v.URLID
+		items[i].Status = v.Status
+		items[i].Reason = v.Reason
+		switch v.Status {
+		case internal.BatchCreated:
+			next.Created++
+		case internal.BatchExisting:
+			next.Existing++
+		case internal.BatchInvalid:
+			next.Invalid++
+		default:
+			next.Failed++
+		}
+	}
+	next.Processed += len(items)
+	next.UpdatedAt = w.now()
+	next.LeaseUntil = next.UpdatedAt.Add(importLease)
+	err = w.store.SaveResults(ctx, next, items)
+	if err != nil {
+		return fmt.Errorf(`error while saving job results: %w`, err)
+	}
+	*job = next
+	return nil
+}
+
+// shortenWithRetries calls Service.ShortenBatch retrying failures importRetries times with exponential backoff.
+func (w *ImportURL) shortenWithRetries(ctx context.Context, urls []internal.CorrIDOriginalURL, userID int) ([]internal.BatchResult, error) {
+	delay := time.Second
+	var err error
+	for i := 0; ; i++ {
+		var results []internal.BatchResult
+		results, err = w.service.ShortenBatch(ctx, urls, userID)
+		if err == nil {
+			return results, nil
+		}
+		if i == importRetries {
+			return nil, err
+		}
+		slog.WarnContext(ctx, "Error while importing URLs, retrying", "delay", delay, logging.Err(err))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/MalyginaEkaterina/shortener/internal/outbox"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"log/slog"
+	"time"
+)
+
+const (
+	outboxBatchSize = 100
+	outboxTimeout   = 30 * time.Second
+)
+
+// OutboxRelay periodically publishes events from the outbox to the sink.
+type OutboxRelay struct {
+	outbox   storage.Outbox
+	sink     outbox.Sink
+	interval time.Duration
+}
+
+// NewOutboxRelay creates new OutboxRelay.
+func NewOutboxRelay(o storage.Outbox, sink outbox.Sink, interval time.Duration) *OutboxRelay {
+	return &OutboxRelay{outbox: o, sink: sink, interval: interval}
+}
+
+// Run publishes events every interval until ctx is done. Full batches are followed by the next one at once.
+// If the sink fails the events stay in the outbox and are retried with exponential backoff,
+// the next events are not published until then to keep the order. When ctx is done the next batch is published
+// unless the sink is failing.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	attempts := 0
+	for {
+		select {
+		case <-timer.C:
+			n, err := r.relay(ctx)
+			switch {
+			case err != nil:
+				attempts++
+				slog.ErrorContext(ctx, "Error while publishing outbox events", "attempts", attempts, logging.Err(err))
+				timer.Reset(retryDelay(attempts))
+			case n == outboxBatchSize:
+				attempts = 0
+				timer.Reset(0)
+			default:
+				attempts = 0
+				timer.Reset(r.interval)
+			}
+		case <-ctx.Done():
+			slog.Info("Stopping outbox relay")
+			if attempts == 0 {
+				_, err := r.relay(context.Background())
+				if err != nil {
+					slog.Error("Error while publishing outbox events", logging.Err(err))
+				}
+			}
+			return
+		}
+	}
+}
+
+// relay publishes the next batch of events. Returns the number of taken events.
+func (r *OutboxRelay) relay(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, outboxTimeout)
+	defer cancel()
+	return r.outbox.Process(ctx, outboxBatchSize, r.sink.Publish)
+}
+
+// Close closes the sink and the outbox.
+func (r *OutboxRelay) Close() {
+	if err := r.sink.Close(); err != nil {
+		slog.Error("Error while closing outbox sink", logging.Err(err))
+	}
+	r.outbox.Close()
+}
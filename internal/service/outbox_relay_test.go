@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/outbox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+// memoryOutbox keeps events in a slice and removes them after fn succeeds like DBOutbox.
+type memoryOutbox struct {
+	events []internal.OutboxEvent
+}
+
+func (m *memoryOutbox) Process(ctx context.Context, limit int, fn func(ctx context.Context, events []internal.OutboxEvent) error) (int, error) {
+	n := len(m.events)
+	if n > limit {
+		n = limit
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	err := fn(ctx, m.events[:n])
+	if err != nil {
+		return n, err
+	}
+	m.events = m.events[n:]
+	return n, nil
+}
+
+func (m *memoryOutbox) Close() {}
+
+type failingSink struct {
+	outbox.Sink
+	fail bool
+}
+
+func (s *failingSink) Publish(ctx context.Context, events []internal.OutboxEvent) error {
+	if s.fail {
+		return errors.New("unavailable")
+	}
+	return s.Sink.Publish(ctx, events)
+}
+
+func TestOutboxRelay(t *testing.T) {
+	ctx := context.Background()
+	o := &memoryOutbox{}
+	for i := 1; i <= outboxBatchSize+1; i++ {
+		o.events = append(o.events, internal.OutboxEvent{ID: int64(i), Type: internal.EventLinkCreated, URLID: i})
+	}
+	var out strings.Builder
+	sink := &failingSink{Sink: outbox.NewWriterSink(&out), fail: true}
+	r := NewOutboxRelay(o, sink, 0)
+
+	n, err := r.relay(ctx)
+	require.Error(t, err)
+	assert.Equal(t, outboxBatchSize, n)
+	assert.Len(t, o.events, outboxBatchSize+1)
+	assert.Empty(t, out.String())
+
+	sink.fail = false
+	n, err = r.relay(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, outboxBatchSize, n)
+	n, err = r.relay(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Empty(t, o.events)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, outboxBatchSize+1)
+	assert.Contains(t, lines[0], `"id":1,`)
+	assert.Contains(t, lines[outboxBatchSize], `"id":101,`)
+}
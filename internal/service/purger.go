@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"log/slog"
+	"time"
+)
+
+const purgeTimeout = 5 * time.Minute
+
+// Purger periodically purges URLs deleted longer than the retention period ago
+// and records the update event for every purged URL.
+type Purger struct {
+	store     storage.Storage
+	audit     *Auditor
+	retention time.Duration
+	interval  time.Duration
+}
+
+// NewPurger creates new Purger. audit can be nil.
+func NewPurger(store storage.Storage, audit *Auditor, retention, interval time.Duration) *Purger {
+	return &Purger{store: store, audit: audit, retention: retention, interval: interval}
+}
+
+// Run purges deleted URLs at start and every interval until ctx is done.
+func (p *Purger) Run(ctx context.Context) {
+	tick := time.NewTicker(p.interval)
+	defer tick.Stop()
+	p.purge(ctx)
+	for {
+		select {
+		case <-tick.C:
+			p.purge(ctx)
+		case <-ctx.Done():
+			slog.Info("Stopping purger")
+			return
+		}
+	}
+}
+
+// purge removes URLs deleted before now minus retention.
+func (p *Purger) purge(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, purgeTimeout)
+	defer cancel()
+	purged, err := p.store.PurgeDeleted(ctx, time.Now().Add(-p.retention))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error while purging deleted URLs", logging.Err(err))
+	}
+	if len(purged) == 0 {
+		return
+	}
+	slog.InfoContext(ctx, "Purged deleted URLs", "urls", len(purged))
+	byUser := make(map[int][]int)
+	for _, rec := range purged {
+		byUser[rec.UserID] = append(byUser[rec.UserID], rec.ID)
+	}
+	for userID, urlIDs := range byUser {
+		p.audit.Record(ctx, internal.AuditUpdate, userID, urlIDs)
+	}
+}
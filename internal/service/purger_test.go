@@ -0,0 +1,36 @@
+package service
+
+import (
+	"context"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestPurgerRecordsUpdate(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	audits := storage.NewMemoryAuditStorage()
+	id1, err := store.AddURL(ctx, "https://ya1.ru", 1)
+	require.NoError(t, err)
+	id2, err := store.AddURL(ctx, "https://ya2.ru", 2)
+	require.NoError(t, err)
+	_, err = store.AddURL(ctx, "https://ya3.ru", 2)
+	require.NoError(t, err)
+	_, err = store.DeleteBatch(ctx, []internal.IDToDelete{{ID: id1, UserID: 1}, {ID: id2, UserID: 2}})
+	require.NoError(t, err)
+
+	NewPurger(store, NewAuditor(audits), 0, time.Hour).purge(ctx)
+	events, err := audits.GetEvents(ctx, internal.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	updated := make(map[int]int)
+	for _, e := range events {
+		assert.Equal(t, internal.AuditUpdate, e.Action)
+		updated[e.URLID] = e.UserID
+	}
+	assert.Equal(t, map[int]int{id1: 1, id2: 2}, updated)
+}
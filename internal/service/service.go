@@ -4,7 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
 	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/MalyginaEkaterina/shortener/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"net/url"
+	"strconv"
+	"time"
 )
 
 // Service is service between Storage and handlers.
@@ -12,18 +19,44 @@ type Service interface {
 	// AddURL saves URL into storage. If this URL already exists then gets its ID.
 	// Returns ID of shortened URL and a flag if the URL existed.
 	AddURL(ctx context.Context, url string, userID int) (int, bool, error)
+	// ShortenBatch validates and saves the batch of URLs into storage.
+	// Returns the result for every URL in the same order.
+	ShortenBatch(ctx context.Context, urls []internal.CorrIDOriginalURL, userID int) ([]internal.BatchResult, error)
+	// Restore restores URLs of the user deleted within the grace period. Returns the result for every id in the same order.
+	Restore(ctx context.Context, userID int, urlIDs []int) ([]internal.DeleteResult, error)
+	// EraseUser removes all URLs of the user from storage, revokes its tokens and saves the audit record of the erasure.
+	// Returns the saved record.
+	EraseUser(ctx context.Context, erasure internal.UserErasure) (internal.UserErasure, error)
+	// Clicked is called after the redirect by the URL with id.
+	Clicked(ctx context.Context, id string)
 }
 
+// ErrErasureDisabled is returned by EraseUser if there is no storage of erasures to revoke tokens.
+var ErrErasureDisabled = errors.New("erasure is disabled")
+
 var _ Service = (*URLService)(nil)
 
 // URLService contains storage.
 type URLService struct {
 	Store storage.Storage
+	// RestoreGracePeriod is the time after deletion during which URLs can be restored.
+	RestoreGracePeriod time.Duration
+	// Erasures keeps audit records of erased users whose tokens are revoked.
+	Erasures storage.ErasureStorage
+	// Audit records created, restored and erased URLs. Nothing is recorded if it is nil.
+	Audit *Auditor
+	// Webhooks receive events of created and clicked URLs. Nothing is published if it is nil.
+	Webhooks *Webhooks
+	// Jobs and Operations keep URLs and requests of the user, they are erased with the user if they are set.
+	Jobs       storage.JobStorage
+	Operations storage.OperationStorage
 }
 
 // AddURL saves URL into storage. If this URL already exists then gets its ID.
 // Returns ID of shortened URL and a flag if the URL existed.
-func (u URLService) AddURL(ctx context.Context, url string, userID int) (int, bool, error) {
+func (u URLService) AddURL(ctx context.Context, url string, userID int) (_ int, _ bool, err error) {
+	ctx, span := tracing.Start(ctx, "URLService.AddURL")
+	defer func() { tracing.End(span, err) }()
 	ind, err := u.Store.AddURL(ctx, url, userID)
 	if errors.Is(err, storage.ErrAlreadyExists) {
 		ind, err = u.Store.GetURLID(ctx, url)
@@ -34,5 +67,144 @@ func (u URLService) AddURL(ctx context.Context, url string, userID int) (int, bo
 	} else if err != nil {
 		return 0, false, fmt.Errorf(`error while adding url: %w`, err)
 	}
+	u.Audit.Record(ctx, internal.AuditCreate, userID, []int{ind})
+	u.Webhooks.Publish(ctx, internal.EventLinkCreated, userID, []int{ind})
 	return ind, false, nil
 }
+
+// ShortenBatch validates and saves the batch of URLs into storage.
+// Returns the result for every URL in the same order: created or existing with the URL id,
+// invalid or failed with the reason.
+func (u URLService) ShortenBatch(ctx context.Context, urls []internal.CorrIDOriginalURL, userID int) (_ []internal.BatchResult, err error) {
+	ctx, span := tracing.Start(ctx, "URLService.ShortenBatch", trace.WithAttributes(attribute.Int("shortener.batch_size", len(urls))))
+	defer func() { tracing.End(span, err) }()
+	res := make([]internal.BatchResult, len(urls))
+	valid := make([]internal.CorrIDOriginalURL, 0, len(urls))
+	validInd := make([]int, 0, len(urls))
+	for i, v := range urls {
+		res[i].CorrID = v.CorrID
+		if reason := validateBatchItem(v); reason != "" {
+			res[i].Status = internal.BatchInvalid
+			res[i].Reason = reason
+			continue
+		}
+		valid = append(valid, v)
+		validInd = append(validInd, i)
+	}
+	if len(valid) == 0 {
+		return res, nil
+	}
+
+	saved, err := u.Store.AddBatch(ctx, valid, userID)
+	if err != nil {
+		return nil, fmt.Errorf(`error while adding urls: %w`, err)
+	}
+	if len(saved) != len(valid) {
+		return nil, fmt.Errorf(`storage returned %d results for %d urls`, len(saved), len(valid))
+	}
+	created := make([]int, 0, len(saved))
+	for j, v := range saved {
+		r := &res[validInd[j]]
+		r.URLID = v.URLID
+		switch {
+		case errors.Is(v.Err, storage.ErrEmptyURL):
+			r.Status = internal.BatchInvalid
+			r.Reason = "original_url is required"
+		case v.Err != nil:
+			r.Status = internal.BatchFailed
+			r.Reason = "URL was not saved"
+		case v.Existed:
+			r.Status = internal.BatchExisting
+		default:
+			r.Status = internal.BatchCreated
+			created = append(created, v.URLID)
+		}
+	}
+	u.Audit.Record(ctx, internal.AuditBatchCreate, userID, created)
+	u.Webhooks.Publish(ctx, internal.EventLinkCreated, userID, created)
+	return res, nil
+}
+
+// Restore restores URLs of the user deleted within RestoreGracePeriod. Returns the result for every id in the same order:
+// restored, not_deleted, expired, not_found or not_owner.
+func (u URLService) Restore(ctx context.Context, userID int, urlIDs []int) (_ []internal.DeleteResult, err error) {
+	ctx, span := tracing.Start(ctx, "URLService.Restore", trace.WithAttributes(attribute.Int("shortener.batch_size", len(urlIDs))))
+	defer func() { tracing.End(span, err) }()
+	ids := make([]internal.IDToDelete, len(urlIDs))
+	for i, v := range urlIDs {
+		ids[i] = internal.IDToDelete{ID: v, UserID: userID}
+	}
+	res, err := u.Store.RestoreBatch(ctx, ids, time.Now().Add(-u.RestoreGracePeriod))
+	if err != nil {
+		return nil, fmt.Errorf(`error while restoring urls: %w`, err)
+	}
+	restored := make([]int, 0, len(res))
+	for _, v := range res {
+		if v.Status == internal.DeleteRestored {
+			restored = append(restored, v.ID)
+		}
+	}
+	u.Audit.Record(ctx, internal.AuditRestore, userID, restored)
+	return res, nil
+}
+
+// EraseUser removes all URLs of the user from storage keeping anonymous tombstones of their ids, removes import jobs,
+// deletion operations and webhooks of the user and saves the audit record of the erasure which revokes tokens of the user.
+// The data is removed first, so the request can be repeated with the same token if saving of the record fails.
+func (u URLService) EraseUser(ctx context.Context, erasure internal.UserErasure) (_ internal.UserErasure, err error) {
+	ctx, span := tracing.Start(ctx, "URLService.EraseUser")
+	defer func() { tracing.End(span, err) }()
+	if u.Erasures == nil {
+		return internal.UserErasure{}, ErrErasureDisabled
+	}
+	ids, err := u.Store.EraseUser(ctx, erasure.UserID)
+	if err != nil {
+		return internal.UserErasure{}, fmt.Errorf(`error while erasing urls: %w`, err)
+	}
+	u.Audit.RecordRequest(ctx, internal.RequestMeta{RequestID: erasure.RequestID, IP: erasure.IP}, internal.AuditErase,
+		erasure.UserID, ids)
+	if u.Jobs != nil {
+		if err = u.Jobs.EraseUser(ctx, erasure.UserID); err != nil {
+			return internal.UserErasure{}, fmt.Errorf(`error while erasing import jobs: %w`, err)
+		}
+	}
+	if u.Operations != nil {
+		if err = u.Operations.EraseUser(ctx, erasure.UserID); err != nil {
+			return internal.UserErasure{}, fmt.Errorf(`error while erasing deletion operations: %w`, err)
+		}
+	}
+	if err = u.Webhooks.EraseUser(ctx, erasure.UserID); err != nil {
+		return internal.UserErasure{}, fmt.Errorf(`error while erasing webhooks: %w`, err)
+	}
+	erasure.Links = len(ids)
+	erasure.ErasedAt = time.Now()
+	err = u.Erasures.AddErasure(ctx, erasure)
+	if err != nil {
+		return internal.UserErasure{}, fmt.Errorf(`error while saving erasure: %w`, err)
+	}
+	return erasure, nil
+}
+
+// Clicked queues the click event for webhooks of the owner of the URL.
+func (u URLService) Clicked(_ context.Context, id string) {
+	urlID, err := strconv.Atoi(id)
+	if err != nil {
+		return
+	}
+	u.Webhooks.Click(urlID)
+}
+
+// validateBatchItem returns the reason why the item is invalid or empty string if it is valid.
+func validateBatchItem(v internal.CorrIDOriginalURL) string {
+	if v.CorrID == "" {
+		return "correlation_id is required"
+	}
+	if v.OriginalURL == "" {
+		return "original_url is required"
+	}
+	parsed, err := url.ParseRequestURI(v.OriginalURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "original_url must be an absolute http or https URL"
+	}
+	return ""
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/MalyginaEkaterina/shortener/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func TestDeleteBatchLinkedToRequests(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	id1, err := store.AddURL(ctx, "https://ya1.ru", 1)
+	require.NoError(t, err)
+	id2, err := store.AddURL(ctx, "https://ya2.ru", 2)
+	require.NoError(t, err)
+	w := NewDeleteWorker(store, storage.NewMemoryDeleteQueue(10), storage.NewMemoryOperationStorage(), nil, nil)
+
+	var requests []string
+	for _, v := range []struct{ userID, urlID int }{{1, id1}, {2, id2}} {
+		reqCtx, span := tracing.Start(ctx, "request")
+		_, err = w.Delete(WithRequestMeta(reqCtx, internal.RequestMeta{TraceParent: tracing.Inject(reqCtx)}), v.userID, []int{v.urlID})
+		require.NoError(t, err)
+		span.End()
+		requests = append(requests, span.SpanContext().TraceID().String())
+	}
+	w.flush(ctx)
+
+	var batch sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		if s.Name() == "DeleteURL.deleteBatch" {
+			batch = s
+		}
+	}
+	require.NotNil(t, batch)
+	assert.False(t, batch.Parent().IsValid())
+	var linked []string
+	for _, l := range batch.Links() {
+		linked = append(linked, l.SpanContext.TraceID().String())
+	}
+	assert.ElementsMatch(t, requests, linked)
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const userGCTimeout = 5 * time.Minute
+
+// UserGCStats contains counters of deleted anonymous users. Deleted users are the churn of anonymous users
+// which never kept a URL or lost all of them.
+type UserGCStats struct {
+	Runs        int        `json:"runs"`
+	Deleted     int        `json:"deleted"`
+	LastDeleted int        `json:"last_deleted"`
+	LastRunAt   *time.Time `json:"last_run_at,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// UserGC periodically deletes users without URLs which have not been active for the inactivity period.
+type UserGC struct {
+	cleaner    storage.UserCleaner
+	inactivity time.Duration
+	interval   time.Duration
+	stats      UserGCStats
+	mutex      sync.RWMutex
+}
+
+// NewUserGC creates new UserGC.
+func NewUserGC(cleaner storage.UserCleaner, inactivity, interval time.Duration) *UserGC {
+	return &UserGC{cleaner: cleaner, inactivity: inactivity, interval: interval}
+}
+
+// Run deletes inactive users at start and every interval until ctx is done.
+func (g *UserGC) Run(ctx context.Context) {
+	tick := time.NewTicker(g.interval)
+	defer tick.Stop()
+	g.collect(ctx)
+	for {
+		select {
+		case <-tick.C:
+			g.collect(ctx)
+		case <-ctx.Done():
+			slog.Info("Stopping user GC")
+			return
+		}
+	}
+}
+
+// Stats returns counters of deleted users.
+func (g *UserGC) Stats() UserGCStats {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.stats
+}
+
+// collect deletes users inactive since now minus inactivity and updates stats.
+func (g *UserGC) collect(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, userGCTimeout)
+	defer cancel()
+	now := time.Now()
+	n, err := g.cleaner.DeleteInactiveUsers(ctx, now.Add(-g.inactivity))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error while deleting inactive users", logging.Err(err))
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "Deleted inactive users", "users", n)
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.stats.Runs++
+	g.stats.Deleted += n
+	g.stats.LastDeleted = n
+	g.stats.LastRunAt = &now
+	g.stats.LastError = ""
+	if err != nil {
+		g.stats.LastError = err.Error()
+	}
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type fakeCleaner struct {
+	deleted       int
+	err           error
+	inactiveSince time.Time
+}
+
+func (c *fakeCleaner) DeleteInactiveUsers(_ context.Context, inactiveSince time.Time) (int, error) {
+	c.inactiveSince = inactiveSince
+	return c.deleted, c.err
+}
+
+func TestUserGC(t *testing.T) {
+	cleaner := &fakeCleaner{deleted: 3}
+	gc := NewUserGC(cleaner, time.Hour, time.Minute)
+	gc.collect(context.Background())
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), cleaner.inactiveSince, time.Second)
+
+	cleaner.deleted, cleaner.err = 0, errors.New("db is down")
+	gc.collect(context.Background())
+	stats := gc.Stats()
+	assert.Equal(t, 2, stats.Runs)
+	assert.Equal(t, 3, stats.Deleted)
+	assert.Equal(t, 0, stats.LastDeleted)
+	assert.Equal(t, "db is down", stats.LastError)
+	require.NotNil(t, stats.LastRunAt)
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// errPrivateTarget is returned when the webhook endpoint resolves to an address which is not public.
+var errPrivateTarget = errors.New("webhook address is not public")
+
+// nonPublicPrefixes are ranges of global unicast addresses which are not reachable from the Internet.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+}
+
+// publicAddr reports whether webhooks may be posted to the address. Loopback, private, link-local
+// including the cloud metadata address 169.254.169.254, multicast and unspecified addresses are rejected,
+// so that webhooks can not be used to reach internal services.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// newWebhookClient returns the client posting webhooks. Its dialer checks every resolved address with allow
+// right before connecting, so a host can not pass Register and resolve to an internal address later.
+// Redirects are not followed, and the proxy from the environment is not used because it would be dialed instead
+// of the endpoint.
+func newWebhookClient(timeout time.Duration, allow func(netip.Addr) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !allow(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", errPrivateTarget, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: otelhttp.NewTransport(transport),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// checkTarget returns errPrivateTarget if the host is an address or resolves to addresses which are not allowed.
+// Hosts which can not be resolved yet are accepted because addresses are checked again on every delivery.
+func (w *Webhooks) checkTarget(ctx context.Context, host string) error {
+	var addrs []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = append(addrs, addr)
+	} else if resolved, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host); err == nil {
+		addrs = resolved
+	}
+	for _, addr := range addrs {
+		if !w.allowAddr(addr) {
+			return fmt.Errorf("%w: %s", errPrivateTarget, addr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,524 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	webhookClickChanSize = 1000
+	webhookPollInterval  = time.Second
+	webhookClaimSize     = 100
+	webhookWorkers       = 4
+	webhookLease         = time.Minute
+	webhookErrorSize     = 200
+	webhookFlushTimeout  = 10 * time.Second
+	webhookPruneInterval = time.Hour
+	// webhookCacheTTL is the time during which subscriptions of the user are taken from the cache, so other
+	// instances start publishing to the new webhook after it.
+	webhookCacheTTL = 30 * time.Second
+	// webhookCacheSize is the number of users whose subscriptions are cached. The cache is cleared when it is full.
+	webhookCacheSize = 10000
+	// webhookSecretSize is the number of random bytes of the secret of the webhook.
+	webhookSecretSize = 32
+)
+
+// Headers of webhook requests.
+const (
+	WebhookEventHeader     = "X-Shortener-Event"
+	WebhookDeliveryHeader  = "X-Shortener-Delivery"
+	WebhookTimestampHeader = "X-Shortener-Timestamp"
+	WebhookSignatureHeader = "X-Shortener-Signature"
+)
+
+// Webhook errors
+var (
+	ErrBadWebhook      = errors.New("bad webhook")
+	ErrDeliveryNotDead = errors.New("delivery is not dead")
+)
+
+// Webhooks keeps webhooks of users and posts events of their URLs to them.
+// Events are saved as deliveries first and are posted by Run, so they survive restarts if WebhookStorage is durable.
+// Failed deliveries are retried with exponential backoff and become dead after maxAttempts.
+// Delivered and dead deliveries are pruned after retention. Endpoints must resolve to public addresses.
+// Nil *Webhooks publishes nothing.
+type Webhooks struct {
+	store       storage.WebhookStorage
+	urls        storage.Storage
+	client      *http.Client
+	baseURL     string
+	maxAttempts int
+	retention   time.Duration
+	clicks      chan int
+	signal      Signal
+	now         func() time.Time
+	// allowAddr reports whether webhooks may be posted to the address.
+	allowAddr func(netip.Addr) bool
+
+	cacheMutex sync.Mutex
+	cache      map[int]cachedWebhooks
+}
+
+// cachedWebhooks are webhooks of the user loaded from the storage.
+type cachedWebhooks struct {
+	webhooks  []internal.Webhook
+	expiresAt time.Time
+}
+
+// NewWebhooks creates new *Webhooks. urls is used to find owners of clicked URLs.
+// Delivered and dead deliveries are kept for retention, they are never pruned if it is zero.
+func NewWebhooks(store storage.WebhookStorage, urls storage.Storage, baseURL string, maxAttempts int, timeout,
+	retention time.Duration) *Webhooks {
+	w := &Webhooks{
+		store:       store,
+		urls:        urls,
+		baseURL:     baseURL,
+		maxAttempts: maxAttempts,
+		retention:   retention,
+		clicks:      make(chan int, webhookClickChanSize),
+		signal:      NewSignal(),
+		now:         time.Now,
+		allowAddr:   publicAddr,
+		cache:       make(map[int]cachedWebhooks),
+	}
+	w.client = newWebhookClient(timeout, func(addr netip.Addr) bool { return w.allowAddr(addr) })
+	return w
+}
+
+// Register creates the webhook of the user which receives events of types to endpoint. All types are received
+// if types is empty. Returns the webhook with its secret or ErrBadWebhook.
+func (w *Webhooks) Register(ctx context.Context, userID int, endpoint string, types []string) (internal.Webhook, error) {
+	parsed, err := url.ParseRequestURI(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return internal.Webhook{}, fmt.Errorf("%w: url must be an absolute http or https URL", ErrBadWebhook)
+	}
+	if err = w.checkTarget(ctx, parsed.Hostname()); err != nil {
+		return internal.Webhook{}, fmt.Errorf("%w: %w", ErrBadWebhook, err)
+	}
+	if len(types) == 0 {
+		types = []string{internal.EventLinkCreated, internal.EventLinkDeleted, internal.EventLinkClicked}
+	}
+	for _, t := range types {
+		if t != internal.EventLinkCreated && t != internal.EventLinkDeleted && t != internal.EventLinkClicked {
+			return internal.Webhook{}, fmt.Errorf("%w: unknown event %q", ErrBadWebhook, t)
+		}
+	}
+	id, err := newID()
+	if err != nil {
+		return internal.Webhook{}, fmt.Errorf(`error while generating webhook id: %w`, err)
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return internal.Webhook{}, fmt.Errorf(`error while generating webhook secret: %w`, err)
+	}
+	webhook := internal.Webhook{
+		ID:        id,
+		UserID:    userID,
+		URL:       endpoint,
+		Events:    types,
+		Secret:    secret,
+		CreatedAt: w.now(),
+	}
+	err = w.store.AddWebhook(ctx, webhook)
+	if err != nil {
+		return internal.Webhook{}, fmt.Errorf(`error while saving webhook: %w`, err)
+	}
+	w.forget(userID)
+	return webhook, nil
+}
+
+// List returns webhooks of the user without secrets.
+func (w *Webhooks) List(ctx context.Context, userID int) ([]internal.Webhook, error) {
+	webhooks, err := w.store.GetUserWebhooks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+// Delete deletes the webhook of the user. Returns storage.ErrNotFound if the user has no such webhook.
+// Undelivered events of the deleted webhook become dead.
+func (w *Webhooks) Delete(ctx context.Context, userID int, id string) error {
+	if _, err := w.userWebhook(ctx, userID, id); err != nil {
+		return err
+	}
+	defer w.forget(userID)
+	return w.store.DeleteWebhook(ctx, id)
+}
+
+// EraseUser removes webhooks of the user with their endpoints, secrets and all deliveries, so no events
+// of the user are delivered after the erasure.
+func (w *Webhooks) EraseUser(ctx context.Context, userID int) error {
+	if w == nil {
+		return nil
+	}
+	defer w.forget(userID)
+	return w.store.EraseUser(ctx, userID)
+}
+
+// Deliveries returns up to limit last deliveries of the webhook of the user, only ones with status if it is not empty.
+// Dead deliveries are the dead-letter list of the webhook. Returns storage.ErrNotFound if the user has no such webhook.
+func (w *Webhooks) Deliveries(ctx context.Context, userID int, id string, status string, limit int) ([]internal.WebhookDelivery, error) {
+	if _, err := w.userWebhook(ctx, userID, id); err != nil {
+		return nil, err
+	}
+	return w.store.GetDeliveries(ctx, id, status, limit)
+}
+
+// Redeliver queues the dead delivery of the webhook of the user again with reset attempts.
+// Returns storage.ErrNotFound if the user has no such delivery and ErrDeliveryNotDead if it is not dead.
+func (w *Webhooks) Redeliver(ctx context.Context, userID int, id string, deliveryID string) (internal.WebhookDelivery, error) {
+	if _, err := w.userWebhook(ctx, userID, id); err != nil {
+		return internal.WebhookDelivery{}, err
+	}
+	d, err := w.store.GetDelivery(ctx, deliveryID)
+	if err != nil {
+		return internal.WebhookDelivery{}, err
+	}
+	if d.WebhookID != id {
+		return internal.WebhookDelivery{}, storage.ErrNotFound
+	}
+	if d.Status != internal.DeliveryDead {
+		return internal.WebhookDelivery{}, ErrDeliveryNotDead
+	}
+	now := w.now()
+	d.Status = internal.DeliveryPending
+	d.Attempts = 0
+	d.NextAttemptAt = now
+	d.UpdatedAt = now
+	err = w.store.SaveDelivery(ctx, d)
+	if err != nil {
+		return internal.WebhookDelivery{}, err
+	}
+	w.signal.Notify()
+	return d, nil
+}
+
+func (w *Webhooks) userWebhook(ctx context.Context, userID int, id string) (internal.Webhook, error) {
+	webhook, err := w.store.GetWebhook(ctx, id)
+	if err != nil {
+		return internal.Webhook{}, err
+	}
+	if webhook.UserID != userID {
+		return internal.Webhook{}, storage.ErrNotFound
+	}
+	return webhook, nil
+}
+
+// Publish saves deliveries of the event of eventType for every URL id of the user to its webhooks.
+// Errors are logged only, so that failures of webhooks do not fail changes which are already done.
+func (w *Webhooks) Publish(ctx context.Context, eventType string, userID int, urlIDs []int) {
+	if w == nil || len(urlIDs) == 0 {
+		return
+	}
+	webhooks, err := w.subscriptions(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error while getting webhooks", logging.Err(err))
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+	now := w.now()
+	var deliveries []internal.WebhookDelivery
+	for _, webhook := range webhooks {
+		if !subscribed(webhook, eventType) {
+			continue
+		}
+		for _, urlID := range urlIDs {
+			id, err := newID()
+			if err != nil {
+				slog.ErrorContext(ctx, "Error while generating delivery id", logging.Err(err))
+				return
+			}
+			deliveries = append(deliveries, internal.WebhookDelivery{
+				ID:        id,
+				WebhookID: webhook.ID,
+				Event: internal.LinkEvent{
+					ID:       id,
+					Type:     eventType,
+					URLID:    urlID,
+					ShortURL: w.baseURL + "/" + strconv.Itoa(urlID),
+					Time:     now,
+				},
+				Status:        internal.DeliveryPending,
+				NextAttemptAt: now,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+	err = w.store.AddDeliveries(ctx, deliveries)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error while saving webhook deliveries", "deliveries", len(deliveries), logging.Err(err))
+		return
+	}
+	w.signal.Notify()
+}
+
+// subscriptions returns webhooks of the user from the cache or loads them from the storage.
+// Users without webhooks are cached too, so events of most URLs do not query the storage.
+func (w *Webhooks) subscriptions(ctx context.Context, userID int) ([]internal.Webhook, error) {
+	now := w.now()
+	w.cacheMutex.Lock()
+	cached, ok := w.cache[userID]
+	w.cacheMutex.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.webhooks, nil
+	}
+	webhooks, err := w.store.GetUserWebhooks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	w.cacheMutex.Lock()
+	defer w.cacheMutex.Unlock()
+	if len(w.cache) >= webhookCacheSize {
+		clear(w.cache)
+	}
+	w.cache[userID] = cachedWebhooks{webhooks: webhooks, expiresAt: now.Add(webhookCacheTTL)}
+	return webhooks, nil
+}
+
+// forget removes cached webhooks of the user after they are changed.
+func (w *Webhooks) forget(userID int) {
+	w.cacheMutex.Lock()
+	defer w.cacheMutex.Unlock()
+	delete(w.cache, userID)
+}
+
+// Click queues the click on the URL with id without blocking. Clicks which do not fit into the queue are dropped.
+func (w *Webhooks) Click(urlID int) {
+	if w == nil {
+		return
+	}
+	select {
+	case w.clicks <- urlID:
+	default:
+		slog.Warn("Webhook click queue is full, click is dropped", "url_id", urlID)
+	}
+}
+
+// Run publishes queued clicks and posts due deliveries at start, after new events and every webhookPollInterval.
+// Old delivered and dead deliveries are pruned at start and every webhookPruneInterval.
+// When ctx is done the queued clicks are published and deliveries are left for the next start.
+func (w *Webhooks) Run(ctx context.Context) {
+	tick := time.NewTicker(webhookPollInterval)
+	defer tick.Stop()
+	prune := time.NewTicker(webhookPruneInterval)
+	defer prune.Stop()
+	w.prune(ctx)
+	w.deliver(ctx)
+	for {
+		select {
+		case urlID := <-w.clicks:
+			w.publishClick(ctx, urlID)
+		case <-w.signal.C:
+			w.deliver(ctx)
+		case <-tick.C:
+			w.deliver(ctx)
+		case <-prune.C:
+			w.prune(ctx)
+		case <-ctx.Done():
+			slog.Info("Stopping webhooks")
+			w.flushClicks()
+			return
+		}
+	}
+}
+
+// prune removes delivered and dead deliveries which were last updated before retention.
+func (w *Webhooks) prune(ctx context.Context) {
+	if w.retention <= 0 || ctx.Err() != nil {
+		return
+	}
+	n, err := w.store.PruneDeliveries(ctx, w.now().Add(-w.retention))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error while pruning webhook deliveries", logging.Err(err))
+		return
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "Pruned webhook deliveries", "deliveries", n)
+	}
+}
+
+// flushClicks publishes clicks left in the queue, so that they are delivered after restart.
+func (w *Webhooks) flushClicks() {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookFlushTimeout)
+	defer cancel()
+	for ctx.Err() == nil {
+		select {
+		case urlID := <-w.clicks:
+			w.publishClick(ctx, urlID)
+		default:
+			return
+		}
+	}
+}
+
+// publishClick publishes the click event to webhooks of the owner of the URL.
+func (w *Webhooks) publishClick(ctx context.Context, urlID int) {
+	userID, err := w.urls.GetURLOwner(ctx, urlID)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			slog.ErrorContext(ctx, "Error while getting owner of URL", "url_id", urlID, logging.Err(err))
+		}
+		return
+	}
+	w.Publish(ctx, internal.EventLinkClicked, userID, []int{urlID})
+}
+
+// deliver claims due deliveries by chunks of webhookClaimSize and posts them by webhookWorkers concurrent requests
+// until there are no due deliveries left.
+func (w *Webhooks) deliver(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := w.store.ClaimDeliveries(ctx, w.now(), webhookLease, webhookClaimSize)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error while claiming webhook deliveries", logging.Err(err))
+			return
+		}
+		sem := make(chan struct{}, webhookWorkers)
+		var wg sync.WaitGroup
+		for _, d := range deliveries {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(d internal.WebhookDelivery) {
+				defer wg.Done()
+				w.attempt(ctx, d)
+				<-sem
+			}(d)
+		}
+		wg.Wait()
+		if len(deliveries) < webhookClaimSize {
+			return
+		}
+	}
+}
+
+// attempt posts the delivery and saves the result.
+func (w *Webhooks) attempt(ctx context.Context, d internal.WebhookDelivery) {
+	webhook, err := w.store.GetWebhook(ctx, d.WebhookID)
+	if errors.Is(err, storage.ErrNotFound) {
+		d.Status = internal.DeliveryDead
+		d.Error = "webhook was deleted"
+		d.UpdatedAt = w.now()
+		w.save(ctx, d)
+		return
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error while getting webhook", "webhook_id", d.WebhookID, logging.Err(err))
+		return
+	}
+
+	d.Attempts++
+	d.StatusCode, err = w.post(ctx, webhook, d)
+	now := w.now()
+	d.UpdatedAt = now
+	switch {
+	case err == nil:
+		d.Status = internal.DeliveryDelivered
+		d.Error = ""
+	case d.Attempts >= w.maxAttempts:
+		d.Status = internal.DeliveryDead
+		d.Error = truncate(err.Error(), webhookErrorSize)
+	default:
+		d.Status = internal.DeliveryFailed
+		d.Error = truncate(err.Error(), webhookErrorSize)
+		d.NextAttemptAt = now.Add(retryDelay(d.Attempts))
+	}
+	w.save(ctx, d)
+}
+
+func (w *Webhooks) save(ctx context.Context, d internal.WebhookDelivery) {
+	if err := w.store.SaveDelivery(ctx, d); err != nil {
+		slog.ErrorContext(ctx, "Error while saving webhook delivery", "delivery_id", d.ID, logging.Err(err))
+	}
+}
+
+// post sends the event to the webhook and returns the status code of the response.
+// Returns an error if the request failed or the status is not 2xx.
+func (w *Webhooks) post(ctx context.Context, webhook internal.Webhook, d internal.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(d.Event)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := w.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, d.Event.Type)
+	req.Header.Set(WebhookDeliveryHeader, d.ID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, timestamp, body))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhook returns the value of the signature header of the webhook request: "sha256=" and hex HMAC-SHA256
+// with the secret of the timestamp header value, a dot and the body. Receivers should compare it in constant time
+// and reject old timestamps.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func subscribed(webhook internal.Webhook, eventType string) bool {
+	for _, t := range webhook.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// newSecret returns random hex secret of a webhook.
+func newSecret() (string, error) {
+	b := make([]byte, webhookSecretSize)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
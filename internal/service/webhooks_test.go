@@ -0,0 +1,304 @@
+package service
+
+import (
+	"context"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWebhooksDelivery(t *testing.T) {
+	ctx := context.Background()
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan received, 10)
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		requests <- received{header: req.Header, body: body}
+		writer.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	urls := storage.NewMemoryStorage()
+	store := storage.NewMemoryWebhookStorage()
+	w := NewWebhooks(store, urls, "http://localhost:8080", 2, time.Second, time.Hour)
+	w.allowAddr = func(netip.Addr) bool { return true }
+	now := time.Now()
+	w.now = func() time.Time { return now }
+
+	_, err := w.Register(ctx, 1, "ftp://example.com", nil)
+	require.ErrorIs(t, err, ErrBadWebhook)
+	_, err = w.Register(ctx, 1, server.URL, []string{"link.unknown"})
+	require.ErrorIs(t, err, ErrBadWebhook)
+	webhook, err := w.Register(ctx, 1, server.URL, []string{internal.EventLinkCreated, internal.EventLinkClicked})
+	require.NoError(t, err)
+	require.NotEmpty(t, webhook.Secret)
+	listed, err := w.List(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Empty(t, listed[0].Secret)
+
+	w.Publish(ctx, internal.EventLinkDeleted, 1, []int{5})
+	w.Publish(ctx, internal.EventLinkCreated, 2, []int{5})
+	w.Publish(ctx, internal.EventLinkCreated, 1, []int{5})
+	w.deliver(ctx)
+	require.Len(t, requests, 1)
+	req := <-requests
+	assert.Equal(t, internal.EventLinkCreated, req.header.Get(WebhookEventHeader))
+	timestamp, err := strconv.ParseInt(req.header.Get(WebhookTimestampHeader), 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, SignWebhook(webhook.Secret, timestamp, req.body), req.header.Get(WebhookSignatureHeader))
+	assert.JSONEq(t, `{"id":"`+req.header.Get(WebhookDeliveryHeader)+`","type":"link.created","url_id":5,`+
+		`"short_url":"http://localhost:8080/5","time":"`+now.Format(time.RFC3339Nano)+`"}`, string(req.body))
+
+	// Clicks are published to webhooks of the owner of the URL.
+	id, err := urls.AddURL(ctx, "https://ya.ru", 1)
+	require.NoError(t, err)
+	status = http.StatusInternalServerError
+	w.publishClick(ctx, id)
+	w.deliver(ctx)
+	require.Len(t, requests, 1)
+	<-requests
+	deliveries, err := w.Deliveries(ctx, 1, webhook.ID, internal.DeliveryFailed, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, internal.EventLinkClicked, deliveries[0].Event.Type)
+	assert.Equal(t, http.StatusInternalServerError, deliveries[0].StatusCode)
+	assert.Equal(t, now.Add(retryDelay(1)), deliveries[0].NextAttemptAt)
+
+	// The failed delivery is retried after the delay and becomes dead after maxAttempts.
+	w.deliver(ctx)
+	require.Len(t, requests, 0)
+	now = now.Add(retryDelay(1))
+	w.deliver(ctx)
+	require.Len(t, requests, 1)
+	<-requests
+	dead, err := w.Deliveries(ctx, 1, webhook.ID, internal.DeliveryDead, 10)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, 2, dead[0].Attempts)
+
+	_, err = w.Redeliver(ctx, 2, webhook.ID, dead[0].ID)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	status = http.StatusNoContent
+	_, err = w.Redeliver(ctx, 1, webhook.ID, dead[0].ID)
+	require.NoError(t, err)
+	_, err = w.Redeliver(ctx, 1, webhook.ID, dead[0].ID)
+	require.ErrorIs(t, err, ErrDeliveryNotDead)
+	w.deliver(ctx)
+	require.Len(t, requests, 1)
+	<-requests
+	all, err := w.Deliveries(ctx, 1, webhook.ID, "", 10)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, internal.DeliveryDelivered, all[0].Status)
+	assert.Equal(t, internal.DeliveryDelivered, all[1].Status)
+
+	// Undelivered events of the deleted webhook become dead.
+	require.ErrorIs(t, w.Delete(ctx, 2, webhook.ID), storage.ErrNotFound)
+	w.Publish(ctx, internal.EventLinkCreated, 1, []int{6})
+	require.NoError(t, w.Delete(ctx, 1, webhook.ID))
+	w.deliver(ctx)
+	assert.Len(t, requests, 0)
+	dead, err = store.GetDeliveries(ctx, webhook.ID, internal.DeliveryDead, 10)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, 6, dead[0].Event.URLID)
+}
+
+func TestWebhooksFlushClicksOnStop(t *testing.T) {
+	ctx := context.Background()
+	urls := storage.NewMemoryStorage()
+	store := storage.NewMemoryWebhookStorage()
+	w := NewWebhooks(store, urls, "http://localhost:8080", 2, time.Second, time.Hour)
+	w.allowAddr = func(netip.Addr) bool { return true }
+	webhook, err := w.Register(ctx, 1, "http://localhost:1", []string{internal.EventLinkClicked})
+	require.NoError(t, err)
+	id, err := urls.AddURL(ctx, "https://ya.ru", 1)
+	require.NoError(t, err)
+
+	w.Click(id)
+	w.Click(id)
+	stopped, cancel := context.WithCancel(ctx)
+	cancel()
+	w.Run(stopped)
+
+	deliveries, err := w.Deliveries(ctx, 1, webhook.ID, internal.DeliveryPending, 10)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 2)
+}
+
+func TestPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"2a00:1450:4001::1", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.public, publicAddr(netip.MustParseAddr(tt.addr)), tt.addr)
+	}
+}
+
+func TestWebhookTargets(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryWebhookStorage()
+	w := NewWebhooks(store, storage.NewMemoryStorage(), "http://localhost:8080", 1, time.Second, time.Hour)
+	now := time.Now()
+	w.now = func() time.Time { return now }
+
+	for _, endpoint := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://10.0.0.1/hook",
+		"http://localhost/hook",
+	} {
+		_, err := w.Register(ctx, 1, endpoint, nil)
+		assert.ErrorIs(t, err, ErrBadWebhook, endpoint)
+	}
+	_, err := w.Register(ctx, 1, "https://93.184.216.34/hook", nil)
+	require.NoError(t, err)
+
+	hits := make(chan string, 10)
+	target := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		hits <- "target"
+	}))
+	defer target.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		hits <- "redirect"
+		http.Redirect(writer, req, target.URL, http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	// The endpoint which resolves to a loopback address after registration is not dialed.
+	require.NoError(t, store.AddWebhook(ctx, internal.Webhook{ID: "w1", UserID: 2, URL: target.URL,
+		Events: []string{internal.EventLinkCreated}}))
+	w.Publish(ctx, internal.EventLinkCreated, 2, []int{1})
+	w.deliver(ctx)
+	assert.Len(t, hits, 0)
+	dead, err := store.GetDeliveries(ctx, "w1", internal.DeliveryDead, 10)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Contains(t, dead[0].Error, errPrivateTarget.Error())
+
+	// Redirects are not followed.
+	w.allowAddr = func(netip.Addr) bool { return true }
+	require.NoError(t, store.AddWebhook(ctx, internal.Webhook{ID: "w2", UserID: 3, URL: redirect.URL,
+		Events: []string{internal.EventLinkCreated}}))
+	w.Publish(ctx, internal.EventLinkCreated, 3, []int{1})
+	w.deliver(ctx)
+	require.Len(t, hits, 1)
+	assert.Equal(t, "redirect", <-hits)
+	dead, err = store.GetDeliveries(ctx, "w2", internal.DeliveryDead, 10)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, http.StatusFound, dead[0].StatusCode)
+}
+
+// countingWebhookStorage counts loads of webhooks of users.
+type countingWebhookStorage struct {
+	*storage.MemoryWebhookStorage
+	loads int
+}
+
+func (s *countingWebhookStorage) GetUserWebhooks(ctx context.Context, userID int) ([]internal.Webhook, error) {
+	s.loads++
+	return s.MemoryWebhookStorage.GetUserWebhooks(ctx, userID)
+}
+
+func TestWebhooksSubscriptionCache(t *testing.T) {
+	ctx := context.Background()
+	store := &countingWebhookStorage{MemoryWebhookStorage: storage.NewMemoryWebhookStorage()}
+	w := NewWebhooks(store, storage.NewMemoryStorage(), "http://localhost:8080", 1, time.Second, time.Hour)
+	w.allowAddr = func(netip.Addr) bool { return true }
+	now := time.Now()
+	w.now = func() time.Time { return now }
+
+	w.Publish(ctx, internal.EventLinkCreated, 1, []int{1})
+	w.Publish(ctx, internal.EventLinkCreated, 1, []int{2})
+	assert.Equal(t, 1, store.loads)
+
+	// Registration drops the cached subscriptions of the user.
+	webhook, err := w.Register(ctx, 1, "http://localhost:1", nil)
+	require.NoError(t, err)
+	w.Publish(ctx, internal.EventLinkCreated, 1, []int{3})
+	assert.Equal(t, 2, store.loads)
+	deliveries, err := store.GetDeliveries(ctx, webhook.ID, "", 10)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+
+	now = now.Add(webhookCacheTTL)
+	w.Publish(ctx, internal.EventLinkCreated, 1, []int{4})
+	assert.Equal(t, 3, store.loads)
+
+	require.NoError(t, w.Delete(ctx, 1, webhook.ID))
+	w.Publish(ctx, internal.EventLinkCreated, 1, []int{5})
+	deliveries, err = store.GetDeliveries(ctx, webhook.ID, "", 10)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 2)
+}
+
+func TestWebhooksEraseUser(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryWebhookStorage()
+	w := NewWebhooks(store, storage.NewMemoryStorage(), "http://localhost:8080", 1, time.Second, time.Hour)
+	w.allowAddr = func(netip.Addr) bool { return true }
+	webhook, err := w.Register(ctx, 1, "http://localhost:1", nil)
+	require.NoError(t, err)
+	w.Publish(ctx, internal.EventLinkCreated, 1, []int{1})
+
+	require.NoError(t, w.EraseUser(ctx, 1))
+	_, err = store.GetWebhook(ctx, webhook.ID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	deliveries, err := store.ClaimDeliveries(ctx, time.Now(), time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+	// The cached subscriptions are dropped, so no events of the user are queued after the erasure.
+	w.Publish(ctx, internal.EventLinkCreated, 1, []int{2})
+	deliveries, err = store.GetDeliveries(ctx, webhook.ID, "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+	assert.NoError(t, (*Webhooks)(nil).EraseUser(ctx, 1))
+}
+
+func TestWebhooksPrune(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryWebhookStorage()
+	w := NewWebhooks(store, storage.NewMemoryStorage(), "http://localhost:8080", 1, time.Second, time.Hour)
+	now := time.Now()
+	w.now = func() time.Time { return now }
+	require.NoError(t, store.AddDeliveries(ctx, []internal.WebhookDelivery{
+		{ID: "d1", WebhookID: "w1", Status: internal.DeliveryDelivered, UpdatedAt: now.Add(-2 * time.Hour)},
+		{ID: "d2", WebhookID: "w1", Status: internal.DeliveryDead, UpdatedAt: now.Add(-time.Minute)},
+	}))
+	w.prune(ctx)
+	deliveries, err := store.GetDeliveries(ctx, "w1", "", 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, "d2", deliveries[0].ID)
+}
@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// DefaultAuditLimit is the number of audit events returned if the filter has no limit.
+const DefaultAuditLimit = 100
+
+// AuditStorage keeps the append-only audit log of changes of URLs. The log is best-effort: events are appended
+// after the change is done, not in its transaction, so a change can miss its events if appending fails.
+type AuditStorage interface {
+	// AddEvents appends events to the log. Ids of events are assigned by the storage.
+	AddEvents(ctx context.Context, events []internal.AuditEvent) error
+	// GetEvents returns events matching the filter in descending order of id.
+	GetEvents(ctx context.Context, filter internal.AuditFilter) ([]internal.AuditEvent, error)
+	// Close closes resources.
+	Close()
+}
+
+var _ AuditStorage = (*MemoryAuditStorage)(nil)
+
+// MemoryAuditStorage keeps the audit log in memory. The id of the event is its position in the log plus one.
+type MemoryAuditStorage struct {
+	events []internal.AuditEvent
+	index  auditIndex
+	mutex  sync.RWMutex
+}
+
+// NewMemoryAuditStorage creates new *MemoryAuditStorage.
+func NewMemoryAuditStorage() *MemoryAuditStorage {
+	return &MemoryAuditStorage{index: newAuditIndex()}
+}
+
+// AddEvents appends events to the log.
+func (s *MemoryAuditStorage) AddEvents(_ context.Context, events []internal.AuditEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, e := range events {
+		e.ID = s.index.add(e)
+		s.events = append(s.events, e)
+	}
+	return nil
+}
+
+// GetEvents returns events matching the filter in descending order of id.
+func (s *MemoryAuditStorage) GetEvents(_ context.Context, filter internal.AuditFilter) ([]internal.AuditEvent, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.index.find(filter, func(id int64) (internal.AuditEvent, error) {
+		return s.events[id-1], nil
+	})
+}
+
+// Close does nothing.
+func (s *MemoryAuditStorage) Close() {
+}
+
+var _ AuditStorage = (*FileAuditStorage)(nil)
+
+// FileAuditStorage appends every event into the file and keeps only the index of the log in memory.
+// Matching events are read from the file. The file is scanned on start to build the index.
+type FileAuditStorage struct {
+	index auditIndex
+	// offsets keeps the offset of the line of the event with id i+1 in the file.
+	offsets []int64
+	size    int64
+	file    *os.File
+	mutex   sync.RWMutex
+}
+
+// NewFileAuditStorage creates FileAuditStorage and builds the index from the file with name=filename.
+func NewFileAuditStorage(filename string) (*FileAuditStorage, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0777)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileAuditStorage{index: newAuditIndex(), file: file}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var event internal.AuditEvent
+			if err := json.Unmarshal(line, &event); err != nil {
+				file.Close()
+				return nil, err
+			}
+			s.offsets = append(s.offsets, s.size)
+			s.size += int64(len(line))
+			s.index.add(event)
+		}
+		if errors.Is(err, io.EOF) {
+			return s, nil
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+}
+
+// AddEvents appends events into file and adds them to the index. The file is truncated back
+// if the write fails, so that a partly written line does not break the log.
+func (s *FileAuditStorage) AddEvents(_ context.Context, events []internal.AuditEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var data []byte
+	offsets := make([]int64, len(events))
+	for i, e := range events {
+		e.ID = int64(len(s.offsets) + i + 1)
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		offsets[i] = s.size + int64(len(data))
+		data = append(append(data, line...), '\n')
+	}
+	_, err := s.file.Write(data)
+	if err != nil {
+		if truncErr := s.file.Truncate(s.size); truncErr != nil {
+			err = errors.Join(err, truncErr)
+		}
+		return fmt.Errorf("error while writing audit events: %w", err)
+	}
+	for _, e := range events {
+		s.index.add(e)
+	}
+	s.offsets = append(s.offsets, offsets...)
+	s.size += int64(len(data))
+	return nil
+}
+
+// GetEvents returns events matching the filter in descending order of id reading them from the file.
+func (s *FileAuditStorage) GetEvents(_ context.Context, filter internal.AuditFilter) ([]internal.AuditEvent, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.index.find(filter, s.readEvent)
+}
+
+// readEvent reads the event with id from the file.
+func (s *FileAuditStorage) readEvent(id int64) (internal.AuditEvent, error) {
+	end := s.size
+	if id < int64(len(s.offsets)) {
+		end = s.offsets[id]
+	}
+	line := make([]byte, end-s.offsets[id-1])
+	var event internal.AuditEvent
+	if _, err := s.file.ReadAt(line, s.offsets[id-1]); err != nil {
+		return event, fmt.Errorf("error while reading audit event %d: %w", id, err)
+	}
+	if err := json.Unmarshal(line, &event); err != nil {
+		return event, err
+	}
+	// events written by older versions have no ids in the file
+	event.ID = id
+	return event, nil
+}
+
+// Close closes the file.
+func (s *FileAuditStorage) Close() {
+	s.file.Close()
+}
+
+// auditIndex keeps times of events and ids of events of every URL and every user, so that queries do not
+// read the whole log. Ids are assigned in ascending order, so lists of ids are sorted.
+type auditIndex struct {
+	// times keeps the time of the event with id i+1 in nanoseconds.
+	times  []int64
+	byURL  map[int][]int64
+	byUser map[int][]int64
+}
+
+func newAuditIndex() auditIndex {
+	return auditIndex{byURL: make(map[int][]int64), byUser: make(map[int][]int64)}
+}
+
+// add indexes the event as the next one of the log and returns its id.
+func (x *auditIndex) add(e internal.AuditEvent) int64 {
+	id := int64(len(x.times) + 1)
+	x.times = append(x.times, e.Time.UnixNano())
+	x.byURL[e.URLID] = append(x.byURL[e.URLID], id)
+	x.byUser[e.UserID] = append(x.byUser[e.UserID], id)
+	return id
+}
+
+// find returns events matching the filter in descending order of id. Only events of the URL or the user
+// of the filter are visited, and the time range is checked by the index, so get reads only events
+// which are likely to match.
+func (x *auditIndex) find(filter internal.AuditFilter, get func(id int64) (internal.AuditEvent, error)) ([]internal.AuditEvent, error) {
+	last := int64(len(x.times))
+	if filter.BeforeID > 0 && filter.BeforeID <= last {
+		last = filter.BeforeID - 1
+	}
+	limit := auditLimit(filter)
+	var res []internal.AuditEvent
+	visit := func(id int64) error {
+		t := x.times[id-1]
+		if !filter.From.IsZero() && t < filter.From.UnixNano() || !filter.To.IsZero() && t >= filter.To.UnixNano() {
+			return nil
+		}
+		e, err := get(id)
+		if err != nil {
+			return err
+		}
+		if filter.Match(e) {
+			res = append(res, e)
+		}
+		return nil
+	}
+
+	ids, ok := x.candidates(filter)
+	if !ok {
+		for id := last; id > 0 && len(res) < limit; id-- {
+			if err := visit(id); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+	i := sort.Search(len(ids), func(i int) bool { return ids[i] > last }) - 1
+	for ; i >= 0 && len(res) < limit; i-- {
+		if err := visit(ids[i]); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// candidates returns ids of events of the URL or the user of the filter choosing the shorter list.
+// Returns false if the filter selects neither a URL nor a user.
+func (x *auditIndex) candidates(filter internal.AuditFilter) ([]int64, bool) {
+	switch {
+	case filter.URLID != nil && filter.UserID != nil:
+		byURL, byUser := x.byURL[*filter.URLID], x.byUser[*filter.UserID]
+		if len(byURL) < len(byUser) {
+			return byURL, true
+		}
+		return byUser, true
+	case filter.URLID != nil:
+		return x.byURL[*filter.URLID], true
+	case filter.UserID != nil:
+		return x.byUser[*filter.UserID], true
+	default:
+		return nil, false
+	}
+}
+
+func auditLimit(filter internal.AuditFilter) int {
+	if filter.Limit <= 0 {
+		return DefaultAuditLimit
+	}
+	return filter.Limit
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileAuditStorage(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "audit")
+	store, err := NewFileAuditStorage(filename)
+	require.NoError(t, err)
+	now := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, store.AddEvents(ctx, []internal.AuditEvent{
+		{Action: internal.AuditCreate, URLID: 1, UserID: 1, RequestID: "a", Time: now.Add(-time.Hour)},
+		{Action: internal.AuditCreate, URLID: 2, UserID: 2, RequestID: "b", Time: now},
+	}))
+	require.NoError(t, store.AddEvents(ctx, []internal.AuditEvent{
+		{Action: internal.AuditDeleteRequest, URLID: 1, UserID: 1, RequestID: "c", IP: "10.0.0.1", Time: now},
+	}))
+	store.Close()
+
+	store, err = NewFileAuditStorage(filename)
+	require.NoError(t, err)
+	defer store.Close()
+	ids := func(filter internal.AuditFilter) []int64 {
+		events, err := store.GetEvents(ctx, filter)
+		require.NoError(t, err)
+		res := make([]int64, len(events))
+		for i, e := range events {
+			res[i] = e.ID
+		}
+		return res
+	}
+	urlID, userID := 1, 2
+	assert.Equal(t, []int64{3, 2, 1}, ids(internal.AuditFilter{}))
+	assert.Equal(t, []int64{3, 1}, ids(internal.AuditFilter{URLID: &urlID}))
+	assert.Equal(t, []int64{2}, ids(internal.AuditFilter{UserID: &userID}))
+	assert.Equal(t, []int64{3, 2}, ids(internal.AuditFilter{From: now}))
+	assert.Equal(t, []int64{1}, ids(internal.AuditFilter{To: now}))
+	assert.Equal(t, []int64{2}, ids(internal.AuditFilter{BeforeID: 3, Limit: 1}))
+	assert.Equal(t, []int64{1}, ids(internal.AuditFilter{URLID: &urlID, BeforeID: 3}))
+	assert.Equal(t, []int64{3}, ids(internal.AuditFilter{URLID: &urlID, From: now}))
+	assert.Empty(t, ids(internal.AuditFilter{URLID: &urlID, UserID: &userID}))
+	unknown := 100
+	assert.Empty(t, ids(internal.AuditFilter{UserID: &unknown}))
+	events, err := store.GetEvents(ctx, internal.AuditFilter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, internal.AuditEvent{ID: 3, Action: internal.AuditDeleteRequest, URLID: 1, UserID: 1, RequestID: "c",
+		IP: "10.0.0.1", Time: now}, events[0])
+
+	// Ids continue after the replayed events.
+	require.NoError(t, store.AddEvents(ctx, []internal.AuditEvent{{Action: internal.AuditRestore, URLID: 1, UserID: 1, Time: now}}))
+	assert.Equal(t, []int64{4}, ids(internal.AuditFilter{Limit: 1}))
+}
+
+func TestMemoryAuditStorage(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryAuditStorage()
+	now := time.Now()
+	var events []internal.AuditEvent
+	for i := 0; i < 10; i++ {
+		events = append(events, internal.AuditEvent{Action: internal.AuditCreate, URLID: i % 3, UserID: i % 2, Time: now})
+	}
+	require.NoError(t, store.AddEvents(ctx, events))
+	urlID, userID := 1, 1
+	res, err := store.GetEvents(ctx, internal.AuditFilter{URLID: &urlID, UserID: &userID, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	assert.Equal(t, int64(8), res[0].ID)
+	assert.Equal(t, int64(2), res[1].ID)
+}
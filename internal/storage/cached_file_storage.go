@@ -5,13 +5,19 @@ import (
 	"context"
 	"fmt"
 	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/health"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 var _ Storage = (*CachedFileStorage)(nil)
+var _ UserReserver = (*CachedFileStorage)(nil)
 
 // CachedFileStorage uses file for storage and cache in memory.
 type CachedFileStorage struct {
@@ -41,32 +47,24 @@ func NewCachedFileStorage(filename string) (*CachedFileStorage, error) {
 	urlsID := make(map[string]int)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		str := string(scanner.Bytes())
-		d := strings.Split(str, " ")
 		var id int
-		id, err = strconv.Atoi(d[0])
+		var url URL
+		id, url, err = parseLine(string(scanner.Bytes()))
 		if err != nil {
 			return nil, err
 		}
 		if urlCount < id {
 			urlCount = id
 		}
-		var userID int
-		userID, err = strconv.Atoi(d[1])
-		if err != nil {
-			return nil, err
-		}
+		userID := int(url.userID)
 		if userCount < userID {
 			userCount = userID
 		}
-		var isDeleted bool
-		isDeleted, err = strconv.ParseBool(d[3])
-		if err != nil {
-			return nil, err
-		}
-		url := URL{userID: int32(userID), url: d[2], isDeleted: isDeleted}
 		urls[id] = url
-		urlsID[d[1]] = id
+		if url.purged {
+			continue
+		}
+		urlsID[url.url] = id
 		userUrls[userID] = append(userUrls[userID], id)
 	}
 	if err = scanner.Err(); err != nil {
@@ -83,6 +81,22 @@ func NewCachedFileStorage(filename string) (*CachedFileStorage, error) {
 	}, nil
 }
 
+// RegisterChecks registers the check that the file of the storage is open and exists.
+func (s *CachedFileStorage) RegisterChecks(r health.Registry) {
+	r.Register(CheckName, s.check)
+}
+
+// check returns error if the file is closed or removed.
+func (s *CachedFileStorage) check(_ context.Context) error {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+	if _, err := s.file.Stat(); err != nil {
+		return err
+	}
+	_, err := os.Stat(s.filename)
+	return err
+}
+
 // Close closes the file.
 func (s *CachedFileStorage) Close() {
 	s.file.Close()
@@ -96,6 +110,16 @@ func (s *CachedFileStorage) AddUser(_ context.Context) (int, error) {
 	return s.userCount, nil
 }
 
+// ReserveUser makes sure that AddUser never returns userID or lower.
+func (s *CachedFileStorage) ReserveUser(_ context.Context, userID int) error {
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
+	if s.userCount < userID {
+		s.userCount = userID
+	}
+	return nil
+}
+
 // AddURL saves URL into file and after that saves it into cache. Returns ErrAlreadyExists if URL has been added already.
 func (s *CachedFileStorage) AddURL(_ context.Context, url string, userID int) (int, error) {
 	s.fileMutex.Lock()
@@ -106,12 +130,13 @@ func (s *CachedFileStorage) AddURL(_ context.Context, url string, userID int) (i
 
 	s.urlCount++
 	id := s.urlCount
-	_, err := fmt.Fprintf(s.file, "%d %d %s %v\n", id, userID, url, false)
+	u := URL{url: url, userID: int32(userID), isDeleted: false, createdAt: time.Now()}
+	err := writeLine(s.file, id, u)
 	if err != nil {
 		return 0, err
 	}
 
-	s.addToCache(userID, url, id)
+	s.addToCache(id, u)
 	return id, nil
 }
 
@@ -133,11 +158,26 @@ func (s *CachedFileStorage) GetURL(_ context.Context, idStr string) (string, err
 	return url.url, nil
 }
 
+// GetURLOwner returns the id of the user who saved the URL with id from cache.
+func (s *CachedFileStorage) GetURLOwner(_ context.Context, id int) (int, error) {
+	s.cacheMutex.RLock()
+	defer s.cacheMutex.RUnlock()
+	url, ok := s.urls[id]
+	if !ok || url.purged {
+		return 0, ErrNotFound
+	}
+	return int(url.userID), nil
+}
+
 // GetURLID returns url ID from cache.
 func (s *CachedFileStorage) GetURLID(_ context.Context, url string) (int, error) {
 	s.cacheMutex.RLock()
 	defer s.cacheMutex.RUnlock()
-	return s.urlsID[url], nil
+	id, ok := s.urlsID[url]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return id, nil
 }
 
 // GetUserUrls returns map with ids and original urls for all user's urls from cache.
@@ -155,88 +195,324 @@ func (s *CachedFileStorage) GetUserUrls(_ context.Context, userID int) (map[int]
 	return res, nil
 }
 
-// AddBatch saves list of urls into file and into cache.
+// AddBatch saves list of urls into file and into cache and returns the result for every URL.
+// URLs which already exist are not saved, their ids are returned with the flag Existed.
 func (s *CachedFileStorage) AddBatch(_ context.Context, urls []internal.CorrIDOriginalURL, userID int) ([]internal.CorrIDUrlID, error) {
 	s.fileMutex.Lock()
 	defer s.fileMutex.Unlock()
 
-	var res []internal.CorrIDUrlID
-	for _, v := range urls {
-		if _, ok := s.urlsID[v.OriginalURL]; ok {
+	res := make([]internal.CorrIDUrlID, len(urls))
+	for i, v := range urls {
+		res[i].CorrID = v.CorrID
+		if v.OriginalURL == "" {
+			res[i].Err = ErrEmptyURL
+			continue
+		}
+		s.cacheMutex.RLock()
+		id, ok := s.urlsID[v.OriginalURL]
+		s.cacheMutex.RUnlock()
+		if ok {
+			res[i].URLID = id
+			res[i].Existed = true
 			continue
 		}
 		s.urlCount++
-		id := s.urlCount
-		_, err := fmt.Fprintf(s.file, "%d %d %s %v\n", id, userID, v.OriginalURL, false)
-		if err == nil {
-			res = append(res, internal.CorrIDUrlID{CorrID: v.CorrID, URLID: id})
-			s.addToCache(userID, v.OriginalURL, id)
+		id = s.urlCount
+		u := URL{url: v.OriginalURL, userID: int32(userID), isDeleted: false, createdAt: time.Now()}
+		err := writeLine(s.file, id, u)
+		if err != nil {
+			res[i].Err = err
+			continue
 		}
+		res[i].URLID = id
+		s.addToCache(id, u)
 	}
 	return res, nil
 }
 
-// DeleteBatch rewrites file marking URLs from the list as deleted in file and in cache.
-func (s *CachedFileStorage) DeleteBatch(_ context.Context, ids []internal.IDToDelete) error {
-	// TODO: Change int to specific types.
-	idsMap := make(map[int]int)
-	for _, id := range ids {
-		idsMap[id.ID] = id.UserID
+// DeleteBatch marks URLs from the list as deleted in cache and rewrites file. Returns the result for every id.
+func (s *CachedFileStorage) DeleteBatch(_ context.Context, ids []internal.IDToDelete) ([]internal.DeleteResult, error) {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+	now := time.Now()
+	res := make([]internal.DeleteResult, len(ids))
+	changed := false
+	s.cacheMutex.Lock()
+	for i, v := range ids {
+		url, exists := s.urls[v.ID]
+		res[i] = internal.DeleteResult{ID: v.ID, Status: deleteStatus(exists, int(url.userID), v.UserID, url.isDeleted)}
+		if res[i].Status == internal.DeleteDeleted {
+			url.isDeleted = true
+			url.deletedAt = now
+			s.urls[v.ID] = url
+			changed = true
+		}
 	}
+	s.cacheMutex.Unlock()
+	if !changed {
+		return res, nil
+	}
+	return res, s.rewrite()
+}
 
+// RestoreBatch unmarks URLs from the list deleted after deletedAfter in cache and rewrites file.
+// Returns the result for every id.
+func (s *CachedFileStorage) RestoreBatch(_ context.Context, ids []internal.IDToDelete, deletedAfter time.Time) ([]internal.DeleteResult, error) {
 	s.fileMutex.Lock()
 	defer s.fileMutex.Unlock()
-	tmpPath := "tmp_" + s.filename
-	tmpFile, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0777)
-	if err != nil {
-		return err
+	res := make([]internal.DeleteResult, len(ids))
+	changed := false
+	s.cacheMutex.Lock()
+	for i, v := range ids {
+		url, exists := s.urls[v.ID]
+		res[i] = internal.DeleteResult{
+			ID:     v.ID,
+			Status: restoreStatus(exists, int(url.userID), v.UserID, url.isDeleted, url.purged, url.deletedAt, deletedAfter),
+		}
+		if res[i].Status == internal.DeleteRestored {
+			url.isDeleted = false
+			url.deletedAt = time.Time{}
+			s.urls[v.ID] = url
+			changed = true
+		}
+	}
+	s.cacheMutex.Unlock()
+	if !changed {
+		return res, nil
 	}
+	return res, s.rewrite()
+}
+
+// PurgeDeleted replaces URLs deleted before deletedBefore with tombstones in cache, frees their original URLs
+// and rewrites file.
+func (s *CachedFileStorage) PurgeDeleted(_ context.Context, deletedBefore time.Time) ([]internal.URLRecord, error) {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+	var purged []internal.URLRecord
+	s.cacheMutex.Lock()
 	for id, url := range s.urls {
-		userID, deleted := idsMap[id]
-		if deleted {
-			if int32(userID) != url.userID {
-				deleted = false
-			}
+		if !url.isDeleted || url.purged || !url.deletedAt.Before(deletedBefore) {
+			continue
 		}
-		if deleted {
-			s.setDeletedInCache(id)
+		userID := int(url.userID)
+		delete(s.urlsID, url.url)
+		s.userUrls[userID] = removeID(s.userUrls[userID], id)
+		if len(s.userUrls[userID]) == 0 {
+			delete(s.userUrls, userID)
 		}
-		_, err = fmt.Fprintf(tmpFile, "%d %d %s %v\n", id, url.userID, url.url, deleted)
-		if err != nil {
-			return err
+		purged = append(purged, url.record(id))
+		s.urls[id] = url.tombstone()
+	}
+	s.cacheMutex.Unlock()
+	if len(purged) == 0 {
+		return nil, nil
+	}
+	sort.Slice(purged, func(i, j int) bool {
+		return purged[i].ID < purged[j].ID
+	})
+	return purged, s.rewrite()
+}
+
+// GetUserRecords returns all URLs of the user including deleted ones from cache in ascending order of id.
+func (s *CachedFileStorage) GetUserRecords(_ context.Context, userID int) ([]internal.URLRecord, error) {
+	s.cacheMutex.RLock()
+	defer s.cacheMutex.RUnlock()
+	urlIDs := s.userUrls[userID]
+	res := make([]internal.URLRecord, 0, len(urlIDs))
+	for _, id := range urlIDs {
+		res = append(res, s.urls[id].record(id))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res, nil
+}
+
+// EraseUser replaces all URLs of the user with anonymous tombstones in cache, frees their original URLs
+// and rewrites file.
+func (s *CachedFileStorage) EraseUser(_ context.Context, userID int) ([]int, error) {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+	now := time.Now()
+	s.cacheMutex.Lock()
+	urlIDs := s.userUrls[userID]
+	erased := make([]int, 0, len(urlIDs))
+	for _, id := range urlIDs {
+		url := s.urls[id]
+		delete(s.urlsID, url.url)
+		s.urls[id] = url.erased(now)
+		erased = append(erased, id)
+	}
+	delete(s.userUrls, userID)
+	changed := len(erased) > 0
+	for id, url := range s.urls {
+		if url.purged && int(url.userID) == userID {
+			url.userID = 0
+			s.urls[id] = url
+			changed = true
 		}
 	}
-	err = s.file.Close()
+	s.cacheMutex.Unlock()
+	if !changed {
+		return erased, nil
+	}
+	return erased, s.rewrite()
+}
+
+// rewrite writes all URLs from cache into the temporary file and replaces the file with it.
+// Must be called under fileMutex.
+func (s *CachedFileStorage) rewrite() error {
+	var err error
+	s.file, err = rewriteFile(s.filename, s.file, func(w io.Writer) error {
+		s.cacheMutex.RLock()
+		defer s.cacheMutex.RUnlock()
+		for id, url := range s.urls {
+			if err := writeLine(w, id, url); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// rewriteFile writes the content by write into the temporary file and replaces the file with name=filename
+// opened as file with it. Returns the replaced file opened for appending. The file stays open if writing fails.
+func rewriteFile(filename string, file *os.File, write func(w io.Writer) error) (*os.File, error) {
+	tmpPath := filepath.Join(filepath.Dir(filename), "tmp_"+filepath.Base(filename))
+	tmpFile, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
 	if err != nil {
-		return err
+		return file, err
+	}
+	err = write(tmpFile)
+	if err != nil {
+		tmpFile.Close()
+		return file, err
 	}
 	err = tmpFile.Close()
 	if err != nil {
-		return err
+		return file, err
 	}
-	err = os.Rename(tmpPath, s.filename)
+	err = file.Close()
 	if err != nil {
-		return err
+		return file, err
 	}
-	s.file, err = os.OpenFile(s.filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0777)
+	err = os.Rename(tmpPath, filename)
 	if err != nil {
-		return err
+		return file, err
 	}
-	return nil
+	return os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0777)
 }
 
-func (s *CachedFileStorage) setDeletedInCache(id int) {
+func (s *CachedFileStorage) addToCache(id int, url URL) {
 	s.cacheMutex.Lock()
 	defer s.cacheMutex.Unlock()
-	url := s.urls[id]
-	url.isDeleted = true
 	s.urls[id] = url
+	s.urlsID[url.url] = id
+	s.userUrls[int(url.userID)] = append(s.userUrls[int(url.userID)], id)
 }
 
-func (s *CachedFileStorage) addToCache(userID int, url string, id int) {
-	s.cacheMutex.Lock()
-	defer s.cacheMutex.Unlock()
-	s.urls[id] = URL{userID: int32(userID), url: url, isDeleted: false}
-	s.urlsID[url] = id
-	s.userUrls[userID] = append(s.userUrls[userID], id)
+// Export calls fn for every URL from cache in ascending order of id.
+func (s *CachedFileStorage) Export(ctx context.Context, fn func(url internal.URLRecord) error) error {
+	s.cacheMutex.RLock()
+	records := make([]internal.URLRecord, 0, len(s.urls))
+	for id, url := range s.urls {
+		if url.purged {
+			continue
+		}
+		records = append(records, url.record(id))
+	}
+	s.cacheMutex.RUnlock()
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+
+	for _, rec := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import saves URLs keeping their ids into file and after that into cache.
+// Returns ErrIDOutOfRange if the id or the owner does not fit into int32.
+func (s *CachedFileStorage) Import(_ context.Context, urls []internal.URLRecord) (int, error) {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+
+	var imported int
+	for _, v := range urls {
+		if v.ID < 0 || v.OriginalURL == "" {
+			continue
+		}
+		if err := checkRecordIDs(v); err != nil {
+			return imported, err
+		}
+		s.cacheMutex.RLock()
+		_, idExists := s.urls[v.ID]
+		_, urlExists := s.urlsID[v.OriginalURL]
+		s.cacheMutex.RUnlock()
+		if idExists || urlExists {
+			continue
+		}
+		u := newURL(v)
+		err := writeLine(s.file, v.ID, u)
+		if err != nil {
+			return imported, err
+		}
+		s.addToCache(v.ID, u)
+		if s.urlCount < v.ID {
+			s.urlCount = v.ID
+		}
+		s.ReserveUser(context.Background(), v.UserID)
+		imported++
+	}
+	return imported, nil
+}
+
+// writeLine writes URL into w in the format "id userID url isDeleted createdAt deletedAt".
+// The url of a purged URL is empty.
+func writeLine(w io.Writer, id int, url URL) error {
+	_, err := fmt.Fprintf(w, "%d %d %s %v %s %s\n", id, url.userID, url.url, url.isDeleted,
+		url.createdAt.Format(time.RFC3339Nano), url.deletedAt.Format(time.RFC3339Nano))
+	return err
+}
+
+// parseLine parses the line written by writeLine. Lines written before createdAt and deletedAt were added
+// have them zero.
+func parseLine(line string) (int, URL, error) {
+	d := strings.Split(line, " ")
+	if len(d) < 4 {
+		return 0, URL{}, fmt.Errorf("wrong line format: %q", line)
+	}
+	id, err := strconv.Atoi(d[0])
+	if err != nil {
+		return 0, URL{}, err
+	}
+	userID, err := strconv.Atoi(d[1])
+	if err != nil {
+		return 0, URL{}, err
+	}
+	isDeleted, err := strconv.ParseBool(d[3])
+	if err != nil {
+		return 0, URL{}, err
+	}
+	url := URL{userID: int32(userID), url: d[2], isDeleted: isDeleted, purged: d[2] == ""}
+	if len(d) > 4 {
+		url.createdAt, err = time.Parse(time.RFC3339Nano, d[4])
+		if err != nil {
+			return 0, URL{}, err
+		}
+	}
+	if len(d) > 5 {
+		url.deletedAt, err = time.Parse(time.RFC3339Nano, d[5])
+		if err != nil {
+			return 0, URL{}, err
+		}
+	}
+	return id, url, nil
 }
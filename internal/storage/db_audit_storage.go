@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"strconv"
+	"strings"
+)
+
+var _ AuditStorage = (*DBAuditStorage)(nil)
+
+// DBAuditStorage keeps the audit log in table audit_events.
+type DBAuditStorage struct {
+	DB *sql.DB
+}
+
+// NewDBAuditStorage opens sql connection, creates table and returns *DBAuditStorage.
+func NewDBAuditStorage(dsn string) (*DBAuditStorage, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_events (
+			id bigserial PRIMARY KEY,
+			action varchar NOT NULL,
+			url_id bigint NOT NULL,
+			user_id integer NOT NULL,
+			request_id varchar,
+			ip varchar,
+			time timestamptz NOT NULL
+		)
+	`)
+	if err == nil {
+		_, err = db.Exec("CREATE INDEX IF NOT EXISTS audit_events_url_id_idx ON audit_events (url_id, id)")
+	}
+	if err == nil {
+		_, err = db.Exec("CREATE INDEX IF NOT EXISTS audit_events_user_id_idx ON audit_events (user_id, id)")
+	}
+	if err == nil {
+		_, err = db.Exec("CREATE INDEX IF NOT EXISTS audit_events_time_idx ON audit_events (time)")
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DBAuditStorage{DB: db}, nil
+}
+
+// AddEvents inserts events in one transaction.
+func (d DBAuditStorage) AddEvents(ctx context.Context, events []internal.AuditEvent) error {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO audit_events (action, url_id, user_id, request_id, ip, time) VALUES ($1, $2, $3, $4, $5, $6)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range events {
+		_, err = stmt.ExecContext(ctx, e.Action, e.URLID, e.UserID, e.RequestID, e.IP, e.Time)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetEvents returns events matching the filter in descending order of id.
+func (d DBAuditStorage) GetEvents(ctx context.Context, filter internal.AuditFilter) ([]internal.AuditEvent, error) {
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, cond+" $"+strconv.Itoa(len(args)))
+	}
+	if filter.URLID != nil {
+		add("url_id =", *filter.URLID)
+	}
+	if filter.UserID != nil {
+		add("user_id =", *filter.UserID)
+	}
+	if !filter.From.IsZero() {
+		add("time >=", filter.From)
+	}
+	if !filter.To.IsZero() {
+		add("time <", filter.To)
+	}
+	if filter.BeforeID != 0 {
+		add("id <", filter.BeforeID)
+	}
+	query := "SELECT id, action, url_id, user_id, COALESCE(request_id, ''), COALESCE(ip, ''), time FROM audit_events"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	args = append(args, auditLimit(filter))
+	query += " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := d.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []internal.AuditEvent
+	for rows.Next() {
+		var e internal.AuditEvent
+		err = rows.Scan(&e.ID, &e.Action, &e.URLID, &e.UserID, &e.RequestID, &e.IP, &e.Time)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
+
+// Close closes sql connection.
+func (d DBAuditStorage) Close() {
+	d.DB.Close()
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"time"
+)
+
+var _ DeleteQueue = (*DBDeleteQueue)(nil)
+
+// deleteQueueLockKey is the key of the advisory lock which makes the check of the size of the queue and the insert
+// atomic.
+const deleteQueueLockKey = 7_265_746
+
+// DBDeleteQueue keeps up to maxSize ids queued for deletion in table delete_queue.
+// Instances sharing the database take ids with FOR UPDATE SKIP LOCKED, so every id is processed by one of them.
+type DBDeleteQueue struct {
+	DB      *sql.DB
+	maxSize int
+}
+
+// NewDBDeleteQueue opens sql connection, creates table and returns *DBDeleteQueue.
+func NewDBDeleteQueue(dsn string, maxSize int) (*DBDeleteQueue, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS delete_queue (
+			id bigserial PRIMARY KEY,
+			url_id bigint NOT NULL,
+			user_id integer NOT NULL,
+			op_id varchar NOT NULL DEFAULT '',
+			attempts integer NOT NULL DEFAULT 0,
+			available_at timestamptz NOT NULL DEFAULT now()
+		)
+	`)
+	if err == nil {
+		_, err = db.Exec("ALTER TABLE delete_queue ADD COLUMN IF NOT EXISTS op_id varchar NOT NULL DEFAULT ''")
+	}
+	if err == nil {
+		// ids of urls are bigserial
+		_, err = db.Exec("ALTER TABLE delete_queue ALTER COLUMN url_id TYPE bigint")
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DBDeleteQueue{DB: db, maxSize: maxSize}, nil
+}
+
+// Push adds ids to the queue. Returns ErrQueueFull if the queue has no room for all of them.
+// Concurrent pushes wait for each other on the advisory lock of the transaction, so the queue never exceeds maxSize.
+func (d DBDeleteQueue) Push(ctx context.Context, ids []internal.IDToDelete) error {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", deleteQueueLockKey)
+	if err != nil {
+		return err
+	}
+	var n int
+	err = tx.QueryRowContext(ctx, "SELECT count(*) FROM delete_queue").Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n+len(ids) > d.maxSize {
+		return ErrQueueFull
+	}
+	urlIDs := make([]int64, len(ids))
+	userIDs := make([]int64, len(ids))
+	opIDs := make([]string, len(ids))
+	for i, v := range ids {
+		urlIDs[i] = int64(v.ID)
+		userIDs[i] = int64(v.UserID)
+		opIDs[i] = v.OpID
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO delete_queue (url_id, user_id, op_id)
+		SELECT * FROM unnest($1::bigint[], $2::integer[], $3::varchar[])`, urlIDs, userIDs, opIDs)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Process takes up to limit ready ids locking them until the end of the transaction and calls fn with them.
+// If fn succeeds the ids are removed from the queue, otherwise they become ready again after retryDelay(attempts).
+// If the instance crashes the lock is released and the ids are taken again.
+func (d DBDeleteQueue) Process(ctx context.Context, limit int, fn func(ctx context.Context, ids []internal.IDToDelete) error,
+	retryDelay func(attempts int) time.Duration) (int, error) {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, url_id, user_id, op_id, attempts FROM delete_queue
+		WHERE available_at <= now()
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED`, limit)
+	if err != nil {
+		return 0, err
+	}
+	var seqs []int64
+	var attempts []int
+	var ids []internal.IDToDelete
+	for rows.Next() {
+		var seq int64
+		var id internal.IDToDelete
+		err = rows.Scan(&seq, &id.ID, &id.UserID, &id.OpID, &id.Attempts)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		seqs = append(seqs, seq)
+		attempts = append(attempts, id.Attempts)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	fnErr := fn(ctx, ids)
+	if fnErr == nil {
+		_, err = tx.ExecContext(ctx, "DELETE FROM delete_queue WHERE id = ANY($1)", seqs)
+	} else {
+		now := time.Now()
+		availableAt := make([]time.Time, len(seqs))
+		for i, n := range attempts {
+			availableAt[i] = now.Add(retryDelay(n + 1))
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE delete_queue q SET attempts = q.attempts + 1, available_at = r.available_at
+			FROM unnest($1::bigint[], $2::timestamptz[]) AS r(id, available_at)
+			WHERE q.id = r.id`, seqs, availableAt)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if fnErr != nil {
+		return len(ids), fnErr
+	}
+	return len(ids), err
+}
+
+// Len returns the number of queued ids.
+func (d DBDeleteQueue) Len(ctx context.Context) (int, error) {
+	var n int
+	err := d.DB.QueryRowContext(ctx, "SELECT count(*) FROM delete_queue").Scan(&n)
+	return n, err
+}
+
+// Close closes sql connection.
+func (d DBDeleteQueue) Close() {
+	d.DB.Close()
+}
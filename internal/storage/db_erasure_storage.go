@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"github.com/MalyginaEkaterina/shortener/internal"
+)
+
+var _ ErasureStorage = (*DBErasureStorage)(nil)
+
+// DBErasureStorage keeps records of erased users in table user_erasures.
+type DBErasureStorage struct {
+	DB *sql.DB
+}
+
+// NewDBErasureStorage opens sql connection, creates table and returns *DBErasureStorage.
+func NewDBErasureStorage(dsn string) (*DBErasureStorage, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_erasures (
+			user_id integer PRIMARY KEY,
+			request_id varchar,
+			ip varchar,
+			links integer,
+			erased_at timestamptz
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DBErasureStorage{DB: db}, nil
+}
+
+// AddErasure inserts the audit record of the erasure of the user.
+func (d DBErasureStorage) AddErasure(ctx context.Context, erasure internal.UserErasure) error {
+	_, err := d.DB.ExecContext(ctx, `
+		INSERT INTO user_erasures (user_id, request_id, ip, links, erased_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE
+		SET request_id = $2, ip = $3, links = $4, erased_at = $5`,
+		erasure.UserID, erasure.RequestID, erasure.IP, erasure.Links, erasure.ErasedAt)
+	return err
+}
+
+// IsErased reports whether the user was erased.
+func (d DBErasureStorage) IsErased(ctx context.Context, userID int) (bool, error) {
+	var erased bool
+	err := d.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM user_erasures WHERE user_id = $1)", userID).Scan(&erased)
+	return erased, err
+}
+
+// LastErased returns the highest id of erased users or 0 if no user was erased.
+func (d DBErasureStorage) LastErased(ctx context.Context) (int, error) {
+	var last int
+	err := d.DB.QueryRowContext(ctx, "SELECT COALESCE(max(user_id), 0) FROM user_erasures").Scan(&last)
+	return last, err
+}
+
+// Close closes sql connection.
+func (d DBErasureStorage) Close() {
+	d.DB.Close()
+}
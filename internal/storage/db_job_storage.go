@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"time"
+)
+
+var _ JobStorage = (*DBJobStorage)(nil)
+
+// DBJobStorage keeps import jobs in tables import_jobs and import_job_items.
+type DBJobStorage struct {
+	DB *sql.DB
+}
+
+// NewDBJobStorage opens sql connection, creates tables and returns *DBJobStorage.
+func NewDBJobStorage(dsn string) (*DBJobStorage, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = initJobTables(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DBJobStorage{DB: db}, nil
+}
+
+func initJobTables(db *sql.DB) error {
+	const createJobsTableSQL = `
+		CREATE TABLE IF NOT EXISTS import_jobs (
+			id varchar PRIMARY KEY,
+			user_id integer,
+			status varchar,
+			total integer,
+			processed integer,
+			created integer,
+			existing integer,
+			invalid integer,
+			failed integer,
+			error varchar,
+			created_at timestamptz,
+			updated_at timestamptz
+		)
+	`
+	const createJobItemsTableSQL = `
+		CREATE TABLE IF NOT EXISTS import_job_items (
+			job_id varchar,
+			line integer,
+			correlation_id varchar,
+			original_url varchar,
+			url_id bigint,
+			status varchar,
+			reason varchar,
+			PRIMARY KEY (job_id, line),
+			FOREIGN KEY (job_id) REFERENCES import_jobs (id) ON DELETE CASCADE
+		)
+	`
+	_, err := db.Exec(createJobsTableSQL)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS lease_id varchar NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS lease_until timestamptz")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS import_jobs_status ON import_jobs (status, created_at)")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(createJobItemsTableSQL)
+	return err
+}
+
+// CreateJob inserts the job and copies its items in one transaction.
+func (d DBJobStorage) CreateJob(ctx context.Context, job internal.ImportJob, items []internal.JobItem) error {
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn any) error {
+		pgxConn := driverConn.(*stdlib.Conn).Conn()
+		tx, err := pgxConn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+		_, err = tx.Exec(ctx, `
+			INSERT INTO import_jobs (id, user_id, status, total, processed, created, existing, invalid, failed,
+				error, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+			job.ID, job.UserID, job.Status, job.Total, job.Processed, job.Created, job.Existing, job.Invalid,
+			job.Failed, job.Error, job.CreatedAt, job.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CopyFrom(ctx, pgx.Identifier{"import_job_items"},
+			[]string{"job_id", "line", "correlation_id", "original_url"},
+			pgx.CopyFromSlice(len(items), func(i int) ([]any, error) {
+				return []any{job.ID, items[i].Line, items[i].CorrID, items[i].OriginalURL}, nil
+			}))
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+// GetJob returns the job by its id or ErrNotFound.
+func (d DBJobStorage) GetJob(ctx context.Context, id string) (internal.ImportJob, error) {
+	row := d.DB.QueryRowContext(ctx, `
+		SELECT id, user_id, status, total, processed, created, existing, invalid, failed, error, created_at, updated_at,
+			lease_id, lease_until
+		FROM import_jobs WHERE id = $1`, id)
+	job, err := scanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return job, ErrNotFound
+	}
+	return job, err
+}
+
+// ClaimJob claims the oldest queued job or the running job whose lease expired by now. Concurrent claims skip
+// the jobs locked by each other, so every job is claimed once.
+func (d DBJobStorage) ClaimJob(ctx context.Context, leaseID string, now, leaseUntil time.Time) (internal.ImportJob, error) {
+	row := d.DB.QueryRowContext(ctx, `
+		UPDATE import_jobs SET status = $1, lease_id = $3, lease_until = $4, updated_at = $5
+		WHERE id = (
+			SELECT id FROM import_jobs
+			WHERE status = $2 OR (status = $1 AND (lease_until IS NULL OR lease_until <= $5))
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, status, total, processed, created, existing, invalid, failed, error, created_at,
+			updated_at, lease_id, lease_until`,
+		internal.JobRunning, internal.JobQueued, leaseID, leaseUntil, now)
+	job, err := scanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return job, ErrNotFound
+	}
+	return job, err
+}
+
+func scanJob(row *sql.Row) (internal.ImportJob, error) {
+	var job internal.ImportJob
+	var leaseUntil sql.NullTime
+	err := row.Scan(&job.ID, &job.UserID, &job.Status, &job.Total, &job.Processed, &job.Created, &job.Existing,
+		&job.Invalid, &job.Failed, &job.Error, &job.CreatedAt, &job.UpdatedAt, &job.LeaseID, &leaseUntil)
+	job.LeaseUntil = leaseUntil.Time
+	return job, err
+}
+
+// UpdateJob saves the state of the job.
+func (d DBJobStorage) UpdateJob(ctx context.Context, job internal.ImportJob) error {
+	return updateJob(ctx, d.DB, job)
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// updateJob updates the job if it has the same lease.
+func updateJob(ctx context.Context, db execer, job internal.ImportJob) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE import_jobs SET status = $2, total = $3, processed = $4, created = $5, existing = $6, invalid = $7,
+			failed = $8, error = $9, updated_at = $10, lease_until = $12
+		WHERE id = $1 AND lease_id = $11`,
+		job.ID, job.Status, job.Total, job.Processed, job.Created, job.Existing, job.Invalid, job.Failed, job.Error,
+		job.UpdatedAt, job.LeaseID, job.LeaseUntil)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Items returns up to limit items of the job ordered by line skipping the first offset items.
+func (d DBJobStorage) Items(ctx context.Context, jobID string, offset, limit int) ([]internal.JobItem, error) {
+	rows, err := d.DB.QueryContext(ctx, `
+		SELECT line, correlation_id, original_url, COALESCE(url_id, 0), COALESCE(status, ''), COALESCE(reason, '')
+		FROM import_job_items WHERE job_id = $1 ORDER BY line OFFSET $2 LIMIT $3`, jobID, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []internal.JobItem
+	for rows.Next() {
+		var item internal.JobItem
+		err = rows.Scan(&item.Line, &item.CorrID, &item.OriginalURL, &item.URLID, &item.Status, &item.Reason)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// SaveResults updates the results of processed items and the state of the job in one transaction.
+func (d DBJobStorage) SaveResults(ctx context.Context, job internal.ImportJob, items []internal.JobItem) error {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	lines := make([]int32, len(items))
+	urlIDs := make([]int64, len(items))
+	statuses := make([]string, len(items))
+	reasons := make([]string, len(items))
+	for i, v := range items {
+		lines[i] = int32(v.Line)
+		urlIDs[i] = int64(v.URLID)
+		statuses[i] = v.Status
+		reasons[i] = v.Reason
+	}
+	// the job is updated first, so items are not changed if it was claimed with another lease
+	err = updateJob(ctx, tx, job)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE import_job_items i SET url_id = r.url_id, status = r.status, reason = r.reason
+		FROM unnest($2::integer[], $3::bigint[], $4::varchar[], $5::varchar[]) AS r(line, url_id, status, reason)
+		WHERE i.job_id = $1 AND i.line = r.line`, job.ID, lines, urlIDs, statuses, reasons)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EraseUser deletes jobs of the user, their items are deleted by the foreign key.
+func (d DBJobStorage) EraseUser(ctx context.Context, userID int) error {
+	_, err := d.DB.ExecContext(ctx, "DELETE FROM import_jobs WHERE user_id = $1", userID)
+	return err
+}
+
+// Close closes sql connection.
+func (d DBJobStorage) Close() {
+	d.DB.Close()
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	urlChangesChannel = "url_changes"
+	// maxPayloadSize is less than the limit of 8000 bytes of Postgres NOTIFY payload.
+	maxPayloadSize    = 7900
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// Invalidator removes cached URLs.
+type Invalidator interface {
+	// Invalidate removes URLs with ids from cache.
+	Invalidate(ids ...int)
+	// Purge removes all URLs from cache.
+	Purge()
+}
+
+var _ Invalidator = (*LRUStorage)(nil)
+
+// notifyURLChanges sends ids of changed URLs to other instances. Notifications are delivered after the commit of tx.
+func notifyURLChanges(ctx context.Context, tx *sql.Tx, ids []int) error {
+	for _, payload := range changePayloads(ids) {
+		_, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", urlChangesChannel, payload)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changePayloads joins ids with commas into payloads not exceeding maxPayloadSize.
+func changePayloads(ids []int) []string {
+	var payloads []string
+	var b strings.Builder
+	for _, id := range ids {
+		s := strconv.Itoa(id)
+		if b.Len() > 0 && b.Len()+len(s)+1 > maxPayloadSize {
+			payloads = append(payloads, b.String())
+			b.Reset()
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(s)
+	}
+	if b.Len() > 0 {
+		payloads = append(payloads, b.String())
+	}
+	return payloads
+}
+
+func parseChangePayload(payload string) ([]int, error) {
+	fields := strings.Split(payload, ",")
+	ids := make([]int, len(fields))
+	for i, f := range fields {
+		id, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// ListenURLChanges receives notifications about changed URLs from all instances sharing the database
+// and invalidates them in cache. After a connection loss it reconnects and purges the whole cache
+// because notifications sent during the reconnection are lost. Returns when ctx is done.
+func ListenURLChanges(ctx context.Context, dsn string, cache Invalidator) {
+	delay := minReconnectDelay
+	for {
+		err := listen(ctx, dsn, cache, func() {
+			delay = minReconnectDelay
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		slog.WarnContext(ctx, "Listening to URL changes failed, reconnecting", "delay", delay, logging.Err(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// listen subscribes to urlChangesChannel, purges cache and invalidates URLs until an error occurs.
+// onConnected is called after the subscription.
+func listen(ctx context.Context, dsn string, cache Invalidator, onConnected func()) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	_, err = conn.Exec(ctx, "LISTEN "+urlChangesChannel)
+	if err != nil {
+		return err
+	}
+	// Changes made before the subscription could be missed.
+	cache.Purge()
+	onConnected()
+	slog.InfoContext(ctx, "Listening to URL changes")
+
+	for {
+		var notification *pgconn.Notification
+		notification, err = conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var ids []int
+		ids, err = parseChangePayload(notification.Payload)
+		if err != nil {
+			slog.WarnContext(ctx, "Wrong URL changes payload", "payload", notification.Payload, logging.Err(err))
+			cache.Purge()
+			continue
+		}
+		cache.Invalidate(ids...)
+	}
+}
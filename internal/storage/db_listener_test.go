@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestChangePayloads(t *testing.T) {
+	tests := []struct {
+		name     string
+		ids      []int
+		payloads int
+	}{
+		{name: "No ids", ids: nil, payloads: 0},
+		{name: "One id", ids: []int{42}, payloads: 1},
+		{name: "Many ids", ids: make([]int, 1000), payloads: 1},
+		{name: "Ids exceeding payload size", ids: make([]int, 5000), payloads: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.ids {
+				tt.ids[i] = i * 7
+			}
+			payloads := changePayloads(tt.ids)
+			require.Len(t, payloads, tt.payloads)
+			var ids []int
+			for _, p := range payloads {
+				assert.LessOrEqual(t, len(p), maxPayloadSize)
+				parsed, err := parseChangePayload(p)
+				require.NoError(t, err)
+				ids = append(ids, parsed...)
+			}
+			assert.Equal(t, len(tt.ids), len(ids))
+			if len(tt.ids) > 0 {
+				assert.Equal(t, tt.ids, ids)
+			}
+		})
+	}
+
+	_, err := parseChangePayload("1,x")
+	assert.Error(t, err)
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"time"
+)
+
+var _ OperationStorage = (*DBOperationStorage)(nil)
+
+// DBOperationStorage keeps deletion operations in table delete_operations with results as jsonb.
+type DBOperationStorage struct {
+	DB *sql.DB
+}
+
+// NewDBOperationStorage opens sql connection, creates table and returns *DBOperationStorage.
+func NewDBOperationStorage(dsn string) (*DBOperationStorage, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS delete_operations (
+			id varchar PRIMARY KEY,
+			user_id integer,
+			status varchar,
+			results jsonb,
+			created_at timestamptz,
+			updated_at timestamptz
+		)
+	`)
+	if err == nil {
+		_, err = db.Exec("ALTER TABLE delete_operations ADD COLUMN IF NOT EXISTS request_id varchar")
+	}
+	if err == nil {
+		_, err = db.Exec("ALTER TABLE delete_operations ADD COLUMN IF NOT EXISTS ip varchar")
+	}
+	if err == nil {
+		_, err = db.Exec("ALTER TABLE delete_operations ADD COLUMN IF NOT EXISTS trace_parent varchar")
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DBOperationStorage{DB: db}, nil
+}
+
+// CreateOperation inserts the new operation.
+func (d DBOperationStorage) CreateOperation(ctx context.Context, op internal.DeleteOperation) error {
+	results, err := json.Marshal(op.Results)
+	if err != nil {
+		return err
+	}
+	_, err = d.DB.ExecContext(ctx, `
+		INSERT INTO delete_operations (id, user_id, status, results, created_at, updated_at, request_id, ip, trace_parent)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		op.ID, op.UserID, op.Status, string(results), op.CreatedAt, op.UpdatedAt, op.Request.RequestID, op.Request.IP,
+		op.Request.TraceParent)
+	return err
+}
+
+// GetOperation returns the operation by its id or ErrNotFound.
+func (d DBOperationStorage) GetOperation(ctx context.Context, id string) (internal.DeleteOperation, error) {
+	return getOperation(ctx, d.DB, id, "")
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func getOperation(ctx context.Context, db queryRower, id string, suffix string) (internal.DeleteOperation, error) {
+	var op internal.DeleteOperation
+	var results []byte
+	err := db.QueryRowContext(ctx, `
+		SELECT id, user_id, status, results, created_at, updated_at, COALESCE(request_id, ''), COALESCE(ip, ''),
+			COALESCE(trace_parent, '')
+		FROM delete_operations WHERE id = $1`+suffix, id).
+		Scan(&op.ID, &op.UserID, &op.Status, &results, &op.CreatedAt, &op.UpdatedAt, &op.Request.RequestID, &op.Request.IP,
+			&op.Request.TraceParent)
+	if errors.Is(err, sql.ErrNoRows) {
+		return op, ErrNotFound
+	} else if err != nil {
+		return op, err
+	}
+	err = json.Unmarshal(results, &op.Results)
+	return op, err
+}
+
+// SaveResults locks the operation, sets statuses of its queued URL ids and updates it.
+func (d DBOperationStorage) SaveResults(ctx context.Context, opID string, results []internal.DeleteResult) error {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	op, err := getOperation(ctx, tx, opID, " FOR UPDATE")
+	if err != nil {
+		return err
+	}
+	applyResults(&op, results, time.Now())
+	data, err := json.Marshal(op.Results)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE delete_operations SET status = $2, results = $3, updated_at = $4 WHERE id = $1",
+		op.ID, op.Status, string(data), op.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EraseUser deletes operations of the user.
+func (d DBOperationStorage) EraseUser(ctx context.Context, userID int) error {
+	_, err := d.DB.ExecContext(ctx, "DELETE FROM delete_operations WHERE user_id = $1", userID)
+	return err
+}
+
+// Close closes sql connection.
+func (d DBOperationStorage) Close() {
+	d.DB.Close()
+}
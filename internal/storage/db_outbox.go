@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"sort"
+	"time"
+)
+
+// createOutboxTableSQL creates the table of events saved by DBStorage in the same transaction as changes of URLs.
+const createOutboxTableSQL = `
+	CREATE TABLE IF NOT EXISTS outbox (
+		id bigserial PRIMARY KEY,
+		type varchar NOT NULL,
+		url_id bigint NOT NULL,
+		user_id integer NOT NULL,
+		original_url varchar,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		claimed_until timestamptz
+	)
+`
+
+// createOutboxTable creates table outbox and adds columns of newer versions.
+func createOutboxTable(db *sql.DB) error {
+	_, err := db.Exec(createOutboxTableSQL)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE outbox ADD COLUMN IF NOT EXISTS claimed_until timestamptz")
+	return err
+}
+
+// insertOutboxSQL inserts the event of type $1 of the URL with id $2, owner $3 and original URL $4.
+const insertOutboxSQL = `INSERT INTO outbox (type, url_id, user_id, original_url) VALUES ($1, $2, $3, $4)`
+
+// outboxLockKey is the key of the advisory lock which allows only one relay to claim events at a time.
+const outboxLockKey = 7_240_411
+
+// outboxLease is the time during which claimed events are published by the relay which claimed them.
+const outboxLease = time.Minute
+
+// Outbox keeps events which are not published yet.
+type Outbox interface {
+	// Process takes up to limit oldest events and calls fn with them in ascending order of id.
+	// If fn succeeds the events are removed, otherwise they are taken again by the next call.
+	// Returns the number of taken events and the error of fn.
+	Process(ctx context.Context, limit int, fn func(ctx context.Context, events []internal.OutboxEvent) error) (int, error)
+	// Close closes resources.
+	Close()
+}
+
+var _ Outbox = (*DBOutbox)(nil)
+
+// DBOutbox reads events from table outbox written by DBStorage.
+type DBOutbox struct {
+	DB *sql.DB
+}
+
+// NewDBOutbox opens sql connection, creates table and returns *DBOutbox.
+func NewDBOutbox(dsn string) (*DBOutbox, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = createOutboxTable(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DBOutbox{DB: db}, nil
+}
+
+// Process claims up to limit oldest events for outboxLease in a short transaction under the advisory lock,
+// so that only one instance publishes events at a time and events of the same URL are never published out of order.
+// Takes nothing if another instance holds the lock or has claimed events which are not released yet.
+// fn is called without holding the transaction. Events are removed after fn succeeds and released otherwise,
+// events of a relay which stopped are claimed again after the lease, so they are published at least once.
+func (d DBOutbox) Process(ctx context.Context, limit int, fn func(ctx context.Context, events []internal.OutboxEvent) error) (int, error) {
+	events, err := d.claim(ctx, limit)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+	ids := make([]int64, len(events))
+	for i, e := range events {
+		ids[i] = e.ID
+	}
+
+	err = fn(ctx, events)
+	if err != nil {
+		if _, releaseErr := d.DB.ExecContext(ctx, "UPDATE outbox SET claimed_until = NULL WHERE id = ANY($1)", ids); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+		return len(events), err
+	}
+	_, err = d.DB.ExecContext(ctx, "DELETE FROM outbox WHERE id = ANY($1)", ids)
+	return len(events), err
+}
+
+// claim marks up to limit oldest events as claimed for outboxLease and returns them in ascending order of id.
+func (d DBOutbox) claim(ctx context.Context, limit int) ([]internal.OutboxEvent, error) {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	err = tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", outboxLockKey).Scan(&locked)
+	if err != nil || !locked {
+		return nil, err
+	}
+	now := time.Now()
+	var busy bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM outbox WHERE claimed_until > $1)", now).Scan(&busy)
+	if err != nil || busy {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE outbox SET claimed_until = $2
+		WHERE id IN (SELECT id FROM outbox ORDER BY id LIMIT $1)
+		RETURNING id, type, url_id, user_id, COALESCE(original_url, ''), created_at`,
+		limit, now.Add(outboxLease))
+	if err != nil {
+		return nil, err
+	}
+	var events []internal.OutboxEvent
+	for rows.Next() {
+		var e internal.OutboxEvent
+		err = rows.Scan(&e.ID, &e.Type, &e.URLID, &e.UserID, &e.OriginalURL, &e.Time)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+	return events, tx.Commit()
+}
+
+// Close closes sql connection.
+func (d DBOutbox) Close() {
+	d.DB.Close()
+}
@@ -5,23 +5,40 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/MalyginaEkaterina/shortener/internal"
-	"log"
+	"github.com/MalyginaEkaterina/shortener/internal/health"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"log/slog"
+	"strconv"
 	"time"
 )
 
 var _ Storage = (*DBStorage)(nil)
+var _ UserReserver = (*DBStorage)(nil)
+var _ UserCleaner = (*DBStorage)(nil)
 
 // DBStorage contains *sql.DB and prepared statements.
 type DBStorage struct {
 	DB               *sql.DB
 	insertUser       *sql.Stmt
+	saveUser         *sql.Stmt
 	insertURL        *sql.Stmt
 	selectURLByID    *sql.Stmt
 	selectUrlsByUser *sql.Stmt
 	selectURLID      *sql.Stmt
+	selectURLState   *sql.Stmt
 	deleteURL        *sql.Stmt
+	restoreURL       *sql.Stmt
+	selectPurgedURL  *sql.Stmt
+	// Outbox enables saving of events of created, deleted, restored, purged and erased URLs into table outbox
+	// in the same transaction as the change. Events are published by the relay of DBOutbox.
+	Outbox bool
 }
 
+// purgeBatchSize is the number of URLs purged or users removed in one statement.
+const purgeBatchSize = 1000
+
 // NewDBStorage opens sql connection, prepares statements and returns *DBStorage.
 func NewDBStorage(dsn string) (*DBStorage, error) {
 	db, err := sql.Open("pgx", dsn)
@@ -32,7 +49,12 @@ func NewDBStorage(dsn string) (*DBStorage, error) {
 	if err != nil {
 		return nil, err
 	}
-	stmtInsertUser, err := db.Prepare("INSERT INTO users DEFAULT VALUES RETURNING id")
+	// Users are saved with their first URL, so only the id is allocated for a new user.
+	stmtInsertUser, err := db.Prepare("SELECT nextval('users_id_seq')")
+	if err != nil {
+		return nil, err
+	}
+	stmtSaveUser, err := db.Prepare(saveUserSQL)
 	if err != nil {
 		return nil, err
 	}
@@ -52,25 +74,47 @@ func NewDBStorage(dsn string) (*DBStorage, error) {
 	if err != nil {
 		return nil, err
 	}
-	stmtDeleteURL, err := db.Prepare("UPDATE urls SET is_deleted = true WHERE id = $1 AND user_id = $2")
+	// URLs deleted before deleted_at was added are considered deleted at the epoch.
+	stmtSelectURLState, err := db.Prepare(`SELECT COALESCE(user_id, 0), is_deleted, COALESCE(deleted_at, 'epoch')
+		FROM urls WHERE id = $1 FOR UPDATE`)
+	if err != nil {
+		return nil, err
+	}
+	stmtDeleteURL, err := db.Prepare("UPDATE urls SET is_deleted = true, deleted_at = now() WHERE id = $1")
+	if err != nil {
+		return nil, err
+	}
+	stmtRestoreURL, err := db.Prepare("UPDATE urls SET is_deleted = false, deleted_at = NULL WHERE id = $1")
+	if err != nil {
+		return nil, err
+	}
+	stmtSelectPurgedURL, err := db.Prepare("SELECT COALESCE(user_id, 0), deleted_at FROM purged_urls WHERE id = $1")
 	if err != nil {
 		return nil, err
 	}
 	return &DBStorage{
 		DB:               db,
 		insertUser:       stmtInsertUser,
+		saveUser:         stmtSaveUser,
 		insertURL:        stmtInsertURL,
 		selectURLByID:    stmtSelectURLByID,
 		selectUrlsByUser: stmtSelectUrlsByUser,
 		selectURLID:      stmtSelectURLID,
+		selectURLState:   stmtSelectURLState,
 		deleteURL:        stmtDeleteURL,
+		restoreURL:       stmtRestoreURL,
+		selectPurgedURL:  stmtSelectPurgedURL,
 	}, nil
 }
 
+// saveUserSQL inserts the user with id $1 or updates the time of its last activity.
+const saveUserSQL = `INSERT INTO users (id) VALUES ($1) ON CONFLICT (id) DO UPDATE SET last_active_at = now()`
+
 func initTables(db *sql.DB) error {
 	const createUsersTableSQL = `
 		CREATE TABLE IF NOT EXISTS users (
-			id serial PRIMARY KEY
+			id serial PRIMARY KEY,
+			last_active_at timestamptz NOT NULL DEFAULT now()
 		)
 	`
 	const createUrlsTableSQL = `
@@ -79,10 +123,21 @@ func initTables(db *sql.DB) error {
 			original_url varchar,
 			user_id integer,
 			is_deleted boolean DEFAULT false,
+			created_at timestamptz NOT NULL DEFAULT now(),
+			deleted_at timestamptz,
 			UNIQUE(original_url),
 			FOREIGN KEY (user_id) REFERENCES users (id)
 	   )
 	`
+	// purged_urls keeps ids of URLs removed after the retention period so that they stay known as deleted.
+	const createPurgedUrlsTableSQL = `
+		CREATE TABLE IF NOT EXISTS purged_urls (
+			id bigint PRIMARY KEY,
+			user_id integer,
+			deleted_at timestamptz NOT NULL,
+			purged_at timestamptz NOT NULL DEFAULT now()
+		)
+	`
 	_, err := db.Exec(createUsersTableSQL)
 	if err != nil {
 		return err
@@ -91,10 +146,30 @@ func initTables(db *sql.DB) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	_, err = db.Exec("ALTER TABLE urls ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now()")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_at timestamptz")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(createPurgedUrlsTableSQL)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE users ADD COLUMN IF NOT EXISTS last_active_at timestamptz NOT NULL DEFAULT now()")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS urls_user_id_idx ON urls (user_id)")
+	if err != nil {
+		return err
+	}
+	return createOutboxTable(db)
 }
 
-// AddUser inserts new user and returns its id.
+// AddUser allocates the id for a new user. The user is inserted with its first URL.
 func (d DBStorage) AddUser(ctx context.Context) (int, error) {
 	row := d.insertUser.QueryRowContext(ctx)
 	var id int
@@ -105,38 +180,78 @@ func (d DBStorage) AddUser(ctx context.Context) (int, error) {
 	return id, nil
 }
 
-// AddURL inserts new URL and returns its id or ErrAlreadyExists if this URL already exists.
+// ReserveUser moves the users id sequence forward to userID. The user is inserted with its first URL.
+func (d DBStorage) ReserveUser(ctx context.Context, userID int) error {
+	_, err := d.DB.ExecContext(ctx, "SELECT setval('users_id_seq', GREATEST($1, (SELECT last_value FROM users_id_seq)))", userID)
+	return err
+}
+
+// AddURL inserts the user if it does not exist yet and new URL in one transaction with its outbox event.
+// Returns id of URL or ErrAlreadyExists if this URL already exists.
 func (d DBStorage) AddURL(ctx context.Context, url string, userID int) (int, error) {
-	row := d.insertURL.QueryRowContext(ctx, url, userID)
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	saveUserStmt := tx.StmtContext(ctx, d.saveUser)
+	defer saveUserStmt.Close()
+	_, err = saveUserStmt.ExecContext(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	insertURLStmt := tx.StmtContext(ctx, d.insertURL)
+	defer insertURLStmt.Close()
 	var id int
-	err := row.Scan(&id)
+	err = insertURLStmt.QueryRowContext(ctx, url, userID).Scan(&id)
 	if errors.Is(err, sql.ErrNoRows) {
 		return 0, ErrAlreadyExists
 	} else if err != nil {
 		return 0, err
 	}
-	return id, nil
+	if d.Outbox {
+		_, err = tx.ExecContext(ctx, insertOutboxSQL, internal.EventLinkCreated, id, userID, url)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
 }
 
-// GetURLID returns url id by its url string.
+// GetURLID returns url id by its url string or ErrNotFound if the URL is not saved.
 func (d DBStorage) GetURLID(ctx context.Context, url string) (int, error) {
 	row := d.selectURLID.QueryRowContext(ctx, url)
 	var id int
 	err := row.Scan(&id)
-	if err != nil {
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	} else if err != nil {
 		return 0, err
 	}
 	return id, nil
 }
 
-// GetURL returns URL by its id. Returns ErrNotFound if there is no such id or ErrDeleted if id is marked as deleted.
-func (d DBStorage) GetURL(ctx context.Context, id string) (string, error) {
+// GetURL returns URL by its id. Returns ErrNotFound if there is no such id or ErrDeleted if id is marked as deleted
+// or purged.
+func (d DBStorage) GetURL(ctx context.Context, idStr string) (string, error) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return "", ErrNotFound
+	}
 	row := d.selectURLByID.QueryRowContext(ctx, id)
 	var originalURL string
 	var isDeleted bool
-	err := row.Scan(&originalURL, &isDeleted)
+	err = row.Scan(&originalURL, &isDeleted)
 	if errors.Is(err, sql.ErrNoRows) {
-		return "", ErrNotFound
+		var ownerID int
+		var deletedAt time.Time
+		err = d.selectPurgedURL.QueryRowContext(ctx, id).Scan(&ownerID, &deletedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNotFound
+		} else if err != nil {
+			return "", err
+		}
+		return "", ErrDeleted
 	} else if err != nil {
 		return "", err
 	}
@@ -146,6 +261,16 @@ func (d DBStorage) GetURL(ctx context.Context, id string) (string, error) {
 	return originalURL, nil
 }
 
+// GetURLOwner returns the id of the user who saved the URL with id.
+func (d DBStorage) GetURLOwner(ctx context.Context, id int) (int, error) {
+	var userID int
+	err := d.DB.QueryRowContext(ctx, "SELECT user_id FROM urls WHERE id = $1", id).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return userID, err
+}
+
 // GetUserUrls returns a map with ids and their original URLs for all URLs for the user.
 func (d DBStorage) GetUserUrls(ctx context.Context, userID int) (map[int]string, error) {
 	rows, err := d.selectUrlsByUser.QueryContext(ctx, userID)
@@ -173,68 +298,516 @@ func (d DBStorage) GetUserUrls(ctx context.Context, userID int) (map[int]string,
 	return userUrls, nil
 }
 
-// AddBatch inserts the list of URLs in one transaction.
+// AddBatch inserts the list of URLs in one transaction using COPY into a temporary table.
+// Returns a result for every URL: the id of the inserted URL, the id of the existing URL or the error.
 func (d DBStorage) AddBatch(ctx context.Context, urls []internal.CorrIDOriginalURL, userID int) ([]internal.CorrIDUrlID, error) {
-	tx, err := d.DB.Begin()
+	res := make([]internal.CorrIDUrlID, len(urls))
+	valid := make([]int, 0, len(urls))
+	for i, v := range urls {
+		res[i].CorrID = v.CorrID
+		if v.OriginalURL == "" {
+			res[i].Err = ErrEmptyURL
+			continue
+		}
+		valid = append(valid, i)
+	}
+	if len(valid) == 0 {
+		return res, nil
+	}
+
+	conn, err := d.DB.Conn(ctx)
 	if err != nil {
-		log.Println("Begin transaction error", err)
 		return nil, err
 	}
-	txStmt := tx.StmtContext(ctx, d.insertURL)
-	defer txStmt.Close()
-	var corrURLIDs []internal.CorrIDUrlID
-	for _, v := range urls {
-		row := txStmt.QueryRowContext(ctx, v.OriginalURL, userID)
-		corrURLID := internal.CorrIDUrlID{CorrID: v.CorrID}
-		err = row.Scan(&corrURLID.URLID)
-		if err == nil {
-			corrURLIDs = append(corrURLIDs, corrURLID)
+	defer conn.Close()
+	err = conn.Raw(func(driverConn any) error {
+		pgxConn := driverConn.(*stdlib.Conn).Conn()
+		return copyBatch(ctx, pgxConn, urls, valid, userID, res, d.Outbox)
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Batch insert error", logging.Err(err))
+		return nil, err
+	}
+	return res, nil
+}
+
+// copyBatch copies URLs with indexes from valid into the temporary table and inserts the new ones into urls
+// with their outbox events if outbox is set. Fills res with ids of inserted and existing URLs.
+func copyBatch(ctx context.Context, conn *pgx.Conn, urls []internal.CorrIDOriginalURL, valid []int, userID int,
+	res []internal.CorrIDUrlID, outbox bool) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, saveUserSQL, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "CREATE TEMP TABLE batch_urls (ord integer, original_url varchar) ON COMMIT DROP")
+	if err != nil {
+		return err
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"batch_urls"}, []string{"ord", "original_url"},
+		pgx.CopyFromSlice(len(valid), func(i int) ([]any, error) {
+			return []any{valid[i], urls[valid[i]].OriginalURL}, nil
+		}))
+	if err != nil {
+		return err
+	}
+
+	// The select does not see rows inserted by the CTE, so new URLs are found in inserted and existing ones in urls.
+	rows, err := tx.Query(ctx, `
+		WITH inserted AS (
+			INSERT INTO urls (original_url, user_id)
+			SELECT original_url, $1 FROM batch_urls GROUP BY original_url ORDER BY MIN(ord)
+			ON CONFLICT (original_url) DO NOTHING
+			RETURNING id, original_url
+		), outboxed AS (
+			INSERT INTO outbox (type, url_id, user_id, original_url)
+			SELECT $2, id, $1, original_url FROM inserted WHERE $3::boolean ORDER BY id
+		)
+		SELECT b.ord, i.id, u.id
+		FROM batch_urls b
+		LEFT JOIN inserted i ON i.original_url = b.original_url
+		LEFT JOIN urls u ON u.original_url = b.original_url
+		ORDER BY b.ord`, userID, internal.EventLinkCreated, outbox)
+	if err != nil {
+		return err
+	}
+	found := make(map[int]bool, len(valid))
+	created := make(map[int]bool, len(valid))
+	var concurrent []int
+	for rows.Next() {
+		var ord int
+		var insertedID, existingID *int
+		err = rows.Scan(&ord, &insertedID, &existingID)
+		if err != nil {
+			rows.Close()
+			return err
 		}
+		found[ord] = true
+		switch {
+		case insertedID != nil && !created[*insertedID]:
+			res[ord].URLID = *insertedID
+			created[*insertedID] = true
+		case insertedID != nil:
+			// The same URL occurs in the batch several times.
+			res[ord].URLID = *insertedID
+			res[ord].Existed = true
+		case existingID != nil:
+			res[ord].URLID = *existingID
+			res[ord].Existed = true
+		default:
+			// The URL was inserted by a concurrent transaction after the snapshot of the query.
+			concurrent = append(concurrent, ord)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, ord := range concurrent {
+		err = tx.QueryRow(ctx, "SELECT id FROM urls WHERE original_url = $1", urls[ord].OriginalURL).Scan(&res[ord].URLID)
+		if err != nil {
+			res[ord].Err = err
+			continue
+		}
+		res[ord].Existed = true
+	}
+	for _, ord := range valid {
+		if !found[ord] {
+			res[ord].Err = errors.New("URL was not saved")
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// DeleteBatch marks URLs by ids from the list as deleted in one transaction. Returns the result for every id.
+func (d DBStorage) DeleteBatch(ctx context.Context, ids []internal.IDToDelete) ([]internal.DeleteResult, error) {
+	return d.changeBatch(ctx, ids, d.deleteURL, func(v internal.IDToDelete, ownerID int, isDeleted, _ bool, _ time.Time) string {
+		return deleteStatus(true, ownerID, v.UserID, isDeleted)
+	}, internal.DeleteDeleted, internal.EventLinkDeleted)
+}
+
+// RestoreBatch unmarks URLs by ids from the list deleted after deletedAfter in one transaction.
+// Returns the result for every id.
+func (d DBStorage) RestoreBatch(ctx context.Context, ids []internal.IDToDelete, deletedAfter time.Time) ([]internal.DeleteResult, error) {
+	return d.changeBatch(ctx, ids, d.restoreURL, func(v internal.IDToDelete, ownerID int, isDeleted, purged bool, deletedAt time.Time) string {
+		return restoreStatus(true, ownerID, v.UserID, isDeleted, purged, deletedAt, deletedAfter)
+	}, internal.DeleteRestored, internal.EventLinkRestored)
+}
+
+// changeBatch locks URLs by ids one by one, gets the status of every URL by statusFn and executes update
+// for URLs with status changedStatus. Purged URLs are passed to statusFn as deleted ones and are never updated.
+// Saves the outbox event of eventType for every changed URL if Outbox is set and notifies other instances
+// about changed URLs.
+func (d DBStorage) changeBatch(ctx context.Context, ids []internal.IDToDelete, update *sql.Stmt,
+	statusFn func(v internal.IDToDelete, ownerID int, isDeleted, purged bool, deletedAt time.Time) string,
+	changedStatus string, eventType string) ([]internal.DeleteResult, error) {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "Begin transaction error", logging.Err(err))
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	selectStmt := tx.StmtContext(ctx, d.selectURLState)
+	defer selectStmt.Close()
+	updateStmt := tx.StmtContext(ctx, update)
+	defer updateStmt.Close()
+	purgedStmt := tx.StmtContext(ctx, d.selectPurgedURL)
+	defer purgedStmt.Close()
+	res := make([]internal.DeleteResult, len(ids))
+	changed := make([]int, 0, len(ids))
+	for i, v := range ids {
+		res[i].ID = v.ID
+		var ownerID int
+		var isDeleted bool
+		var deletedAt time.Time
+		err = selectStmt.QueryRowContext(ctx, v.ID).Scan(&ownerID, &isDeleted, &deletedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = purgedStmt.QueryRowContext(ctx, v.ID).Scan(&ownerID, &deletedAt)
+			if errors.Is(err, sql.ErrNoRows) {
+				res[i].Status = internal.DeleteNotFound
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			res[i].Status = statusFn(v, ownerID, true, true, deletedAt)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		res[i].Status = statusFn(v, ownerID, isDeleted, false, deletedAt)
+		if res[i].Status != changedStatus {
+			continue
+		}
+		_, err = updateStmt.ExecContext(ctx, v.ID)
+		if err != nil {
+			return nil, err
+		}
+		if d.Outbox {
+			_, err = tx.ExecContext(ctx, insertOutboxSQL, eventType, v.ID, ownerID, nil)
+			if err != nil {
+				return nil, err
+			}
+		}
+		changed = append(changed, v.ID)
+	}
+	err = notifyURLChanges(ctx, tx, changed)
+	if err != nil {
+		return nil, err
 	}
 	err = tx.Commit()
 	if err != nil {
-		log.Println("Commit error", err)
+		slog.ErrorContext(ctx, "Commit error", logging.Err(err))
 		return nil, err
 	}
-	return corrURLIDs, nil
+	return res, nil
+}
+
+// PurgeDeleted moves URLs deleted before deletedBefore from urls into purged_urls by batches of purgeBatchSize.
+// Removing the rows frees their original URLs for reuse. Returns purged URLs in ascending order of id.
+func (d DBStorage) PurgeDeleted(ctx context.Context, deletedBefore time.Time) ([]internal.URLRecord, error) {
+	var purged []internal.URLRecord
+	for {
+		n, err := d.purgeBatch(ctx, deletedBefore, &purged)
+		if err != nil {
+			return purged, err
+		}
+		if n < purgeBatchSize {
+			return purged, nil
+		}
+	}
 }
 
-// DeleteBatch marks URLs by ids from the list as deleted in one transaction.
-func (d DBStorage) DeleteBatch(ctx context.Context, ids []internal.IDToDelete) error {
-	tx, err := d.DB.Begin()
+// purgeBatch purges up to purgeBatchSize URLs appending them to purged. Returns the number of purged URLs.
+func (d DBStorage) purgeBatch(ctx context.Context, deletedBefore time.Time, purged *[]internal.URLRecord) (int, error) {
+	rows, err := d.DB.QueryContext(ctx, `
+		WITH purged AS (
+			DELETE FROM urls WHERE id IN (
+				SELECT id FROM urls WHERE is_deleted AND COALESCE(deleted_at, 'epoch') < $1
+				ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, user_id, original_url, created_at, COALESCE(deleted_at, 'epoch') AS deleted_at
+		), saved AS (
+			INSERT INTO purged_urls (id, user_id, deleted_at)
+			SELECT id, user_id, deleted_at FROM purged
+			ON CONFLICT DO NOTHING
+		), outboxed AS (
+			INSERT INTO outbox (type, url_id, user_id)
+			SELECT $3, id, COALESCE(user_id, 0) FROM purged WHERE $4
+		)
+		SELECT id, COALESCE(user_id, 0), COALESCE(original_url, ''), created_at, deleted_at FROM purged ORDER BY id`,
+		deletedBefore, purgeBatchSize, internal.EventLinkPurged, d.Outbox)
 	if err != nil {
-		log.Println("Begin transaction error", err)
-		return err
+		return 0, err
+	}
+	defer rows.Close()
+
+	var n int
+	for rows.Next() {
+		rec := internal.URLRecord{IsDeleted: true}
+		err = rows.Scan(&rec.ID, &rec.UserID, &rec.OriginalURL, &rec.CreatedAt, &rec.DeletedAt)
+		if err != nil {
+			return n, err
+		}
+		*purged = append(*purged, rec)
+		n++
+	}
+	return n, rows.Err()
+}
+
+// GetUserRecords returns all URLs of the user including deleted ones in ascending order of id.
+func (d DBStorage) GetUserRecords(ctx context.Context, userID int) ([]internal.URLRecord, error) {
+	rows, err := d.DB.QueryContext(ctx,
+		`SELECT id, original_url, is_deleted, created_at, COALESCE(deleted_at, 'epoch')
+		FROM urls WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []internal.URLRecord
+	for rows.Next() {
+		rec := internal.URLRecord{UserID: userID}
+		err = rows.Scan(&rec.ID, &rec.OriginalURL, &rec.IsDeleted, &rec.CreatedAt, &rec.DeletedAt)
+		if err != nil {
+			return nil, err
+		}
+		if !rec.IsDeleted {
+			rec.DeletedAt = time.Time{}
+		}
+		res = append(res, rec)
+	}
+	return res, rows.Err()
+}
+
+// EraseUser moves all URLs of the user into purged_urls without the owner, anonymises URLs of the user purged before
+// and deletes the user in one transaction. Notifies other instances about erased URLs.
+func (d DBStorage) EraseUser(ctx context.Context, userID int) ([]int, error) {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
 	}
 	defer tx.Rollback()
 
-	txStmt := tx.StmtContext(ctx, d.deleteURL)
-	defer txStmt.Close()
-	for _, v := range ids {
-		_, err = txStmt.ExecContext(ctx, v.ID, v.UserID)
+	rows, err := tx.QueryContext(ctx, `
+		WITH erased AS (
+			DELETE FROM urls WHERE user_id = $1
+			RETURNING id, COALESCE(deleted_at, now()) AS deleted_at
+		)
+		INSERT INTO purged_urls (id, deleted_at)
+		SELECT id, deleted_at FROM erased
+		ON CONFLICT DO NOTHING
+		RETURNING id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	var erased []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		erased = append(erased, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if d.Outbox {
+		// pending events of the user carry its original URLs
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM outbox WHERE user_id = $1 AND (claimed_until IS NULL OR claimed_until <= now())`, userID)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO outbox (type, url_id, user_id) SELECT $1, id, $2 FROM unnest($3::bigint[]) AS id`,
+			internal.EventLinkErased, userID, erased)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE purged_urls SET user_id = NULL WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	err = notifyURLChanges(ctx, tx, erased)
+	if err != nil {
+		return nil, err
+	}
+	return erased, tx.Commit()
+}
+
+// Export calls fn for every URL in ascending order of id.
+func (d DBStorage) Export(ctx context.Context, fn func(url internal.URLRecord) error) error {
+	rows, err := d.DB.QueryContext(ctx,
+		`SELECT id, COALESCE(user_id, 0), original_url, is_deleted, created_at, COALESCE(deleted_at, 'epoch')
+		FROM urls ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec internal.URLRecord
+		err = rows.Scan(&rec.ID, &rec.UserID, &rec.OriginalURL, &rec.IsDeleted, &rec.CreatedAt, &rec.DeletedAt)
 		if err != nil {
 			return err
 		}
+		if !rec.IsDeleted {
+			rec.DeletedAt = time.Time{}
+		}
+		err = fn(rec)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Import inserts the list of URLs keeping their ids in one transaction.
+// Creates missing users and moves id sequences forward so that new ids do not collide with imported ones.
+func (d DBStorage) Import(ctx context.Context, urls []internal.URLRecord) (int, error) {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	userStmt, err := tx.PrepareContext(ctx, "INSERT INTO users (id) VALUES ($1) ON CONFLICT DO NOTHING")
+	if err != nil {
+		return 0, err
+	}
+	defer userStmt.Close()
+	// Ids of purged URLs are not reused.
+	urlStmt, err := tx.PrepareContext(ctx, `INSERT INTO urls (id, original_url, user_id, is_deleted, created_at, deleted_at)
+		SELECT $1::bigint, $2::varchar, $3::integer, $4::boolean, $5::timestamptz, $6::timestamptz
+		WHERE NOT EXISTS (SELECT 1 FROM purged_urls WHERE id = $1)
+		ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return 0, err
+	}
+	defer urlStmt.Close()
+
+	users := make(map[int]bool)
+	var imported int
+	changed := make([]int, 0, len(urls))
+	for _, v := range urls {
+		if v.ID < 0 || v.OriginalURL == "" {
+			continue
+		}
+		if !users[v.UserID] {
+			_, err = userStmt.ExecContext(ctx, v.UserID)
+			if err != nil {
+				return 0, err
+			}
+			users[v.UserID] = true
+		}
+		createdAt := v.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now()
+		}
+		var deletedAt sql.NullTime
+		if v.IsDeleted {
+			deletedAt = sql.NullTime{Time: v.DeletedAt, Valid: true}
+			if v.DeletedAt.IsZero() {
+				deletedAt.Time = time.Now()
+			}
+		}
+		var res sql.Result
+		res, err = urlStmt.ExecContext(ctx, v.ID, v.OriginalURL, v.UserID, v.IsDeleted, createdAt, deletedAt)
+		if err != nil {
+			return 0, err
+		}
+		var n int64
+		n, err = res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if n > 0 {
+			imported++
+			changed = append(changed, v.ID)
+		}
+	}
+	err = notifyURLChanges(ctx, tx, changed)
+	if err != nil {
+		return 0, err
+	}
+
+	// Ids allocated for users without URLs are not saved, so the sequence never moves back.
+	_, err = tx.ExecContext(ctx, `SELECT setval('users_id_seq',
+		GREATEST((SELECT MAX(id) FROM users), (SELECT last_value FROM users_id_seq)))`)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `SELECT setval(pg_get_serial_sequence('urls', 'id'),
+		GREATEST((SELECT MAX(id) FROM urls), (SELECT MAX(id) FROM purged_urls)))`)
+	if err != nil {
+		return 0, err
 	}
 	err = tx.Commit()
 	if err != nil {
-		log.Println("Commit error", err)
-		return err
+		return 0, err
+	}
+	return imported, nil
+}
+
+// DeleteInactiveUsers deletes users without URLs whose last activity was before inactiveSince
+// by batches of purgeBatchSize. Returns the number of deleted users.
+func (d DBStorage) DeleteInactiveUsers(ctx context.Context, inactiveSince time.Time) (int, error) {
+	var deleted int
+	for {
+		res, err := d.DB.ExecContext(ctx, `
+			DELETE FROM users WHERE id IN (
+				SELECT u.id FROM users u
+				WHERE u.last_active_at < $1 AND NOT EXISTS (SELECT 1 FROM urls WHERE urls.user_id = u.id)
+				ORDER BY u.id LIMIT $2 FOR UPDATE SKIP LOCKED
+			)`, inactiveSince, purgeBatchSize)
+		if err != nil {
+			return deleted, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += int(n)
+		if n < purgeBatchSize {
+			return deleted, nil
+		}
 	}
-	return nil
 }
 
 // Close closes prepared statements and sql connection.
 func (d DBStorage) Close() {
 	d.insertUser.Close()
+	d.saveUser.Close()
 	d.insertURL.Close()
 	d.selectURLByID.Close()
 	d.selectUrlsByUser.Close()
 	d.selectURLID.Close()
+	d.selectURLState.Close()
 	d.deleteURL.Close()
+	d.restoreURL.Close()
+	d.selectPurgedURL.Close()
 	d.DB.Close()
 }
 
+// RegisterChecks registers the check of the sql connection.
+func (d DBStorage) RegisterChecks(r health.Registry) {
+	r.Register(CheckName, d.Ping)
+}
+
 // Ping check the sql connection.
 func (d DBStorage) Ping(c context.Context) error {
 	ctx, cancel := context.WithTimeout(c, 1*time.Second)
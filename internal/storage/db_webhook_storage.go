@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"time"
+)
+
+var _ WebhookStorage = (*DBWebhookStorage)(nil)
+
+// pruneBatchSize is the max number of deliveries deleted by one statement of PruneDeliveries.
+const pruneBatchSize = 1000
+
+// DBWebhookStorage keeps webhooks in table webhooks and deliveries in table webhook_deliveries.
+// Events and lists of event types are kept as jsonb.
+type DBWebhookStorage struct {
+	DB *sql.DB
+}
+
+const selectDeliverySQL = `
+	SELECT id, webhook_id, event, status, attempts, status_code, error, next_attempt_at, created_at, updated_at
+	FROM webhook_deliveries`
+
+// NewDBWebhookStorage opens sql connection, creates tables and returns *DBWebhookStorage.
+func NewDBWebhookStorage(dsn string) (*DBWebhookStorage, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id varchar PRIMARY KEY,
+			user_id integer NOT NULL,
+			url varchar NOT NULL,
+			events jsonb NOT NULL,
+			secret varchar NOT NULL,
+			created_at timestamptz NOT NULL
+		)
+	`)
+	if err == nil {
+		_, err = db.Exec("CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id)")
+	}
+	if err == nil {
+		_, err = db.Exec(`
+			CREATE TABLE IF NOT EXISTS webhook_deliveries (
+				id varchar PRIMARY KEY,
+				webhook_id varchar NOT NULL,
+				event jsonb NOT NULL,
+				status varchar NOT NULL,
+				attempts integer NOT NULL,
+				status_code integer NOT NULL,
+				error varchar NOT NULL,
+				next_attempt_at timestamptz NOT NULL,
+				created_at timestamptz NOT NULL,
+				updated_at timestamptz NOT NULL
+			)
+		`)
+	}
+	if err == nil {
+		_, err = db.Exec(`
+			CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at)
+			WHERE status IN ('pending', 'failed')`)
+	}
+	if err == nil {
+		_, err = db.Exec(
+			"CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, created_at)")
+	}
+	if err == nil {
+		_, err = db.Exec(`
+			CREATE INDEX IF NOT EXISTS webhook_deliveries_done_idx ON webhook_deliveries (updated_at)
+			WHERE status IN ('delivered', 'dead')`)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DBWebhookStorage{DB: db}, nil
+}
+
+// AddWebhook inserts the new webhook.
+func (d DBWebhookStorage) AddWebhook(ctx context.Context, webhook internal.Webhook) error {
+	events, err := json.Marshal(webhook.Events)
+	if err != nil {
+		return err
+	}
+	_, err = d.DB.ExecContext(ctx, `
+		INSERT INTO webhooks (id, user_id, url, events, secret, created_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		webhook.ID, webhook.UserID, webhook.URL, string(events), webhook.Secret, webhook.CreatedAt)
+	return err
+}
+
+// GetWebhook returns the webhook by its id or ErrNotFound.
+func (d DBWebhookStorage) GetWebhook(ctx context.Context, id string) (internal.Webhook, error) {
+	rows, err := d.DB.QueryContext(ctx,
+		"SELECT id, user_id, url, events, secret, created_at FROM webhooks WHERE id = $1", id)
+	if err != nil {
+		return internal.Webhook{}, err
+	}
+	webhooks, err := scanWebhooks(rows)
+	if err != nil {
+		return internal.Webhook{}, err
+	}
+	if len(webhooks) == 0 {
+		return internal.Webhook{}, ErrNotFound
+	}
+	return webhooks[0], nil
+}
+
+// GetUserWebhooks returns all webhooks of the user in ascending order of creation.
+func (d DBWebhookStorage) GetUserWebhooks(ctx context.Context, userID int) ([]internal.Webhook, error) {
+	rows, err := d.DB.QueryContext(ctx, `
+		SELECT id, user_id, url, events, secret, created_at FROM webhooks WHERE user_id = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhooks(rows)
+}
+
+func scanWebhooks(rows *sql.Rows) ([]internal.Webhook, error) {
+	defer rows.Close()
+	var res []internal.Webhook
+	for rows.Next() {
+		var webhook internal.Webhook
+		var events []byte
+		err := rows.Scan(&webhook.ID, &webhook.UserID, &webhook.URL, &events, &webhook.Secret, &webhook.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(events, &webhook.Events)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, webhook)
+	}
+	return res, rows.Err()
+}
+
+// DeleteWebhook deletes the webhook by its id or returns ErrNotFound.
+func (d DBWebhookStorage) DeleteWebhook(ctx context.Context, id string) error {
+	res, err := d.DB.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AddDeliveries inserts new deliveries in one transaction.
+func (d DBWebhookStorage) AddDeliveries(ctx context.Context, deliveries []internal.WebhookDelivery) error {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, v := range deliveries {
+		err = saveDelivery(ctx, tx, v)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveDelivery updates the state of the delivery. Deliveries which were deleted meanwhile are not inserted again.
+func (d DBWebhookStorage) SaveDelivery(ctx context.Context, delivery internal.WebhookDelivery) error {
+	_, err := d.DB.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, status_code = $4, error = $5, next_attempt_at = $6, updated_at = $7
+		WHERE id = $1`,
+		delivery.ID, delivery.Status, delivery.Attempts, delivery.StatusCode, delivery.Error, delivery.NextAttemptAt,
+		delivery.UpdatedAt)
+	return err
+}
+
+func saveDelivery(ctx context.Context, db execer, v internal.WebhookDelivery) error {
+	event, err := json.Marshal(v.Event)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries
+			(id, webhook_id, event, status, attempts, status_code, error, next_attempt_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO UPDATE
+		SET status = $4, attempts = $5, status_code = $6, error = $7, next_attempt_at = $8, updated_at = $10`,
+		v.ID, v.WebhookID, string(event), v.Status, v.Attempts, v.StatusCode, v.Error, v.NextAttemptAt, v.CreatedAt,
+		v.UpdatedAt)
+	return err
+}
+
+// GetDelivery returns the delivery by its id or ErrNotFound.
+func (d DBWebhookStorage) GetDelivery(ctx context.Context, id string) (internal.WebhookDelivery, error) {
+	rows, err := d.DB.QueryContext(ctx, selectDeliverySQL+" WHERE id = $1", id)
+	if err != nil {
+		return internal.WebhookDelivery{}, err
+	}
+	deliveries, err := scanDeliveries(rows)
+	if err != nil {
+		return internal.WebhookDelivery{}, err
+	}
+	if len(deliveries) == 0 {
+		return internal.WebhookDelivery{}, ErrNotFound
+	}
+	return deliveries[0], nil
+}
+
+// GetDeliveries returns up to limit deliveries of the webhook in descending order of creation.
+func (d DBWebhookStorage) GetDeliveries(ctx context.Context, webhookID string, status string, limit int) ([]internal.WebhookDelivery, error) {
+	rows, err := d.DB.QueryContext(ctx, selectDeliverySQL+`
+		WHERE webhook_id = $1 AND ($2 = '' OR status = $2) ORDER BY created_at DESC LIMIT $3`, webhookID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+// ClaimDeliveries locks up to limit due deliveries skipping ones locked by other instances and moves their next attempt
+// by lease.
+func (d DBWebhookStorage) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]internal.WebhookDelivery, error) {
+	rows, err := d.DB.QueryContext(ctx, `
+		UPDATE webhook_deliveries SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status IN ('pending', 'failed') AND next_attempt_at <= $1
+			ORDER BY next_attempt_at LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, webhook_id, event, status, attempts, status_code, error, next_attempt_at, created_at, updated_at`,
+		now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+// PruneDeliveries deletes delivered and dead deliveries which were last updated before updatedBefore
+// by batches of pruneBatchSize, so that pruning does not hold long locks.
+func (d DBWebhookStorage) PruneDeliveries(ctx context.Context, updatedBefore time.Time) (int, error) {
+	var pruned int
+	for {
+		res, err := d.DB.ExecContext(ctx, `
+			DELETE FROM webhook_deliveries WHERE id IN (
+				SELECT id FROM webhook_deliveries
+				WHERE status IN ('delivered', 'dead') AND updated_at < $1
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)`, updatedBefore, pruneBatchSize)
+		if err != nil {
+			return pruned, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return pruned, err
+		}
+		pruned += int(n)
+		if n < pruneBatchSize {
+			return pruned, nil
+		}
+	}
+}
+
+func scanDeliveries(rows *sql.Rows) ([]internal.WebhookDelivery, error) {
+	defer rows.Close()
+	var res []internal.WebhookDelivery
+	for rows.Next() {
+		var v internal.WebhookDelivery
+		var event []byte
+		err := rows.Scan(&v.ID, &v.WebhookID, &event, &v.Status, &v.Attempts, &v.StatusCode, &v.Error, &v.NextAttemptAt,
+			&v.CreatedAt, &v.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(event, &v.Event)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// EraseUser deletes webhooks of the user with all their deliveries in one transaction.
+func (d DBWebhookStorage) EraseUser(ctx context.Context, userID int) error {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = $1)`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM webhooks WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close closes sql connection.
+func (d DBWebhookStorage) Close() {
+	d.DB.Close()
+}
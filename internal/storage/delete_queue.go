@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned when the queue has no room for new items.
+var ErrQueueFull = errors.New("queue is full")
+
+// DeleteQueue keeps URL ids queued for deletion until they are deleted.
+type DeleteQueue interface {
+	// Push adds ids to the queue. Returns ErrQueueFull if the queue has no room for all of them.
+	Push(ctx context.Context, ids []internal.IDToDelete) error
+	// Process takes up to limit ids which are ready and not taken by other consumers and calls fn with them
+	// and the number of their failed attempts.
+	// If fn succeeds the ids are removed from the queue, otherwise they become ready again after retryDelay(attempts).
+	// Ids taken by a consumer which crashed are processed again. Returns the number of taken ids and the error of fn.
+	Process(ctx context.Context, limit int, fn func(ctx context.Context, ids []internal.IDToDelete) error,
+		retryDelay func(attempts int) time.Duration) (int, error)
+	// Len returns the number of queued ids.
+	Len(ctx context.Context) (int, error)
+	// Close closes resources.
+	Close()
+}
+
+var _ DeleteQueue = (*MemoryDeleteQueue)(nil)
+
+// MemoryDeleteQueue keeps up to maxSize ids queued for deletion in memory.
+type MemoryDeleteQueue struct {
+	maxSize int
+	tasks   []*deleteTask
+	nextSeq int64
+	mutex   sync.Mutex
+	now     func() time.Time
+}
+
+type deleteTask struct {
+	seq         int64
+	id          internal.IDToDelete
+	attempts    int
+	availableAt time.Time
+	taken       bool
+}
+
+// NewMemoryDeleteQueue creates new *MemoryDeleteQueue.
+func NewMemoryDeleteQueue(maxSize int) *MemoryDeleteQueue {
+	return &MemoryDeleteQueue{maxSize: maxSize, nextSeq: 1, now: time.Now}
+}
+
+// Push adds ids to the queue. Returns ErrQueueFull if the queue has no room for all of them.
+func (q *MemoryDeleteQueue) Push(_ context.Context, ids []internal.IDToDelete) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if !q.hasRoom(len(ids)) {
+		return ErrQueueFull
+	}
+	q.push(ids)
+	return nil
+}
+
+func (q *MemoryDeleteQueue) hasRoom(n int) bool {
+	return len(q.tasks)+n <= q.maxSize
+}
+
+func (q *MemoryDeleteQueue) push(ids []internal.IDToDelete) {
+	for _, v := range ids {
+		q.tasks = append(q.tasks, &deleteTask{seq: q.nextSeq, id: v})
+		q.nextSeq++
+	}
+}
+
+// Process takes up to limit ready ids and calls fn with them.
+// If fn succeeds the ids are removed from the queue, otherwise they become ready again after retryDelay(attempts).
+func (q *MemoryDeleteQueue) Process(ctx context.Context, limit int, fn func(ctx context.Context, ids []internal.IDToDelete) error,
+	retryDelay func(attempts int) time.Duration) (int, error) {
+	tasks := q.take(limit)
+	if len(tasks) == 0 {
+		return 0, nil
+	}
+	err := fn(ctx, taskIDs(tasks))
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if err != nil {
+		q.retry(tasks, retryDelay)
+	} else {
+		q.remove(tasks)
+	}
+	return len(tasks), err
+}
+
+// take marks up to limit ready tasks as taken and returns them.
+func (q *MemoryDeleteQueue) take(limit int) []*deleteTask {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	now := q.now()
+	var tasks []*deleteTask
+	for _, t := range q.tasks {
+		if len(tasks) == limit {
+			break
+		}
+		if !t.taken && !t.availableAt.After(now) {
+			t.taken = true
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks
+}
+
+func (q *MemoryDeleteQueue) retry(tasks []*deleteTask, retryDelay func(attempts int) time.Duration) {
+	now := q.now()
+	for _, t := range tasks {
+		t.attempts++
+		t.availableAt = now.Add(retryDelay(t.attempts))
+		t.taken = false
+	}
+}
+
+func (q *MemoryDeleteQueue) remove(tasks []*deleteTask) {
+	done := make(map[int64]bool, len(tasks))
+	for _, t := range tasks {
+		done[t.seq] = true
+	}
+	rest := q.tasks[:0]
+	for _, t := range q.tasks {
+		if !done[t.seq] {
+			rest = append(rest, t)
+		}
+	}
+	for i := len(rest); i < len(q.tasks); i++ {
+		q.tasks[i] = nil
+	}
+	q.tasks = rest
+}
+
+func taskIDs(tasks []*deleteTask) []internal.IDToDelete {
+	ids := make([]internal.IDToDelete, len(tasks))
+	for i, t := range tasks {
+		ids[i] = t.id
+		ids[i].Attempts = t.attempts
+	}
+	return ids
+}
+
+// Len returns the number of queued ids.
+func (q *MemoryDeleteQueue) Len(_ context.Context) (int, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.tasks), nil
+}
+
+// Close does nothing.
+func (q *MemoryDeleteQueue) Close() {
+}
+
+var _ DeleteQueue = (*FileDeleteQueue)(nil)
+
+// deleteQueueCompactAfter is the number of lines of processed ids after which the file of FileDeleteQueue
+// is rewritten with the queued ids only.
+const deleteQueueCompactAfter = 1000
+
+// FileDeleteQueue keeps ids queued for deletion in memory and writes every change into the write-ahead log file
+// before applying it. The file is replayed on start, truncated when the queue becomes empty and compacted after
+// deleteQueueCompactAfter lines of processed ids, so it does not grow under steady load.
+// Lines of the file are "p seq urlID userID opID" for pushed ids, "a seq" for deleted ids
+// and "r seq attempts availableAtUnixNano" for failed ids.
+type FileDeleteQueue struct {
+	*MemoryDeleteQueue
+	filename string
+	file     *os.File
+	// processed is the number of lines of processed ids written since the file was compacted.
+	processed int
+}
+
+// NewFileDeleteQueue creates FileDeleteQueue and fills memory from the file with name=filename.
+func NewFileDeleteQueue(filename string, maxSize int) (*FileDeleteQueue, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0777)
+	if err != nil {
+		return nil, err
+	}
+	q := &FileDeleteQueue{MemoryDeleteQueue: NewMemoryDeleteQueue(maxSize), filename: filename, file: file}
+	err = q.replay()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *FileDeleteQueue) replay() error {
+	var pushed []*deleteTask
+	bySeq := make(map[int64]*deleteTask)
+	scanner := bufio.NewScanner(q.file)
+	for scanner.Scan() {
+		d := strings.Fields(scanner.Text())
+		if len(d) < 2 {
+			continue
+		}
+		var opID string
+		if d[0] == "p" && len(d) == 5 {
+			opID = d[4]
+			d = d[:4]
+		}
+		nums := make([]int64, len(d)-1)
+		for i := range nums {
+			n, err := strconv.ParseInt(d[i+1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("wrong delete queue line %q: %w", scanner.Text(), err)
+			}
+			nums[i] = n
+		}
+		seq := nums[0]
+		switch {
+		case d[0] == "p" && len(nums) == 3:
+			t := &deleteTask{seq: seq, id: internal.IDToDelete{ID: int(nums[1]), UserID: int(nums[2]), OpID: opID}}
+			bySeq[seq] = t
+			pushed = append(pushed, t)
+			if seq >= q.nextSeq {
+				q.nextSeq = seq + 1
+			}
+		case d[0] == "a":
+			delete(bySeq, seq)
+			q.processed++
+		case d[0] == "r" && len(nums) == 3:
+			q.processed++
+			if t, ok := bySeq[seq]; ok {
+				t.attempts = int(nums[1])
+				t.availableAt = time.Unix(0, nums[2])
+			}
+		default:
+			return fmt.Errorf("wrong delete queue line %q", scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, t := range pushed {
+		if _, ok := bySeq[t.seq]; ok {
+			q.tasks = append(q.tasks, t)
+		}
+	}
+	if len(q.tasks) == 0 {
+		q.processed = 0
+		return q.file.Truncate(0)
+	}
+	return nil
+}
+
+// compact rewrites the file with lines of queued ids only.
+func (q *FileDeleteQueue) compact() error {
+	var err error
+	q.file, err = rewriteFile(q.filename, q.file, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		for _, t := range q.tasks {
+			fmt.Fprintf(bw, "p %d %d %d %s\n", t.seq, t.id.ID, t.id.UserID, t.id.OpID)
+			if t.attempts > 0 {
+				fmt.Fprintf(bw, "r %d %d %d\n", t.seq, t.attempts, t.availableAt.UnixNano())
+			}
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if f, ok := w.(*os.File); ok {
+			return f.Sync()
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error while compacting delete queue: %w", err)
+	}
+	q.processed = 0
+	return nil
+}
+
+// write appends lines to the file and syncs it.
+func (q *FileDeleteQueue) write(lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	_, err := q.file.WriteString(strings.Join(lines, "\n") + "\n")
+	if err != nil {
+		return fmt.Errorf("error while writing delete queue: %w", err)
+	}
+	return q.file.Sync()
+}
+
+// Push writes ids into the file and adds them to the queue. Returns ErrQueueFull if the queue has no room for all of them.
+func (q *FileDeleteQueue) Push(_ context.Context, ids []internal.IDToDelete) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if !q.hasRoom(len(ids)) {
+		return ErrQueueFull
+	}
+	lines := make([]string, len(ids))
+	for i, v := range ids {
+		lines[i] = fmt.Sprintf("p %d %d %d %s", q.nextSeq+int64(i), v.ID, v.UserID, v.OpID)
+	}
+	err := q.write(lines)
+	if err != nil {
+		return err
+	}
+	q.push(ids)
+	return nil
+}
+
+// Process takes up to limit ready ids and calls fn with them.
+// If fn succeeds the ids are removed from the queue, otherwise they become ready again after retryDelay(attempts).
+// The result is written into the file.
+func (q *FileDeleteQueue) Process(ctx context.Context, limit int, fn func(ctx context.Context, ids []internal.IDToDelete) error,
+	retryDelay func(attempts int) time.Duration) (int, error) {
+	tasks := q.take(limit)
+	if len(tasks) == 0 {
+		return 0, nil
+	}
+	err := fn(ctx, taskIDs(tasks))
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	lines := make([]string, len(tasks))
+	if err != nil {
+		q.retry(tasks, retryDelay)
+		for i, t := range tasks {
+			lines[i] = fmt.Sprintf("r %d %d %d", t.seq, t.attempts, t.availableAt.UnixNano())
+		}
+	} else {
+		q.remove(tasks)
+		for i, t := range tasks {
+			lines[i] = fmt.Sprintf("a %d", t.seq)
+		}
+	}
+	var writeErr error
+	switch {
+	case len(q.tasks) == 0:
+		writeErr = q.file.Truncate(0)
+		q.processed = 0
+	case q.processed+len(lines) >= deleteQueueCompactAfter:
+		// the state in memory already contains the result
+		writeErr = q.compact()
+	default:
+		writeErr = q.write(lines)
+		if writeErr == nil {
+			q.processed += len(lines)
+		}
+	}
+	if err == nil {
+		err = writeErr
+	}
+	return len(tasks), err
+}
+
+// Close closes the file.
+func (q *FileDeleteQueue) Close() {
+	q.file.Close()
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryDeleteQueue(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryDeleteQueue(3)
+	now := time.Now()
+	q.now = func() time.Time { return now }
+	retryDelay := func(attempts int) time.Duration { return time.Duration(attempts) * time.Minute }
+
+	require.NoError(t, q.Push(ctx, []internal.IDToDelete{{ID: 1, UserID: 1}, {ID: 2, UserID: 1}}))
+	assert.ErrorIs(t, q.Push(ctx, []internal.IDToDelete{{ID: 3, UserID: 1}, {ID: 4, UserID: 1}}), ErrQueueFull)
+	require.NoError(t, q.Push(ctx, []internal.IDToDelete{{ID: 3, UserID: 1}}))
+
+	// Failed ids are not ready until the retry delay passes.
+	failErr := errors.New("fail")
+	n, err := q.Process(ctx, 2, func(_ context.Context, ids []internal.IDToDelete) error {
+		assert.Equal(t, []internal.IDToDelete{{ID: 1, UserID: 1}, {ID: 2, UserID: 1}}, ids)
+		return failErr
+	}, retryDelay)
+	assert.Equal(t, 2, n)
+	assert.ErrorIs(t, err, failErr)
+
+	var processed []internal.IDToDelete
+	collect := func(_ context.Context, ids []internal.IDToDelete) error {
+		processed = append(processed, ids...)
+		return nil
+	}
+	n, err = q.Process(ctx, 2, collect, retryDelay)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []internal.IDToDelete{{ID: 3, UserID: 1}}, processed)
+	length, err := q.Len(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, length)
+
+	now = now.Add(time.Minute)
+	n, err = q.Process(ctx, 10, collect, retryDelay)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []internal.IDToDelete{{ID: 1, UserID: 1, Attempts: 1}, {ID: 2, UserID: 1, Attempts: 1}}, processed[1:])
+	length, err = q.Len(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, length)
+}
+
+func TestFileDeleteQueue(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "deletes")
+	q, err := NewFileDeleteQueue(filename, 10)
+	require.NoError(t, err)
+	retryDelay := func(attempts int) time.Duration { return time.Hour }
+
+	require.NoError(t, q.Push(ctx, []internal.IDToDelete{{ID: 1, UserID: 1}, {ID: 2, UserID: 2}, {ID: 3, UserID: 3}}))
+	_, err = q.Process(ctx, 1, func(_ context.Context, _ []internal.IDToDelete) error { return nil }, retryDelay)
+	require.NoError(t, err)
+	_, err = q.Process(ctx, 1, func(_ context.Context, _ []internal.IDToDelete) error { return errors.New("fail") }, retryDelay)
+	require.Error(t, err)
+	q.Close()
+
+	// Id 1 is deleted, id 2 waits for retry and id 3 is ready after restart.
+	q, err = NewFileDeleteQueue(filename, 10)
+	require.NoError(t, err)
+	length, err := q.Len(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, length)
+	var processed []internal.IDToDelete
+	n, err := q.Process(ctx, 10, func(_ context.Context, ids []internal.IDToDelete) error {
+		processed = append(processed, ids...)
+		return nil
+	}, retryDelay)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []internal.IDToDelete{{ID: 3, UserID: 3}}, processed)
+
+	// The file is truncated when the queue becomes empty.
+	q.now = func() time.Time { return time.Now().Add(time.Hour) }
+	n, err = q.Process(ctx, 10, func(_ context.Context, _ []internal.IDToDelete) error { return nil }, retryDelay)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	q.Close()
+	info, err := os.Stat(filename)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size())
+}
+
+func TestFileDeleteQueueCompaction(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "deletes")
+	q, err := NewFileDeleteQueue(filename, 10)
+	require.NoError(t, err)
+	retryDelay := func(attempts int) time.Duration { return time.Hour }
+
+	// The failed id stays in the queue, so it never becomes empty.
+	require.NoError(t, q.Push(ctx, []internal.IDToDelete{{ID: 1, UserID: 1, OpID: "op"}}))
+	_, err = q.Process(ctx, 1, func(_ context.Context, _ []internal.IDToDelete) error { return errors.New("fail") }, retryDelay)
+	require.Error(t, err)
+	for i := 2; i < 3*deleteQueueCompactAfter; i++ {
+		require.NoError(t, q.Push(ctx, []internal.IDToDelete{{ID: i, UserID: 2}}))
+		_, err = q.Process(ctx, 1, func(_ context.Context, _ []internal.IDToDelete) error { return nil }, retryDelay)
+		require.NoError(t, err)
+	}
+	q.Close()
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Less(t, strings.Count(string(data), "\n"), 2*deleteQueueCompactAfter+2)
+	q, err = NewFileDeleteQueue(filename, 10)
+	require.NoError(t, err)
+	defer q.Close()
+	length, err := q.Len(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, length)
+	n, err := q.Process(ctx, 10, func(_ context.Context, _ []internal.IDToDelete) error { return nil }, retryDelay)
+	require.NoError(t, err)
+	assert.Zero(t, n, "the failed id waits for retry after compaction")
+	q.now = func() time.Time { return time.Now().Add(time.Hour) }
+	var processed []internal.IDToDelete
+	_, err = q.Process(ctx, 10, func(_ context.Context, ids []internal.IDToDelete) error {
+		processed = append(processed, ids...)
+		return nil
+	}, retryDelay)
+	require.NoError(t, err)
+	assert.Equal(t, []internal.IDToDelete{{ID: 1, UserID: 1, OpID: "op", Attempts: 1}}, processed)
+}
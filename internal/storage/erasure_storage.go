@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"os"
+	"sync"
+)
+
+// ErasureStorage keeps audit records of erased users. Tokens of erased users are revoked.
+type ErasureStorage interface {
+	// AddErasure saves the audit record of the erasure of the user.
+	AddErasure(ctx context.Context, erasure internal.UserErasure) error
+	// IsErased reports whether the user was erased.
+	IsErased(ctx context.Context, userID int) (bool, error)
+	// LastErased returns the highest id of erased users or 0 if no user was erased.
+	LastErased(ctx context.Context) (int, error)
+	// Close closes resources.
+	Close()
+}
+
+var _ ErasureStorage = (*MemoryErasureStorage)(nil)
+
+// MemoryErasureStorage keeps records of erased users in memory.
+type MemoryErasureStorage struct {
+	erasures map[int]internal.UserErasure
+	mutex    sync.RWMutex
+}
+
+// NewMemoryErasureStorage creates new *MemoryErasureStorage.
+func NewMemoryErasureStorage() *MemoryErasureStorage {
+	return &MemoryErasureStorage{erasures: make(map[int]internal.UserErasure)}
+}
+
+// AddErasure saves the audit record of the erasure of the user.
+func (s *MemoryErasureStorage) AddErasure(_ context.Context, erasure internal.UserErasure) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.erasures[erasure.UserID] = erasure
+	return nil
+}
+
+// IsErased reports whether the user was erased.
+func (s *MemoryErasureStorage) IsErased(_ context.Context, userID int) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.erasures[userID]
+	return ok, nil
+}
+
+// LastErased returns the highest id of erased users or 0 if no user was erased.
+func (s *MemoryErasureStorage) LastErased(_ context.Context) (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var last int
+	for userID := range s.erasures {
+		if userID > last {
+			last = userID
+		}
+	}
+	return last, nil
+}
+
+// Close does nothing.
+func (s *MemoryErasureStorage) Close() {
+}
+
+var _ ErasureStorage = (*FileErasureStorage)(nil)
+
+// FileErasureStorage keeps records of erased users in memory and appends them into the file.
+// The file is replayed on start so that tokens stay revoked after restarts.
+type FileErasureStorage struct {
+	*MemoryErasureStorage
+	file      *os.File
+	fileMutex sync.Mutex
+}
+
+// NewFileErasureStorage creates FileErasureStorage and fills memory from the file with name=filename.
+func NewFileErasureStorage(filename string) (*FileErasureStorage, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0777)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileErasureStorage{MemoryErasureStorage: NewMemoryErasureStorage(), file: file}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var erasure internal.UserErasure
+		err = json.Unmarshal(scanner.Bytes(), &erasure)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		s.erasures[erasure.UserID] = erasure
+	}
+	if err = scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// AddErasure saves the audit record of the erasure of the user into file and into memory.
+func (s *FileErasureStorage) AddErasure(ctx context.Context, erasure internal.UserErasure) error {
+	data, err := json.Marshal(erasure)
+	if err != nil {
+		return err
+	}
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+	_, err = s.file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("error while writing erasure: %w", err)
+	}
+	err = s.file.Sync()
+	if err != nil {
+		return fmt.Errorf("error while writing erasure: %w", err)
+	}
+	return s.MemoryErasureStorage.AddErasure(ctx, erasure)
+}
+
+// Close closes the file.
+func (s *FileErasureStorage) Close() {
+	s.file.Close()
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"context"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileErasureStorage(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "erasures")
+	store, err := NewFileErasureStorage(filename)
+	require.NoError(t, err)
+	require.NoError(t, store.AddErasure(ctx, internal.UserErasure{UserID: 1, RequestID: "req", Links: 2, ErasedAt: time.Now()}))
+	store.Close()
+
+	store, err = NewFileErasureStorage(filename)
+	require.NoError(t, err)
+	defer store.Close()
+	erased, err := store.IsErased(ctx, 1)
+	require.NoError(t, err)
+	assert.True(t, erased)
+	erased, err = store.IsErased(ctx, 2)
+	require.NoError(t, err)
+	assert.False(t, erased)
+	last, err := store.LastErased(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, last)
+}
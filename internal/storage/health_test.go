@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/MalyginaEkaterina/shortener/internal/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStorageChecks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls")
+	newStore, err := NewCachedFileStorage(path)
+	require.NoError(t, err)
+	old := &failingStorage{Storage: NewMemoryStorage()}
+	m := NewMigratingStorage(old, NewTracingStorage(newStore, "file"))
+	defer m.Close()
+
+	checker := health.NewChecker(0, time.Second)
+	m.RegisterChecks(checker)
+	assert.Equal(t, []string{"migration_source.storage", "storage"}, checker.Names())
+
+	report := checker.Run(context.Background())
+	assert.Equal(t, health.StatusOK, report.Components["storage"].Status)
+	assert.Equal(t, health.StatusFail, report.Components["migration_source.storage"].Status)
+
+	m.newOnly.Store(true)
+	require.NoError(t, os.Remove(path))
+	report = checker.Run(context.Background())
+	assert.Equal(t, health.StatusOK, report.Components["migration_source.storage"].Status)
+	assert.Equal(t, health.StatusFail, report.Components["storage"].Status)
+}
+
+// failingStorage is a storage whose check always fails.
+type failingStorage struct {
+	Storage
+}
+
+func (f *failingStorage) RegisterChecks(r health.Registry) {
+	r.Register("storage", func(ctx context.Context) error { return errors.New("unavailable") })
+}
@@ -0,0 +1,350 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JobStorage keeps import jobs and their items.
+type JobStorage interface {
+	// CreateJob saves the new job with its items.
+	CreateJob(ctx context.Context, job internal.ImportJob, items []internal.JobItem) error
+	// GetJob returns the job by its id or ErrNotFound.
+	GetJob(ctx context.Context, id string) (internal.ImportJob, error)
+	// UpdateJob saves the state of the job. Returns ErrNotFound if there is no such job or it was claimed
+	// with another lease.
+	UpdateJob(ctx context.Context, job internal.ImportJob) error
+	// Items returns up to limit items of the job ordered by line skipping the first offset items.
+	Items(ctx context.Context, jobID string, offset, limit int) ([]internal.JobItem, error)
+	// SaveResults saves the results of processed items together with the state of the job. Returns ErrNotFound
+	// if there is no such job or it was claimed with another lease.
+	SaveResults(ctx context.Context, job internal.ImportJob, items []internal.JobItem) error
+	// ClaimJob atomically claims the oldest queued job or the running job whose lease expired by now.
+	// The claimed job is running with the lease leaseID until leaseUntil. Returns ErrNotFound if there is no such job.
+	ClaimJob(ctx context.Context, leaseID string, now, leaseUntil time.Time) (internal.ImportJob, error)
+	// EraseUser removes jobs of the user with their items.
+	EraseUser(ctx context.Context, userID int) error
+	// Close closes resources.
+	Close()
+}
+
+var _ JobStorage = (*MemoryJobStorage)(nil)
+
+// MemoryJobStorage keeps import jobs in memory.
+type MemoryJobStorage struct {
+	jobs  map[string]*memoryJob
+	mutex sync.RWMutex
+}
+
+type memoryJob struct {
+	job       internal.ImportJob
+	items     []internal.JobItem
+	lineIndex map[int]int
+}
+
+// NewMemoryJobStorage creates new *MemoryJobStorage.
+func NewMemoryJobStorage() *MemoryJobStorage {
+	return &MemoryJobStorage{jobs: make(map[string]*memoryJob)}
+}
+
+// CreateJob saves the job with its items sorted by line.
+func (s *MemoryJobStorage) CreateJob(_ context.Context, job internal.ImportJob, items []internal.JobItem) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.createJob(job, items)
+	return nil
+}
+
+func (s *MemoryJobStorage) createJob(job internal.ImportJob, items []internal.JobItem) {
+	sorted := make([]internal.JobItem, len(items))
+	copy(sorted, items)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Line < sorted[j].Line
+	})
+	lineIndex := make(map[int]int, len(sorted))
+	for i, v := range sorted {
+		lineIndex[v.Line] = i
+	}
+	s.jobs[job.ID] = &memoryJob{job: job, items: sorted, lineIndex: lineIndex}
+}
+
+// GetJob returns the job by its id or ErrNotFound.
+func (s *MemoryJobStorage) GetJob(_ context.Context, id string) (internal.ImportJob, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return internal.ImportJob{}, ErrNotFound
+	}
+	return j.job, nil
+}
+
+// UpdateJob saves the state of the job.
+func (s *MemoryJobStorage) UpdateJob(_ context.Context, job internal.ImportJob) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.updateJob(job)
+}
+
+func (s *MemoryJobStorage) updateJob(job internal.ImportJob) error {
+	j, ok := s.jobs[job.ID]
+	if !ok || j.job.LeaseID != job.LeaseID {
+		return ErrNotFound
+	}
+	j.job = job
+	return nil
+}
+
+// Items returns up to limit items of the job ordered by line skipping the first offset items.
+func (s *MemoryJobStorage) Items(_ context.Context, jobID string, offset, limit int) ([]internal.JobItem, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	j, ok := s.jobs[jobID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if offset >= len(j.items) {
+		return nil, nil
+	}
+	end := offset + limit
+	if end > len(j.items) {
+		end = len(j.items)
+	}
+	res := make([]internal.JobItem, end-offset)
+	copy(res, j.items[offset:end])
+	return res, nil
+}
+
+// SaveResults saves the results of processed items together with the state of the job.
+func (s *MemoryJobStorage) SaveResults(_ context.Context, job internal.ImportJob, items []internal.JobItem) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.saveResults(job, items)
+}
+
+func (s *MemoryJobStorage) saveResults(job internal.ImportJob, items []internal.JobItem) error {
+	j, ok := s.jobs[job.ID]
+	if !ok || j.job.LeaseID != job.LeaseID {
+		return ErrNotFound
+	}
+	for _, v := range items {
+		if i, ok := j.lineIndex[v.Line]; ok {
+			j.items[i] = v
+		}
+	}
+	j.job = job
+	return nil
+}
+
+// ClaimJob claims the oldest queued job or the running job whose lease expired by now.
+func (s *MemoryJobStorage) ClaimJob(_ context.Context, leaseID string, now, leaseUntil time.Time) (internal.ImportJob, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.claimJob(leaseID, now, leaseUntil)
+}
+
+func (s *MemoryJobStorage) claimJob(leaseID string, now, leaseUntil time.Time) (internal.ImportJob, error) {
+	var claimed *memoryJob
+	for _, j := range s.jobs {
+		if j.job.Status != internal.JobQueued && (j.job.Status != internal.JobRunning || j.job.LeaseUntil.After(now)) {
+			continue
+		}
+		if claimed == nil || j.job.CreatedAt.Before(claimed.job.CreatedAt) {
+			claimed = j
+		}
+	}
+	if claimed == nil {
+		return internal.ImportJob{}, ErrNotFound
+	}
+	claimed.job.Status = internal.JobRunning
+	claimed.job.LeaseID = leaseID
+	claimed.job.LeaseUntil = leaseUntil
+	claimed.job.UpdatedAt = now
+	return claimed.job, nil
+}
+
+// EraseUser removes jobs of the user with their items.
+func (s *MemoryJobStorage) EraseUser(_ context.Context, userID int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.eraseUser(userID)
+	return nil
+}
+
+func (s *MemoryJobStorage) eraseUser(userID int) {
+	for id, j := range s.jobs {
+		if j.job.UserID == userID {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// Close does nothing.
+func (s *MemoryJobStorage) Close() {
+}
+
+var _ JobStorage = (*FileJobStorage)(nil)
+
+// FileJobStorage keeps import jobs in memory and appends every change into the file.
+// The file is replayed on start so that jobs survive restarts. Leases are not written, so running jobs
+// can be claimed right after restart.
+type FileJobStorage struct {
+	*MemoryJobStorage
+	file      *os.File
+	filename  string
+	fileMutex sync.Mutex
+}
+
+// jobLogRecord is the line of the FileJobStorage file.
+// UserID is kept separately because ImportJob does not marshal it.
+type jobLogRecord struct {
+	Type   string             `json:"type"`
+	UserID int                `json:"user_id"`
+	Job    internal.ImportJob `json:"job"`
+	Items  []internal.JobItem `json:"items,omitempty"`
+}
+
+// Types of jobLogRecord.
+const (
+	jobLogCreate  = "create"
+	jobLogUpdate  = "update"
+	jobLogResults = "results"
+)
+
+// NewFileJobStorage creates FileJobStorage and fills memory from the file with name=filename.
+func NewFileJobStorage(filename string) (*FileJobStorage, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0777)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileJobStorage{MemoryJobStorage: NewMemoryJobStorage(), file: file, filename: filename}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var rec jobLogRecord
+		err = json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		s.apply(rec)
+	}
+	if err = scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileJobStorage) apply(rec jobLogRecord) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	rec.Job.UserID = rec.UserID
+	switch rec.Type {
+	case jobLogCreate:
+		s.createJob(rec.Job, rec.Items)
+	case jobLogUpdate:
+		s.updateJob(rec.Job)
+	case jobLogResults:
+		s.saveResults(rec.Job, rec.Items)
+	}
+}
+
+// write appends the record into the file and after that applies it to memory. The job of updates must exist
+// with the same lease.
+func (s *FileJobStorage) write(rec jobLogRecord) error {
+	rec.UserID = rec.Job.UserID
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+	if rec.Type != jobLogCreate {
+		if cur, err := s.GetJob(context.Background(), rec.Job.ID); err != nil {
+			return err
+		} else if cur.LeaseID != rec.Job.LeaseID {
+			return ErrNotFound
+		}
+	}
+	err := s.append(rec)
+	if err != nil {
+		return err
+	}
+	s.apply(rec)
+	return nil
+}
+
+// append appends the record into the file.
+func (s *FileJobStorage) append(rec jobLogRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("error while writing job: %w", err)
+	}
+	return nil
+}
+
+// CreateJob saves the job with its items into file and into memory.
+func (s *FileJobStorage) CreateJob(_ context.Context, job internal.ImportJob, items []internal.JobItem) error {
+	return s.write(jobLogRecord{Type: jobLogCreate, Job: job, Items: items})
+}
+
+// UpdateJob saves the state of the job into file and into memory.
+func (s *FileJobStorage) UpdateJob(_ context.Context, job internal.ImportJob) error {
+	return s.write(jobLogRecord{Type: jobLogUpdate, Job: job})
+}
+
+// SaveResults saves the results of processed items together with the state of the job into file and into memory.
+func (s *FileJobStorage) SaveResults(_ context.Context, job internal.ImportJob, items []internal.JobItem) error {
+	return s.write(jobLogRecord{Type: jobLogResults, Job: job, Items: items})
+}
+
+// ClaimJob claims the job in memory and writes its running state into file.
+func (s *FileJobStorage) ClaimJob(_ context.Context, leaseID string, now, leaseUntil time.Time) (internal.ImportJob, error) {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+	s.mutex.Lock()
+	job, err := s.claimJob(leaseID, now, leaseUntil)
+	s.mutex.Unlock()
+	if err != nil {
+		return job, err
+	}
+	return job, s.append(jobLogRecord{Type: jobLogUpdate, UserID: job.UserID, Job: job})
+}
+
+// EraseUser removes jobs of the user from memory and rewrites the file with the rest of jobs,
+// so URLs of the user do not stay in the file.
+func (s *FileJobStorage) EraseUser(_ context.Context, userID int) error {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+	s.mutex.Lock()
+	s.eraseUser(userID)
+	s.mutex.Unlock()
+	var err error
+	s.file, err = rewriteFile(s.filename, s.file, func(w io.Writer) error {
+		s.mutex.RLock()
+		defer s.mutex.RUnlock()
+		enc := json.NewEncoder(w)
+		for _, j := range s.jobs {
+			err := enc.Encode(jobLogRecord{Type: jobLogCreate, UserID: j.job.UserID, Job: j.job, Items: j.items})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// Close closes the file.
+func (s *FileJobStorage) Close() {
+	s.file.Close()
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileJobStorage(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "jobs")
+	store, err := NewFileJobStorage(filename)
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	job := internal.ImportJob{ID: "a", UserID: 1, Status: internal.JobQueued, Total: 3, CreatedAt: now, UpdatedAt: now}
+	items := []internal.JobItem{
+		{Line: 4, OriginalURL: "https://ya3.ru"},
+		{Line: 2, CorrID: "1", OriginalURL: "https://ya1.ru"},
+		{Line: 3, OriginalURL: "https://ya2.ru"},
+	}
+	require.NoError(t, store.CreateJob(ctx, job, items))
+	job.Status = internal.JobRunning
+	require.NoError(t, store.UpdateJob(ctx, job))
+
+	// Items are ordered by line.
+	pending, err := store.Items(ctx, "a", 0, 2)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, 2, pending[0].Line)
+	assert.Equal(t, 3, pending[1].Line)
+	pending[0].URLID, pending[0].Status = 7, internal.BatchCreated
+	pending[1].Status, pending[1].Reason = internal.BatchInvalid, "bad"
+	job.Processed, job.Created, job.Invalid = 2, 1, 1
+	require.NoError(t, store.SaveResults(ctx, job, pending))
+	assert.ErrorIs(t, store.UpdateJob(ctx, internal.ImportJob{ID: "b"}), ErrNotFound)
+	store.Close()
+
+	store, err = NewFileJobStorage(filename)
+	require.NoError(t, err)
+	defer store.Close()
+	restored, err := store.GetJob(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, job, restored)
+
+	// The lease of the running job is not kept after restart.
+	claimed, err := store.ClaimJob(ctx, "lease1", now, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, "a", claimed.ID)
+	assert.Equal(t, 1, claimed.UserID)
+	assert.Equal(t, internal.JobRunning, claimed.Status)
+	assert.Equal(t, 2, claimed.Processed)
+	_, err = store.ClaimJob(ctx, "lease2", now.Add(time.Second), now.Add(time.Minute))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, store.UpdateJob(ctx, job), ErrNotFound)
+	claimed, err = store.ClaimJob(ctx, "lease2", now.Add(time.Minute), now.Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, "lease2", claimed.LeaseID)
+	claimed.LeaseID = "lease1"
+	assert.ErrorIs(t, store.SaveResults(ctx, claimed, nil), ErrNotFound)
+	claimed.LeaseID = "lease2"
+	require.NoError(t, store.SaveResults(ctx, claimed, nil))
+	all, err := store.Items(ctx, "a", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []internal.JobItem{
+		{Line: 2, CorrID: "1", OriginalURL: "https://ya1.ru", URLID: 7, Status: internal.BatchCreated},
+		{Line: 3, OriginalURL: "https://ya2.ru", Status: internal.BatchInvalid, Reason: "bad"},
+		{Line: 4, OriginalURL: "https://ya3.ru"},
+	}, all)
+	rest, err := store.Items(ctx, "a", 2, 10)
+	require.NoError(t, err)
+	assert.Len(t, rest, 1)
+	_, err = store.GetJob(ctx, "b")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestClaimJob(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryJobStorage()
+	now := time.Now()
+	for i, id := range []string{"b", "a", "c"} {
+		job := internal.ImportJob{ID: id, Status: internal.JobQueued, CreatedAt: now.Add(time.Duration(i) * time.Second)}
+		require.NoError(t, store.CreateJob(ctx, job, nil))
+	}
+	done := internal.ImportJob{ID: "d", Status: internal.JobDone, CreatedAt: now.Add(-time.Hour)}
+	require.NoError(t, store.CreateJob(ctx, done, nil))
+
+	// Jobs are claimed once in the order of creation.
+	var ids []string
+	for i := 0; i < 3; i++ {
+		job, err := store.ClaimJob(ctx, "lease", now, now.Add(time.Minute))
+		require.NoError(t, err)
+		ids = append(ids, job.ID)
+	}
+	assert.Equal(t, []string{"b", "a", "c"}, ids)
+	_, err := store.ClaimJob(ctx, "lease", now, now.Add(time.Minute))
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFileJobStorageEraseUser(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "jobs")
+	store, err := NewFileJobStorage(filename)
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	erased := internal.ImportJob{ID: "a", UserID: 1, Status: internal.JobQueued, Total: 1, CreatedAt: now, UpdatedAt: now}
+	kept := internal.ImportJob{ID: "b", UserID: 2, Status: internal.JobQueued, Total: 1, CreatedAt: now, UpdatedAt: now}
+	require.NoError(t, store.CreateJob(ctx, erased, []internal.JobItem{{Line: 1, OriginalURL: "https://erased.ru"}}))
+	require.NoError(t, store.CreateJob(ctx, kept, []internal.JobItem{{Line: 1, OriginalURL: "https://kept.ru"}}))
+	require.NoError(t, store.EraseUser(ctx, 1))
+	_, err = store.GetJob(ctx, "a")
+	assert.ErrorIs(t, err, ErrNotFound)
+	kept.Status = internal.JobDone
+	require.NoError(t, store.UpdateJob(ctx, kept))
+	store.Close()
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "https://erased.ru")
+	store, err = NewFileJobStorage(filename)
+	require.NoError(t, err)
+	defer store.Close()
+	_, err = store.GetJob(ctx, "a")
+	assert.ErrorIs(t, err, ErrNotFound)
+	got, err := store.GetJob(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, kept, got)
+	items, err := store.Items(ctx, "b", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []internal.JobItem{{Line: 1, OriginalURL: "https://kept.ru"}}, items)
+}
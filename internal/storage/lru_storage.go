@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats contains counters of LRUStorage.
+type CacheStats struct {
+	Capacity      int    `json:"capacity"`
+	Size          int    `json:"size"`
+	Hits          uint64 `json:"hits"`
+	NegativeHits  uint64 `json:"negative_hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+}
+
+var _ Storage = (*LRUStorage)(nil)
+
+// LRUStorage is a read-through cache of GetURL in front of another Storage.
+// It keeps at most capacity entries and evicts the least recently used one.
+// Not found ids are cached for negativeTTL, negative caching is disabled if it is zero.
+// Entries are invalidated when URLs are added, imported or deleted through LRUStorage.
+type LRUStorage struct {
+	Storage
+	capacity    int
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mutex      sync.Mutex
+	items      map[string]*list.Element
+	order      *list.List
+	generation uint64
+
+	hits          atomic.Uint64
+	negativeHits  atomic.Uint64
+	misses        atomic.Uint64
+	evictions     atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+type cacheEntry struct {
+	id        string
+	url       string
+	err       error
+	expiresAt time.Time
+}
+
+// NewLRUStorage creates LRUStorage in front of store. Entries never expire if ttl is zero.
+func NewLRUStorage(store Storage, capacity int, ttl, negativeTTL time.Duration) *LRUStorage {
+	return &LRUStorage{
+		Storage:     store,
+		capacity:    capacity,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		items:       make(map[string]*list.Element, capacity),
+		order:       list.New(),
+	}
+}
+
+// GetURL returns URL from cache or loads it from the underlying storage.
+// ErrNotFound and ErrDeleted results are cached too.
+func (c *LRUStorage) GetURL(ctx context.Context, id string) (string, error) {
+	key := cacheKey(id)
+	if entry, ok := c.get(key); ok {
+		return entry.url, entry.err
+	}
+	c.misses.Add(1)
+
+	c.mutex.Lock()
+	generation := c.generation
+	c.mutex.Unlock()
+
+	url, err := c.Storage.GetURL(ctx, id)
+	var ttl time.Duration
+	switch {
+	case err == nil, errors.Is(err, ErrDeleted):
+		ttl = c.ttl
+	case errors.Is(err, ErrNotFound) && c.negativeTTL > 0:
+		ttl = c.negativeTTL
+	default:
+		return url, err
+	}
+	c.set(generation, cacheEntry{id: key, url: url, err: err}, ttl)
+	return url, err
+}
+
+// AddURL adds URL into the underlying storage and invalidates its id.
+func (c *LRUStorage) AddURL(ctx context.Context, url string, userID int) (int, error) {
+	id, err := c.Storage.AddURL(ctx, url, userID)
+	if err == nil {
+		c.Invalidate(id)
+	}
+	return id, err
+}
+
+// AddBatch adds URLs into the underlying storage and invalidates their ids.
+func (c *LRUStorage) AddBatch(ctx context.Context, urls []internal.CorrIDOriginalURL, userID int) ([]internal.CorrIDUrlID, error) {
+	res, err := c.Storage.AddBatch(ctx, urls, userID)
+	ids := make([]int, len(res))
+	for i, v := range res {
+		ids[i] = v.URLID
+	}
+	c.Invalidate(ids...)
+	return res, err
+}
+
+// DeleteBatch deletes URLs in the underlying storage and invalidates their ids.
+func (c *LRUStorage) DeleteBatch(ctx context.Context, ids []internal.IDToDelete) ([]internal.DeleteResult, error) {
+	res, err := c.Storage.DeleteBatch(ctx, ids)
+	c.invalidateIDs(ids)
+	return res, err
+}
+
+// RestoreBatch restores URLs in the underlying storage and invalidates their ids.
+func (c *LRUStorage) RestoreBatch(ctx context.Context, ids []internal.IDToDelete, deletedAfter time.Time) ([]internal.DeleteResult, error) {
+	res, err := c.Storage.RestoreBatch(ctx, ids, deletedAfter)
+	c.invalidateIDs(ids)
+	return res, err
+}
+
+// EraseUser erases the user in the underlying storage and invalidates cached redirects of its URLs.
+func (c *LRUStorage) EraseUser(ctx context.Context, userID int) ([]int, error) {
+	ids, err := c.Storage.EraseUser(ctx, userID)
+	c.Invalidate(ids...)
+	return ids, err
+}
+
+func (c *LRUStorage) invalidateIDs(ids []internal.IDToDelete) {
+	urlIDs := make([]int, len(ids))
+	for i, v := range ids {
+		urlIDs[i] = v.ID
+	}
+	c.Invalidate(urlIDs...)
+}
+
+// Import imports URLs into the underlying storage and invalidates their ids.
+func (c *LRUStorage) Import(ctx context.Context, urls []internal.URLRecord) (int, error) {
+	n, err := c.Storage.Import(ctx, urls)
+	ids := make([]int, len(urls))
+	for i, v := range urls {
+		ids[i] = v.ID
+	}
+	c.Invalidate(ids...)
+	return n, err
+}
+
+// Invalidate removes ids from cache.
+func (c *LRUStorage) Invalidate(ids ...int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	for _, id := range ids {
+		if el, ok := c.items[strconv.Itoa(id)]; ok {
+			c.remove(el)
+			c.invalidations.Add(1)
+		}
+	}
+}
+
+// Purge removes all entries from cache.
+func (c *LRUStorage) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	c.invalidations.Add(uint64(len(c.items)))
+	c.items = make(map[string]*list.Element, c.capacity)
+	c.order.Init()
+}
+
+// Stats returns cache counters.
+func (c *LRUStorage) Stats() CacheStats {
+	c.mutex.Lock()
+	size := len(c.items)
+	c.mutex.Unlock()
+	return CacheStats{
+		Capacity:      c.capacity,
+		Size:          size,
+		Hits:          c.hits.Load(),
+		NegativeHits:  c.negativeHits.Load(),
+		Misses:        c.misses.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+	}
+}
+
+func (c *LRUStorage) get(key string) (cacheEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		c.remove(el)
+		return cacheEntry{}, false
+	}
+	c.order.MoveToFront(el)
+	if errors.Is(entry.err, ErrNotFound) {
+		c.negativeHits.Add(1)
+	} else {
+		c.hits.Add(1)
+	}
+	return *entry, true
+}
+
+// set saves entry if there were no invalidations since the generation.
+// Otherwise, the loaded value may be already stale.
+func (c *LRUStorage) set(generation uint64, entry cacheEntry, ttl time.Duration) {
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.generation != generation {
+		return
+	}
+	if el, ok := c.items[entry.id]; ok {
+		el.Value = &entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[entry.id] = c.order.PushFront(&entry)
+	for len(c.items) > c.capacity {
+		c.remove(c.order.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *LRUStorage) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry).id)
+}
+
+// cacheKey returns the canonical form of numeric ids so that "07" and "7" share the entry.
+func cacheKey(id string) string {
+	if n, err := strconv.Atoi(id); err == nil {
+		return strconv.Itoa(n)
+	}
+	return id
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// countingStorage counts GetURL calls of the underlying storage.
+type countingStorage struct {
+	Storage
+	getURLCalls int
+}
+
+func (s *countingStorage) GetURL(ctx context.Context, id string) (string, error) {
+	s.getURLCalls++
+	return s.Storage.GetURL(ctx, id)
+}
+
+func TestLRUStorage(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStorage{Storage: NewMemoryStorage()}
+	for i := 0; i < 3; i++ {
+		_, err := store.AddURL(ctx, "https://ya"+strconv.Itoa(i)+".ru", 1)
+		require.NoError(t, err)
+	}
+	cache := NewLRUStorage(store, 2, 0, time.Minute)
+
+	url, err := cache.GetURL(ctx, "0")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya0.ru", url)
+	url, err = cache.GetURL(ctx, "00")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya0.ru", url)
+	assert.Equal(t, 1, store.getURLCalls)
+
+	// Negative caching of the id which will be created next.
+	_, err = cache.GetURL(ctx, "3")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = cache.GetURL(ctx, "3")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 2, store.getURLCalls)
+	id, err := cache.AddURL(ctx, "https://ya3.ru", 1)
+	require.NoError(t, err)
+	require.Equal(t, 3, id)
+	url, err = cache.GetURL(ctx, "3")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya3.ru", url)
+	assert.Equal(t, 3, store.getURLCalls)
+
+	// "0" is evicted as the least recently used entry.
+	_, err = cache.GetURL(ctx, "1")
+	require.NoError(t, err)
+	_, err = cache.GetURL(ctx, "0")
+	require.NoError(t, err)
+	assert.Equal(t, 5, store.getURLCalls)
+
+	// Deletion invalidates the entry.
+	_, err = cache.DeleteBatch(ctx, []internal.IDToDelete{{ID: 0, UserID: 1}})
+	require.NoError(t, err)
+	_, err = cache.GetURL(ctx, "0")
+	assert.ErrorIs(t, err, ErrDeleted)
+	_, err = cache.GetURL(ctx, "0")
+	assert.ErrorIs(t, err, ErrDeleted)
+	assert.Equal(t, 6, store.getURLCalls)
+
+	assert.Equal(t, CacheStats{
+		Capacity:      2,
+		Size:          2,
+		Hits:          2,
+		NegativeHits:  1,
+		Misses:        6,
+		Evictions:     2,
+		Invalidations: 2,
+	}, cache.Stats())
+}
+
+func TestLRUStorageTTL(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStorage{Storage: NewMemoryStorage()}
+	_, err := store.AddURL(ctx, "https://ya.ru", 1)
+	require.NoError(t, err)
+	cache := NewLRUStorage(store, 10, time.Millisecond, 0)
+
+	_, err = cache.GetURL(ctx, "0")
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = cache.GetURL(ctx, "0")
+	require.NoError(t, err)
+	assert.Equal(t, 2, store.getURLCalls)
+
+	// Negative caching is disabled.
+	_, err = cache.GetURL(ctx, "5")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = cache.GetURL(ctx, "5")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 4, store.getURLCalls)
+	assert.Equal(t, 1, cache.Stats().Size)
+}
@@ -2,20 +2,34 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/health"
+	"math"
+	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // URL represents a URL stored in MemoryStorage.
+// A purged URL is a tombstone without the original URL which keeps its id known as deleted.
 type URL struct {
 	url       string
 	userID    int32
 	isDeleted bool
+	purged    bool
+	createdAt time.Time
+	deletedAt time.Time
 }
 
 var _ Storage = (*MemoryStorage)(nil)
+var _ UserReserver = (*MemoryStorage)(nil)
+
+// maxIDGap is the largest number of missing ids before an imported id. Ids of MemoryStorage are indexes of
+// the slice of URLs, so the gap is allocated.
+const maxIDGap = 1 << 20
 
 // MemoryStorage represents an in-memory storage implementation of the Storage interface.
 type MemoryStorage struct {
@@ -44,7 +58,7 @@ func (s *MemoryStorage) AddURL(_ context.Context, url string, userID int) (int,
 	if ok {
 		return 0, ErrAlreadyExists
 	}
-	s.urls = append(s.urls, URL{url: url, userID: int32(userID), isDeleted: false})
+	s.urls = append(s.urls, URL{url: url, userID: int32(userID), isDeleted: false, createdAt: time.Now()})
 	urlID := len(s.urls) - 1
 	s.UrlsID[url] = int32(urlID)
 	s.UserUrls[int32(userID)] = append(s.UserUrls[int32(userID)], int32(urlID))
@@ -54,23 +68,40 @@ func (s *MemoryStorage) AddURL(_ context.Context, url string, userID int) (int,
 // GetURL returns the original URL corresponding to the given id, or an error if not found or deleted.
 func (s *MemoryStorage) GetURL(_ context.Context, idStr string) (string, error) {
 	id, err := strconv.Atoi(idStr)
-	if err != nil || id < 0 || id >= len(s.urls) {
+	if err != nil {
 		return "", ErrNotFound
 	}
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
-	url := s.urls[id]
+	url, ok := s.get(id)
+	if !ok {
+		return "", ErrNotFound
+	}
 	if url.isDeleted {
 		return "", ErrDeleted
 	}
-	return url.url, err
+	return url.url, nil
+}
+
+// GetURLOwner returns the id of the user who saved the URL with id.
+func (s *MemoryStorage) GetURLOwner(_ context.Context, id int) (int, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	url, ok := s.get(id)
+	if !ok || url.purged {
+		return 0, ErrNotFound
+	}
+	return int(url.userID), nil
 }
 
 // GetURLID returns the id by the given original URL.
 func (s *MemoryStorage) GetURLID(_ context.Context, url string) (int, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
-	id := s.UrlsID[url]
+	id, ok := s.UrlsID[url]
+	if !ok {
+		return 0, ErrNotFound
+	}
 	return int(id), nil
 }
 
@@ -89,37 +120,282 @@ func (s *MemoryStorage) GetUserUrls(_ context.Context, userID int) (map[int]stri
 	return res, nil
 }
 
-// AddBatch adds a list of new URLs to MemoryStorage and returns their corresponding correlation IDs and URL IDs.
+// AddBatch adds a list of new URLs to MemoryStorage and returns the result for every URL.
+// URLs which already exist are not added, their ids are returned with the flag Existed.
 func (s *MemoryStorage) AddBatch(_ context.Context, urls []internal.CorrIDOriginalURL, userID int) ([]internal.CorrIDUrlID, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	var res []internal.CorrIDUrlID
-	for _, v := range urls {
-		corrIDUrlID := internal.CorrIDUrlID{CorrID: v.CorrID}
-		s.urls = append(s.urls, URL{url: v.OriginalURL, userID: int32(userID), isDeleted: false})
-		corrIDUrlID.URLID = len(s.urls) - 1
-		s.UserUrls[int32(userID)] = append(s.UserUrls[int32(userID)], int32(corrIDUrlID.URLID))
-		res = append(res, corrIDUrlID)
+	res := make([]internal.CorrIDUrlID, len(urls))
+	for i, v := range urls {
+		res[i].CorrID = v.CorrID
+		if v.OriginalURL == "" {
+			res[i].Err = ErrEmptyURL
+			continue
+		}
+		if id, ok := s.UrlsID[v.OriginalURL]; ok {
+			res[i].URLID = int(id)
+			res[i].Existed = true
+			continue
+		}
+		s.urls = append(s.urls, URL{url: v.OriginalURL, userID: int32(userID), isDeleted: false, createdAt: time.Now()})
+		res[i].URLID = len(s.urls) - 1
+		s.UrlsID[v.OriginalURL] = int32(res[i].URLID)
+		s.UserUrls[int32(userID)] = append(s.UserUrls[int32(userID)], int32(res[i].URLID))
+	}
+	return res, nil
+}
+
+// DeleteBatch marks a list of URLs as deleted in MemoryStorage and returns the result for every id.
+func (s *MemoryStorage) DeleteBatch(_ context.Context, ids []internal.IDToDelete) ([]internal.DeleteResult, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	res := make([]internal.DeleteResult, len(ids))
+	for i, v := range ids {
+		url, exists := s.get(v.ID)
+		res[i] = internal.DeleteResult{ID: v.ID, Status: deleteStatus(exists, int(url.userID), v.UserID, url.isDeleted)}
+		if res[i].Status == internal.DeleteDeleted {
+			url.isDeleted = true
+			url.deletedAt = now
+			s.urls[v.ID] = url
+		}
+	}
+	return res, nil
+}
+
+// RestoreBatch unmarks a list of URLs deleted after deletedAfter and returns the result for every id.
+func (s *MemoryStorage) RestoreBatch(_ context.Context, ids []internal.IDToDelete, deletedAfter time.Time) ([]internal.DeleteResult, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	res := make([]internal.DeleteResult, len(ids))
+	for i, v := range ids {
+		url, exists := s.get(v.ID)
+		res[i] = internal.DeleteResult{
+			ID:     v.ID,
+			Status: restoreStatus(exists, int(url.userID), v.UserID, url.isDeleted, url.purged, url.deletedAt, deletedAfter),
+		}
+		if res[i].Status == internal.DeleteRestored {
+			url.isDeleted = false
+			url.deletedAt = time.Time{}
+			s.urls[v.ID] = url
+		}
 	}
 	return res, nil
 }
 
-// DeleteBatch marks a list of URLs as deleted in MemoryStorage.
-func (s *MemoryStorage) DeleteBatch(_ context.Context, ids []internal.IDToDelete) error {
+// PurgeDeleted replaces URLs deleted before deletedBefore with tombstones and frees their original URLs.
+func (s *MemoryStorage) PurgeDeleted(_ context.Context, deletedBefore time.Time) ([]internal.URLRecord, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	for _, v := range ids {
-		if v.ID >= 0 || v.ID < len(s.urls) {
-			url := s.urls[v.ID]
-			if url.userID == int32(v.UserID) {
-				url.isDeleted = true
-				s.urls[v.ID] = url
-			}
+	var purged []internal.URLRecord
+	for id, url := range s.urls {
+		if !url.isDeleted || url.purged || !url.deletedAt.Before(deletedBefore) {
+			continue
+		}
+		delete(s.UrlsID, url.url)
+		s.UserUrls[url.userID] = removeID(s.UserUrls[url.userID], int32(id))
+		if len(s.UserUrls[url.userID]) == 0 {
+			delete(s.UserUrls, url.userID)
+		}
+		purged = append(purged, url.record(id))
+		s.urls[id] = url.tombstone()
+	}
+	sort.Slice(purged, func(i, j int) bool {
+		return purged[i].ID < purged[j].ID
+	})
+	return purged, nil
+}
+
+// GetUserRecords returns all URLs of the user including deleted ones in ascending order of id.
+func (s *MemoryStorage) GetUserRecords(_ context.Context, userID int) ([]internal.URLRecord, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	urlIDs := s.UserUrls[int32(userID)]
+	res := make([]internal.URLRecord, 0, len(urlIDs))
+	for _, id := range urlIDs {
+		res = append(res, s.urls[id].record(int(id)))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res, nil
+}
+
+// EraseUser replaces all URLs of the user with anonymous tombstones and frees their original URLs.
+func (s *MemoryStorage) EraseUser(_ context.Context, userID int) ([]int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	urlIDs := s.UserUrls[int32(userID)]
+	erased := make([]int, 0, len(urlIDs))
+	for _, id := range urlIDs {
+		url := s.urls[id]
+		delete(s.UrlsID, url.url)
+		s.urls[id] = url.erased(now)
+		erased = append(erased, int(id))
+	}
+	delete(s.UserUrls, int32(userID))
+	for id, url := range s.urls {
+		if url.purged && url.userID == int32(userID) {
+			s.urls[id].userID = 0
+		}
+	}
+	return erased, nil
+}
+
+// get returns URL by id and a flag if it exists. Must be called under mutex.
+func (s *MemoryStorage) get(id int) (URL, bool) {
+	if id < 0 || id >= len(s.urls) || !s.urls[id].exists() {
+		return URL{}, false
+	}
+	return s.urls[id], true
+}
+
+// Export calls fn for every stored URL in ascending order of id.
+// URLs are copied before calling fn so that fn does not block writers.
+func (s *MemoryStorage) Export(ctx context.Context, fn func(url internal.URLRecord) error) error {
+	s.mutex.RLock()
+	records := make([]internal.URLRecord, 0, len(s.urls))
+	for id, url := range s.urls {
+		if url.url == "" {
+			continue
+		}
+		records = append(records, url.record(id))
+	}
+	s.mutex.RUnlock()
+
+	for _, rec := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// Import saves URLs keeping their ids. Ids missing between the imported ones stay empty and are not found.
+// Returns ErrIDOutOfRange if the id is more than maxIDGap after the last stored id.
+func (s *MemoryStorage) Import(_ context.Context, urls []internal.URLRecord) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var imported int
+	for _, v := range urls {
+		if v.ID < 0 || v.OriginalURL == "" {
+			continue
+		}
+		if err := checkRecordIDs(v); err != nil {
+			return imported, err
+		}
+		if v.ID-len(s.urls) > maxIDGap {
+			return imported, fmt.Errorf("%w: url %d is more than %d after the last id %d", ErrIDOutOfRange,
+				v.ID, maxIDGap, len(s.urls)-1)
+		}
+		if v.ID < len(s.urls) && s.urls[v.ID].exists() {
+			continue
+		}
+		if _, ok := s.UrlsID[v.OriginalURL]; ok {
+			continue
+		}
+		for len(s.urls) <= v.ID {
+			s.urls = append(s.urls, URL{})
+		}
+		s.urls[v.ID] = newURL(v)
+		s.UrlsID[v.OriginalURL] = int32(v.ID)
+		s.UserUrls[int32(v.UserID)] = append(s.UserUrls[int32(v.UserID)], int32(v.ID))
+		s.reserveUser(v.UserID)
+		imported++
+	}
+	return imported, nil
+}
+
+// ReserveUser makes sure that AddUser never returns userID or lower.
+func (s *MemoryStorage) ReserveUser(_ context.Context, userID int) error {
+	s.reserveUser(userID)
+	return nil
+}
+
+// reserveUser makes sure that AddUser never returns userID or lower.
+func (s *MemoryStorage) reserveUser(userID int) {
+	for {
+		count := s.userCount.Load()
+		if count >= int32(userID) || s.userCount.CompareAndSwap(count, int32(userID)) {
+			return
+		}
+	}
+}
+
+// RegisterChecks registers the check of the storage which is always healthy.
+func (s *MemoryStorage) RegisterChecks(r health.Registry) {
+	r.Register(CheckName, func(ctx context.Context) error { return nil })
+}
+
 // Close does nothing.
 func (s *MemoryStorage) Close() {
 }
+
+// checkRecordIDs returns ErrIDOutOfRange if the id or the owner of the imported URL does not fit into int32
+// which URL keeps them in.
+func checkRecordIDs(rec internal.URLRecord) error {
+	if rec.ID > math.MaxInt32 || rec.UserID < 0 || rec.UserID > math.MaxInt32 {
+		return fmt.Errorf("%w: url %d of user %d", ErrIDOutOfRange, rec.ID, rec.UserID)
+	}
+	return nil
+}
+
+func newURL(rec internal.URLRecord) URL {
+	createdAt := rec.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	var deletedAt time.Time
+	if rec.IsDeleted {
+		deletedAt = rec.DeletedAt
+		if deletedAt.IsZero() {
+			deletedAt = time.Now()
+		}
+	}
+	return URL{url: rec.OriginalURL, userID: int32(rec.UserID), isDeleted: rec.IsDeleted, createdAt: createdAt, deletedAt: deletedAt}
+}
+
+// exists reports whether the URL was saved. Ids missing between imported ones are empty.
+func (u URL) exists() bool {
+	return u.url != "" || u.purged
+}
+
+// tombstone returns the purged URL which keeps the owner and the time of deletion only.
+func (u URL) tombstone() URL {
+	return URL{userID: u.userID, isDeleted: true, purged: true, createdAt: u.createdAt, deletedAt: u.deletedAt}
+}
+
+// erased returns the anonymous tombstone of the URL of the erased user.
+func (u URL) erased(now time.Time) URL {
+	if !u.isDeleted {
+		u.deletedAt = now
+	}
+	u = u.tombstone()
+	u.userID = 0
+	return u
+}
+
+// removeID removes id from ids keeping the order.
+func removeID[T comparable](ids []T, id T) []T {
+	for i, v := range ids {
+		if v == id {
+			return append(ids[:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
+
+func (u URL) record(id int) internal.URLRecord {
+	return internal.URLRecord{
+		ID:          id,
+		UserID:      int(u.userID),
+		OriginalURL: u.url,
+		IsDeleted:   u.isDeleted,
+		CreatedAt:   u.createdAt,
+		DeletedAt:   u.deletedAt,
+	}
+}
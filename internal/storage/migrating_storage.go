@@ -0,0 +1,536 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/health"
+	"github.com/MalyginaEkaterina/shortener/internal/logging"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Migration states.
+const (
+	MigrationCopying   = "copying"
+	MigrationVerifying = "verifying"
+	MigrationDone      = "done"
+	MigrationFailed    = "failed"
+)
+
+const migrationBatchSize = 1000
+
+// migrationVerifyAttempts is the number of verifications before the migration fails if writes into the new storage
+// keep failing during the verification.
+const migrationVerifyAttempts = 3
+
+// UserReserver is implemented by storages which are able to reserve user ids created by another storage.
+type UserReserver interface {
+	// ReserveUser makes sure that AddUser never returns userID or lower.
+	ReserveUser(ctx context.Context, userID int) error
+}
+
+// MigrationProgress contains the state of the migration between storages.
+type MigrationProgress struct {
+	State        string     `json:"state"`
+	Copied       int        `json:"copied"`
+	Imported     int        `json:"imported"`
+	LastID       int        `json:"last_id"`
+	Verified     int        `json:"verified"`
+	Fixed        int        `json:"fixed"`
+	Mismatched   int        `json:"mismatched"`
+	FailedWrites int64      `json:"failed_writes"`
+	Error        string     `json:"error,omitempty"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+var _ Storage = (*MigratingStorage)(nil)
+
+// MigratingStorage moves URLs from the old storage to the new one without downtime.
+// Until the migration is done it writes into both storages and reads from the new one with a fallback to the old one.
+// The old storage stays the source of ids, the new one receives URLs with the same ids.
+// After the verification it switches to the new storage only. A dual write which fails in the new storage
+// after its URL was verified makes the verification run again, so the switch never loses it.
+type MigratingStorage struct {
+	old     Storage
+	new     Storage
+	newOnly atomic.Bool
+	// writes is held for reading by dual writes and for writing by the switch to the new storage.
+	writes       sync.RWMutex
+	failedWrites atomic.Int64
+	// lastUserID is the highest user id added into the old storage.
+	lastUserID atomic.Int64
+	progress   MigrationProgress
+	mutex      sync.RWMutex
+}
+
+// NewMigratingStorage creates MigratingStorage. Run must be called to copy the existing URLs.
+func NewMigratingStorage(old, new Storage) *MigratingStorage {
+	return &MigratingStorage{
+		old:      old,
+		new:      new,
+		progress: MigrationProgress{State: MigrationCopying, StartedAt: time.Now()},
+	}
+}
+
+// Run copies all URLs from the old storage into the new one keeping ids, verifies the new storage
+// and switches to it. The verification runs again if dual writes failed in the new storage during it.
+// It stays in the dual-write mode if the copying or the verification fails.
+func (m *MigratingStorage) Run(ctx context.Context) {
+	slog.InfoContext(ctx, "Starting migration")
+	err := m.copyAll(ctx)
+	if err != nil {
+		m.fail(fmt.Errorf("copying error: %w", err))
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		m.update(func(p *MigrationProgress) {
+			p.State = MigrationVerifying
+			p.Verified, p.Fixed, p.Mismatched = 0, 0, 0
+		})
+		failed := m.failedWrites.Load()
+		err = m.verifyAll(ctx)
+		if err != nil {
+			m.fail(fmt.Errorf("verification error: %w", err))
+			return
+		}
+		var switched bool
+		switched, err = m.switchToNew(ctx, failed)
+		if err != nil {
+			m.fail(fmt.Errorf("switching error: %w", err))
+			return
+		}
+		if switched {
+			break
+		}
+		if attempt == migrationVerifyAttempts {
+			m.fail(fmt.Errorf("writes into the new storage failed during %d verifications", attempt))
+			return
+		}
+		slog.WarnContext(ctx, "Writes into the new storage failed during the verification, verifying again")
+	}
+	m.update(func(p *MigrationProgress) {
+		now := time.Now()
+		p.State = MigrationDone
+		p.FinishedAt = &now
+	})
+	slog.InfoContext(ctx, "Migration is done, switched to the new storage")
+}
+
+// Progress returns the current state of the migration.
+func (m *MigratingStorage) Progress() MigrationProgress {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	progress := m.progress
+	progress.FailedWrites = m.failedWrites.Load()
+	return progress
+}
+
+// switchToNew switches to the new storage if no dual write has failed in it since failed writes were counted.
+// Dual writes wait for the switch, so none of them fails unnoticed in between.
+func (m *MigratingStorage) switchToNew(ctx context.Context, failed int64) (bool, error) {
+	m.writes.Lock()
+	defer m.writes.Unlock()
+	if m.failedWrites.Load() != failed {
+		return false, nil
+	}
+	// reservations could fail in dual writes, the new storage issues user ids from now on
+	if reserver, ok := m.new.(UserReserver); ok && m.lastUserID.Load() > 0 {
+		if err := reserver.ReserveUser(ctx, int(m.lastUserID.Load())); err != nil {
+			return false, err
+		}
+	}
+	m.newOnly.Store(true)
+	return true, nil
+}
+
+// failWrite counts the dual write which failed in the new storage.
+func (m *MigratingStorage) failWrite(ctx context.Context, msg string, err error) {
+	m.failedWrites.Add(1)
+	slog.ErrorContext(ctx, msg, logging.Err(err))
+}
+
+func (m *MigratingStorage) update(fn func(p *MigrationProgress)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	fn(&m.progress)
+}
+
+func (m *MigratingStorage) fail(err error) {
+	slog.Error("Migration failed", logging.Err(err))
+	m.update(func(p *MigrationProgress) {
+		now := time.Now()
+		p.State = MigrationFailed
+		p.Error = err.Error()
+		p.FinishedAt = &now
+	})
+}
+
+func (m *MigratingStorage) copyAll(ctx context.Context) error {
+	batch := make([]internal.URLRecord, 0, migrationBatchSize)
+	flush := func() error {
+		n, err := m.new.Import(ctx, batch)
+		if err != nil {
+			return err
+		}
+		lastID := batch[len(batch)-1].ID
+		copied := len(batch)
+		m.update(func(p *MigrationProgress) {
+			p.Copied += copied
+			p.Imported += n
+			p.LastID = lastID
+		})
+		batch = batch[:0]
+		return nil
+	}
+	err := m.old.Export(ctx, func(rec internal.URLRecord) error {
+		batch = append(batch, rec)
+		if len(batch) == migrationBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return flush()
+	}
+	return nil
+}
+
+// verifyAll checks that every URL of the old storage exists in the new one.
+// Copies missing URLs and deletes URLs which were deleted during the copying.
+func (m *MigratingStorage) verifyAll(ctx context.Context) error {
+	var mismatched int
+	err := m.old.Export(ctx, func(rec internal.URLRecord) error {
+		fixed, err := m.verify(ctx, rec)
+		if err != nil {
+			mismatched++
+			slog.WarnContext(ctx, "Migration mismatch", "url_id", rec.ID, logging.Err(err))
+		}
+		m.update(func(p *MigrationProgress) {
+			p.Verified++
+			if fixed {
+				p.Fixed++
+			}
+			if err != nil {
+				p.Mismatched++
+			}
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if mismatched > 0 {
+		return fmt.Errorf("%d URLs differ", mismatched)
+	}
+	return nil
+}
+
+func (m *MigratingStorage) verify(ctx context.Context, rec internal.URLRecord) (bool, error) {
+	url, err := m.new.GetURL(ctx, strconv.Itoa(rec.ID))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		var n int
+		n, err = m.new.Import(ctx, []internal.URLRecord{rec})
+		if err != nil {
+			return false, err
+		}
+		if n == 0 {
+			return false, errors.New("URL was not imported")
+		}
+		return true, nil
+	case errors.Is(err, ErrDeleted):
+		if rec.IsDeleted {
+			return false, nil
+		}
+		// The URL could be deleted in both storages after it was exported.
+		_, err = m.old.GetURL(ctx, strconv.Itoa(rec.ID))
+		if errors.Is(err, ErrDeleted) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		// The URL was restored in the old storage while the new one missed it.
+		_, err = m.new.RestoreBatch(ctx, []internal.IDToDelete{{ID: rec.ID, UserID: rec.UserID}}, time.Time{})
+		return err == nil, err
+	case err != nil:
+		return false, err
+	case url != rec.OriginalURL:
+		return false, fmt.Errorf("original URL %q differs from %q", url, rec.OriginalURL)
+	case rec.IsDeleted:
+		_, err = m.new.DeleteBatch(ctx, []internal.IDToDelete{{ID: rec.ID, UserID: rec.UserID}})
+		return err == nil, err
+	}
+	return false, nil
+}
+
+// AddUser adds the user into the old storage and reserves its id in the new one.
+func (m *MigratingStorage) AddUser(ctx context.Context) (int, error) {
+	m.writes.RLock()
+	defer m.writes.RUnlock()
+	if m.newOnly.Load() {
+		return m.new.AddUser(ctx)
+	}
+	id, err := m.old.AddUser(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for last := m.lastUserID.Load(); int64(id) > last && !m.lastUserID.CompareAndSwap(last, int64(id)); {
+		last = m.lastUserID.Load()
+	}
+	if reserver, ok := m.new.(UserReserver); ok {
+		if err = reserver.ReserveUser(ctx, id); err != nil {
+			m.failWrite(ctx, "Error while reserving user in the new storage", err)
+		}
+	}
+	return id, nil
+}
+
+// AddURL adds URL into the old storage and copies it with the same id into the new one.
+func (m *MigratingStorage) AddURL(ctx context.Context, url string, userID int) (int, error) {
+	m.writes.RLock()
+	defer m.writes.RUnlock()
+	if m.newOnly.Load() {
+		return m.new.AddURL(ctx, url, userID)
+	}
+	id, err := m.old.AddURL(ctx, url, userID)
+	if err != nil {
+		return 0, err
+	}
+	m.importIntoNew(ctx, []internal.URLRecord{{ID: id, UserID: userID, OriginalURL: url, CreatedAt: time.Now()}})
+	return id, nil
+}
+
+// GetURLID returns URL id from the new storage or from the old one if the URL has not been copied yet.
+func (m *MigratingStorage) GetURLID(ctx context.Context, url string) (int, error) {
+	id, err := m.new.GetURLID(ctx, url)
+	if m.newOnly.Load() || !errors.Is(err, ErrNotFound) {
+		return id, err
+	}
+	return m.old.GetURLID(ctx, url)
+}
+
+// GetURL returns URL from the new storage or from the old one if the URL has not been copied yet.
+func (m *MigratingStorage) GetURL(ctx context.Context, id string) (string, error) {
+	url, err := m.new.GetURL(ctx, id)
+	if m.newOnly.Load() || !errors.Is(err, ErrNotFound) {
+		return url, err
+	}
+	return m.old.GetURL(ctx, id)
+}
+
+// GetURLOwner returns the owner of the URL from the new storage or from the old one if the URL has not been copied yet.
+func (m *MigratingStorage) GetURLOwner(ctx context.Context, id int) (int, error) {
+	userID, err := m.new.GetURLOwner(ctx, id)
+	if m.newOnly.Load() || !errors.Is(err, ErrNotFound) {
+		return userID, err
+	}
+	return m.old.GetURLOwner(ctx, id)
+}
+
+// GetUserUrls returns user's URLs from both storages.
+func (m *MigratingStorage) GetUserUrls(ctx context.Context, userID int) (map[int]string, error) {
+	newUrls, err := m.new.GetUserUrls(ctx, userID)
+	if m.newOnly.Load() {
+		return newUrls, err
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	oldUrls, err := m.old.GetUserUrls(ctx, userID)
+	if errors.Is(err, ErrNotFound) {
+		if len(newUrls) == 0 {
+			return nil, ErrNotFound
+		}
+		return newUrls, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for id, url := range newUrls {
+		oldUrls[id] = url
+	}
+	return oldUrls, nil
+}
+
+// AddBatch adds URLs into the old storage and copies them with the same ids into the new one.
+func (m *MigratingStorage) AddBatch(ctx context.Context, urls []internal.CorrIDOriginalURL, userID int) ([]internal.CorrIDUrlID, error) {
+	m.writes.RLock()
+	defer m.writes.RUnlock()
+	if m.newOnly.Load() {
+		return m.new.AddBatch(ctx, urls, userID)
+	}
+	res, err := m.old.AddBatch(ctx, urls, userID)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]internal.URLRecord, 0, len(res))
+	now := time.Now()
+	for i, v := range res {
+		if v.Err != nil || v.Existed {
+			continue
+		}
+		records = append(records, internal.URLRecord{ID: v.URLID, UserID: userID, OriginalURL: urls[i].OriginalURL, CreatedAt: now})
+	}
+	m.importIntoNew(ctx, records)
+	return res, nil
+}
+
+// DeleteBatch marks URLs as deleted in both storages. Returns the results of the old storage until the migration is done.
+func (m *MigratingStorage) DeleteBatch(ctx context.Context, ids []internal.IDToDelete) ([]internal.DeleteResult, error) {
+	m.writes.RLock()
+	defer m.writes.RUnlock()
+	if m.newOnly.Load() {
+		return m.new.DeleteBatch(ctx, ids)
+	}
+	res, err := m.old.DeleteBatch(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.new.DeleteBatch(ctx, ids)
+	if err != nil {
+		m.failWrite(ctx, "Error while deleting URLs in the new storage", err)
+	}
+	return res, nil
+}
+
+// RestoreBatch restores URLs in both storages. Returns the results of the old storage until the migration is done.
+func (m *MigratingStorage) RestoreBatch(ctx context.Context, ids []internal.IDToDelete, deletedAfter time.Time) ([]internal.DeleteResult, error) {
+	m.writes.RLock()
+	defer m.writes.RUnlock()
+	if m.newOnly.Load() {
+		return m.new.RestoreBatch(ctx, ids, deletedAfter)
+	}
+	res, err := m.old.RestoreBatch(ctx, ids, deletedAfter)
+	if err != nil {
+		return nil, err
+	}
+	restored := make([]internal.IDToDelete, 0, len(ids))
+	for i, v := range res {
+		if v.Status == internal.DeleteRestored {
+			restored = append(restored, ids[i])
+		}
+	}
+	if len(restored) > 0 {
+		// URLs restored in the old storage are restored in the new one regardless of when they were deleted there.
+		_, err = m.new.RestoreBatch(ctx, restored, time.Time{})
+		if err != nil {
+			m.failWrite(ctx, "Error while restoring URLs in the new storage", err)
+		}
+	}
+	return res, nil
+}
+
+// PurgeDeleted purges deleted URLs in both storages. Returns URLs purged in the old storage
+// until the migration is done.
+func (m *MigratingStorage) PurgeDeleted(ctx context.Context, deletedBefore time.Time) ([]internal.URLRecord, error) {
+	m.writes.RLock()
+	defer m.writes.RUnlock()
+	if m.newOnly.Load() {
+		return m.new.PurgeDeleted(ctx, deletedBefore)
+	}
+	res, err := m.old.PurgeDeleted(ctx, deletedBefore)
+	if err != nil {
+		return res, err
+	}
+	_, err = m.new.PurgeDeleted(ctx, deletedBefore)
+	if err != nil {
+		m.failWrite(ctx, "Error while purging URLs in the new storage", err)
+	}
+	return res, nil
+}
+
+// GetUserRecords returns URLs of the user from the old storage until the migration is done.
+func (m *MigratingStorage) GetUserRecords(ctx context.Context, userID int) ([]internal.URLRecord, error) {
+	if m.newOnly.Load() {
+		return m.new.GetUserRecords(ctx, userID)
+	}
+	return m.old.GetUserRecords(ctx, userID)
+}
+
+// EraseUser erases the user in both storages. Returns ids erased in the old storage until the migration is done
+// or the error of the new storage, so the erasure is repeated.
+func (m *MigratingStorage) EraseUser(ctx context.Context, userID int) ([]int, error) {
+	m.writes.RLock()
+	defer m.writes.RUnlock()
+	if m.newOnly.Load() {
+		return m.new.EraseUser(ctx, userID)
+	}
+	ids, err := m.old.EraseUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.new.EraseUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error while erasing user in the new storage: %w", err)
+	}
+	return ids, nil
+}
+
+// Export exports URLs from the old storage until the migration is done.
+func (m *MigratingStorage) Export(ctx context.Context, fn func(url internal.URLRecord) error) error {
+	if m.newOnly.Load() {
+		return m.new.Export(ctx, fn)
+	}
+	return m.old.Export(ctx, fn)
+}
+
+// Import imports URLs into both storages.
+func (m *MigratingStorage) Import(ctx context.Context, urls []internal.URLRecord) (int, error) {
+	m.writes.RLock()
+	defer m.writes.RUnlock()
+	if m.newOnly.Load() {
+		return m.new.Import(ctx, urls)
+	}
+	n, err := m.old.Import(ctx, urls)
+	if err != nil {
+		return n, err
+	}
+	m.importIntoNew(ctx, urls)
+	return n, nil
+}
+
+// RegisterChecks registers checks of the new storage and checks of the old one with prefix "migration_source.".
+// Checks of the old storage always pass after switching to the new storage.
+func (m *MigratingStorage) RegisterChecks(r health.Registry) {
+	m.new.RegisterChecks(r)
+	m.old.RegisterChecks(migrationSourceRegistry{registry: health.WithPrefix(r, "migration_source."), m: m})
+}
+
+// migrationSourceRegistry skips checks of the old storage after switching to the new storage.
+type migrationSourceRegistry struct {
+	registry health.Registry
+	m        *MigratingStorage
+}
+
+// Register registers the check which passes after switching to the new storage.
+func (s migrationSourceRegistry) Register(name string, check health.Check) {
+	s.registry.Register(name, func(ctx context.Context) error {
+		if s.m.newOnly.Load() {
+			return nil
+		}
+		return check(ctx)
+	})
+}
+
+// Close closes both storages.
+func (m *MigratingStorage) Close() {
+	m.old.Close()
+	m.new.Close()
+}
+
+// importIntoNew copies URLs into the new storage. Errors are counted as failed writes because
+// missing URLs are copied again during the verification.
+func (m *MigratingStorage) importIntoNew(ctx context.Context, urls []internal.URLRecord) {
+	_, err := m.new.Import(ctx, urls)
+	if err != nil {
+		m.failWrite(ctx, "Error while copying URLs into the new storage", err)
+	}
+}
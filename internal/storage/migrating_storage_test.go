@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestMigratingStorage(t *testing.T) {
+	ctx := context.Background()
+	old := NewMemoryStorage()
+	for i := 0; i < 2500; i++ {
+		_, err := old.AddURL(ctx, "https://ya"+strconv.Itoa(i)+".ru", i%10+1)
+		require.NoError(t, err)
+	}
+	_, err := old.DeleteBatch(ctx, []internal.IDToDelete{{ID: 5, UserID: 6}})
+	require.NoError(t, err)
+	_, err = old.AddUser(ctx)
+	require.NoError(t, err)
+	newStore, err := NewCachedFileStorage(filepath.Join(t.TempDir(), "urls"))
+	require.NoError(t, err)
+
+	m := NewMigratingStorage(old, newStore)
+	defer m.Close()
+
+	// Dual writes before the background copying.
+	userID, err := m.AddUser(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, userID)
+	id, err := m.AddURL(ctx, "https://dual.ru", userID)
+	require.NoError(t, err)
+	assert.Equal(t, 2500, id)
+	url, err := newStore.GetURL(ctx, strconv.Itoa(id))
+	require.NoError(t, err)
+	assert.Equal(t, "https://dual.ru", url)
+
+	// Reads fall back to the old storage.
+	url, err = m.GetURL(ctx, "10")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya10.ru", url)
+	_, err = m.GetURL(ctx, "5")
+	assert.ErrorIs(t, err, ErrDeleted)
+	urlID, err := m.GetURLID(ctx, "https://ya10.ru")
+	require.NoError(t, err)
+	assert.Equal(t, 10, urlID)
+
+	m.Run(ctx)
+	progress := m.Progress()
+	assert.Equal(t, MigrationDone, progress.State)
+	assert.Equal(t, 2501, progress.Copied)
+	assert.Equal(t, 2500, progress.Imported)
+	assert.Equal(t, 2500, progress.LastID)
+	assert.Equal(t, 2501, progress.Verified)
+	assert.Zero(t, progress.Mismatched)
+
+	for i := 0; i < 2500; i++ {
+		url, err = newStore.GetURL(ctx, strconv.Itoa(i))
+		if i == 5 {
+			assert.ErrorIs(t, err, ErrDeleted)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, "https://ya"+strconv.Itoa(i)+".ru", url)
+	}
+
+	// Only the new storage is used after the migration.
+	id, err = m.AddURL(ctx, "https://new-only.ru", userID)
+	require.NoError(t, err)
+	assert.Equal(t, 2501, id)
+	_, err = old.GetURL(ctx, strconv.Itoa(id))
+	assert.ErrorIs(t, err, ErrNotFound)
+	userID, err = m.AddUser(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 11, userID)
+}
+
+// exportHookStorage calls the hook after every export.
+type exportHookStorage struct {
+	Storage
+	hook func(ctx context.Context)
+}
+
+func (s exportHookStorage) Export(ctx context.Context, fn func(url internal.URLRecord) error) error {
+	err := s.Storage.Export(ctx, fn)
+	s.hook(ctx)
+	return err
+}
+
+// failingImportStorage fails imports while fail is set.
+type failingImportStorage struct {
+	Storage
+	fail *bool
+}
+
+func (s failingImportStorage) Import(ctx context.Context, urls []internal.URLRecord) (int, error) {
+	if *s.fail {
+		return 0, errors.New("connection lost")
+	}
+	return s.Storage.Import(ctx, urls)
+}
+
+func TestMigratingStorageFailedWrite(t *testing.T) {
+	ctx := context.Background()
+	old := NewMemoryStorage()
+	_, err := old.AddURL(ctx, "https://ya.ru", 1)
+	require.NoError(t, err)
+	newStore := NewMemoryStorage()
+	var fail bool
+	var m *MigratingStorage
+	exports := 0
+	var lateID int
+	hooked := exportHookStorage{Storage: old, hook: func(ctx context.Context) {
+		exports++
+		if exports != 2 {
+			return
+		}
+		// the URL is added after the verification read the old storage, and its copy fails
+		fail = true
+		lateID, err = m.AddURL(ctx, "https://late.ru", 1)
+		require.NoError(t, err)
+		fail = false
+	}}
+	m = NewMigratingStorage(hooked, failingImportStorage{Storage: newStore, fail: &fail})
+
+	id, err := m.GetURLID(ctx, "https://ya.ru")
+	require.NoError(t, err)
+	assert.Zero(t, id)
+	_, err = m.GetURLID(ctx, "https://unknown.ru")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	m.Run(ctx)
+	progress := m.Progress()
+	assert.Equal(t, MigrationDone, progress.State)
+	assert.Equal(t, int64(1), progress.FailedWrites)
+	assert.Equal(t, 3, exports)
+	url, err := newStore.GetURL(ctx, strconv.Itoa(lateID))
+	require.NoError(t, err)
+	assert.Equal(t, "https://late.ru", url)
+}
+
+// failingEraseStorage fails to erase users.
+type failingEraseStorage struct {
+	Storage
+}
+
+func (s failingEraseStorage) EraseUser(_ context.Context, _ int) ([]int, error) {
+	return nil, errors.New("connection lost")
+}
+
+func TestMigratingStorageEraseUserError(t *testing.T) {
+	ctx := context.Background()
+	old := NewMemoryStorage()
+	_, err := old.AddURL(ctx, "https://ya.ru", 1)
+	require.NoError(t, err)
+	m := NewMigratingStorage(old, failingEraseStorage{Storage: NewMemoryStorage()})
+	_, err = m.EraseUser(ctx, 1)
+	require.Error(t, err)
+}
+
+func TestMigratingStorageThroughTracing(t *testing.T) {
+	ctx := context.Background()
+	old := NewMemoryStorage()
+	for i := 0; i < 5; i++ {
+		_, err := old.AddUser(ctx)
+		require.NoError(t, err)
+	}
+	_, err := old.AddURL(ctx, "https://ya.ru", 1)
+	require.NoError(t, err)
+	newStore := NewMemoryStorage()
+
+	m := NewMigratingStorage(old, NewTracingStorage(newStore, "memory"))
+	defer m.Close()
+	userID, err := m.AddUser(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 6, userID)
+
+	m.Run(ctx)
+	require.Equal(t, MigrationDone, m.Progress().State)
+	// Ids of users without URLs are reserved in the new storage through the wrapper.
+	userID, err = m.AddUser(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 7, userID)
+	url, err := newStore.GetURL(ctx, "0")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya.ru", url)
+}
+
+// notCopiedStorage is the new storage which has not received URLs yet. It reports missing URLs with ErrNotFound
+// like DBStorage does for a query without rows.
+type notCopiedStorage struct {
+	Storage
+}
+
+func (s notCopiedStorage) GetURLID(_ context.Context, _ string) (int, error) {
+	return 0, ErrNotFound
+}
+
+func TestMigratingStorageExistingInOld(t *testing.T) {
+	ctx := context.Background()
+	old := NewMemoryStorage()
+	id, err := old.AddURL(ctx, "https://ya.ru", 1)
+	require.NoError(t, err)
+	m := NewMigratingStorage(old, notCopiedStorage{Storage: NewMemoryStorage()})
+	defer m.Close()
+
+	// URLService.AddURL looks the id up after ErrAlreadyExists.
+	_, err = m.AddURL(ctx, "https://ya.ru", 2)
+	require.ErrorIs(t, err, ErrAlreadyExists)
+	urlID, err := m.GetURLID(ctx, "https://ya.ru")
+	require.NoError(t, err)
+	assert.Equal(t, id, urlID)
+}
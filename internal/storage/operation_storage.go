@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// OperationStorage keeps deletion operations with the result for every URL id.
+type OperationStorage interface {
+	// CreateOperation saves the new operation.
+	CreateOperation(ctx context.Context, op internal.DeleteOperation) error
+	// GetOperation returns the operation by its id or ErrNotFound.
+	GetOperation(ctx context.Context, id string) (internal.DeleteOperation, error)
+	// SaveResults sets statuses of queued URL ids of the operation. The operation is done when no ids are queued.
+	SaveResults(ctx context.Context, opID string, results []internal.DeleteResult) error
+	// EraseUser removes operations of the user.
+	EraseUser(ctx context.Context, userID int) error
+	// Close closes resources.
+	Close()
+}
+
+// applyResults sets statuses of queued URL ids of op and marks it done when no ids are queued.
+func applyResults(op *internal.DeleteOperation, results []internal.DeleteResult, now time.Time) {
+	for _, r := range results {
+		for i := range op.Results {
+			if op.Results[i].ID == r.ID && op.Results[i].Status == internal.DeleteQueued {
+				op.Results[i].Status = r.Status
+				break
+			}
+		}
+	}
+	op.Status = internal.OperationDone
+	for _, r := range op.Results {
+		if r.Status == internal.DeleteQueued {
+			op.Status = internal.OperationPending
+			break
+		}
+	}
+	op.UpdatedAt = now
+}
+
+var _ OperationStorage = (*MemoryOperationStorage)(nil)
+
+// MemoryOperationStorage keeps deletion operations in memory.
+type MemoryOperationStorage struct {
+	ops   map[string]*internal.DeleteOperation
+	mutex sync.RWMutex
+	now   func() time.Time
+}
+
+// NewMemoryOperationStorage creates new *MemoryOperationStorage.
+func NewMemoryOperationStorage() *MemoryOperationStorage {
+	return &MemoryOperationStorage{ops: make(map[string]*internal.DeleteOperation), now: time.Now}
+}
+
+// CreateOperation saves the new operation.
+func (s *MemoryOperationStorage) CreateOperation(_ context.Context, op internal.DeleteOperation) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.createOperation(op)
+	return nil
+}
+
+func (s *MemoryOperationStorage) createOperation(op internal.DeleteOperation) {
+	op.Results = append([]internal.DeleteResult(nil), op.Results...)
+	s.ops[op.ID] = &op
+}
+
+// GetOperation returns the operation by its id or ErrNotFound.
+func (s *MemoryOperationStorage) GetOperation(_ context.Context, id string) (internal.DeleteOperation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	op, ok := s.ops[id]
+	if !ok {
+		return internal.DeleteOperation{}, ErrNotFound
+	}
+	res := *op
+	res.Results = append([]internal.DeleteResult(nil), op.Results...)
+	return res, nil
+}
+
+// SaveResults sets statuses of queued URL ids of the operation. The operation is done when no ids are queued.
+func (s *MemoryOperationStorage) SaveResults(_ context.Context, opID string, results []internal.DeleteResult) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.saveResults(opID, results, s.now())
+}
+
+func (s *MemoryOperationStorage) saveResults(opID string, results []internal.DeleteResult, now time.Time) error {
+	op, ok := s.ops[opID]
+	if !ok {
+		return ErrNotFound
+	}
+	applyResults(op, results, now)
+	return nil
+}
+
+// EraseUser removes operations of the user.
+func (s *MemoryOperationStorage) EraseUser(_ context.Context, userID int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.eraseUser(userID)
+	return nil
+}
+
+func (s *MemoryOperationStorage) eraseUser(userID int) {
+	for id, op := range s.ops {
+		if op.UserID == userID {
+			delete(s.ops, id)
+		}
+	}
+}
+
+// Close does nothing.
+func (s *MemoryOperationStorage) Close() {
+}
+
+var _ OperationStorage = (*FileOperationStorage)(nil)
+
+// FileOperationStorage keeps deletion operations in memory and appends every change into the file.
+// The file is replayed on start so that operations survive restarts.
+type FileOperationStorage struct {
+	*MemoryOperationStorage
+	file      *os.File
+	filename  string
+	fileMutex sync.Mutex
+}
+
+// operationLogRecord is the line of the FileOperationStorage file.
+// UserID and Request are kept separately because DeleteOperation does not marshal them.
+type operationLogRecord struct {
+	Type      string                   `json:"type"`
+	UserID    int                      `json:"user_id,omitempty"`
+	Request   *internal.RequestMeta    `json:"request,omitempty"`
+	Operation internal.DeleteOperation `json:"operation"`
+	Results   []internal.DeleteResult  `json:"results,omitempty"`
+	Time      time.Time                `json:"time"`
+}
+
+// Types of operationLogRecord.
+const (
+	operationLogCreate  = "create"
+	operationLogResults = "results"
+)
+
+// NewFileOperationStorage creates FileOperationStorage and fills memory from the file with name=filename.
+func NewFileOperationStorage(filename string) (*FileOperationStorage, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0777)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileOperationStorage{MemoryOperationStorage: NewMemoryOperationStorage(), file: file, filename: filename}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var rec operationLogRecord
+		err = json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		s.apply(rec)
+	}
+	if err = scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileOperationStorage) apply(rec operationLogRecord) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch rec.Type {
+	case operationLogCreate:
+		rec.Operation.UserID = rec.UserID
+		if rec.Request != nil {
+			rec.Operation.Request = *rec.Request
+		}
+		s.createOperation(rec.Operation)
+	case operationLogResults:
+		s.saveResults(rec.Operation.ID, rec.Results, rec.Time)
+	}
+}
+
+// write appends the record into the file and after that applies it to memory.
+func (s *FileOperationStorage) write(rec operationLogRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+	_, err = s.file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("error while writing operation: %w", err)
+	}
+	s.apply(rec)
+	return nil
+}
+
+// CreateOperation saves the new operation into file and into memory.
+func (s *FileOperationStorage) CreateOperation(_ context.Context, op internal.DeleteOperation) error {
+	return s.write(operationLogRecord{
+		Type:      operationLogCreate,
+		UserID:    op.UserID,
+		Request:   &op.Request,
+		Operation: op,
+		Time:      s.now(),
+	})
+}
+
+// SaveResults saves statuses of queued URL ids of the operation into file and into memory.
+func (s *FileOperationStorage) SaveResults(ctx context.Context, opID string, results []internal.DeleteResult) error {
+	if _, err := s.GetOperation(ctx, opID); err != nil {
+		return err
+	}
+	return s.write(operationLogRecord{
+		Type:      operationLogResults,
+		Operation: internal.DeleteOperation{ID: opID},
+		Results:   results,
+		Time:      s.now(),
+	})
+}
+
+// EraseUser removes operations of the user from memory and rewrites the file with the rest of operations,
+// so requests of the user do not stay in the file.
+func (s *FileOperationStorage) EraseUser(_ context.Context, userID int) error {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+	s.mutex.Lock()
+	s.eraseUser(userID)
+	s.mutex.Unlock()
+	var err error
+	s.file, err = rewriteFile(s.filename, s.file, func(w io.Writer) error {
+		s.mutex.RLock()
+		defer s.mutex.RUnlock()
+		enc := json.NewEncoder(w)
+		for _, op := range s.ops {
+			err := enc.Encode(operationLogRecord{
+				Type:      operationLogCreate,
+				UserID:    op.UserID,
+				Request:   &op.Request,
+				Operation: *op,
+				Time:      op.UpdatedAt,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// Close closes the file.
+func (s *FileOperationStorage) Close() {
+	s.file.Close()
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileOperationStorage(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "operations")
+	store, err := NewFileOperationStorage(filename)
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	store.now = func() time.Time { return now }
+	op := internal.DeleteOperation{
+		ID:     "a",
+		UserID: 1,
+		Status: internal.OperationPending,
+		Results: []internal.DeleteResult{
+			{ID: 1, Status: internal.DeleteQueued},
+			{ID: 2, Status: internal.DeleteQueued},
+			{ID: 1, Status: internal.DeleteQueued},
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	require.NoError(t, store.CreateOperation(ctx, op))
+	require.NoError(t, store.SaveResults(ctx, "a", []internal.DeleteResult{
+		{ID: 1, Status: internal.DeleteDeleted},
+		{ID: 1, Status: internal.DeleteAlreadyDeleted},
+	}))
+	got, err := store.GetOperation(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, internal.OperationPending, got.Status)
+	assert.ErrorIs(t, store.SaveResults(ctx, "b", nil), ErrNotFound)
+
+	require.NoError(t, store.SaveResults(ctx, "a", []internal.DeleteResult{{ID: 2, Status: internal.DeleteNotOwner}}))
+	store.Close()
+
+	store, err = NewFileOperationStorage(filename)
+	require.NoError(t, err)
+	defer store.Close()
+	got, err = store.GetOperation(ctx, "a")
+	require.NoError(t, err)
+	op.Status = internal.OperationDone
+	op.Results = []internal.DeleteResult{
+		{ID: 1, Status: internal.DeleteDeleted},
+		{ID: 2, Status: internal.DeleteNotOwner},
+		{ID: 1, Status: internal.DeleteAlreadyDeleted},
+	}
+	assert.Equal(t, op, got)
+	_, err = store.GetOperation(ctx, "b")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFileOperationStorageEraseUser(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "operations")
+	store, err := NewFileOperationStorage(filename)
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	erased := internal.DeleteOperation{ID: "a", UserID: 1, Request: internal.RequestMeta{IP: "10.0.0.1"},
+		Status: internal.OperationPending, Results: []internal.DeleteResult{{ID: 1, Status: internal.DeleteQueued}},
+		CreatedAt: now, UpdatedAt: now}
+	kept := internal.DeleteOperation{ID: "b", UserID: 2, Request: internal.RequestMeta{IP: "10.0.0.2"},
+		Status: internal.OperationPending, Results: []internal.DeleteResult{{ID: 2, Status: internal.DeleteQueued}},
+		CreatedAt: now, UpdatedAt: now}
+	require.NoError(t, store.CreateOperation(ctx, erased))
+	require.NoError(t, store.CreateOperation(ctx, kept))
+	require.NoError(t, store.EraseUser(ctx, 1))
+	_, err = store.GetOperation(ctx, "a")
+	assert.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, store.SaveResults(ctx, "b", []internal.DeleteResult{{ID: 2, Status: internal.DeleteDeleted}}))
+	store.Close()
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "10.0.0.1")
+	store, err = NewFileOperationStorage(filename)
+	require.NoError(t, err)
+	defer store.Close()
+	_, err = store.GetOperation(ctx, "a")
+	assert.ErrorIs(t, err, ErrNotFound)
+	got, err := store.GetOperation(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, kept.Request, got.Request)
+	assert.Equal(t, internal.OperationDone, got.Status)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/health"
+	"time"
 )
 
 // Storage errors
@@ -11,24 +13,87 @@ var (
 	ErrNotFound      = errors.New("not found")
 	ErrAlreadyExists = errors.New("already exists")
 	ErrDeleted       = errors.New("was deleted")
+	ErrEmptyURL      = errors.New("empty URL")
+	ErrIDOutOfRange  = errors.New("id is out of range")
 )
 
+// CheckName is the name of the health check component of the storage.
+const CheckName = "storage"
+
 // Storage.
 type Storage interface {
-	// AddUser creates a new user into storage and returns its id.
+	// AddUser returns the id for a new user. Storages which keep users save the user with its first URL.
 	AddUser(ctx context.Context) (int, error)
 	// AddURL saves URL for the user into storage and returns its id.
 	AddURL(ctx context.Context, url string, userID int) (int, error)
-	// GetURLID returns id of URL.
+	// GetURLID returns id of URL. Returns ErrNotFound if there is no such URL.
 	GetURLID(ctx context.Context, url string) (int, error)
 	// GetURL returns URL by its id.
 	GetURL(ctx context.Context, id string) (string, error)
+	// GetURLOwner returns the id of the user who saved the URL with id. Returns ErrNotFound for purged URLs.
+	GetURLOwner(ctx context.Context, id int) (int, error)
 	// GetUserUrls returns map of id and URL with all URLs for the user.
 	GetUserUrls(ctx context.Context, userID int) (map[int]string, error)
-	// AddBatch saves the batch of URLs for the user. Returns array of url IDs and its CorrID.
+	// AddBatch saves the batch of URLs for the user. Returns the result for every URL in the same order:
+	// the id of the new URL, the id of the existing URL with the flag Existed or the error.
 	AddBatch(ctx context.Context, urls []internal.CorrIDOriginalURL, userID int) ([]internal.CorrIDUrlID, error)
-	// DeleteBatch marks url IDs from the list as deleted in storage.
-	DeleteBatch(ctx context.Context, ids []internal.IDToDelete) error
+	// DeleteBatch marks url IDs from the list as deleted in storage. Returns the result for every id in the same order:
+	// deleted, already_deleted, not_found or not_owner.
+	DeleteBatch(ctx context.Context, ids []internal.IDToDelete) ([]internal.DeleteResult, error)
+	// RestoreBatch unmarks url IDs from the list deleted after deletedAfter. Returns the result for every id
+	// in the same order: restored, not_deleted, expired, not_found or not_owner.
+	RestoreBatch(ctx context.Context, ids []internal.IDToDelete, deletedAfter time.Time) ([]internal.DeleteResult, error)
+	// PurgeDeleted removes URLs deleted before deletedBefore and frees their original URLs for reuse.
+	// Ids of purged URLs stay known as deleted. Returns purged URLs as they were before purging in ascending order of id.
+	PurgeDeleted(ctx context.Context, deletedBefore time.Time) ([]internal.URLRecord, error)
+	// GetUserRecords returns all URLs of the user including deleted ones in ascending order of id.
+	GetUserRecords(ctx context.Context, userID int) ([]internal.URLRecord, error)
+	// EraseUser removes all URLs of the user keeping anonymous tombstones of their ids, so that they stay known
+	// as deleted, and anonymises tombstones of URLs of the user purged before. Returns ids of removed URLs.
+	EraseUser(ctx context.Context, userID int) ([]int, error)
+	// Export calls fn for every stored URL in ascending order of id except purged ones.
+	// Stops on the first error returned by fn.
+	Export(ctx context.Context, fn func(url internal.URLRecord) error) error
+	// Import saves URLs keeping their ids. Skips URLs whose id or original URL already exist.
+	// Returns the number of saved URLs.
+	Import(ctx context.Context, urls []internal.URLRecord) (int, error)
+	// RegisterChecks registers health checks of the storage as component CheckName.
+	RegisterChecks(r health.Registry)
 	// Close closes resources.
 	Close()
 }
+
+// UserCleaner is implemented by storages which keep users and are able to delete inactive ones.
+type UserCleaner interface {
+	// DeleteInactiveUsers deletes users without URLs whose last activity was before inactiveSince.
+	// Returns the number of deleted users.
+	DeleteInactiveUsers(ctx context.Context, inactiveSince time.Time) (int, error)
+}
+
+// deleteStatus returns the status of deletion of the URL by the user.
+func deleteStatus(exists bool, ownerID, userID int, isDeleted bool) string {
+	switch {
+	case !exists:
+		return internal.DeleteNotFound
+	case ownerID != userID:
+		return internal.DeleteNotOwner
+	case isDeleted:
+		return internal.DeleteAlreadyDeleted
+	}
+	return internal.DeleteDeleted
+}
+
+// restoreStatus returns the status of restoration of the URL by the user. Purged URLs are always expired.
+func restoreStatus(exists bool, ownerID, userID int, isDeleted, purged bool, deletedAt, deletedAfter time.Time) string {
+	switch {
+	case !exists:
+		return internal.DeleteNotFound
+	case ownerID != userID:
+		return internal.DeleteNotOwner
+	case !isDeleted:
+		return internal.DeleteNotDeleted
+	case purged || deletedAt.Before(deletedAfter):
+		return internal.DeleteExpired
+	}
+	return internal.DeleteRestored
+}
@@ -8,8 +8,10 @@ import (
 	"github.com/stretchr/testify/require"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func BenchmarkGetUrl(b *testing.B) {
@@ -173,3 +175,282 @@ func BenchmarkAddBatch(b *testing.B) {
 		})
 	}
 }
+
+func TestAddBatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		storeNew func(t *testing.T) Storage
+	}{
+		{
+			name: "Memory storage",
+			storeNew: func(t *testing.T) Storage {
+				return NewMemoryStorage()
+			},
+		},
+		{
+			name: "File storage",
+			storeNew: func(t *testing.T) Storage {
+				store, err := NewCachedFileStorage(filepath.Join(t.TempDir(), "urls"))
+				require.NoError(t, err)
+				return store
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := tt.storeNew(t)
+			defer store.Close()
+			existingID, err := store.AddURL(context.Background(), "https://existing.ru", 1)
+			require.NoError(t, err)
+
+			res, err := store.AddBatch(context.Background(), []internal.CorrIDOriginalURL{
+				{CorrID: "1", OriginalURL: "https://new.ru"},
+				{CorrID: "2", OriginalURL: "https://existing.ru"},
+				{CorrID: "3", OriginalURL: ""},
+				{CorrID: "4", OriginalURL: "https://new.ru"},
+			}, 2)
+			require.NoError(t, err)
+			require.Len(t, res, 4)
+
+			assert.Equal(t, "1", res[0].CorrID)
+			assert.False(t, res[0].Existed)
+			assert.NoError(t, res[0].Err)
+			assert.Equal(t, internal.CorrIDUrlID{CorrID: "2", URLID: existingID, Existed: true}, res[1])
+			assert.Equal(t, "3", res[2].CorrID)
+			assert.ErrorIs(t, res[2].Err, ErrEmptyURL)
+			assert.Equal(t, internal.CorrIDUrlID{CorrID: "4", URLID: res[0].URLID, Existed: true}, res[3])
+
+			url, err := store.GetURL(context.Background(), strconv.Itoa(res[0].URLID))
+			require.NoError(t, err)
+			assert.Equal(t, "https://new.ru", url)
+		})
+	}
+}
+
+func TestDeleteRestoreBatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		storeNew func(t *testing.T) Storage
+	}{
+		{
+			name: "Memory storage",
+			storeNew: func(t *testing.T) Storage {
+				return NewMemoryStorage()
+			},
+		},
+		{
+			name: "File storage",
+			storeNew: func(t *testing.T) Storage {
+				store, err := NewCachedFileStorage(filepath.Join(t.TempDir(), "urls"))
+				require.NoError(t, err)
+				return store
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := tt.storeNew(t)
+			defer store.Close()
+			id1, err := store.AddURL(ctx, "https://ya1.ru", 1)
+			require.NoError(t, err)
+			id2, err := store.AddURL(ctx, "https://ya2.ru", 2)
+			require.NoError(t, err)
+
+			res, err := store.DeleteBatch(ctx, []internal.IDToDelete{
+				{ID: id1, UserID: 1}, {ID: id2, UserID: 1}, {ID: 100, UserID: 1}, {ID: id1, UserID: 1},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []internal.DeleteResult{
+				{ID: id1, Status: internal.DeleteDeleted},
+				{ID: id2, Status: internal.DeleteNotOwner},
+				{ID: 100, Status: internal.DeleteNotFound},
+				{ID: id1, Status: internal.DeleteAlreadyDeleted},
+			}, res)
+			_, err = store.GetURL(ctx, strconv.Itoa(id1))
+			assert.ErrorIs(t, err, ErrDeleted)
+
+			res, err = store.RestoreBatch(ctx, []internal.IDToDelete{{ID: id1, UserID: 1}}, time.Now().Add(time.Hour))
+			require.NoError(t, err)
+			assert.Equal(t, []internal.DeleteResult{{ID: id1, Status: internal.DeleteExpired}}, res)
+
+			res, err = store.RestoreBatch(ctx, []internal.IDToDelete{
+				{ID: id1, UserID: 1}, {ID: id2, UserID: 1}, {ID: id1, UserID: 1}, {ID: 100, UserID: 1},
+			}, time.Now().Add(-time.Hour))
+			require.NoError(t, err)
+			assert.Equal(t, []internal.DeleteResult{
+				{ID: id1, Status: internal.DeleteRestored},
+				{ID: id2, Status: internal.DeleteNotOwner},
+				{ID: id1, Status: internal.DeleteNotDeleted},
+				{ID: 100, Status: internal.DeleteNotFound},
+			}, res)
+			url, err := store.GetURL(ctx, strconv.Itoa(id1))
+			require.NoError(t, err)
+			assert.Equal(t, "https://ya1.ru", url)
+		})
+	}
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "urls")
+	tests := []struct {
+		name     string
+		storeNew func(t *testing.T) Storage
+		// reopen returns the storage after restart or nil if it does not survive restarts.
+		reopen func(t *testing.T) Storage
+	}{
+		{
+			name: "Memory storage",
+			storeNew: func(t *testing.T) Storage {
+				return NewMemoryStorage()
+			},
+		},
+		{
+			name: "File storage",
+			storeNew: func(t *testing.T) Storage {
+				store, err := NewCachedFileStorage(filename)
+				require.NoError(t, err)
+				return store
+			},
+			reopen: func(t *testing.T) Storage {
+				store, err := NewCachedFileStorage(filename)
+				require.NoError(t, err)
+				return store
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := tt.storeNew(t)
+			id1, err := store.AddURL(ctx, "https://ya1.ru", 1)
+			require.NoError(t, err)
+			id2, err := store.AddURL(ctx, "https://ya2.ru", 1)
+			require.NoError(t, err)
+			_, err = store.DeleteBatch(ctx, []internal.IDToDelete{{ID: id1, UserID: 1}})
+			require.NoError(t, err)
+
+			purged, err := store.PurgeDeleted(ctx, time.Now().Add(-time.Hour))
+			require.NoError(t, err)
+			assert.Empty(t, purged)
+			purged, err = store.PurgeDeleted(ctx, time.Now().Add(time.Hour))
+			require.NoError(t, err)
+			require.Len(t, purged, 1)
+			assert.Equal(t, id1, purged[0].ID)
+			assert.Equal(t, 1, purged[0].UserID)
+			assert.Equal(t, "https://ya1.ru", purged[0].OriginalURL)
+
+			if tt.reopen != nil {
+				store.Close()
+				store = tt.reopen(t)
+			}
+			defer store.Close()
+			_, err = store.GetURL(ctx, strconv.Itoa(id1))
+			assert.ErrorIs(t, err, ErrDeleted)
+			urls, err := store.GetUserUrls(ctx, 1)
+			require.NoError(t, err)
+			assert.Equal(t, map[int]string{id2: "https://ya2.ru"}, urls)
+			res, err := store.DeleteBatch(ctx, []internal.IDToDelete{{ID: id1, UserID: 1}})
+			require.NoError(t, err)
+			assert.Equal(t, []internal.DeleteResult{{ID: id1, Status: internal.DeleteAlreadyDeleted}}, res)
+			res, err = store.RestoreBatch(ctx, []internal.IDToDelete{{ID: id1, UserID: 1}}, time.Time{})
+			require.NoError(t, err)
+			assert.Equal(t, []internal.DeleteResult{{ID: id1, Status: internal.DeleteExpired}}, res)
+
+			// The original URL is free for reuse.
+			id3, err := store.AddURL(ctx, "https://ya1.ru", 2)
+			require.NoError(t, err)
+			assert.NotEqual(t, id1, id3)
+			url, err := store.GetURL(ctx, strconv.Itoa(id3))
+			require.NoError(t, err)
+			assert.Equal(t, "https://ya1.ru", url)
+
+			var exported []int
+			err = store.Export(ctx, func(rec internal.URLRecord) error {
+				exported = append(exported, rec.ID)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []int{id2, id3}, exported)
+		})
+	}
+}
+
+func TestEraseUser(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "urls")
+	tests := []struct {
+		name     string
+		storeNew func(t *testing.T) Storage
+		// reopen returns the storage after restart or nil if it does not survive restarts.
+		reopen func(t *testing.T) Storage
+	}{
+		{
+			name: "Memory storage",
+			storeNew: func(t *testing.T) Storage {
+				return NewMemoryStorage()
+			},
+		},
+		{
+			name: "File storage",
+			storeNew: func(t *testing.T) Storage {
+				store, err := NewCachedFileStorage(filename)
+				require.NoError(t, err)
+				return store
+			},
+			reopen: func(t *testing.T) Storage {
+				store, err := NewCachedFileStorage(filename)
+				require.NoError(t, err)
+				return store
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := tt.storeNew(t)
+			id1, err := store.AddURL(ctx, "https://ya1.ru", 1)
+			require.NoError(t, err)
+			id2, err := store.AddURL(ctx, "https://ya2.ru", 1)
+			require.NoError(t, err)
+			id3, err := store.AddURL(ctx, "https://ya3.ru", 2)
+			require.NoError(t, err)
+			_, err = store.DeleteBatch(ctx, []internal.IDToDelete{{ID: id2, UserID: 1}})
+			require.NoError(t, err)
+
+			records, err := store.GetUserRecords(ctx, 1)
+			require.NoError(t, err)
+			require.Len(t, records, 2)
+			assert.Equal(t, id1, records[0].ID)
+			assert.Equal(t, "https://ya1.ru", records[0].OriginalURL)
+			assert.False(t, records[0].IsDeleted)
+			assert.Equal(t, id2, records[1].ID)
+			assert.True(t, records[1].IsDeleted)
+
+			erased, err := store.EraseUser(ctx, 1)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []int{id1, id2}, erased)
+
+			if tt.reopen != nil {
+				store.Close()
+				store = tt.reopen(t)
+			}
+			defer store.Close()
+			records, err = store.GetUserRecords(ctx, 1)
+			require.NoError(t, err)
+			assert.Empty(t, records)
+			_, err = store.GetURL(ctx, strconv.Itoa(id1))
+			assert.ErrorIs(t, err, ErrDeleted)
+			res, err := store.RestoreBatch(ctx, []internal.IDToDelete{{ID: id1, UserID: 1}}, time.Time{})
+			require.NoError(t, err)
+			assert.Equal(t, []internal.DeleteResult{{ID: id1, Status: internal.DeleteNotOwner}}, res)
+			url, err := store.GetURL(ctx, strconv.Itoa(id3))
+			require.NoError(t, err)
+			assert.Equal(t, "https://ya3.ru", url)
+
+			// The original URL is free for reuse.
+			id4, err := store.AddURL(ctx, "https://ya1.ru", 2)
+			require.NoError(t, err)
+			assert.NotEqual(t, id1, id4)
+		})
+	}
+}
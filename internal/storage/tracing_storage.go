@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/MalyginaEkaterina/shortener/internal/health"
+	"github.com/MalyginaEkaterina/shortener/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+var (
+	_ Storage      = (*TracingStorage)(nil)
+	_ UserReserver = (*TracingStorage)(nil)
+	_ UserCleaner  = (*TracingStorage)(nil)
+)
+
+// TracingStorage creates a span for every call of another Storage. Optional interfaces of the storage
+// are forwarded too, so that it can be wrapped before MigratingStorage and UserGC.
+type TracingStorage struct {
+	next Storage
+	kind string
+}
+
+// NewTracingStorage creates new TracingStorage. kind is the kind of the storage, e.g. postgresql or file,
+// which is added to spans as attribute db.system.
+func NewTracingStorage(next Storage, kind string) *TracingStorage {
+	return &TracingStorage{next: next, kind: kind}
+}
+
+// start starts the span of the method with attributes of the storage and attrs.
+func (t *TracingStorage) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("db.system", t.kind), attribute.String("db.operation", method))
+	return tracing.Start(ctx, "Storage."+method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// AddUser calls AddUser of the storage in the span.
+func (t *TracingStorage) AddUser(ctx context.Context) (id int, err error) {
+	ctx, span := t.start(ctx, "AddUser")
+	defer func() { tracing.End(span, err) }()
+	return t.next.AddUser(ctx)
+}
+
+// AddURL calls AddURL of the storage in the span. ErrAlreadyExists is not recorded as an error.
+func (t *TracingStorage) AddURL(ctx context.Context, url string, userID int) (id int, err error) {
+	ctx, span := t.start(ctx, "AddURL")
+	defer func() { tracing.End(span, ignore(err, ErrAlreadyExists)) }()
+	return t.next.AddURL(ctx, url, userID)
+}
+
+// GetURLID calls GetURLID of the storage in the span.
+func (t *TracingStorage) GetURLID(ctx context.Context, url string) (id int, err error) {
+	ctx, span := t.start(ctx, "GetURLID")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetURLID(ctx, url)
+}
+
+// GetURL calls GetURL of the storage in the span. ErrNotFound and ErrDeleted are not recorded as errors.
+func (t *TracingStorage) GetURL(ctx context.Context, id string) (url string, err error) {
+	ctx, span := t.start(ctx, "GetURL", attribute.String("shortener.url_id", id))
+	defer func() { tracing.End(span, ignore(err, ErrNotFound, ErrDeleted)) }()
+	return t.next.GetURL(ctx, id)
+}
+
+// GetURLOwner calls GetURLOwner of the storage in the span.
+func (t *TracingStorage) GetURLOwner(ctx context.Context, id int) (owner int, err error) {
+	ctx, span := t.start(ctx, "GetURLOwner", attribute.Int("shortener.url_id", id))
+	defer func() { tracing.End(span, ignore(err, ErrNotFound)) }()
+	return t.next.GetURLOwner(ctx, id)
+}
+
+// GetUserUrls calls GetUserUrls of the storage in the span.
+func (t *TracingStorage) GetUserUrls(ctx context.Context, userID int) (urls map[int]string, err error) {
+	ctx, span := t.start(ctx, "GetUserUrls")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetUserUrls(ctx, userID)
+}
+
+// AddBatch calls AddBatch of the storage in the span.
+func (t *TracingStorage) AddBatch(ctx context.Context, urls []internal.CorrIDOriginalURL, userID int) (res []internal.CorrIDUrlID, err error) {
+	ctx, span := t.start(ctx, "AddBatch", attribute.Int("shortener.batch_size", len(urls)))
+	defer func() { tracing.End(span, err) }()
+	return t.next.AddBatch(ctx, urls, userID)
+}
+
+// DeleteBatch calls DeleteBatch of the storage in the span.
+func (t *TracingStorage) DeleteBatch(ctx context.Context, ids []internal.IDToDelete) (res []internal.DeleteResult, err error) {
+	ctx, span := t.start(ctx, "DeleteBatch", attribute.Int("shortener.batch_size", len(ids)))
+	defer func() { tracing.End(span, err) }()
+	return t.next.DeleteBatch(ctx, ids)
+}
+
+// RestoreBatch calls RestoreBatch of the storage in the span.
+func (t *TracingStorage) RestoreBatch(ctx context.Context, ids []internal.IDToDelete, deletedAfter time.Time) (res []internal.DeleteResult, err error) {
+	ctx, span := t.start(ctx, "RestoreBatch", attribute.Int("shortener.batch_size", len(ids)))
+	defer func() { tracing.End(span, err) }()
+	return t.next.RestoreBatch(ctx, ids, deletedAfter)
+}
+
+// PurgeDeleted calls PurgeDeleted of the storage in the span.
+func (t *TracingStorage) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (res []internal.URLRecord, err error) {
+	ctx, span := t.start(ctx, "PurgeDeleted")
+	defer func() { tracing.End(span, err) }()
+	return t.next.PurgeDeleted(ctx, deletedBefore)
+}
+
+// GetUserRecords calls GetUserRecords of the storage in the span.
+func (t *TracingStorage) GetUserRecords(ctx context.Context, userID int) (res []internal.URLRecord, err error) {
+	ctx, span := t.start(ctx, "GetUserRecords")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetUserRecords(ctx, userID)
+}
+
+// EraseUser calls EraseUser of the storage in the span.
+func (t *TracingStorage) EraseUser(ctx context.Context, userID int) (ids []int, err error) {
+	ctx, span := t.start(ctx, "EraseUser")
+	defer func() { tracing.End(span, err) }()
+	return t.next.EraseUser(ctx, userID)
+}
+
+// Export calls Export of the storage in the span.
+func (t *TracingStorage) Export(ctx context.Context, fn func(url internal.URLRecord) error) (err error) {
+	ctx, span := t.start(ctx, "Export")
+	defer func() { tracing.End(span, err) }()
+	return t.next.Export(ctx, fn)
+}
+
+// Import calls Import of the storage in the span.
+func (t *TracingStorage) Import(ctx context.Context, urls []internal.URLRecord) (n int, err error) {
+	ctx, span := t.start(ctx, "Import", attribute.Int("shortener.batch_size", len(urls)))
+	defer func() { tracing.End(span, err) }()
+	return t.next.Import(ctx, urls)
+}
+
+// ReserveUser calls ReserveUser of the storage in the span. Does nothing if the storage is not a UserReserver.
+func (t *TracingStorage) ReserveUser(ctx context.Context, userID int) (err error) {
+	reserver, ok := t.next.(UserReserver)
+	if !ok {
+		return nil
+	}
+	ctx, span := t.start(ctx, "ReserveUser")
+	defer func() { tracing.End(span, err) }()
+	return reserver.ReserveUser(ctx, userID)
+}
+
+// DeleteInactiveUsers calls DeleteInactiveUsers of the storage in the span.
+// Deletes nothing if the storage is not a UserCleaner.
+func (t *TracingStorage) DeleteInactiveUsers(ctx context.Context, inactiveSince time.Time) (n int, err error) {
+	cleaner, ok := t.next.(UserCleaner)
+	if !ok {
+		return 0, nil
+	}
+	ctx, span := t.start(ctx, "DeleteInactiveUsers")
+	defer func() { tracing.End(span, err) }()
+	return cleaner.DeleteInactiveUsers(ctx, inactiveSince)
+}
+
+// RegisterChecks registers checks of the storage.
+func (t *TracingStorage) RegisterChecks(r health.Registry) {
+	t.next.RegisterChecks(r)
+}
+
+// Close closes the storage.
+func (t *TracingStorage) Close() {
+	t.next.Close()
+}
+
+// ignore returns nil if err is one of expected errors which are results rather than failures.
+func ignore(err error, expected ...error) error {
+	for _, e := range expected {
+		if errors.Is(err, e) {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,438 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WebhookStorage keeps webhooks of users and the log of deliveries of events to them.
+type WebhookStorage interface {
+	// AddWebhook saves the new webhook.
+	AddWebhook(ctx context.Context, webhook internal.Webhook) error
+	// GetWebhook returns the webhook by its id or ErrNotFound.
+	GetWebhook(ctx context.Context, id string) (internal.Webhook, error)
+	// GetUserWebhooks returns all webhooks of the user in ascending order of creation.
+	GetUserWebhooks(ctx context.Context, userID int) ([]internal.Webhook, error)
+	// DeleteWebhook deletes the webhook by its id or returns ErrNotFound. Its deliveries are kept in the log.
+	DeleteWebhook(ctx context.Context, id string) error
+	// AddDeliveries saves new deliveries.
+	AddDeliveries(ctx context.Context, deliveries []internal.WebhookDelivery) error
+	// SaveDelivery saves the state of the delivery. Deliveries which were removed meanwhile are not saved back.
+	SaveDelivery(ctx context.Context, delivery internal.WebhookDelivery) error
+	// GetDelivery returns the delivery by its id or ErrNotFound.
+	GetDelivery(ctx context.Context, id string) (internal.WebhookDelivery, error)
+	// GetDeliveries returns up to limit deliveries of the webhook in descending order of creation.
+	// Only deliveries with status are returned if it is not empty.
+	GetDeliveries(ctx context.Context, webhookID string, status string, limit int) ([]internal.WebhookDelivery, error)
+	// ClaimDeliveries returns up to limit pending or failed deliveries whose next attempt is not after now.
+	// Their next attempt is moved to now plus lease, so that they are not claimed again until the attempt is saved.
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]internal.WebhookDelivery, error)
+	// PruneDeliveries removes delivered and dead deliveries which were last updated before updatedBefore.
+	// Returns the number of removed deliveries.
+	PruneDeliveries(ctx context.Context, updatedBefore time.Time) (int, error)
+	// EraseUser removes webhooks of the user with all their deliveries.
+	EraseUser(ctx context.Context, userID int) error
+	// Close closes resources.
+	Close()
+}
+
+var _ WebhookStorage = (*MemoryWebhookStorage)(nil)
+
+// MemoryWebhookStorage keeps webhooks and deliveries in memory.
+type MemoryWebhookStorage struct {
+	webhooks   map[string]internal.Webhook
+	deliveries map[string]internal.WebhookDelivery
+	// order contains ids of deliveries in the order of creation.
+	order []string
+	// due contains ids of pending and failed deliveries.
+	due   map[string]struct{}
+	mutex sync.RWMutex
+}
+
+// NewMemoryWebhookStorage creates new *MemoryWebhookStorage.
+func NewMemoryWebhookStorage() *MemoryWebhookStorage {
+	return &MemoryWebhookStorage{
+		webhooks:   make(map[string]internal.Webhook),
+		deliveries: make(map[string]internal.WebhookDelivery),
+		due:        make(map[string]struct{}),
+	}
+}
+
+// AddWebhook saves the new webhook.
+func (s *MemoryWebhookStorage) AddWebhook(_ context.Context, webhook internal.Webhook) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.webhooks[webhook.ID] = webhook
+	return nil
+}
+
+// GetWebhook returns the webhook by its id or ErrNotFound.
+func (s *MemoryWebhookStorage) GetWebhook(_ context.Context, id string) (internal.Webhook, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	webhook, ok := s.webhooks[id]
+	if !ok {
+		return internal.Webhook{}, ErrNotFound
+	}
+	return webhook, nil
+}
+
+// GetUserWebhooks returns all webhooks of the user in ascending order of creation.
+func (s *MemoryWebhookStorage) GetUserWebhooks(_ context.Context, userID int) ([]internal.Webhook, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var res []internal.Webhook
+	for _, webhook := range s.webhooks {
+		if webhook.UserID == userID {
+			res = append(res, webhook)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+	return res, nil
+}
+
+// DeleteWebhook deletes the webhook by its id or returns ErrNotFound.
+func (s *MemoryWebhookStorage) DeleteWebhook(_ context.Context, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.webhooks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.webhooks, id)
+	return nil
+}
+
+// AddDeliveries saves new deliveries.
+func (s *MemoryWebhookStorage) AddDeliveries(_ context.Context, deliveries []internal.WebhookDelivery) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, d := range deliveries {
+		s.saveDelivery(d)
+	}
+	return nil
+}
+
+// SaveDelivery saves the state of the delivery if it was not removed.
+func (s *MemoryWebhookStorage) SaveDelivery(_ context.Context, delivery internal.WebhookDelivery) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.deliveries[delivery.ID]; ok {
+		s.saveDelivery(delivery)
+	}
+	return nil
+}
+
+func (s *MemoryWebhookStorage) saveDelivery(d internal.WebhookDelivery) {
+	if _, ok := s.deliveries[d.ID]; !ok {
+		s.order = append(s.order, d.ID)
+	}
+	s.deliveries[d.ID] = d
+	if d.Status == internal.DeliveryPending || d.Status == internal.DeliveryFailed {
+		s.due[d.ID] = struct{}{}
+	} else {
+		delete(s.due, d.ID)
+	}
+}
+
+// GetDelivery returns the delivery by its id or ErrNotFound.
+func (s *MemoryWebhookStorage) GetDelivery(_ context.Context, id string) (internal.WebhookDelivery, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	d, ok := s.deliveries[id]
+	if !ok {
+		return internal.WebhookDelivery{}, ErrNotFound
+	}
+	return d, nil
+}
+
+// GetDeliveries returns up to limit deliveries of the webhook in descending order of creation.
+func (s *MemoryWebhookStorage) GetDeliveries(_ context.Context, webhookID string, status string, limit int) ([]internal.WebhookDelivery, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var res []internal.WebhookDelivery
+	for i := len(s.order) - 1; i >= 0 && len(res) < limit; i-- {
+		d := s.deliveries[s.order[i]]
+		if d.WebhookID == webhookID && (status == "" || d.Status == status) {
+			res = append(res, d)
+		}
+	}
+	return res, nil
+}
+
+// ClaimDeliveries returns up to limit due deliveries in ascending order of their next attempt and moves it by lease.
+func (s *MemoryWebhookStorage) ClaimDeliveries(_ context.Context, now time.Time, lease time.Duration, limit int) ([]internal.WebhookDelivery, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var res []internal.WebhookDelivery
+	for id := range s.due {
+		d := s.deliveries[id]
+		if !d.NextAttemptAt.After(now) {
+			res = append(res, d)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].NextAttemptAt.Before(res[j].NextAttemptAt)
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	for _, d := range res {
+		d.NextAttemptAt = now.Add(lease)
+		s.deliveries[d.ID] = d
+	}
+	return res, nil
+}
+
+// PruneDeliveries removes delivered and dead deliveries which were last updated before updatedBefore.
+func (s *MemoryWebhookStorage) PruneDeliveries(_ context.Context, updatedBefore time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.pruneDeliveries(updatedBefore), nil
+}
+
+func (s *MemoryWebhookStorage) pruneDeliveries(updatedBefore time.Time) int {
+	order := s.order[:0]
+	for _, id := range s.order {
+		d := s.deliveries[id]
+		if (d.Status == internal.DeliveryDelivered || d.Status == internal.DeliveryDead) && d.UpdatedAt.Before(updatedBefore) {
+			delete(s.deliveries, id)
+			continue
+		}
+		order = append(order, id)
+	}
+	pruned := len(s.order) - len(order)
+	clear(s.order[len(order):])
+	s.order = order
+	return pruned
+}
+
+// EraseUser removes webhooks of the user with all their deliveries.
+func (s *MemoryWebhookStorage) EraseUser(_ context.Context, userID int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.eraseUser(userID)
+	return nil
+}
+
+func (s *MemoryWebhookStorage) eraseUser(userID int) {
+	erased := make(map[string]bool)
+	for id, webhook := range s.webhooks {
+		if webhook.UserID == userID {
+			erased[id] = true
+			delete(s.webhooks, id)
+		}
+	}
+	if len(erased) == 0 {
+		return
+	}
+	order := s.order[:0]
+	for _, id := range s.order {
+		if erased[s.deliveries[id].WebhookID] {
+			delete(s.deliveries, id)
+			delete(s.due, id)
+			continue
+		}
+		order = append(order, id)
+	}
+	clear(s.order[len(order):])
+	s.order = order
+}
+
+// Close does nothing.
+func (s *MemoryWebhookStorage) Close() {
+}
+
+var _ WebhookStorage = (*FileWebhookStorage)(nil)
+
+// FileWebhookStorage keeps webhooks and deliveries in memory and appends every change into the file.
+// The file is replayed on start so that webhooks and undelivered events survive restarts.
+// Claims of deliveries are not written, so deliveries claimed before a restart are attempted again.
+// The file is rewritten when deliveries are pruned or users are erased, so neither the file nor memory keep
+// removed webhooks and deliveries.
+type FileWebhookStorage struct {
+	*MemoryWebhookStorage
+	filename  string
+	file      *os.File
+	fileMutex sync.Mutex
+}
+
+// webhookLogRecord is the line of the FileWebhookStorage file.
+// UserID is kept separately because Webhook does not marshal it.
+type webhookLogRecord struct {
+	Type     string                    `json:"type"`
+	UserID   int                       `json:"user_id,omitempty"`
+	Webhook  *internal.Webhook         `json:"webhook,omitempty"`
+	Delivery *internal.WebhookDelivery `json:"delivery,omitempty"`
+}
+
+// Types of webhookLogRecord.
+const (
+	webhookLogAdd      = "webhook"
+	webhookLogDelete   = "delete_webhook"
+	webhookLogDelivery = "delivery"
+)
+
+// NewFileWebhookStorage creates FileWebhookStorage and fills memory from the file with name=filename.
+func NewFileWebhookStorage(filename string) (*FileWebhookStorage, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0777)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileWebhookStorage{MemoryWebhookStorage: NewMemoryWebhookStorage(), filename: filename, file: file}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec webhookLogRecord
+		err = json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		s.apply(rec)
+	}
+	if err = scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileWebhookStorage) apply(rec webhookLogRecord) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch {
+	case rec.Type == webhookLogAdd && rec.Webhook != nil:
+		webhook := *rec.Webhook
+		webhook.UserID = rec.UserID
+		s.webhooks[webhook.ID] = webhook
+	case rec.Type == webhookLogDelete && rec.Webhook != nil:
+		delete(s.webhooks, rec.Webhook.ID)
+	case rec.Type == webhookLogDelivery && rec.Delivery != nil:
+		s.saveDelivery(*rec.Delivery)
+	}
+}
+
+// write appends records into the file and after that applies them to memory.
+func (s *FileWebhookStorage) write(recs ...webhookLogRecord) error {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+	return s.writeLocked(recs...)
+}
+
+// writeLocked is write for callers holding fileMutex.
+func (s *FileWebhookStorage) writeLocked(recs ...webhookLogRecord) error {
+	var data []byte
+	for _, rec := range recs {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	_, err := s.file.Write(data)
+	if err != nil {
+		return fmt.Errorf("error while writing webhooks: %w", err)
+	}
+	for _, rec := range recs {
+		s.apply(rec)
+	}
+	return nil
+}
+
+// AddWebhook saves the new webhook into file and into memory.
+func (s *FileWebhookStorage) AddWebhook(_ context.Context, webhook internal.Webhook) error {
+	return s.write(webhookLogRecord{Type: webhookLogAdd, UserID: webhook.UserID, Webhook: &webhook})
+}
+
+// DeleteWebhook deletes the webhook from file and from memory or returns ErrNotFound.
+func (s *FileWebhookStorage) DeleteWebhook(ctx context.Context, id string) error {
+	if _, err := s.GetWebhook(ctx, id); err != nil {
+		return err
+	}
+	return s.write(webhookLogRecord{Type: webhookLogDelete, Webhook: &internal.Webhook{ID: id}})
+}
+
+// AddDeliveries saves new deliveries into file and into memory.
+func (s *FileWebhookStorage) AddDeliveries(_ context.Context, deliveries []internal.WebhookDelivery) error {
+	recs := make([]webhookLogRecord, len(deliveries))
+	for i := range deliveries {
+		recs[i] = webhookLogRecord{Type: webhookLogDelivery, Delivery: &deliveries[i]}
+	}
+	return s.write(recs...)
+}
+
+// SaveDelivery saves the state of the delivery into file and into memory if it was not removed.
+func (s *FileWebhookStorage) SaveDelivery(ctx context.Context, delivery internal.WebhookDelivery) error {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+	if _, err := s.GetDelivery(ctx, delivery.ID); errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return s.writeLocked(webhookLogRecord{Type: webhookLogDelivery, Delivery: &delivery})
+}
+
+// PruneDeliveries removes delivered and dead deliveries which were last updated before updatedBefore from memory
+// and rewrites the file with webhooks and the rest of deliveries.
+func (s *FileWebhookStorage) PruneDeliveries(_ context.Context, updatedBefore time.Time) (int, error) {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+	s.mutex.Lock()
+	pruned := s.pruneDeliveries(updatedBefore)
+	s.mutex.Unlock()
+	if pruned == 0 {
+		return 0, nil
+	}
+	return pruned, s.rewrite()
+}
+
+// EraseUser removes webhooks of the user with all their deliveries from memory and rewrites the file
+// with the rest, so endpoints, secrets and events of the user do not stay in the file.
+func (s *FileWebhookStorage) EraseUser(_ context.Context, userID int) error {
+	s.fileMutex.Lock()
+	defer s.fileMutex.Unlock()
+	s.mutex.Lock()
+	s.eraseUser(userID)
+	s.mutex.Unlock()
+	return s.rewrite()
+}
+
+// rewrite replaces the file with webhooks and deliveries from memory. The caller must hold fileMutex.
+func (s *FileWebhookStorage) rewrite() error {
+	var err error
+	s.file, err = rewriteFile(s.filename, s.file, func(w io.Writer) error {
+		s.mutex.RLock()
+		defer s.mutex.RUnlock()
+		enc := json.NewEncoder(w)
+		for _, webhook := range s.webhooks {
+			err := enc.Encode(webhookLogRecord{Type: webhookLogAdd, UserID: webhook.UserID, Webhook: &webhook})
+			if err != nil {
+				return err
+			}
+		}
+		for _, id := range s.order {
+			d := s.deliveries[id]
+			err := enc.Encode(webhookLogRecord{Type: webhookLogDelivery, Delivery: &d})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// Close closes the file.
+func (s *FileWebhookStorage) Close() {
+	s.file.Close()
+}
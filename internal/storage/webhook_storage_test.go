@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"github.com/MalyginaEkaterina/shortener/internal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileWebhookStorage(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "webhooks")
+	store, err := NewFileWebhookStorage(filename)
+	require.NoError(t, err)
+	now := time.Now().UTC().Truncate(time.Second)
+	webhook := internal.Webhook{
+		ID:        "w1",
+		UserID:    1,
+		URL:       "https://example.com/hook",
+		Events:    []string{internal.EventLinkCreated},
+		Secret:    "secret",
+		CreatedAt: now,
+	}
+	require.NoError(t, store.AddWebhook(ctx, webhook))
+	require.NoError(t, store.AddWebhook(ctx, internal.Webhook{ID: "w2", UserID: 1, CreatedAt: now.Add(time.Second)}))
+	require.NoError(t, store.DeleteWebhook(ctx, "w2"))
+	require.ErrorIs(t, store.DeleteWebhook(ctx, "w2"), ErrNotFound)
+	delivery := internal.WebhookDelivery{
+		ID:            "d1",
+		WebhookID:     "w1",
+		Event:         internal.LinkEvent{ID: "d1", Type: internal.EventLinkCreated, URLID: 1, Time: now},
+		Status:        internal.DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	delivered := delivery
+	delivered.ID = "d2"
+	delivered.Status = internal.DeliveryDelivered
+	require.NoError(t, store.AddDeliveries(ctx, []internal.WebhookDelivery{delivery, delivered}))
+
+	claimed, err := store.ClaimDeliveries(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, "d1", claimed[0].ID)
+	claimed, err = store.ClaimDeliveries(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+	store.Close()
+
+	store, err = NewFileWebhookStorage(filename)
+	require.NoError(t, err)
+	defer store.Close()
+	webhooks, err := store.GetUserWebhooks(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []internal.Webhook{webhook}, webhooks)
+	// Claims are not persisted, so the pending delivery is attempted again after restart.
+	claimed, err = store.ClaimDeliveries(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, delivery.Event, claimed[0].Event)
+
+	claimed[0].Status = internal.DeliveryDead
+	require.NoError(t, store.SaveDelivery(ctx, claimed[0]))
+	deliveries, err := store.GetDeliveries(ctx, "w1", "", 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, "d2", deliveries[0].ID)
+	deliveries, err = store.GetDeliveries(ctx, "w1", internal.DeliveryDead, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, "d1", deliveries[0].ID)
+}
+
+func TestFileWebhookStoragePrune(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "webhooks")
+	store, err := NewFileWebhookStorage(filename)
+	require.NoError(t, err)
+	now := time.Now().UTC().Truncate(time.Second)
+	webhook := internal.Webhook{ID: "w1", UserID: 1, URL: "https://example.com/hook", CreatedAt: now}
+	require.NoError(t, store.AddWebhook(ctx, webhook))
+	delivery := func(id, status string, updatedAt time.Time) internal.WebhookDelivery {
+		return internal.WebhookDelivery{ID: id, WebhookID: "w1", Status: status, CreatedAt: updatedAt, UpdatedAt: updatedAt}
+	}
+	old := now.Add(-time.Hour)
+	require.NoError(t, store.AddDeliveries(ctx, []internal.WebhookDelivery{
+		delivery("d1", internal.DeliveryDelivered, old),
+		delivery("d2", internal.DeliveryDead, old),
+		delivery("d3", internal.DeliveryFailed, old),
+		delivery("d4", internal.DeliveryDelivered, now),
+	}))
+
+	n, err := store.PruneDeliveries(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = store.PruneDeliveries(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	// The rewritten file is still appended to.
+	require.NoError(t, store.AddDeliveries(ctx, []internal.WebhookDelivery{delivery("d5", internal.DeliveryPending, now)}))
+	store.Close()
+
+	store, err = NewFileWebhookStorage(filename)
+	require.NoError(t, err)
+	defer store.Close()
+	webhooks, err := store.GetUserWebhooks(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []internal.Webhook{webhook}, webhooks)
+	deliveries, err := store.GetDeliveries(ctx, "w1", "", 10)
+	require.NoError(t, err)
+	var ids []string
+	for _, d := range deliveries {
+		ids = append(ids, d.ID)
+	}
+	assert.Equal(t, []string{"d5", "d4", "d3"}, ids)
+	_, err = store.GetDelivery(ctx, "d1")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFileWebhookStorageEraseUser(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "webhooks")
+	store, err := NewFileWebhookStorage(filename)
+	require.NoError(t, err)
+	now := time.Now().UTC().Truncate(time.Second)
+	erased := internal.Webhook{ID: "w1", UserID: 1, URL: "https://erased.example.com/hook", Secret: "erased-secret", CreatedAt: now}
+	kept := internal.Webhook{ID: "w2", UserID: 2, URL: "https://kept.example.com/hook", Secret: "kept-secret", CreatedAt: now}
+	require.NoError(t, store.AddWebhook(ctx, erased))
+	require.NoError(t, store.AddWebhook(ctx, kept))
+	require.NoError(t, store.AddDeliveries(ctx, []internal.WebhookDelivery{
+		{ID: "d1", WebhookID: "w1", Event: internal.LinkEvent{ShortURL: "http://localhost/erased"},
+			Status: internal.DeliveryPending, NextAttemptAt: now, CreatedAt: now, UpdatedAt: now},
+		{ID: "d2", WebhookID: "w1", Status: internal.DeliveryDelivered, CreatedAt: now, UpdatedAt: now},
+		{ID: "d3", WebhookID: "w2", Status: internal.DeliveryPending, NextAttemptAt: now, CreatedAt: now, UpdatedAt: now},
+	}))
+	claimed, err := store.ClaimDeliveries(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+
+	require.NoError(t, store.EraseUser(ctx, 1))
+	// The delivery which was in flight during the erasure is not saved back.
+	for _, d := range claimed {
+		d.Status = internal.DeliveryDelivered
+		require.NoError(t, store.SaveDelivery(ctx, d))
+	}
+	store.Close()
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "erased")
+	store, err = NewFileWebhookStorage(filename)
+	require.NoError(t, err)
+	defer store.Close()
+	webhooks, err := store.GetUserWebhooks(ctx, 1)
+	require.NoError(t, err)
+	assert.Empty(t, webhooks)
+	for _, id := range []string{"d1", "d2"} {
+		_, err = store.GetDelivery(ctx, id)
+		assert.ErrorIs(t, err, ErrNotFound, id)
+	}
+	webhooks, err = store.GetUserWebhooks(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []internal.Webhook{kept}, webhooks)
+	d, err := store.GetDelivery(ctx, "d3")
+	require.NoError(t, err)
+	assert.Equal(t, internal.DeliveryDelivered, d.Status)
+}
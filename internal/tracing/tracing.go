@@ -0,0 +1,119 @@
+// Package tracing configures OpenTelemetry tracing and W3C trace context propagation.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"strings"
+)
+
+// Name is the name of the tracer and the service.
+const Name = "shortener"
+
+// instrumentation is the name of the instrumentation library.
+const instrumentation = "github.com/MalyginaEkaterina/shortener"
+
+// Exporters.
+const (
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+	filePrefix     = "file:"
+)
+
+// ErrUnknownExporter is returned by Init if the exporter is not supported.
+var ErrUnknownExporter = errors.New("unknown trace exporter")
+
+// Tracer returns the tracer of the application from the global provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// Start starts the span with the tracer of the application.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, opts...)
+}
+
+// End records err in the span if it is not nil and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Init sets the global propagator of W3C trace context and baggage and, if exporter is not empty, the global provider
+// exporting spans to stdout, to the file by "file:<path>" or over OTLP/HTTP by "otlp". OTLP is configured by the standard
+// OTEL_EXPORTER_OTLP_* env vars. Returns the function which flushes spans and stops the provider.
+func Init(ctx context.Context, exporter string) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if exporter == "" {
+		return func(ctx context.Context) error { return nil }, nil
+	}
+	exp, closeFn, err := newExporter(ctx, exporter)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(Name)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeErr := closeFn(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
+
+// newExporter creates the exporter and the function closing its resources.
+func newExporter(ctx context.Context, exporter string) (sdktrace.SpanExporter, func() error, error) {
+	noop := func() error { return nil }
+	switch {
+	case exporter == ExporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		return exp, noop, err
+	case strings.HasPrefix(exporter, filePrefix):
+		f, err := os.OpenFile(strings.TrimPrefix(exporter, filePrefix), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return exp, f.Close, nil
+	case exporter == ExporterOTLP:
+		exp, err := otlptracehttp.New(ctx)
+		return exp, noop, err
+	}
+	return nil, nil, fmt.Errorf("%w: %s", ErrUnknownExporter, exporter)
+}
+
+// Inject returns the W3C traceparent of the span in ctx or empty string if there is no span.
+func Inject(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// Extract returns the span context from the W3C traceparent. It is invalid if traceparent is empty or malformed.
+func Extract(traceParent string) trace.SpanContext {
+	carrier := propagation.MapCarrier{"traceparent": traceParent}
+	return trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), carrier))
+}
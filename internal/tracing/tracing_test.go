@@ -0,0 +1,41 @@
+package tracing
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInitFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans")
+	shutdown, err := Init(context.Background(), "file:"+path)
+	require.NoError(t, err)
+
+	ctx, span := Start(context.Background(), "parent")
+	_, child := Start(ctx, "child")
+	child.End()
+	span.End()
+
+	traceParent := Inject(ctx)
+	require.NotEmpty(t, traceParent)
+	sc := Extract(traceParent)
+	assert.True(t, sc.IsValid())
+	assert.Equal(t, span.SpanContext().TraceID(), sc.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), sc.SpanID())
+	assert.False(t, Extract("").IsValid())
+
+	require.NoError(t, shutdown(context.Background()))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"Name":"parent"`)
+	assert.Contains(t, string(data), `"Name":"child"`)
+	assert.Contains(t, string(data), span.SpanContext().TraceID().String())
+}
+
+func TestInitUnknownExporter(t *testing.T) {
+	_, err := Init(context.Background(), "jaeger")
+	require.ErrorIs(t, err, ErrUnknownExporter)
+}